@@ -164,3 +164,48 @@ resource "google_monitoring_metric_descriptor" "fsid_sql_query_duration" {
     description = "The result of the query, such as \"ok\"."
   }
 }
+
+resource "google_monitoring_metric_descriptor" "fsid_journal_reconcile_count" {
+  project      = var.project
+  description  = "Number of journal entries checked against the database by the KNFSD FSID daemon. Any result other than ok is a conflict."
+  display_name = "knfsd-fsidd journal reconcile count"
+  type         = "custom.googleapis.com/knfsd/fsid/journal/reconcile/count"
+  metric_kind  = "CUMULATIVE"
+  value_type   = "INT64"
+  unit         = "1"
+
+  labels {
+    key         = "result"
+    description = "The result of reconciling the journal entry, such as \"ok\" or \"missing\"."
+  }
+}
+
+resource "google_monitoring_metric_descriptor" "fsid_gc_count" {
+  project      = var.project
+  description  = "Number of stale FSID mappings found by the KNFSD FSID daemon garbage collection. A result of deleted is a mapping that was reclaimed."
+  display_name = "knfsd-fsidd GC count"
+  type         = "custom.googleapis.com/knfsd/fsid/gc/count"
+  metric_kind  = "CUMULATIVE"
+  value_type   = "INT64"
+  unit         = "1"
+
+  labels {
+    key         = "result"
+    description = "The result of the garbage collection, one of \"deleted\", \"skipped\" (used since being listed), \"stale\" (dry run) or \"error\"."
+  }
+}
+
+resource "google_monitoring_metric_descriptor" "fsid_access_denied_count" {
+  project      = var.project
+  description  = "Number of commands rejected by the KNFSD FSID daemon because the peer was not allowed to execute the command."
+  display_name = "knfsd-fsidd access denied count"
+  type         = "custom.googleapis.com/knfsd/fsid/access/denied/count"
+  metric_kind  = "CUMULATIVE"
+  value_type   = "INT64"
+  unit         = "1"
+
+  labels {
+    key         = "command"
+    description = "The command that was rejected, such as \"get_or_create_fsidnum\"."
+  }
+}
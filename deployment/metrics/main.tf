@@ -230,6 +230,80 @@ resource "google_monitoring_metric_descriptor" "nfs_connections" {
   unit         = "1"
 }
 
+resource "google_monitoring_metric_descriptor" "nfs_clients" {
+  project      = var.project
+  description  = "The number of unique NFS Clients (by IP address) connected to the Knfsd filer"
+  display_name = "Knfsd NFS Unique Clients Connected"
+  type         = "custom.googleapis.com/knfsd/nfs_clients"
+  metric_kind  = "GAUGE"
+  value_type   = "INT64"
+  unit         = "1"
+}
+
+resource "google_monitoring_metric_descriptor" "nfs_connections_queue_size" {
+  project      = var.project
+  description  = "Total bytes waiting in the socket queues of NFS client connections"
+  display_name = "Knfsd NFS Connections Queue Size"
+  type         = "custom.googleapis.com/knfsd/nfs_connections_queue_size"
+  metric_kind  = "GAUGE"
+  value_type   = "INT64"
+  unit         = "By"
+
+  labels {
+    key         = "queue"
+    value_type  = "STRING"
+    description = "Socket queue (send or receive)"
+  }
+}
+
+resource "google_monitoring_metric_descriptor" "client_connections" {
+  project      = var.project
+  description  = "The number of connections from each NFS client"
+  display_name = "Knfsd NFS Client Connections"
+  type         = "custom.googleapis.com/knfsd/client/connections"
+  metric_kind  = "GAUGE"
+  value_type   = "INT64"
+  unit         = "1"
+
+  labels {
+    key         = "client"
+    value_type  = "STRING"
+    description = "NFS client IP address"
+  }
+}
+
+resource "google_monitoring_metric_descriptor" "client_retransmits" {
+  project      = var.project
+  description  = "Total TCP segments retransmitted to each NFS client"
+  display_name = "Knfsd NFS Client Retransmits"
+  type         = "custom.googleapis.com/knfsd/client/retransmits"
+  metric_kind  = "GAUGE"
+  value_type   = "INT64"
+  unit         = "1"
+
+  labels {
+    key         = "client"
+    value_type  = "STRING"
+    description = "NFS client IP address"
+  }
+}
+
+resource "google_monitoring_metric_descriptor" "client_rtt" {
+  project      = var.project
+  description  = "Average smoothed TCP round trip time to each NFS client"
+  display_name = "Knfsd NFS Client RTT"
+  type         = "custom.googleapis.com/knfsd/client/rtt"
+  metric_kind  = "GAUGE"
+  value_type   = "DOUBLE"
+  unit         = "ms"
+
+  labels {
+    key         = "client"
+    value_type  = "STRING"
+    description = "NFS client IP address"
+  }
+}
+
 resource "google_monitoring_metric_descriptor" "fscache_oldest_file" {
   project      = var.project
   description  = "Age of the oldest file in FS-Cache"
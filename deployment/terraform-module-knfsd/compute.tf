@@ -188,6 +188,7 @@ resource "google_compute_instance_template" "nfsproxy-template" {
     CUSTOM_PRE_STARTUP_SCRIPT  = var.CUSTOM_PRE_STARTUP_SCRIPT
     CUSTOM_POST_STARTUP_SCRIPT = var.CUSTOM_POST_STARTUP_SCRIPT
     ENABLE_KNFSD_AGENT         = var.ENABLE_KNFSD_AGENT
+    KNFSD_AGENT_CONFIG         = var.KNFSD_AGENT_CONFIG
   }
 
   scheduling {
@@ -278,6 +279,28 @@ resource "google_compute_instance_template" "nfsproxy-template" {
       error_message = "Must specify a database configuration (FSID_DATABASE_CONFIG) when using a custom external database (FSID_MODE = \"external\" and FSID_DATABASE_DEPLOY = false)."
     }
 
+    # The load balancer health check uses the knfsd-agent's /readyz endpoint.
+    precondition {
+      condition = (
+        var.ENABLE_KNFSD_AGENT_HEALTHCHECK
+        ? var.ENABLE_KNFSD_AGENT
+        : true
+      )
+      error_message = "ENABLE_KNFSD_AGENT must be true when ENABLE_KNFSD_AGENT_HEALTHCHECK is enabled."
+    }
+
+    # The health check uses plain HTTP on port 80. If the agent config changes
+    # the plain HTTP listen addresses, such as to only serve HTTPS on
+    # tls-listen, then port 80 must still be included.
+    precondition {
+      condition = (
+        var.ENABLE_KNFSD_AGENT_HEALTHCHECK && can(regex("(?m)^\\s*listen\\s*=", var.KNFSD_AGENT_CONFIG))
+        ? can(regex("(?m)^\\s*listen\\s*=.*:80\\b", var.KNFSD_AGENT_CONFIG))
+        : true
+      )
+      error_message = "KNFSD_AGENT_CONFIG must include port 80 in the [server] listen addresses when ENABLE_KNFSD_AGENT_HEALTHCHECK is enabled. The health check does not use TLS, use tls-listen for HTTPS."
+    }
+
     # Bug check: This should not occur and indicates a bug in the Terraform script.
     # Fail early during terraform plan, otherwise the proxy will deploy and enter
     # a reboot loop.
@@ -332,6 +355,31 @@ resource "google_compute_health_check" "autohealing" {
   ]
 }
 
+# Healthcheck using the knfsd-agent's /readyz endpoint, used by the load
+# balancer to stop sending traffic to proxies that cannot serve clients, such as
+# a proxy with a hung NFS mount or a full cache.
+# This is not used for autohealing, as recreating the proxy will not fix a
+# problem with the source server.
+resource "google_compute_health_check" "agent" {
+  count = var.ENABLE_KNFSD_AGENT_HEALTHCHECK ? 1 : 0
+
+  project             = var.PROJECT
+  name                = "${var.PROXY_BASENAME}-agent-health-check"
+  check_interval_sec  = var.HEALTHCHECK_INTERVAL_SECONDS
+  timeout_sec         = var.HEALTHCHECK_TIMEOUT_SECONDS
+  healthy_threshold   = var.HEALTHCHECK_HEALTHY_THRESHOLD
+  unhealthy_threshold = var.HEALTHCHECK_UNHEALTHY_THRESHOLD
+
+  http_health_check {
+    port         = "80"
+    request_path = "/readyz"
+  }
+
+  depends_on = [
+    google_compute_firewall.allow-tcp-healthcheck
+  ]
+}
+
 # Instance Group Manager for the Knfsd Nodes
 resource "google_compute_instance_group_manager" "proxy-group" {
   provider = google-beta # required to support stateful_internal_ip
@@ -392,7 +440,7 @@ resource "google_compute_firewall" "allow-tcp-healthcheck" {
 
   allow {
     protocol = "tcp"
-    ports    = ["2049"]
+    ports    = var.ENABLE_KNFSD_AGENT_HEALTHCHECK ? ["2049", "80"] : ["2049"]
   }
   source_ranges = ["130.211.0.0/22", "35.191.0.0/16", "209.85.152.0/22", "209.85.204.0/22"]
   target_tags   = ["knfsd-cache-server"]
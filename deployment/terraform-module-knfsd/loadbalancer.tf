@@ -55,7 +55,7 @@ module "loadbalancer" {
   SERVICE_LABEL  = var.SERVICE_LABEL
   IP_ADDRESS     = google_compute_address.nfsproxy_static[0].address
   ENABLE_UDP     = var.ENABLE_UDP
-  HEALTH_CHECK   = google_compute_health_check.autohealing.self_link
+  HEALTH_CHECK   = var.ENABLE_KNFSD_AGENT_HEALTHCHECK ? google_compute_health_check.agent[0].self_link : google_compute_health_check.autohealing.self_link
   INSTANCE_GROUP = google_compute_instance_group_manager.proxy-group.instance_group
 }
 
@@ -117,6 +117,9 @@ function mount_nfs_server() {
     echo "(Attempt ${attempt}/3) Mouting NFS Share: $remote..."
     if mount -t nfs -o "$MOUNT_OPTIONS" "$remote" "$path"; then
       echo "NFS mount succeeded for $remote."
+      # Record the intended mount so that the knfsd-agent can detect if the
+      # kernel changed any of the mount options.
+      printf '%s\t%s\t%s\n' "$remote" "$3" "$MOUNT_OPTIONS" >>/etc/knfsd-mounts.conf
       break
     else
       if ((attempt >= 3)); then
@@ -220,6 +223,9 @@ function init() {
 	WORKDIR=
 	trap cleanup EXIT
 
+	# mount_nfs_server records each mount, clear the mounts from the previous boot.
+	: >/etc/knfsd-mounts.conf
+
 	# Get Variables from VM Metadata Server
 	echo "Reading metadata from metadata server..."
 
@@ -248,6 +254,7 @@ function init() {
 	ENABLE_STACKDRIVER_METRICS=$(get_attribute ENABLE_STACKDRIVER_METRICS)
 	METRICS_AGENT_CONFIG=$(get_attribute METRICS_AGENT_CONFIG)
 	ENABLE_KNFSD_AGENT=$(get_attribute ENABLE_KNFSD_AGENT)
+	KNFSD_AGENT_CONFIG=$(get_attribute KNFSD_AGENT_CONFIG)
 	ROUTE_METRICS_PRIVATE_GOOGLEAPIS=$(get_attribute ROUTE_METRICS_PRIVATE_GOOGLEAPIS)
 
 	CUSTOM_PRE_STARTUP_SCRIPT=$(get_attribute CUSTOM_PRE_STARTUP_SCRIPT)
@@ -392,6 +399,13 @@ function start-fsidd() {
 		echo "Starting knfsd-fsidd..."
 		start-services knfsd-fsidd.socket knfsd-fsidd.service
 		echo "Finished Starting knfsd-fsidd."
+
+		# Warm the FSID cache before nfsd starts requesting FSIDs. This is
+		# best-effort, any paths that are not prefetched will be looked up when
+		# nfsd first requests them.
+		echo "Prefetching FSIDs..."
+		knfsd-fsidd prefetch --format=exports /etc/exports || true
+		echo "Finished prefetching FSIDs."
 		;;
 	*)
 		echo "Unknown FSID_MODE \"$FSID_MODE\"."
@@ -520,6 +534,9 @@ function start-nfs() {
 	# Enable Knfsd Agent if Configured
 	if [[ "$ENABLE_KNFSD_AGENT" = "true" ]]; then
 		echo "Starting Knfsd Agent..."
+		# The config can contain the token for the admin endpoints.
+		install -m 600 /dev/null /etc/knfsd-agent.conf
+		printf '%s' "$KNFSD_AGENT_CONFIG" >/etc/knfsd-agent.conf
 		start-services knfsd-agent
 		echo "Finished Starting Knfsd Agent."
 	else
@@ -310,6 +310,12 @@ variable "HEALTHCHECK_UNHEALTHY_THRESHOLD" {
   default  = 3
 }
 
+variable "ENABLE_KNFSD_AGENT_HEALTHCHECK" {
+  type     = bool
+  nullable = false
+  default  = false
+}
+
 variable "NUM_NFS_THREADS" {
   type     = number
   nullable = false
@@ -396,6 +402,13 @@ variable "ENABLE_KNFSD_AGENT" {
   default  = true
 }
 
+variable "KNFSD_AGENT_CONFIG" {
+  type      = string
+  nullable  = false
+  default   = ""
+  sensitive = true
+}
+
 variable "DISABLED_NFS_VERSIONS" {
   type     = string
   nullable = false
@@ -0,0 +1,348 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-agent/client"
+	"golang.org/x/sys/unix"
+)
+
+// maxAdminRequestSize limits the size of the JSON body of admin requests.
+const maxAdminRequestSize = 64 * 1024
+
+// AdminHandlerFunc implements an admin action. The returned message is sent
+// to the client and recorded in the audit log.
+type AdminHandlerFunc func(*http.Request) (string, error)
+
+// requestError is returned by an admin action when the request is invalid.
+type requestError struct {
+	statusCode int
+	err        error
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(format string, a ...any) error {
+	return &requestError{http.StatusBadRequest, fmt.Errorf(format, a...)}
+}
+
+// AdminAPI implements the endpoints that modify the state of the proxy. Every
+// request must be authenticated using a bearer token or a verified client
+// certificate, and is recorded in the audit log.
+type AdminAPI struct {
+	token  string
+	audit  *auditLog
+	health *HealthChecker
+
+	// clientCert allows requests with a verified client certificate.
+	clientCert bool
+
+	// mu serializes the admin actions, as running multiple actions at once
+	// (such as exportfs) could leave the proxy in an inconsistent state.
+	mu sync.Mutex
+}
+
+func NewAdminAPI(token string, audit *auditLog, health *HealthChecker) *AdminAPI {
+	return &AdminAPI{
+		token:  token,
+		audit:  audit,
+		health: health,
+	}
+}
+
+func (a *AdminAPI) register(mux *http.ServeMux) {
+	mux.Handle("/api/v1/admin/drain", a.handler("drain", a.drain))
+	mux.Handle("/api/v1/admin/undrain", a.handler("undrain", a.undrain))
+	mux.Handle("/api/v1/admin/exports/unexport", a.handler("unexport", a.unexport))
+	mux.Handle("/api/v1/admin/exports/reexport", a.handler("reexport", a.reexport))
+	mux.Handle("/api/v1/admin/exports/flush", a.handler("flush exports", a.flushExports))
+	mux.Handle("/api/v1/admin/cache/drop", a.handler("drop caches", a.dropCaches))
+	mux.Handle("/api/v1/admin/cachefilesd/restart", a.handler("restart cachefilesd", a.restartCachefilesd))
+}
+
+func (a *AdminAPI) handler(action string, fn AdminHandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statusCode, msg, err := a.execute(action, fn, r)
+		if err != nil {
+			msg = err.Error()
+		}
+		a.audit.Record(r, action, statusCode, msg)
+
+		body, jsonErr := json.MarshalIndent(client.AdminResponse{Message: msg}, "", "  ")
+		if jsonErr != nil {
+			statusCode = http.StatusInternalServerError
+			body = []byte("{\"message\": \"An unknown error occurred\"}")
+		}
+
+		if statusCode == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		writeJSON(w, r, statusCode, body, err)
+	})
+}
+
+func (a *AdminAPI) execute(action string, fn AdminHandlerFunc, r *http.Request) (int, string, error) {
+	// Admin endpoints only support POST requests, so that the actions cannot
+	// be triggered by a browser prefetching a link.
+	if r.Method != http.MethodPost {
+		return http.StatusMethodNotAllowed, "", errors.New("method not allowed")
+	}
+
+	if !a.authorized(r) {
+		return http.StatusUnauthorized, "", errors.New("unauthorized")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	msg, err := fn(r)
+	if err != nil {
+		var reqErr *requestError
+		if errors.As(err, &reqErr) {
+			return reqErr.statusCode, "", err
+		}
+		return http.StatusInternalServerError, "", fmt.Errorf("%s failed: %w", action, err)
+	}
+	return http.StatusOK, msg, nil
+}
+
+func (a *AdminAPI) authorized(r *http.Request) bool {
+	// The TLS config only sets VerifiedChains if the client certificate was
+	// signed by the client CA.
+	if a.clientCert && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+func (a *AdminAPI) drain(*http.Request) (string, error) {
+	a.health.SetDraining(true)
+	return "draining, /readyz will report the proxy is not ready", nil
+}
+
+func (a *AdminAPI) undrain(*http.Request) (string, error) {
+	a.health.SetDraining(false)
+	return "not draining", nil
+}
+
+func (a *AdminAPI) unexport(r *http.Request) (string, error) {
+	e, err := a.readExport(r)
+	if err != nil {
+		return "", err
+	}
+
+	for _, c := range e.Clients {
+		_, err = runCommand("exportfs", "-u", c.Host+":"+e.Path)
+		if err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("unexported %s", e.Path), nil
+}
+
+func (a *AdminAPI) reexport(r *http.Request) (string, error) {
+	e, err := a.readExport(r)
+	if err != nil {
+		return "", err
+	}
+
+	for _, c := range e.Clients {
+		_, err = runCommand("exportfs", "-o", c.Options, c.Host+":"+e.Path)
+		if err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("exported %s", e.Path), nil
+}
+
+// readExport finds the export from the request in /etc/exports. The clients
+// and options from /etc/exports are used so that the export can be restored
+// with the same options.
+func (a *AdminAPI) readExport(r *http.Request) (export, error) {
+	var req client.ExportRequest
+	err := decodeRequest(r, &req)
+	if err != nil {
+		return export{}, err
+	}
+	if req.Path == "" {
+		return export{}, badRequest("path is required")
+	}
+
+	exports, err := readExports(exportsFile)
+	if err != nil {
+		return export{}, err
+	}
+
+	e, found := findExport(exports, req.Path)
+	if !found {
+		return export{}, &requestError{http.StatusNotFound, fmt.Errorf("export %s not found in %s", req.Path, exportsFile)}
+	}
+	for _, c := range e.Clients {
+		if c.Host == "" {
+			return export{}, badRequest("export %s does not specify a client", req.Path)
+		}
+	}
+	return e, nil
+}
+
+func (a *AdminAPI) flushExports(*http.Request) (string, error) {
+	_, err := runCommand("exportfs", "-f")
+	if err != nil {
+		return "", err
+	}
+	return "flushed the kernel export table", nil
+}
+
+func (a *AdminAPI) dropCaches(r *http.Request) (string, error) {
+	req := client.DropCachesRequest{Level: 3}
+	err := decodeRequest(r, &req)
+	if err != nil {
+		return "", err
+	}
+	if req.Level < 1 || req.Level > 3 {
+		return "", badRequest("level must be 1, 2 or 3")
+	}
+
+	// Write any dirty pages first, as drop_caches only drops clean pages.
+	unix.Sync()
+	err = os.WriteFile("/proc/sys/vm/drop_caches", []byte(strconv.Itoa(req.Level)), 0)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("dropped caches (level %d)", req.Level), nil
+}
+
+func (a *AdminAPI) restartCachefilesd(*http.Request) (string, error) {
+	_, err := runCommand("systemctl", "restart", "cachefilesd.service")
+	if err != nil {
+		return "", err
+	}
+	return "restarted cachefilesd", nil
+}
+
+// decodeRequest decodes the JSON body of the request. An empty body is allowed
+// for requests where all the fields are optional.
+func decodeRequest(r *http.Request, v any) error {
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxAdminRequestSize))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return badRequest("invalid request: %s", err)
+	}
+	return nil
+}
+
+func runCommand(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if msg != "" {
+			return "", fmt.Errorf("%s: %w: %s", name, err, msg)
+		}
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	return string(out), nil
+}
+
+// auditLog records every call to the admin endpoints as a JSON object per
+// line.
+type auditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+type auditEntry struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remoteAddr"`
+	ClientCert string    `json:"clientCert,omitempty"`
+	Action     string    `json:"action"`
+	Path       string    `json:"path"`
+	StatusCode int       `json:"statusCode"`
+	Message    string    `json:"message"`
+}
+
+// clientCertSubject returns the subject of the verified client certificate,
+// if any.
+func clientCertSubject(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.String()
+}
+
+func openAuditLog(name string) (*auditLog, error) {
+	// The log directory is only created by configureLogging when logging to
+	// a file, so make sure the directory exists.
+	err := os.MkdirAll(filepath.Dir(name), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{w: f}, nil
+}
+
+func (l *auditLog) Record(r *http.Request, action string, statusCode int, msg string) {
+	b, err := json.Marshal(auditEntry{
+		Time:       time.Now().UTC(),
+		RemoteAddr: r.RemoteAddr,
+		ClientCert: clientCertSubject(r),
+		Action:     action,
+		Path:       r.URL.Path,
+		StatusCode: statusCode,
+		Message:    msg,
+	})
+	if err != nil {
+		log.Printf("could not write audit log: %s", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(append(b, '\n'))
+	if err != nil {
+		log.Printf("could not write audit log: %s", err)
+	}
+}
@@ -0,0 +1,172 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-agent/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminAPI(t *testing.T) {
+	health := NewHealthChecker(HealthConfig{Interval: time.Minute})
+	health.status = func() *client.StatusResponse { return &client.StatusResponse{} }
+	health.Check()
+
+	audit := &bytes.Buffer{}
+	admin := NewAdminAPI("secret", &auditLog{w: audit}, health)
+
+	mux := http.NewServeMux()
+	registerRoutes(mux, health, nil, nil, admin)
+
+	execute := func(method, path, token, body string) (int, string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		res := w.Result()
+
+		r, err := gzip.NewReader(res.Body)
+		require.NoError(t, err)
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+
+		var msg client.AdminResponse
+		require.NoError(t, json.Unmarshal(b, &msg))
+		return res.StatusCode, msg.Message
+	}
+
+	lastAudit := func() auditEntry {
+		lines := strings.Split(strings.TrimSpace(audit.String()), "\n")
+		var e auditEntry
+		require.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &e))
+		return e
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		code, _ := execute(http.MethodPost, "/api/v1/admin/drain", "", "")
+		assert.Equal(t, http.StatusUnauthorized, code)
+
+		code, _ = execute(http.MethodPost, "/api/v1/admin/drain", "wrong", "")
+		assert.Equal(t, http.StatusUnauthorized, code)
+		assert.NoError(t, health.Ready())
+
+		e := lastAudit()
+		assert.Equal(t, "drain", e.Action)
+		assert.Equal(t, http.StatusUnauthorized, e.StatusCode)
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		code, _ := execute(http.MethodGet, "/api/v1/admin/drain", "secret", "")
+		assert.Equal(t, http.StatusMethodNotAllowed, code)
+		assert.NoError(t, health.Ready())
+	})
+
+	t.Run("drain", func(t *testing.T) {
+		code, _ := execute(http.MethodPost, "/api/v1/admin/drain", "secret", "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.EqualError(t, health.Ready(), "draining")
+		assert.NoError(t, health.Live())
+
+		e := lastAudit()
+		assert.Equal(t, "drain", e.Action)
+		assert.Equal(t, http.StatusOK, e.StatusCode)
+
+		code, _ = execute(http.MethodPost, "/api/v1/admin/undrain", "secret", "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.NoError(t, health.Ready())
+	})
+
+	t.Run("bad request", func(t *testing.T) {
+		code, msg := execute(http.MethodPost, "/api/v1/admin/cache/drop", "secret", `{"level": 4}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, "level must be 1, 2 or 3", msg)
+
+		code, _ = execute(http.MethodPost, "/api/v1/admin/exports/unexport", "secret", `{"unknown": true}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+
+		code, msg = execute(http.MethodPost, "/api/v1/admin/exports/unexport", "secret", `{}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, "path is required", msg)
+	})
+}
+
+func TestAdminDisabled(t *testing.T) {
+	mux := http.NewServeMux()
+	registerRoutes(mux, NewHealthChecker(defaultHealthConfig), nil, nil, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/drain", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	// Unknown paths fall through to the node info handler, which only allows
+	// GET requests.
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Result().StatusCode)
+}
+
+func TestAdminClientCert(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "operator"}}
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+
+	admin := NewAdminAPI("", &auditLog{w: io.Discard}, nil)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/drain", nil)
+	req.TLS = verified
+	assert.False(t, admin.authorized(req), "client certificates are not enabled")
+
+	admin.clientCert = true
+	assert.True(t, admin.authorized(req))
+	assert.Equal(t, "CN=operator", clientCertSubject(req))
+
+	// An empty token must never match.
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/admin/drain", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	assert.False(t, admin.authorized(req))
+
+	req.TLS = &tls.ConnectionState{}
+	assert.False(t, admin.authorized(req), "unverified TLS connection")
+}
+
+func TestOpenAuditLog(t *testing.T) {
+	// The log directory does not exist when not logging to a file.
+	name := filepath.Join(t.TempDir(), "knfsd-agent", "audit.log")
+	audit, err := openAuditLog(name)
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/admin/drain", nil)
+	audit.Record(r, "drain", http.StatusOK, "draining")
+
+	b, err := os.ReadFile(name)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"action":"drain"`)
+}
@@ -17,15 +17,25 @@
 package main
 
 import (
+	"bufio"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-agent/client"
 	"golang.org/x/sys/unix"
 )
 
 func handleCacheUsage(*http.Request) (*client.CacheUsageResponse, error) {
+	return readCacheUsage("/var/cache/fscache")
+}
+
+func readCacheUsage(dir string) (*client.CacheUsageResponse, error) {
 	var s unix.Statfs_t
-	err := unix.Statfs("/var/cache/fscache", &s)
+	err := unix.Statfs(dir, &s)
 	if err != nil {
 		return nil, err
 	}
@@ -48,3 +58,115 @@ func handleCacheUsage(*http.Request) (*client.CacheUsageResponse, error) {
 		FilesFree:  s.Ffree,
 	}, nil
 }
+
+// cachefilesdConfig holds the culling thresholds from /etc/cachefilesd.conf as
+// percentages of the cache's blocks and files.
+type cachefilesdConfig struct {
+	Dir string
+
+	BRun, BCull, BStop int
+	FRun, FCull, FStop int
+}
+
+// defaultCachefilesdConfig has the defaults used by cachefilesd when a limit
+// is not set in the config file.
+var defaultCachefilesdConfig = cachefilesdConfig{
+	Dir:  "/var/cache/fscache",
+	BRun: 10, BCull: 7, BStop: 3,
+	FRun: 10, FCull: 7, FStop: 3,
+}
+
+func readCachefilesdConfig(name string) (cachefilesdConfig, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return cachefilesdConfig{}, err
+	}
+	defer f.Close()
+	return parseCachefilesdConfig(f)
+}
+
+func parseCachefilesdConfig(r io.Reader) (cachefilesdConfig, error) {
+	cfg := defaultCachefilesdConfig
+	limits := map[string]*int{
+		"brun":  &cfg.BRun,
+		"bcull": &cfg.BCull,
+		"bstop": &cfg.BStop,
+		"frun":  &cfg.FRun,
+		"fcull": &cfg.FCull,
+		"fstop": &cfg.FStop,
+	}
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, " ")
+		value = strings.TrimSpace(value)
+		if key == "dir" {
+			cfg.Dir = value
+			continue
+		}
+
+		limit, found := limits[key]
+		if !found {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+		if err != nil {
+			return cachefilesdConfig{}, fmt.Errorf("invalid %s \"%s\"", key, value)
+		}
+		*limit = n
+	}
+	return cfg, s.Err()
+}
+
+// checkCacheSpace checks the free space in the cache against the culling
+// limits. When the free blocks or files drop below bstop or fstop cachefilesd
+// stops caching new data, so every read goes to the source server.
+func (sh *ServiceHealth) checkCacheSpace() {
+	cfg, err := readCachefilesdConfig(cachefilesdConfigFile)
+	if err != nil {
+		sh.Warn("cache space", err)
+		return
+	}
+
+	var s unix.Statfs_t
+	err = unix.Statfs(cfg.Dir, &s)
+	if err != nil {
+		sh.Fail("cache space", err)
+		return
+	}
+
+	health, err := cacheSpaceHealth(cfg, s.Bavail, s.Blocks, s.Ffree, s.Files)
+	sh.Add("cache space", health, err)
+}
+
+func cacheSpaceHealth(cfg cachefilesdConfig, blocksFree, blocks, filesFree, files uint64) (client.Check, error) {
+	blocksPercent := percent(blocksFree, blocks)
+	filesPercent := percent(filesFree, files)
+
+	switch {
+	case blocksPercent < cfg.BStop:
+		return client.CHECK_FAIL, fmt.Errorf("cache is full, %d%% of blocks are free (bstop %d%%)", blocksPercent, cfg.BStop)
+	case filesPercent < cfg.FStop:
+		return client.CHECK_FAIL, fmt.Errorf("cache is full, %d%% of files are free (fstop %d%%)", filesPercent, cfg.FStop)
+	case blocksPercent < cfg.BCull:
+		return client.CHECK_WARN, fmt.Errorf("cache is culling, %d%% of blocks are free (bcull %d%%)", blocksPercent, cfg.BCull)
+	case filesPercent < cfg.FCull:
+		return client.CHECK_WARN, fmt.Errorf("cache is culling, %d%% of files are free (fcull %d%%)", filesPercent, cfg.FCull)
+	default:
+		return client.CHECK_PASS, nil
+	}
+}
+
+// percent returns n as a percentage of total, rounded down the same as
+// cachefilesd.
+func percent(n, total uint64) int {
+	if total == 0 {
+		return 100
+	}
+	return int(n * 100 / total)
+}
@@ -0,0 +1,65 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-agent/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCachefilesdConfig(t *testing.T) {
+	input := strings.Join([]string{
+		"# comment",
+		"dir /var/cache/fscache",
+		"tag mycache",
+		"brun 20%",
+		"bcull 7%",
+		"bstop 3%",
+		"frun 20%",
+	}, "\n")
+
+	cfg, err := parseCachefilesdConfig(strings.NewReader(input))
+	require.NoError(t, err)
+	assert.Equal(t, cachefilesdConfig{
+		Dir:  "/var/cache/fscache",
+		BRun: 20, BCull: 7, BStop: 3,
+		FRun: 20, FCull: 7, FStop: 3,
+	}, cfg)
+
+	_, err = parseCachefilesdConfig(strings.NewReader("bstop x"))
+	assert.Error(t, err)
+}
+
+func TestCacheSpaceHealth(t *testing.T) {
+	cfg := defaultCachefilesdConfig
+
+	health, err := cacheSpaceHealth(cfg, 50, 100, 50, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, client.CHECK_PASS, health)
+
+	health, err = cacheSpaceHealth(cfg, 5, 100, 50, 100)
+	assert.Error(t, err)
+	assert.Equal(t, client.CHECK_WARN, health)
+
+	health, err = cacheSpaceHealth(cfg, 50, 100, 2, 100)
+	assert.EqualError(t, err, "cache is full, 2% of files are free (fstop 3%)")
+	assert.Equal(t, client.CHECK_FAIL, health)
+}
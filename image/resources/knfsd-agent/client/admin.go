@@ -0,0 +1,81 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package client
+
+import "context"
+
+// AdminResponse is returned by the admin endpoints.
+type AdminResponse struct {
+	Message string `json:"message"`
+}
+
+// ExportRequest selects the export for the unexport and reexport endpoints.
+// The path is the path from /etc/exports, relative to the NFS root.
+type ExportRequest struct {
+	Path string `json:"path"`
+}
+
+// DropCachesRequest sets the value written to /proc/sys/vm/drop_caches.
+// 1 drops the page cache, 2 drops the dentries and inodes (slab), and 3 drops
+// both. Defaults to 3 if not set.
+type DropCachesRequest struct {
+	Level int `json:"level"`
+}
+
+// Drain marks the proxy as not ready, so that load balancers stop sending new
+// connections to the proxy.
+func (c *KnfsdAgentClient) Drain(ctx context.Context) (*AdminResponse, error) {
+	var v *AdminResponse
+	err := c.post(ctx, "api/v1/admin/drain", nil, &v)
+	return v, err
+}
+
+func (c *KnfsdAgentClient) Undrain(ctx context.Context) (*AdminResponse, error) {
+	var v *AdminResponse
+	err := c.post(ctx, "api/v1/admin/undrain", nil, &v)
+	return v, err
+}
+
+func (c *KnfsdAgentClient) Unexport(ctx context.Context, path string) (*AdminResponse, error) {
+	var v *AdminResponse
+	err := c.post(ctx, "api/v1/admin/exports/unexport", ExportRequest{Path: path}, &v)
+	return v, err
+}
+
+func (c *KnfsdAgentClient) Reexport(ctx context.Context, path string) (*AdminResponse, error) {
+	var v *AdminResponse
+	err := c.post(ctx, "api/v1/admin/exports/reexport", ExportRequest{Path: path}, &v)
+	return v, err
+}
+
+func (c *KnfsdAgentClient) FlushExports(ctx context.Context) (*AdminResponse, error) {
+	var v *AdminResponse
+	err := c.post(ctx, "api/v1/admin/exports/flush", nil, &v)
+	return v, err
+}
+
+func (c *KnfsdAgentClient) DropCaches(ctx context.Context, level int) (*AdminResponse, error) {
+	var v *AdminResponse
+	err := c.post(ctx, "api/v1/admin/cache/drop", DropCachesRequest{Level: level}, &v)
+	return v, err
+}
+
+func (c *KnfsdAgentClient) RestartCachefilesd(ctx context.Context) (*AdminResponse, error) {
+	var v *AdminResponse
+	err := c.post(ctx, "api/v1/admin/cachefilesd/restart", nil, &v)
+	return v, err
+}
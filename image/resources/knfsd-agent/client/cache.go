@@ -16,6 +16,11 @@
 
 package client
 
+import (
+	"context"
+	"time"
+)
+
 type CacheUsageResponse struct {
 	BytesTotal     uint64 `json:"bytesTotal"`
 	BytesUsed      uint64 `json:"bytesUsed"`
@@ -33,8 +38,138 @@ type CacheUsageResponse struct {
 	FilesFree  uint64 `json:"filesFree"`
 }
 
-func (c *KnfsdAgentClient) CacheUsage() (*CacheUsageResponse, error) {
+func (c *KnfsdAgentClient) CacheUsage(ctx context.Context) (*CacheUsageResponse, error) {
 	var v *CacheUsageResponse
-	err := c.get("api/v1/cache/usage", &v)
+	err := c.get(ctx, "api/v1/cache/usage", &v)
+	return v, err
+}
+
+// FSCacheStatsResponse is parsed from /proc/fs/fscache/stats. The typed fields
+// are for the statistics reported by the v5.17+ kernel. All the statistics
+// are included in Sections, keyed by the section and counter name from the
+// stats file, for example Sections["Cookies"]["n"].
+type FSCacheStatsResponse struct {
+	Cookies       FSCacheCookies    `json:"cookies"`
+	Acquire       FSCacheAcquire    `json:"acquire"`
+	LRU           FSCacheLRU        `json:"lru"`
+	Invalidations uint64            `json:"invalidations"`
+	Updates       uint64            `json:"updates"`
+	Relinquishes  FSCacheRelinquish `json:"relinquishes"`
+	NoSpace       FSCacheNoSpace    `json:"noSpace"`
+	IO            FSCacheIO         `json:"io"`
+	Retrievals    FSCacheRetrievals `json:"retrievals"`
+
+	Sections map[string]map[string]uint64 `json:"sections"`
+}
+
+type FSCacheCookies struct {
+	Data    uint64 `json:"data"`
+	Volumes uint64 `json:"volumes"`
+}
+
+type FSCacheAcquire struct {
+	Requests uint64 `json:"requests"`
+	OK       uint64 `json:"ok"`
+	OOM      uint64 `json:"oom"`
+}
+
+type FSCacheLRU struct {
+	Cookies uint64 `json:"cookies"`
+	Expired uint64 `json:"expired"`
+	Removed uint64 `json:"removed"`
+	Dropped uint64 `json:"dropped"`
+}
+
+type FSCacheRelinquish struct {
+	Requests uint64 `json:"requests"`
+	Retire   uint64 `json:"retire"`
+	Drop     uint64 `json:"drop"`
+}
+
+// FSCacheNoSpace counts the writes and creates that failed because the cache
+// was full, and the objects culled to make space.
+type FSCacheNoSpace struct {
+	Writes  uint64 `json:"writes"`
+	Creates uint64 `json:"creates"`
+	Culled  uint64 `json:"culled"`
+}
+
+type FSCacheIO struct {
+	Reads  uint64 `json:"reads"`
+	Writes uint64 `json:"writes"`
+}
+
+// FSCacheRetrievals counts the read subrequests. Hits are read from the cache,
+// misses are downloaded from the source server.
+type FSCacheRetrievals struct {
+	Hits         uint64 `json:"hits"`
+	HitsFailed   uint64 `json:"hitsFailed"`
+	Misses       uint64 `json:"misses"`
+	MissesFailed uint64 `json:"missesFailed"`
+}
+
+// CachefilesdResponse reports the cachefilesd culling limits and the current
+// free space. The limits and free space are percentages.
+type CachefilesdResponse struct {
+	Dir string `json:"dir"`
+
+	BRun  int `json:"brun"`
+	BCull int `json:"bcull"`
+	BStop int `json:"bstop"`
+	FRun  int `json:"frun"`
+	FCull int `json:"fcull"`
+	FStop int `json:"fstop"`
+
+	BlocksFree int `json:"blocksFree"`
+	FilesFree  int `json:"filesFree"`
+
+	// State is "ok", "culling" when cachefilesd is removing old files, or
+	// "full" when cachefilesd has stopped caching new data.
+	State string `json:"state"`
+}
+
+type CacheVolumesResponse struct {
+	ScannedAt time.Time `json:"scannedAt"`
+	Duration  Duration  `json:"duration"`
+
+	// Sampled is true if only some of the cache directories were scanned, in
+	// which case the bytes and files are estimates.
+	Sampled bool          `json:"sampled"`
+	Volumes []CacheVolume `json:"volumes"`
+}
+
+// CacheVolume is an FS-Cache volume. NFS creates a volume for each
+// filesystem on the source server.
+type CacheVolume struct {
+	Name string `json:"name"`
+
+	// Server, Version and FSID are decoded from the name of NFS volumes.
+	Server  string `json:"server,omitempty"`
+	Version string `json:"version,omitempty"`
+	FSID    string `json:"fsid,omitempty"`
+
+	// Exports lists the exports that could be using the volume, matched by
+	// server and NFS version.
+	Exports []string `json:"exports"`
+
+	Bytes uint64 `json:"bytes"`
+	Files uint64 `json:"files"`
+}
+
+func (c *KnfsdAgentClient) FSCacheStats(ctx context.Context) (*FSCacheStatsResponse, error) {
+	var v *FSCacheStatsResponse
+	err := c.get(ctx, "api/v1/cache/fscache/stats", &v)
+	return v, err
+}
+
+func (c *KnfsdAgentClient) Cachefilesd(ctx context.Context) (*CachefilesdResponse, error) {
+	var v *CachefilesdResponse
+	err := c.get(ctx, "api/v1/cache/cachefilesd", &v)
+	return v, err
+}
+
+func (c *KnfsdAgentClient) CacheVolumes(ctx context.Context) (*CacheVolumesResponse, error) {
+	var v *CacheVolumesResponse
+	err := c.get(ctx, "api/v1/cache/volumes", &v)
 	return v, err
 }
@@ -17,31 +17,279 @@
 package client
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 )
 
+// Error is returned when the agent responds with an error status code. The
+// message is the message from the agent's response, if any.
+type Error struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, msg)
+}
+
+// IsStatus returns true if err is an *Error with the status code.
+func IsStatus(err error, statusCode int) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == statusCode
+}
+
+// RetryPolicy configures how GET requests are retried. Requests are retried
+// if the request fails to connect, or the agent responds with 502, 503 or 504.
+//
+// Admin requests change the state of the proxy, so are never retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first
+	// attempt. Set to 1 to disable retries.
+	MaxAttempts int
+
+	// MinBackoff is the delay before the first retry. The delay doubles for
+	// each retry up to MaxBackoff, with jitter.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	MinBackoff:  250 * time.Millisecond,
+	MaxBackoff:  2 * time.Second,
+}
+
+type Option func(*KnfsdAgentClient)
+
+// WithToken sets the bearer token used for the admin endpoints.
+func WithToken(token string) Option {
+	return func(c *KnfsdAgentClient) {
+		c.token = token
+	}
+}
+
+// WithRetry sets the retry policy, replacing DefaultRetryPolicy.
+func WithRetry(r RetryPolicy) Option {
+	return func(c *KnfsdAgentClient) {
+		c.retry = r
+	}
+}
+
 type KnfsdAgentClient struct {
 	c       *http.Client
 	baseURL string
+	token   string
+	retry   RetryPolicy
+}
+
+func NewKnfsdAgentClient(c *http.Client, baseURL string, opts ...Option) *KnfsdAgentClient {
+	client := &KnfsdAgentClient{
+		c:       c,
+		baseURL: baseURL,
+		retry:   DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(client)
+	}
+	return client
 }
 
-func NewKnfsdAgentClient(c *http.Client, baseURL string) *KnfsdAgentClient {
-	return &KnfsdAgentClient{c, baseURL}
+// BaseURL returns the URL of the agent.
+func (c *KnfsdAgentClient) BaseURL() string {
+	return c.baseURL
 }
 
-func (c *KnfsdAgentClient) get(path string, v any) error {
-	url, err := url.JoinPath(c.baseURL, path)
+func (c *KnfsdAgentClient) get(ctx context.Context, path string, v any) error {
+	return c.getQuery(ctx, path, nil, v)
+}
+
+func (c *KnfsdAgentClient) getQuery(ctx context.Context, path string, query url.Values, v any) error {
+	res, err := c.do(ctx, http.MethodGet, path, query, nil)
 	if err != nil {
 		return err
 	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(v)
+}
 
-	res, err := c.c.Get(url)
+func (c *KnfsdAgentClient) post(ctx context.Context, path string, body any, v any) error {
+	var b []byte
+	if body != nil {
+		var err error
+		b, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	res, err := c.do(ctx, http.MethodPost, path, nil, b)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
-
 	return json.NewDecoder(res.Body).Decode(v)
 }
+
+// do sends the request, retrying GET requests. If the response is successful
+// the caller must close the response body.
+func (c *KnfsdAgentClient) do(ctx context.Context, method, path string, query url.Values, body []byte) (*http.Response, error) {
+	u, err := url.JoinPath(c.baseURL, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	attempts := 1
+	if method == http.MethodGet && c.retry.MaxAttempts > 1 {
+		attempts = c.retry.MaxAttempts
+	}
+
+	backoff := c.retry.MinBackoff
+	for attempt := 1; ; attempt++ {
+		res, err := c.send(ctx, method, u, body)
+		if err == nil || attempt >= attempts || !shouldRetry(err) {
+			return res, err
+		}
+
+		// Full jitter, so that requests to many proxies do not retry at the
+		// same time.
+		pause := time.Duration(rand.Int63n(int64(backoff) + 1))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pause):
+		}
+
+		backoff *= 2
+		if backoff > c.retry.MaxBackoff {
+			backoff = c.retry.MaxBackoff
+		}
+	}
+}
+
+func (c *KnfsdAgentClient) send(ctx context.Context, method, u string, body []byte) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	res, err := c.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+
+	defer res.Body.Close()
+	return nil, newError(req, res)
+}
+
+// newError reads the message from the error response. The JSON endpoints
+// return {"message": "..."}, while the health endpoints return plain text.
+func newError(req *http.Request, res *http.Response) error {
+	e := &Error{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		StatusCode: res.StatusCode,
+	}
+
+	b, err := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+	if err != nil {
+		return e
+	}
+
+	var msg struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(b, &msg) == nil {
+		e.Message = msg.Message
+	} else {
+		e.Message = strings.TrimSpace(string(b))
+	}
+	return e
+}
+
+func shouldRetry(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		switch e.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// Network errors, such as connection refused while the agent is starting.
+	return true
+}
+
+// Healthz returns nil if the proxy is live, otherwise an *Error with the
+// reason the proxy is not live.
+func (c *KnfsdAgentClient) Healthz(ctx context.Context) error {
+	return c.health(ctx, "healthz")
+}
+
+// Readyz returns nil if the proxy is ready, otherwise an *Error with the
+// reason the proxy is not ready.
+func (c *KnfsdAgentClient) Readyz(ctx context.Context) error {
+	return c.health(ctx, "readyz")
+}
+
+func (c *KnfsdAgentClient) health(ctx context.Context, path string) error {
+	// Do not retry, the caller wants the current state.
+	u, err := url.JoinPath(c.baseURL, path)
+	if err != nil {
+		return err
+	}
+	res, err := c.send(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// Metrics returns the Prometheus metrics in the text exposition format.
+func (c *KnfsdAgentClient) Metrics(ctx context.Context) ([]byte, error) {
+	res, err := c.do(ctx, http.MethodGet, "metrics", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return io.ReadAll(res.Body)
+}
@@ -0,0 +1,174 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	MinBackoff:  time.Millisecond,
+	MaxBackoff:  time.Millisecond,
+}
+
+func newTestClient(t *testing.T, h http.HandlerFunc, opts ...Option) *KnfsdAgentClient {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	opts = append([]Option{WithRetry(testRetryPolicy)}, opts...)
+	return NewKnfsdAgentClient(srv.Client(), srv.URL, opts...)
+}
+
+func TestErrorMessage(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message":"invalid window"}`))
+	})
+
+	_, err := c.NFSServerRates(context.Background(), time.Minute)
+	require.Error(t, err)
+
+	var e *Error
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, http.MethodGet, e.Method)
+	assert.Equal(t, http.StatusBadRequest, e.StatusCode)
+	assert.Equal(t, "invalid window", e.Message)
+	assert.True(t, IsStatus(err, http.StatusBadRequest))
+}
+
+func TestErrorPlainText(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("draining\n"))
+	})
+
+	err := c.Readyz(context.Background())
+	var e *Error
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, "draining", e.Message)
+}
+
+func TestRetry(t *testing.T) {
+	var calls atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"kernel":"6.1.0"}`))
+	})
+
+	os, err := c.GetOS(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "6.1.0", os.Kernel)
+	assert.EqualValues(t, 3, calls.Load())
+}
+
+func TestRetryGiveUp(t *testing.T) {
+	var calls atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	_, err := c.GetOS(context.Background())
+	assert.True(t, IsStatus(err, http.StatusBadGateway))
+	assert.EqualValues(t, 3, calls.Load())
+}
+
+func TestNoRetry(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(*KnfsdAgentClient) error
+		code int
+	}{
+		{
+			name: "client error",
+			call: func(c *KnfsdAgentClient) error {
+				_, err := c.GetOS(context.Background())
+				return err
+			},
+			code: http.StatusNotFound,
+		},
+		{
+			name: "post",
+			call: func(c *KnfsdAgentClient) error {
+				_, err := c.Drain(context.Background())
+				return err
+			},
+			code: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				w.WriteHeader(tt.code)
+			})
+
+			err := tt.call(c)
+			assert.True(t, IsStatus(err, tt.code))
+			assert.EqualValues(t, 1, calls.Load())
+		})
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/api/v1/admin/exports/unexport", r.URL.Path)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		w.Write([]byte(`{"message":"unexported /files"}`))
+	}, WithToken("secret"))
+
+	res, err := c.Unexport(context.Background(), "/files")
+	require.NoError(t, err)
+	assert.Equal(t, "unexported /files", res.Message)
+}
+
+func TestStreamRates(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/rates/stream", r.URL.Path)
+		assert.Equal(t, "nfs-server", r.URL.Query().Get("events"))
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("event: nfs-server\ndata: {\"rpc\":{\"count\":1.5}}\n\n"))
+		w.Write([]byte("event: nfs-server\ndata: {\"rpc\":{\"count\":2.5}}\n\n"))
+	})
+
+	var events []RatesEvent
+	err := c.StreamRates(context.Background(), []string{"nfs-server"}, 0, func(e RatesEvent) error {
+		events = append(events, e)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "nfs-server", events[0].Event)
+	assert.JSONEq(t, `{"rpc":{"count":2.5}}`, string(events[1].Data))
+}
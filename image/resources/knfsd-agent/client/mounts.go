@@ -16,6 +16,8 @@
 
 package client
 
+import "context"
+
 type MountResponse struct {
 	Mounts []Mount `json:"mounts"`
 }
@@ -100,14 +102,57 @@ type NFSOperationStats struct {
 	Errors                uint64 `json:"errors"`
 }
 
-func (c *KnfsdAgentClient) GetMounts() (*MountResponse, error) {
+func (c *KnfsdAgentClient) GetMounts(ctx context.Context) (*MountResponse, error) {
 	var v *MountResponse
-	err := c.get("api/v1/mounts", &v)
+	err := c.get(ctx, "api/v1/mounts", &v)
 	return v, err
 }
 
-func (c *KnfsdAgentClient) GetMountStats() (*MountStatsResponse, error) {
+func (c *KnfsdAgentClient) GetMountStats(ctx context.Context) (*MountStatsResponse, error) {
 	var v *MountStatsResponse
-	err := c.get("api/v1/mountStats", &v)
+	err := c.get(ctx, "api/v1/mountStats", &v)
+	return v, err
+}
+
+type DriftType string
+
+const (
+	// DRIFT_MISSING is an intended export that is not mounted.
+	DRIFT_MISSING DriftType = "missing"
+
+	// DRIFT_EXTRA is a mount that is not in the intended configuration.
+	DRIFT_EXTRA DriftType = "extra"
+
+	// DRIFT_OPTIONS is a mount with options that do not match the intended
+	// options.
+	DRIFT_OPTIONS DriftType = "options"
+)
+
+type MountDriftResponse struct {
+	// Source is where the intended configuration was loaded from, either
+	// "file" or "metadata".
+	Source string       `json:"source"`
+	Drift  []MountDrift `json:"drift"`
+}
+
+type MountDrift struct {
+	Export  string             `json:"export"`
+	Device  string             `json:"device"`
+	Type    DriftType          `json:"type"`
+	Options []MountOptionDrift `json:"options,omitempty"`
+}
+
+// MountOptionDrift is an option that does not match the intended value. For
+// flags, such as "hard", Intended is the flag, and Actual is the opposing flag
+// that was set, such as "soft", or empty if the flag is not set.
+type MountOptionDrift struct {
+	Option   string `json:"option"`
+	Intended string `json:"intended"`
+	Actual   string `json:"actual"`
+}
+
+func (c *KnfsdAgentClient) GetMountDrift(ctx context.Context) (*MountDriftResponse, error) {
+	var v *MountDriftResponse
+	err := c.get(ctx, "api/v1/mounts/drift", &v)
 	return v, err
 }
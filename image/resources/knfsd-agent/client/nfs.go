@@ -16,6 +16,11 @@
 
 package client
 
+import (
+	"context"
+	"time"
+)
+
 type NFSClientStats struct {
 	IO      NFSIO          `json:"io"`
 	Network NFSNetwork     `json:"net"`
@@ -196,14 +201,60 @@ type NFSProc4Ops struct {
 	ReleaseLockOwner   uint64 `json:"RELEASE_LOCKOWNER"`
 }
 
-func (c *KnfsdAgentClient) NFSClientStats() (*NFSClientStats, error) {
+func (c *KnfsdAgentClient) NFSClientStats(ctx context.Context) (*NFSClientStats, error) {
 	var v *NFSClientStats
-	err := c.get("api/v1/nfs/client", &v)
+	err := c.get(ctx, "api/v1/nfs/client", &v)
 	return v, err
 }
 
-func (c *KnfsdAgentClient) NFSServerStats() (*NFSServerStats, error) {
+func (c *KnfsdAgentClient) NFSServerStats(ctx context.Context) (*NFSServerStats, error) {
 	var v *NFSServerStats
-	err := c.get("api/v1/nfs/server", &v)
+	err := c.get(ctx, "api/v1/nfs/server", &v)
+	return v, err
+}
+
+type NFSServerClientsResponse struct {
+	Clients []NFSServerClient `json:"clients"`
+}
+
+// NFSServerClient is a client connected to the NFS server. NFSv4 clients are
+// read from /proc/fs/nfsd/clients. Connections to port 2049 without an NFSv4
+// client are assumed to be NFSv3 clients, and only include the address and
+// connection counters.
+type NFSServerClient struct {
+	Address           string `json:"address"`
+	Version           string `json:"version"`
+	ClientID          string `json:"clientId,omitempty"`
+	Name              string `json:"name,omitempty"`
+	Status            string `json:"status,omitempty"`
+	SecondsSinceRenew uint64 `json:"secondsSinceRenew,omitempty"`
+
+	OpenFiles   int `json:"openFiles"`
+	Locks       int `json:"locks"`
+	Delegations int `json:"delegations"`
+	Layouts     int `json:"layouts"`
+
+	Connections   int    `json:"connections"`
+	BytesSent     uint64 `json:"bytesSent"`
+	BytesReceived uint64 `json:"bytesReceived"`
+	Retransmits   uint64 `json:"retransmits"`
+
+	// Rates is nil if there are not enough samples to calculate the rates.
+	Rates *NFSServerClientRates `json:"rates,omitempty"`
+}
+
+// NFSServerClientRates are the average rate per second of the client's
+// connection counters during the window.
+type NFSServerClientRates struct {
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	BytesSent     float64   `json:"bytesSent"`
+	BytesReceived float64   `json:"bytesReceived"`
+	Retransmits   float64   `json:"retransmits"`
+}
+
+func (c *KnfsdAgentClient) NFSServerClients(ctx context.Context, window time.Duration) (*NFSServerClientsResponse, error) {
+	var v *NFSServerClientsResponse
+	err := c.getQuery(ctx, "api/v1/nfs/server/clients", windowQuery(window), &v)
 	return v, err
 }
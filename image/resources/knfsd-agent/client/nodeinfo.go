@@ -16,6 +16,8 @@
 
 package client
 
+import "context"
+
 type NodeInfo struct {
 	Name            string `json:"name"`
 	Hostname        string `json:"hostname"`
@@ -29,8 +31,8 @@ type NodeInfo struct {
 	Image       string `json:"image"`
 }
 
-func (c *KnfsdAgentClient) NodeInfo() (*NodeInfo, error) {
+func (c *KnfsdAgentClient) NodeInfo(ctx context.Context) (*NodeInfo, error) {
 	var v *NodeInfo
-	err := c.get("api/v1/nodeInfo", &v)
+	err := c.get(ctx, "api/v1/nodeInfo", &v)
 	return v, err
 }
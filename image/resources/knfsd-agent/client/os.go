@@ -16,13 +16,15 @@
 
 package client
 
+import "context"
+
 type OSResponse struct {
 	Kernel string            `json:"kernel"`
 	OS     map[string]string `json:"os"`
 }
 
-func (c *KnfsdAgentClient) GetOS() (*OSResponse, error) {
+func (c *KnfsdAgentClient) GetOS(ctx context.Context) (*OSResponse, error) {
 	var v *OSResponse
-	err := c.get("api/v1/os", &v)
+	err := c.get(ctx, "api/v1/os", &v)
 	return v, err
 }
@@ -0,0 +1,210 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The rate endpoints report the average rate per second of each counter over
+// a window, calculated from the samples recorded by the agent.
+//
+// Start and End are the times of the two samples used to calculate the rates,
+// so the actual window may be slightly shorter than requested.
+
+type NFSServerRates struct {
+	Start    time.Time          `json:"start"`
+	End      time.Time          `json:"end"`
+	IO       NFSIORates         `json:"io"`
+	Network  NFSNetworkRates    `json:"net"`
+	RPC      NFSServerRPCRates  `json:"rpc"`
+	Proc3    map[string]float64 `json:"proc3"`
+	Proc4    map[string]float64 `json:"proc4"`
+	Proc4Ops map[string]float64 `json:"proc4ops"`
+}
+
+type NFSClientRates struct {
+	Start   time.Time          `json:"start"`
+	End     time.Time          `json:"end"`
+	IO      NFSIORates         `json:"io"`
+	Network NFSNetworkRates    `json:"net"`
+	RPC     NFSClientRPCRates  `json:"rpc"`
+	Proc3   map[string]float64 `json:"proc3"`
+	Proc4   map[string]float64 `json:"proc4"`
+}
+
+// NFSIORates are in bytes per second.
+type NFSIORates struct {
+	Read  float64 `json:"read"`
+	Write float64 `json:"write"`
+}
+
+type NFSNetworkRates struct {
+	TotalPackets   float64 `json:"totalPackets"`
+	UDPPackets     float64 `json:"udpPackets"`
+	TCPPackets     float64 `json:"tcpPackets"`
+	TCPConnections float64 `json:"tcpConnections"`
+}
+
+type NFSClientRPCRates struct {
+	Count           float64 `json:"count"`
+	AuthRefreshes   float64 `json:"authRefreshes"`
+	Retransmissions float64 `json:"retransmissions"`
+}
+
+type NFSServerRPCRates struct {
+	Count     float64 `json:"count"`
+	BadTotal  float64 `json:"badTotal"`
+	BadFormat float64 `json:"badFormat"`
+	BadAuth   float64 `json:"badAuth"`
+}
+
+type MountStatsRatesResponse struct {
+	Start  time.Time    `json:"start"`
+	End    time.Time    `json:"end"`
+	Mounts []MountRates `json:"mounts"`
+}
+
+type MountRates struct {
+	Device     string              `json:"device"`
+	Mount      string              `json:"mount"`
+	Export     string              `json:"export"`
+	Bytes      NFSByteRates        `json:"bytes"`
+	Events     map[string]float64  `json:"events"`
+	Operations []NFSOperationRates `json:"operations"`
+}
+
+// NFSByteRates are in bytes per second, except for ReadPages and WritePages
+// which are in pages per second.
+type NFSByteRates struct {
+	NormalRead  float64 `json:"normalRead"`
+	NormalWrite float64 `json:"normalWrite"`
+	DirectRead  float64 `json:"directRead"`
+	DirectWrite float64 `json:"directWrite"`
+	ServerRead  float64 `json:"serverRead"`
+	ServerWrite float64 `json:"serverWrite"`
+	ReadPages   float64 `json:"readPages"`
+	WritePages  float64 `json:"writePages"`
+}
+
+// NFSOperationRates reports the rate per second of each counter, except for
+// the milliseconds which are the average per request during the window.
+type NFSOperationRates struct {
+	Operation             string  `json:"operation"`
+	Requests              float64 `json:"requests"`
+	Transmissions         float64 `json:"transmissions"`
+	Retries               float64 `json:"retries"`
+	MajorTimeouts         float64 `json:"majorTimeouts"`
+	BytesSent             float64 `json:"bytesSent"`
+	BytesReceived         float64 `json:"bytesReceived"`
+	Errors                float64 `json:"errors"`
+	QueueMilliseconds     float64 `json:"queueMilliseconds"`
+	RTTMilliseconds       float64 `json:"rttMilliseconds"`
+	ExecutionMilliseconds float64 `json:"executionMilliseconds"`
+}
+
+func (c *KnfsdAgentClient) NFSServerRates(ctx context.Context, window time.Duration) (*NFSServerRates, error) {
+	var v *NFSServerRates
+	err := c.getQuery(ctx, "api/v1/nfs/server/rates", windowQuery(window), &v)
+	return v, err
+}
+
+func (c *KnfsdAgentClient) NFSClientRates(ctx context.Context, window time.Duration) (*NFSClientRates, error) {
+	var v *NFSClientRates
+	err := c.getQuery(ctx, "api/v1/nfs/client/rates", windowQuery(window), &v)
+	return v, err
+}
+
+func (c *KnfsdAgentClient) GetMountStatsRates(ctx context.Context, window time.Duration) (*MountStatsRatesResponse, error) {
+	var v *MountStatsRatesResponse
+	err := c.getQuery(ctx, "api/v1/mountStats/rates", windowQuery(window), &v)
+	return v, err
+}
+
+func windowQuery(window time.Duration) url.Values {
+	q := url.Values{}
+	if window > 0 {
+		q.Set("window", window.String())
+	}
+	return q
+}
+
+// RatesEvent is an event from the rates stream. Data is the JSON encoded
+// rates for the event, NFSServerRates for "nfs-server", NFSClientRates for
+// "nfs-client" and MountStatsRatesResponse for "mounts".
+type RatesEvent struct {
+	Event string
+	Data  json.RawMessage
+}
+
+// StreamRates calls fn for each event from the rates stream until ctx is
+// cancelled, fn returns an error, or the agent closes the stream. If events
+// is empty the agent's default events are sent. If window is zero the rates
+// are since the previous sample.
+func (c *KnfsdAgentClient) StreamRates(ctx context.Context, events []string, window time.Duration, fn func(RatesEvent) error) error {
+	q := windowQuery(window)
+	if len(events) > 0 {
+		q.Set("events", strings.Join(events, ","))
+	}
+
+	u, err := url.JoinPath(c.baseURL, "api/v1/rates/stream")
+	if err != nil {
+		return err
+	}
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+
+	res, err := c.send(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	s := bufio.NewScanner(res.Body)
+	// mounts events can be large when there are many mounts
+	s.Buffer(nil, 16*1024*1024)
+
+	var e RatesEvent
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case line == "":
+			if e.Event != "" && e.Data != nil {
+				if err := fn(e); err != nil {
+					return err
+				}
+			}
+			e = RatesEvent{}
+		case strings.HasPrefix(line, "event: "):
+			e.Event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			e.Data = json.RawMessage(strings.TrimPrefix(line, "data: "))
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return s.Err()
+}
@@ -17,6 +17,7 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 )
@@ -95,11 +96,13 @@ type ServiceCheck struct {
 }
 
 type StatusResponse struct {
+	// Health is the worst health of all the services.
+	Health   Check           `json:"health"`
 	Services []ServiceHealth `json:"services"`
 }
 
-func (c *KnfsdAgentClient) GetStatus() (*StatusResponse, error) {
+func (c *KnfsdAgentClient) GetStatus(ctx context.Context) (*StatusResponse, error) {
 	var v *StatusResponse
-	err := c.get("api/v1/status", &v)
+	err := c.get(ctx, "api/v1/status", &v)
 	return v, err
 }
@@ -0,0 +1,326 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-agent/client"
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-common/sockdiag"
+)
+
+// nfsPort is the port used by the NFS server.
+const nfsPort = 2049
+
+// nfsdClient is an NFSv4 client from /proc/fs/nfsd/clients/<id>/info.
+type nfsdClient struct {
+	ClientID          string
+	Address           netip.AddrPort
+	Name              string
+	Status            string
+	MinorVersion      int
+	SecondsSinceRenew uint64
+
+	// States is the number of states (open, lock, deleg, layout) held by the
+	// client, from /proc/fs/nfsd/clients/<id>/states.
+	States map[string]int
+}
+
+// readNFSDClients reads the NFSv4 clients. Returns no clients if the kernel
+// does not provide /proc/fs/nfsd/clients.
+func readNFSDClients(dir string) ([]nfsdClient, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var clients []nfsdClient
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		c, err := readNFSDClient(filepath.Join(dir, e.Name()))
+		if errors.Is(err, fs.ErrNotExist) {
+			// The client was removed while reading the directory.
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("client %s: %w", e.Name(), err)
+		}
+		clients = append(clients, c)
+	}
+	return clients, nil
+}
+
+func readNFSDClient(dir string) (nfsdClient, error) {
+	f, err := os.Open(filepath.Join(dir, "info"))
+	if err != nil {
+		return nfsdClient{}, err
+	}
+	defer f.Close()
+
+	c, err := parseNFSDClientInfo(f)
+	if err != nil {
+		return nfsdClient{}, err
+	}
+
+	// The states file is not available on older kernels.
+	states, err := os.Open(filepath.Join(dir, "states"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nfsdClient{}, err
+	}
+	defer states.Close()
+
+	c.States, err = parseNFSDClientStates(states)
+	return c, err
+}
+
+// parseNFSDClientInfo parses the info file, which contains "key: value" lines
+// such as:
+//
+//	clientid: 0x6d9f6a8a64f77c2e
+//	address: "10.0.0.5:815"
+//	status: confirmed
+//	name: "Linux NFSv4.2 render-01"
+//	minor version: 2
+func parseNFSDClientInfo(r io.Reader) (nfsdClient, error) {
+	var c nfsdClient
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		key, value, found := strings.Cut(s.Text(), ": ")
+		if !found {
+			continue
+		}
+		value = unquote(value)
+
+		var err error
+		switch key {
+		case "clientid":
+			c.ClientID = value
+		case "address":
+			c.Address, err = netip.ParseAddrPort(value)
+		case "status":
+			c.Status = value
+		case "name":
+			c.Name = value
+		case "minor version":
+			c.MinorVersion, err = strconv.Atoi(value)
+		case "seconds from last renew":
+			c.SecondsSinceRenew, err = strconv.ParseUint(value, 10, 64)
+		}
+		if err != nil {
+			return nfsdClient{}, fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nfsdClient{}, err
+	}
+
+	if c.ClientID == "" {
+		return nfsdClient{}, errors.New("missing clientid")
+	}
+	return c, nil
+}
+
+// unquote removes the quotes from a value. The kernel escapes non-printable
+// characters as octal, which strconv.Unquote does not always accept, so fall
+// back to trimming the quotes.
+func unquote(v string) string {
+	if len(v) < 2 || v[0] != '"' || v[len(v)-1] != '"' {
+		return v
+	}
+	if s, err := strconv.Unquote(v); err == nil {
+		return s
+	}
+	return v[1 : len(v)-1]
+}
+
+var stateTypeRegexp = regexp.MustCompile(`\btype: (\w+)`)
+
+// parseNFSDClientStates counts the states by type. Each line of the states
+// file is a state, such as `- 0x...: { type: open, access: r, ... }`.
+func parseNFSDClientStates(r io.Reader) (map[string]int, error) {
+	states := make(map[string]int)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		m := stateTypeRegexp.FindStringSubmatch(s.Text())
+		if m != nil {
+			states[m[1]]++
+		}
+	}
+	return states, s.Err()
+}
+
+// clientIndex assigns connections to clients.
+//
+// An NFSv4 client can have multiple connections (such as when using nconnect),
+// but the info file only includes the address of one connection. Connections
+// that do not match the exact address are assigned to the first NFSv4 client
+// with the same IP. Any remaining connections are grouped by IP as NFSv3
+// clients.
+type clientIndex struct {
+	clients    []client.NFSServerClient
+	byAddrPort map[netip.AddrPort]int
+	byAddr     map[netip.Addr]int
+}
+
+func newClientIndex(nfsd []nfsdClient) *clientIndex {
+	// Sort so that the clients are assigned connections consistently.
+	sort.Slice(nfsd, func(i, j int) bool { return nfsd[i].ClientID < nfsd[j].ClientID })
+
+	idx := &clientIndex{
+		byAddrPort: make(map[netip.AddrPort]int),
+		byAddr:     make(map[netip.Addr]int),
+	}
+	for i, c := range nfsd {
+		idx.clients = append(idx.clients, client.NFSServerClient{
+			Address:           c.Address.Addr().String(),
+			Version:           fmt.Sprintf("4.%d", c.MinorVersion),
+			ClientID:          c.ClientID,
+			Name:              c.Name,
+			Status:            c.Status,
+			SecondsSinceRenew: c.SecondsSinceRenew,
+			OpenFiles:         c.States["open"],
+			Locks:             c.States["lock"],
+			Delegations:       c.States["deleg"],
+			Layouts:           c.States["layout"],
+		})
+		idx.byAddrPort[c.Address] = i
+		if _, found := idx.byAddr[c.Address.Addr()]; !found {
+			idx.byAddr[c.Address.Addr()] = i
+		}
+	}
+	return idx
+}
+
+// lookup returns the client for the connection. If add is true, an NFSv3
+// client is added when there is no matching client.
+func (idx *clientIndex) lookup(conn sockdiag.TCPConnection, add bool) (int, bool) {
+	if i, found := idx.byAddrPort[conn.Remote]; found {
+		return i, true
+	}
+	if i, found := idx.byAddr[conn.Remote.Addr()]; found {
+		return i, true
+	}
+	if !add {
+		return 0, false
+	}
+
+	i := len(idx.clients)
+	idx.clients = append(idx.clients, client.NFSServerClient{
+		Address: conn.Remote.Addr().String(),
+		Version: "3",
+	})
+	idx.byAddr[conn.Remote.Addr()] = i
+	return i, true
+}
+
+func (s *Sampler) handleNFSServerClients(r *http.Request) (*client.NFSServerClientsResponse, error) {
+	window, err := s.parseWindow(r)
+	if err != nil {
+		return nil, err
+	}
+
+	nfsd, err := readNFSDClients(filepath.Join(nfsdProcDir, "clients"))
+	if err != nil {
+		return nil, err
+	}
+
+	conns, err := sockdiag.ReadTCPConnections(nfsPort)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := newClientIndex(nfsd)
+	for _, conn := range conns {
+		i, _ := idx.lookup(conn, true)
+		c := &idx.clients[i]
+		c.Connections++
+		c.BytesSent += conn.BytesSent
+		c.BytesReceived += conn.BytesReceived
+		c.Retransmits += uint64(conn.Retransmits)
+	}
+
+	// The rates are optional, as the connections are only sampled every
+	// interval.
+	prev, cur, err := s.window(window, func(v *sample) bool { return v.Connections != nil })
+	if err == nil {
+		clientRates(idx, prev, cur)
+	}
+
+	clients := idx.clients
+	sort.SliceStable(clients, func(i, j int) bool { return clients[i].Address < clients[j].Address })
+	return &client.NFSServerClientsResponse{Clients: clients}, nil
+}
+
+// clientRates calculates the rates of each client from the connections in the
+// two samples. Connections in the samples that no longer exist are ignored.
+// New connections that are not in the previous sample are also ignored, as the
+// counters include everything sent before the previous sample.
+func clientRates(idx *clientIndex, prev, cur *sample) {
+	seconds := cur.Time.Sub(prev.Time).Seconds()
+	previous := make(map[[2]netip.AddrPort]sockdiag.TCPConnection)
+	for _, conn := range prev.Connections {
+		previous[[2]netip.AddrPort{conn.Local, conn.Remote}] = conn
+	}
+
+	rates := make(map[int]*client.NFSServerClientRates)
+	for _, b := range cur.Connections {
+		a, found := previous[[2]netip.AddrPort{b.Local, b.Remote}]
+		if !found {
+			continue
+		}
+
+		i, found := idx.lookup(b, false)
+		if !found {
+			continue
+		}
+
+		r := rates[i]
+		if r == nil {
+			r = &client.NFSServerClientRates{Start: prev.Time, End: cur.Time}
+			rates[i] = r
+		}
+
+		r.BytesSent += rate(a.BytesSent, b.BytesSent, seconds)
+		r.BytesReceived += rate(a.BytesReceived, b.BytesReceived, seconds)
+		r.Retransmits += rate(uint64(a.Retransmits), uint64(b.Retransmits), seconds)
+	}
+
+	for i, r := range rates {
+		idx.clients[i].Rates = r
+	}
+}
@@ -0,0 +1,157 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-common/sockdiag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadNFSDClients(t *testing.T) {
+	clients, err := readNFSDClients("testdata/proc/fs/nfsd/clients")
+	require.NoError(t, err)
+	require.Len(t, clients, 2)
+
+	c := clients[0]
+	assert.Equal(t, "0x6d9f6a8a64f77c2e", c.ClientID)
+	assert.Equal(t, netip.MustParseAddrPort("10.0.0.5:815"), c.Address)
+	assert.Equal(t, "Linux NFSv4.2 render-01", c.Name)
+	assert.Equal(t, "confirmed", c.Status)
+	assert.Equal(t, 2, c.MinorVersion)
+	assert.Equal(t, uint64(12), c.SecondsSinceRenew)
+	assert.Equal(t, map[string]int{"open": 2, "lock": 1, "deleg": 1}, c.States)
+
+	c = clients[1]
+	assert.Equal(t, netip.MustParseAddrPort("[fd20::5]:740"), c.Address)
+	assert.Equal(t, "courtesy", c.Status)
+	assert.Empty(t, c.States)
+
+	clients, err = readNFSDClients("testdata/proc/fs/nfsd/missing")
+	assert.NoError(t, err)
+	assert.Empty(t, clients)
+}
+
+func TestClientIndex(t *testing.T) {
+	nfsd, err := readNFSDClients("testdata/proc/fs/nfsd/clients")
+	require.NoError(t, err)
+
+	conn := func(remote string, sent uint64) sockdiag.TCPConnection {
+		return sockdiag.TCPConnection{
+			Local:     netip.MustParseAddrPort("10.0.0.2:2049"),
+			Remote:    netip.MustParseAddrPort(remote),
+			BytesSent: sent,
+		}
+	}
+
+	idx := newClientIndex(nfsd)
+	for _, c := range []sockdiag.TCPConnection{
+		conn("10.0.0.5:815", 0),
+		// nconnect, additional connection from the same client
+		conn("10.0.0.5:816", 0),
+		conn("10.0.0.9:700", 0),
+		conn("10.0.0.9:701", 0),
+	} {
+		i, found := idx.lookup(c, true)
+		require.True(t, found)
+		idx.clients[i].Connections++
+	}
+
+	require.Len(t, idx.clients, 3)
+	assert.Equal(t, "4.2", idx.clients[0].Version)
+	assert.Equal(t, 2, idx.clients[0].Connections)
+	assert.Equal(t, 2, idx.clients[0].OpenFiles)
+	assert.Equal(t, "fd20::5", idx.clients[1].Address)
+	assert.Equal(t, 0, idx.clients[1].Connections)
+	assert.Equal(t, "10.0.0.9", idx.clients[2].Address)
+	assert.Equal(t, "3", idx.clients[2].Version)
+	assert.Equal(t, 2, idx.clients[2].Connections)
+
+	_, found := idx.lookup(conn("10.0.0.10:700", 0), false)
+	assert.False(t, found)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	prev := &sample{
+		Time: start,
+		Connections: []sockdiag.TCPConnection{
+			conn("10.0.0.5:815", 1000),
+			conn("10.0.0.9:700", 1000),
+		},
+	}
+	cur := &sample{
+		Time: start.Add(10 * time.Second),
+		Connections: []sockdiag.TCPConnection{
+			conn("10.0.0.5:815", 2000),
+			conn("10.0.0.5:816", 500),
+			conn("10.0.0.9:700", 1500),
+			// disconnected client that is no longer in the index
+			conn("10.0.0.10:700", 1000),
+		},
+	}
+	clientRates(idx, prev, cur)
+
+	// The new connection from 10.0.0.5:816 is not in the previous sample so
+	// is excluded, otherwise all the bytes sent before the previous sample
+	// would be included in the rate.
+	require.NotNil(t, idx.clients[0].Rates)
+	assert.Equal(t, 100.0, idx.clients[0].Rates.BytesSent)
+	assert.Nil(t, idx.clients[1].Rates)
+	assert.Equal(t, 50.0, idx.clients[2].Rates.BytesSent)
+
+	// Once the new connection is in the previous sample it is included.
+	next := &sample{
+		Time: start.Add(20 * time.Second),
+		Connections: []sockdiag.TCPConnection{
+			conn("10.0.0.5:815", 3000),
+			conn("10.0.0.5:816", 1500),
+			conn("10.0.0.9:700", 1500),
+		},
+	}
+	clientRates(idx, cur, next)
+	assert.Equal(t, 200.0, idx.clients[0].Rates.BytesSent)
+	assert.Equal(t, 0.0, idx.clients[2].Rates.BytesSent)
+}
+
+func TestClientRatesNewConnection(t *testing.T) {
+	nfsd, err := readNFSDClients("testdata/proc/fs/nfsd/clients")
+	require.NoError(t, err)
+	idx := newClientIndex(nfsd)
+
+	local := netip.MustParseAddrPort("10.0.0.2:2049")
+	remote := netip.MustParseAddrPort("10.0.0.9:700")
+	_, found := idx.lookup(sockdiag.TCPConnection{Local: local, Remote: remote}, true)
+	require.True(t, found)
+
+	// The client connected after the previous sample.
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	prev := &sample{Time: start}
+	cur := &sample{
+		Time: start.Add(10 * time.Second),
+		Connections: []sockdiag.TCPConnection{
+			{Local: local, Remote: remote, BytesSent: 1 << 30},
+		},
+	}
+	clientRates(idx, prev, cur)
+
+	for _, c := range idx.clients {
+		assert.Nil(t, c.Rates, c.Address)
+	}
+}
@@ -0,0 +1,461 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-agent/client"
+)
+
+type command struct {
+	name string
+	args []string
+	help string
+	run  func(ctx context.Context, c *client.KnfsdAgentClient, opts *options, args []string) (any, error)
+
+	// admin commands change the state of the proxy, and need to be confirmed
+	// before running against more than one proxy.
+	admin bool
+
+	// header and rows render the result of run as a table.
+	header []string
+	rows   func(v any) [][]string
+}
+
+type runFunc[T any] func(ctx context.Context, c *client.KnfsdAgentClient, opts *options, args []string) (T, error)
+
+func newCommand[T any](name, help string, args []string, run runFunc[T], header []string, rows func(T) [][]string) *command {
+	return &command{
+		name: name,
+		args: args,
+		help: help,
+		run: func(ctx context.Context, c *client.KnfsdAgentClient, opts *options, args []string) (any, error) {
+			return run(ctx, c, opts, args)
+		},
+		header: header,
+		rows: func(v any) [][]string {
+			return rows(v.(T))
+		},
+	}
+}
+
+var commands = commandMap(
+	newCommand("status", "show the status checks", nil,
+		func(ctx context.Context, c *client.KnfsdAgentClient, _ *options, _ []string) (*client.StatusResponse, error) {
+			return c.GetStatus(ctx)
+		},
+		[]string{"SERVICE", "CHECK", "RESULT", "ERROR"},
+		statusRows,
+	),
+	newCommand("health", "show whether the proxy is live and ready", nil,
+		runHealth,
+		[]string{"LIVE", "READY"},
+		func(v *healthResult) [][]string {
+			return [][]string{{v.Live, v.Ready}}
+		},
+	),
+	newCommand("nodeinfo", "show the proxy's instance details", nil,
+		func(ctx context.Context, c *client.KnfsdAgentClient, _ *options, _ []string) (*client.NodeInfo, error) {
+			return c.NodeInfo(ctx)
+		},
+		[]string{"NAME", "IP", "ZONE", "MACHINE TYPE", "IMAGE"},
+		func(v *client.NodeInfo) [][]string {
+			return [][]string{{v.Name, v.InterfaceConfig.IPAddress, v.Zone, v.MachineType, v.Image}}
+		},
+	),
+	newCommand("os", "show the kernel and OS versions", nil,
+		func(ctx context.Context, c *client.KnfsdAgentClient, _ *options, _ []string) (*client.OSResponse, error) {
+			return c.GetOS(ctx)
+		},
+		[]string{"KERNEL", "OS"},
+		func(v *client.OSResponse) [][]string {
+			return [][]string{{v.Kernel, orDash(v.OS["PRETTY_NAME"])}}
+		},
+	),
+	newCommand("cache", "show the FS-Cache disk usage", nil,
+		func(ctx context.Context, c *client.KnfsdAgentClient, _ *options, _ []string) (*client.CacheUsageResponse, error) {
+			return c.CacheUsage(ctx)
+		},
+		[]string{"SIZE", "USED", "AVAILABLE", "USE%", "FILES", "FILES USE%"},
+		func(v *client.CacheUsageResponse) [][]string {
+			return [][]string{{
+				formatBytes(float64(v.BytesTotal)),
+				formatBytes(float64(v.BytesUsed)),
+				formatBytes(float64(v.BytesAvailable)),
+				formatPercent(v.BytesUsed, v.BytesTotal),
+				formatUint(v.FilesUsed),
+				formatPercent(v.FilesUsed, v.FilesTotal),
+			}}
+		},
+	),
+	newCommand("fscache", "show the FS-Cache statistics", nil,
+		func(ctx context.Context, c *client.KnfsdAgentClient, _ *options, _ []string) (*client.FSCacheStatsResponse, error) {
+			return c.FSCacheStats(ctx)
+		},
+		[]string{"SECTION", "STAT", "VALUE"},
+		fscacheRows,
+	),
+	newCommand("cachefilesd", "show the cachefilesd culling limits", nil,
+		func(ctx context.Context, c *client.KnfsdAgentClient, _ *options, _ []string) (*client.CachefilesdResponse, error) {
+			return c.Cachefilesd(ctx)
+		},
+		[]string{"STATE", "BLOCKS FREE", "BRUN", "BCULL", "BSTOP", "FILES FREE", "FRUN", "FCULL", "FSTOP"},
+		func(v *client.CachefilesdResponse) [][]string {
+			return [][]string{{
+				v.State,
+				strconv.Itoa(v.BlocksFree) + "%",
+				strconv.Itoa(v.BRun) + "%",
+				strconv.Itoa(v.BCull) + "%",
+				strconv.Itoa(v.BStop) + "%",
+				strconv.Itoa(v.FilesFree) + "%",
+				strconv.Itoa(v.FRun) + "%",
+				strconv.Itoa(v.FCull) + "%",
+				strconv.Itoa(v.FStop) + "%",
+			}}
+		},
+	),
+	newCommand("volumes", "show the FS-Cache usage per volume", nil,
+		func(ctx context.Context, c *client.KnfsdAgentClient, _ *options, _ []string) (*client.CacheVolumesResponse, error) {
+			return c.CacheVolumes(ctx)
+		},
+		[]string{"SERVER", "VERSION", "FSID", "EXPORTS", "SIZE", "FILES"},
+		func(v *client.CacheVolumesResponse) [][]string {
+			rows := make([][]string, 0, len(v.Volumes))
+			for _, vol := range v.Volumes {
+				rows = append(rows, []string{
+					orDash(vol.Server),
+					orDash(vol.Version),
+					orDash(vol.FSID),
+					orDash(strings.Join(vol.Exports, ",")),
+					formatBytes(float64(vol.Bytes)),
+					formatUint(vol.Files),
+				})
+			}
+			return rows
+		},
+	),
+	newCommand("mounts", "show the NFS mounts", nil,
+		func(ctx context.Context, c *client.KnfsdAgentClient, _ *options, _ []string) (*client.MountResponse, error) {
+			return c.GetMounts(ctx)
+		},
+		[]string{"DEVICE", "MOUNT", "EXPORT"},
+		func(v *client.MountResponse) [][]string {
+			rows := make([][]string, 0, len(v.Mounts))
+			for _, m := range v.Mounts {
+				rows = append(rows, []string{m.Device, m.Mount, m.Export})
+			}
+			return rows
+		},
+	),
+	newCommand("drift", "show mounts that do not match the intended configuration", nil,
+		func(ctx context.Context, c *client.KnfsdAgentClient, _ *options, _ []string) (*client.MountDriftResponse, error) {
+			return c.GetMountDrift(ctx)
+		},
+		[]string{"EXPORT", "DEVICE", "TYPE", "OPTION", "INTENDED", "ACTUAL"},
+		driftRows,
+	),
+	newCommand("mountstats", "show the NFS mount statistics", nil,
+		func(ctx context.Context, c *client.KnfsdAgentClient, _ *options, _ []string) (*client.MountStatsResponse, error) {
+			return c.GetMountStats(ctx)
+		},
+		[]string{"MOUNT", "AGE", "READ", "WRITE", "REQUESTS", "RETRIES", "TIMEOUTS", "ERRORS"},
+		func(v *client.MountStatsResponse) [][]string {
+			rows := make([][]string, 0, len(v.Mounts))
+			for _, m := range v.Mounts {
+				var requests, retries, timeouts, errs uint64
+				for _, op := range m.Stats.Operations {
+					requests += op.Requests
+					retries += op.Retries
+					timeouts += op.MajorTimeouts
+					errs += op.Errors
+				}
+				rows = append(rows, []string{
+					m.Mount,
+					time.Duration(m.Stats.Age).Truncate(time.Second).String(),
+					formatBytes(float64(m.Stats.Bytes.ServerRead)),
+					formatBytes(float64(m.Stats.Bytes.ServerWrite)),
+					formatUint(requests),
+					formatUint(retries),
+					formatUint(timeouts),
+					formatUint(errs),
+				})
+			}
+			return rows
+		},
+	),
+	newCommand("nfs-server", "show the NFS server statistics", nil,
+		func(ctx context.Context, c *client.KnfsdAgentClient, _ *options, _ []string) (*client.NFSServerStats, error) {
+			return c.NFSServerStats(ctx)
+		},
+		[]string{"THREADS", "READ", "WRITE", "CONNECTIONS", "RPC", "BAD RPC"},
+		func(v *client.NFSServerStats) [][]string {
+			return [][]string{{
+				formatUint(v.Threads),
+				formatBytes(float64(v.IO.Read)),
+				formatBytes(float64(v.IO.Write)),
+				formatUint(v.Network.TCPConnections),
+				formatUint(v.RPC.Count),
+				formatUint(v.RPC.BadTotal),
+			}}
+		},
+	),
+	newCommand("nfs-client", "show the NFS client statistics", nil,
+		func(ctx context.Context, c *client.KnfsdAgentClient, _ *options, _ []string) (*client.NFSClientStats, error) {
+			return c.NFSClientStats(ctx)
+		},
+		[]string{"RPC", "RETRANSMISSIONS", "AUTH REFRESHES"},
+		func(v *client.NFSClientStats) [][]string {
+			return [][]string{{
+				formatUint(v.RPC.Count),
+				formatUint(v.RPC.Retransmissions),
+				formatUint(v.RPC.AuthRefreshes),
+			}}
+		},
+	),
+	newCommand("clients", "show the clients connected to the NFS server", nil,
+		func(ctx context.Context, c *client.KnfsdAgentClient, opts *options, _ []string) (*client.NFSServerClientsResponse, error) {
+			return c.NFSServerClients(ctx, opts.window)
+		},
+		[]string{"ADDRESS", "VERSION", "CONNECTIONS", "OPEN FILES", "LOCKS", "DELEGATIONS", "SENT/S", "RECEIVED/S", "RETRANSMITS/S"},
+		func(v *client.NFSServerClientsResponse) [][]string {
+			rows := make([][]string, 0, len(v.Clients))
+			for _, c := range v.Clients {
+				sent, received, retransmits := "-", "-", "-"
+				if c.Rates != nil {
+					sent = formatBytes(c.Rates.BytesSent)
+					received = formatBytes(c.Rates.BytesReceived)
+					retransmits = formatRate(c.Rates.Retransmits)
+				}
+				rows = append(rows, []string{
+					c.Address,
+					c.Version,
+					strconv.Itoa(c.Connections),
+					strconv.Itoa(c.OpenFiles),
+					strconv.Itoa(c.Locks),
+					strconv.Itoa(c.Delegations),
+					sent,
+					received,
+					retransmits,
+				})
+			}
+			return rows
+		},
+	),
+	newCommand("server-rates", "show the NFS server rates per second", nil,
+		func(ctx context.Context, c *client.KnfsdAgentClient, opts *options, _ []string) (*client.NFSServerRates, error) {
+			return c.NFSServerRates(ctx, opts.window)
+		},
+		[]string{"WINDOW", "READ/S", "WRITE/S", "RPC/S", "BAD RPC/S"},
+		func(v *client.NFSServerRates) [][]string {
+			return [][]string{{
+				v.End.Sub(v.Start).Round(time.Second).String(),
+				formatBytes(v.IO.Read),
+				formatBytes(v.IO.Write),
+				formatRate(v.RPC.Count),
+				formatRate(v.RPC.BadTotal),
+			}}
+		},
+	),
+	newCommand("client-rates", "show the NFS client rates per second", nil,
+		func(ctx context.Context, c *client.KnfsdAgentClient, opts *options, _ []string) (*client.NFSClientRates, error) {
+			return c.NFSClientRates(ctx, opts.window)
+		},
+		[]string{"WINDOW", "RPC/S", "RETRANSMISSIONS/S"},
+		func(v *client.NFSClientRates) [][]string {
+			return [][]string{{
+				v.End.Sub(v.Start).Round(time.Second).String(),
+				formatRate(v.RPC.Count),
+				formatRate(v.RPC.Retransmissions),
+			}}
+		},
+	),
+	newCommand("mount-rates", "show the NFS mount rates per second", nil,
+		func(ctx context.Context, c *client.KnfsdAgentClient, opts *options, _ []string) (*client.MountStatsRatesResponse, error) {
+			return c.GetMountStatsRates(ctx, opts.window)
+		},
+		[]string{"MOUNT", "READ/S", "WRITE/S", "REQUESTS/S", "RETRIES/S", "ERRORS/S"},
+		func(v *client.MountStatsRatesResponse) [][]string {
+			rows := make([][]string, 0, len(v.Mounts))
+			for _, m := range v.Mounts {
+				var requests, retries, errs float64
+				for _, op := range m.Operations {
+					requests += op.Requests
+					retries += op.Retries
+					errs += op.Errors
+				}
+				rows = append(rows, []string{
+					m.Mount,
+					formatBytes(m.Bytes.ServerRead),
+					formatBytes(m.Bytes.ServerWrite),
+					formatRate(requests),
+					formatRate(retries),
+					formatRate(errs),
+				})
+			}
+			return rows
+		},
+	),
+
+	// Admin commands
+	adminCommand("drain", "stop the load balancer sending new connections to the proxy", nil,
+		func(ctx context.Context, c *client.KnfsdAgentClient, _ []string) (*client.AdminResponse, error) {
+			return c.Drain(ctx)
+		},
+	),
+	adminCommand("undrain", "allow the load balancer to send new connections to the proxy", nil,
+		func(ctx context.Context, c *client.KnfsdAgentClient, _ []string) (*client.AdminResponse, error) {
+			return c.Undrain(ctx)
+		},
+	),
+	adminCommand("unexport", "remove an export", []string{"<path>"},
+		func(ctx context.Context, c *client.KnfsdAgentClient, args []string) (*client.AdminResponse, error) {
+			return c.Unexport(ctx, args[0])
+		},
+	),
+	adminCommand("reexport", "restore an export removed by unexport", []string{"<path>"},
+		func(ctx context.Context, c *client.KnfsdAgentClient, args []string) (*client.AdminResponse, error) {
+			return c.Reexport(ctx, args[0])
+		},
+	),
+	adminCommand("flush-exports", "flush the NFS server's export caches", nil,
+		func(ctx context.Context, c *client.KnfsdAgentClient, _ []string) (*client.AdminResponse, error) {
+			return c.FlushExports(ctx)
+		},
+	),
+	adminCommand("drop-caches", "drop the kernel caches, level is 1, 2 or 3", []string{"<level>"},
+		func(ctx context.Context, c *client.KnfsdAgentClient, args []string) (*client.AdminResponse, error) {
+			level, err := strconv.Atoi(args[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid level %q", args[0])
+			}
+			return c.DropCaches(ctx, level)
+		},
+	),
+	adminCommand("restart-cachefilesd", "restart cachefilesd", nil,
+		func(ctx context.Context, c *client.KnfsdAgentClient, _ []string) (*client.AdminResponse, error) {
+			return c.RestartCachefilesd(ctx)
+		},
+	),
+)
+
+func commandMap(cmds ...*command) map[string]*command {
+	m := make(map[string]*command, len(cmds))
+	for _, cmd := range cmds {
+		m[cmd.name] = cmd
+	}
+	return m
+}
+
+func adminCommand(name, help string, args []string, run func(context.Context, *client.KnfsdAgentClient, []string) (*client.AdminResponse, error)) *command {
+	cmd := newCommand(name, help, args,
+		func(ctx context.Context, c *client.KnfsdAgentClient, _ *options, args []string) (*client.AdminResponse, error) {
+			return run(ctx, c, args)
+		},
+		[]string{"MESSAGE"},
+		func(v *client.AdminResponse) [][]string {
+			return [][]string{{v.Message}}
+		},
+	)
+	cmd.admin = true
+	return cmd
+}
+
+func statusRows(v *client.StatusResponse) [][]string {
+	var rows [][]string
+	for _, s := range v.Services {
+		for _, c := range s.Checks {
+			rows = append(rows, []string{s.Name, c.Name, c.Result.String(), orDash(c.Error)})
+		}
+	}
+	return rows
+}
+
+func driftRows(v *client.MountDriftResponse) [][]string {
+	var rows [][]string
+	for _, d := range v.Drift {
+		if len(d.Options) == 0 {
+			rows = append(rows, []string{d.Export, d.Device, string(d.Type), "-", "-", "-"})
+			continue
+		}
+		for _, o := range d.Options {
+			rows = append(rows, []string{d.Export, d.Device, string(d.Type), o.Option, orDash(o.Intended), orDash(o.Actual)})
+		}
+	}
+	return rows
+}
+
+func fscacheRows(v *client.FSCacheStatsResponse) [][]string {
+	sections := make([]string, 0, len(v.Sections))
+	for name := range v.Sections {
+		sections = append(sections, name)
+	}
+	sort.Strings(sections)
+
+	var rows [][]string
+	for _, name := range sections {
+		stats := v.Sections[name]
+		keys := make([]string, 0, len(stats))
+		for k := range stats {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			rows = append(rows, []string{name, k, formatUint(stats[k])})
+		}
+	}
+	return rows
+}
+
+type healthResult struct {
+	Live  string `json:"live"`
+	Ready string `json:"ready"`
+}
+
+func runHealth(ctx context.Context, c *client.KnfsdAgentClient, _ *options, _ []string) (*healthResult, error) {
+	live, err := healthState(c.Healthz(ctx))
+	if err != nil {
+		return nil, err
+	}
+	ready, err := healthState(c.Readyz(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &healthResult{Live: live, Ready: ready}, nil
+}
+
+// healthState converts the result of Healthz or Readyz into a short
+// description. The reason is only returned as an error if the agent could
+// not be queried.
+func healthState(err error) (string, error) {
+	var e *client.Error
+	switch {
+	case err == nil:
+		return "ok", nil
+	case errors.As(err, &e) && e.StatusCode == http.StatusServiceUnavailable:
+		return strings.ReplaceAll(strings.TrimSpace(e.Message), "\n", "; "), nil
+	default:
+		return "", err
+	}
+}
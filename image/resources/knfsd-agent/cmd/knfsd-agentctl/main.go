@@ -0,0 +1,376 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+// knfsd-agentctl queries the knfsd-agent on one or more proxies.
+//
+// Usage:
+//
+//	knfsd-agentctl [flags] <command> [args]
+//
+// Use --proxy-file to query every instance in a MIG, for example:
+//
+//	gcloud compute instances list --filter=name~'^nfs-proxy' \
+//	  --format='value(networkInterfaces[0].networkIP)' > proxies.txt
+//	knfsd-agentctl --proxy-file proxies.txt status
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-agent/client"
+)
+
+type options struct {
+	proxies   []string
+	proxyFile string
+	output    string
+	token     string
+	tokenFile string
+	tlsCA     string
+	tlsCert   string
+	tlsKey    string
+	timeout   time.Duration
+	window    time.Duration
+	parallel  int
+	yes       bool
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	os.Exit(code)
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	opts, f := newFlagSet(stderr)
+	if err := f.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	args = f.Args()
+	if len(args) == 0 {
+		f.Usage()
+		return 2
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n", args[0])
+		f.Usage()
+		return 2
+	}
+	args = args[1:]
+	if len(args) != len(cmd.args) {
+		fmt.Fprintf(stderr, "usage: knfsd-agentctl [flags] %s %s\n", cmd.name, strings.Join(cmd.args, " "))
+		return 2
+	}
+
+	if opts.output != "table" && opts.output != "json" {
+		fmt.Fprintf(stderr, "invalid --output %q, must be table or json\n", opts.output)
+		return 2
+	}
+
+	proxies, err := loadProxies(opts)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	targets, err := newTargets(opts, proxies)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	// Admin commands change the state of the proxies, so guard against
+	// accidentally draining or restarting a whole MIG at once.
+	parallel := opts.parallel
+	if cmd.admin && len(targets) > 1 {
+		if !opts.yes {
+			fmt.Fprintf(stderr, "%s will run against %d proxies, use --yes to confirm\n", cmd.name, len(targets))
+			return 2
+		}
+		if !isFlagSet(f, "parallel") {
+			parallel = 1
+		}
+	}
+
+	results := fanOut(ctx, targets, parallel, func(ctx context.Context, c *client.KnfsdAgentClient) (any, error) {
+		ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+		return cmd.run(ctx, c, opts, args)
+	})
+
+	switch opts.output {
+	case "json":
+		err = writeJSON(stdout, results)
+	default:
+		err = writeTable(stdout, cmd, results)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	code := 0
+	for _, r := range results {
+		if r.Err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", r.Proxy, r.Err)
+			code = 1
+		}
+	}
+	return code
+}
+
+func newFlagSet(output io.Writer) (*options, *flag.FlagSet) {
+	opts := &options{}
+	f := flag.NewFlagSet("knfsd-agentctl", flag.ContinueOnError)
+	f.SetOutput(output)
+
+	f.Func("proxy", "comma separated list of proxies to query, as an IP, host:port or URL", func(s string) error {
+		for _, p := range strings.Split(s, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				opts.proxies = append(opts.proxies, p)
+			}
+		}
+		return nil
+	})
+	f.StringVar(&opts.proxyFile, "proxy-file", "", "file containing a list of proxies, one per line, use - for stdin")
+	f.StringVar(&opts.output, "output", "table", "output format, table or json")
+	f.StringVar(&opts.token, "token", "", "bearer token for the admin commands")
+	f.StringVar(&opts.tokenFile, "token-file", "", "file containing the bearer token for the admin commands")
+	f.StringVar(&opts.tlsCA, "tls-ca", "", "CA certificate used to verify the agent, enables HTTPS")
+	f.StringVar(&opts.tlsCert, "tls-cert", "", "client certificate, enables HTTPS")
+	f.StringVar(&opts.tlsKey, "tls-key", "", "client certificate key")
+	f.DurationVar(&opts.timeout, "timeout", 30*time.Second, "timeout for each proxy, including retries")
+	f.DurationVar(&opts.window, "window", 0, "window for the rates commands, defaults to the agent's default window")
+	f.IntVar(&opts.parallel, "parallel", 16, "maximum number of proxies to query concurrently, admin commands run one at a time unless set")
+	f.BoolVar(&opts.yes, "yes", false, "confirm running an admin command against more than one proxy")
+
+	f.Usage = func() {
+		fmt.Fprintln(output, "usage: knfsd-agentctl [flags] <command> [args]")
+		fmt.Fprintln(output)
+		fmt.Fprintln(output, "Commands:")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			cmd := commands[name]
+			usage := strings.TrimSpace(name + " " + strings.Join(cmd.args, " "))
+			fmt.Fprintf(output, "  %-24s %s\n", usage, cmd.help)
+		}
+		fmt.Fprintln(output)
+		fmt.Fprintln(output, "Flags:")
+		f.PrintDefaults()
+	}
+
+	return opts, f
+}
+
+func isFlagSet(f *flag.FlagSet, name string) bool {
+	set := false
+	f.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func loadProxies(opts *options) ([]string, error) {
+	proxies := opts.proxies
+	if opts.proxyFile != "" {
+		var r io.Reader
+		if opts.proxyFile == "-" {
+			r = os.Stdin
+		} else {
+			f, err := os.Open(opts.proxyFile)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			r = f
+		}
+
+		s := bufio.NewScanner(r)
+		for s.Scan() {
+			line := strings.TrimSpace(s.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			proxies = append(proxies, line)
+		}
+		if err := s.Err(); err != nil {
+			return nil, fmt.Errorf("could not read %s: %w", opts.proxyFile, err)
+		}
+	}
+
+	if len(proxies) == 0 {
+		return nil, errors.New("no proxies, use --proxy or --proxy-file")
+	}
+	return proxies, nil
+}
+
+// target is a proxy to query. name is the proxy as given by the user, and is
+// used to identify the proxy in the output.
+type target struct {
+	name   string
+	client *client.KnfsdAgentClient
+}
+
+func newTargets(opts *options, proxies []string) ([]target, error) {
+	token := opts.token
+	if opts.tokenFile != "" {
+		data, err := os.ReadFile(opts.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	tlsConfig, err := newTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	// Keep the connections open when querying a large MIG.
+	transport.MaxIdleConnsPerHost = 2
+	hc := &http.Client{Transport: transport}
+
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+
+	var opt []client.Option
+	if token != "" {
+		opt = append(opt, client.WithToken(token))
+	}
+
+	targets := make([]target, len(proxies))
+	for i, p := range proxies {
+		targets[i] = target{
+			name:   p,
+			client: client.NewKnfsdAgentClient(hc, proxyURL(scheme, p), opt...),
+		}
+	}
+	return targets, nil
+}
+
+func newTLSConfig(opts *options) (*tls.Config, error) {
+	if opts.tlsCA == "" && opts.tlsCert == "" {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.tlsCA != "" {
+		pem, err := os.ReadFile(opts.tlsCA)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.tlsCA)
+		}
+	}
+
+	if opts.tlsCert != "" {
+		cert, err := tls.LoadX509KeyPair(opts.tlsCert, opts.tlsKey)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// proxyURL converts a proxy address into the base URL for the agent. The
+// address can be a URL, a host:port, or just the IP or host name of the proxy,
+// in which case the agent's default port is used.
+func proxyURL(scheme, proxy string) string {
+	if strings.Contains(proxy, "://") {
+		return proxy
+	}
+	if _, _, err := net.SplitHostPort(proxy); err != nil {
+		if strings.Contains(proxy, ":") {
+			// bare IPv6 address
+			proxy = "[" + proxy + "]"
+		}
+		if scheme == "https" {
+			proxy += ":443"
+		} else {
+			proxy += ":80"
+		}
+	}
+	return scheme + "://" + proxy
+}
+
+type result struct {
+	Proxy string
+	Value any
+	Err   error
+}
+
+// fanOut runs fn against every target, with at most parallel requests in
+// flight. The results are in the same order as the targets.
+func fanOut(ctx context.Context, targets []target, parallel int, fn func(context.Context, *client.KnfsdAgentClient) (any, error)) []result {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	results := make([]result, len(targets))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t target) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			v, err := fn(ctx, t.client)
+			results[i] = result{Proxy: t.name, Value: v, Err: err}
+		}(i, t)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,174 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAgent(t *testing.T, kernel string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/os" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"not found"}`))
+			return
+		}
+		w.Write([]byte(`{"kernel":"` + kernel + `","os":{"PRETTY_NAME":"Ubuntu 24.04"}}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestTableSingleProxy(t *testing.T) {
+	proxy := newTestAgent(t, "6.1.0")
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{"--proxy", proxy, "os"}, &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, []string{"KERNEL", "OS"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"6.1.0", "Ubuntu", "24.04"}, strings.Fields(lines[1]))
+}
+
+func TestTableMultipleProxies(t *testing.T) {
+	a := newTestAgent(t, "6.1.0")
+	b := newTestAgent(t, "6.8.0")
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{"--proxy", a + "," + b, "os"}, &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, []string{"PROXY", "KERNEL", "OS"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{a, "6.1.0", "Ubuntu", "24.04"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{b, "6.8.0", "Ubuntu", "24.04"}, strings.Fields(lines[2]))
+}
+
+func TestJSONWithError(t *testing.T) {
+	a := newTestAgent(t, "6.1.0")
+
+	var stdout, stderr bytes.Buffer
+	// nodeinfo is not handled by the test agent
+	code := run(context.Background(), []string{"--proxy", a + "," + a, "--output", "json", "nodeinfo"}, &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), "not found")
+
+	var out []proxyResult
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &out))
+	require.Len(t, out, 2)
+	assert.Equal(t, a, out[0].Proxy)
+	assert.Nil(t, out[0].Result)
+	assert.Contains(t, out[0].Error, "404 not found")
+}
+
+// newTestAdminAgent returns an agent that handles drain requests. active and
+// peak track the number of concurrent requests across every agent.
+func newTestAdminAgent(t *testing.T, calls, active, peak *atomic.Int32) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte(`{"message":"draining"}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestAdminMultipleProxies(t *testing.T) {
+	var calls, active, peak atomic.Int32
+	proxies := strings.Join([]string{
+		newTestAdminAgent(t, &calls, &active, &peak),
+		newTestAdminAgent(t, &calls, &active, &peak),
+		newTestAdminAgent(t, &calls, &active, &peak),
+	}, ",")
+
+	t.Run("requires confirmation", func(t *testing.T) {
+		calls.Store(0)
+		var stdout, stderr bytes.Buffer
+		code := run(context.Background(), []string{"--proxy", proxies, "drain"}, &stdout, &stderr)
+		assert.Equal(t, 2, code)
+		assert.Contains(t, stderr.String(), "--yes")
+		assert.Equal(t, int32(0), calls.Load())
+	})
+
+	t.Run("one at a time", func(t *testing.T) {
+		calls.Store(0)
+		peak.Store(0)
+		var stdout, stderr bytes.Buffer
+		code := run(context.Background(), []string{"--proxy", proxies, "--yes", "drain"}, &stdout, &stderr)
+		require.Equal(t, 0, code, stderr.String())
+		assert.Equal(t, int32(3), calls.Load())
+		assert.Equal(t, int32(1), peak.Load())
+	})
+
+	t.Run("parallel", func(t *testing.T) {
+		calls.Store(0)
+		peak.Store(0)
+		var stdout, stderr bytes.Buffer
+		code := run(context.Background(), []string{"--proxy", proxies, "--yes", "--parallel", "3", "drain"}, &stdout, &stderr)
+		require.Equal(t, 0, code, stderr.String())
+		assert.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("single proxy", func(t *testing.T) {
+		proxy := newTestAdminAgent(t, &calls, &active, &peak)
+		var stdout, stderr bytes.Buffer
+		code := run(context.Background(), []string{"--proxy", proxy, "drain"}, &stdout, &stderr)
+		require.Equal(t, 0, code, stderr.String())
+	})
+}
+
+func TestProxyURL(t *testing.T) {
+	tests := []struct {
+		scheme string
+		proxy  string
+		want   string
+	}{
+		{"http", "10.0.0.2", "http://10.0.0.2:80"},
+		{"https", "10.0.0.2", "https://10.0.0.2:443"},
+		{"http", "10.0.0.2:8080", "http://10.0.0.2:8080"},
+		{"http", "fd00::2", "http://[fd00::2]:80"},
+		{"http", "[fd00::2]:8080", "http://[fd00::2]:8080"},
+		{"http", "https://proxy.example.com", "https://proxy.example.com"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, proxyURL(tt.scheme, tt.proxy), tt.proxy)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// proxyResult is the JSON output for a single proxy when querying multiple
+// proxies.
+type proxyResult struct {
+	Proxy  string `json:"proxy"`
+	Result any    `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// writeJSON writes the results as JSON. When querying a single proxy the
+// result is written as is, so that the output matches the agent's API.
+func writeJSON(w io.Writer, results []result) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	if len(results) == 1 {
+		if results[0].Err != nil {
+			return nil
+		}
+		return enc.Encode(results[0].Value)
+	}
+
+	out := make([]proxyResult, len(results))
+	for i, r := range results {
+		out[i] = proxyResult{Proxy: r.Proxy, Result: r.Value}
+		if r.Err != nil {
+			out[i].Error = r.Err.Error()
+		}
+	}
+	return enc.Encode(out)
+}
+
+// writeTable writes the results as a table. When querying multiple proxies a
+// PROXY column is added so that the rows can be told apart. Proxies that
+// failed are skipped, the errors are reported separately.
+func writeTable(w io.Writer, cmd *command, results []result) error {
+	multi := len(results) > 1
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	header := cmd.header
+	if multi {
+		header = append([]string{"PROXY"}, header...)
+	}
+	writeRow(tw, header)
+
+	for _, r := range results {
+		if r.Err != nil {
+			continue
+		}
+		for _, row := range cmd.rows(r.Value) {
+			if multi {
+				row = append([]string{r.Proxy}, row...)
+			}
+			writeRow(tw, row)
+		}
+	}
+
+	return tw.Flush()
+}
+
+func writeRow(w io.Writer, row []string) {
+	fmt.Fprintln(w, strings.Join(row, "\t"))
+}
+
+// formatBytes formats a number of bytes using binary units, such as 1.5 GiB.
+func formatBytes(n float64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatFloat(n, 'f', 0, 64) + " B"
+	}
+	exp := 0
+	for n >= unit && exp < 6 {
+		n /= unit
+		exp++
+	}
+	return strconv.FormatFloat(n, 'f', 1, 64) + " " + string("KMGTPE"[exp-1]) + "iB"
+}
+
+func formatRate(n float64) string {
+	return strconv.FormatFloat(n, 'f', 1, 64)
+}
+
+func formatUint(n uint64) string {
+	return strconv.FormatUint(n, 10)
+}
+
+func formatPercent(n, total uint64) string {
+	if total == 0 {
+		return "-"
+	}
+	return strconv.FormatFloat(100*float64(n)/float64(total), 'f', 1, 64) + "%"
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
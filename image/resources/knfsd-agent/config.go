@@ -0,0 +1,311 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ini/ini"
+)
+
+const (
+	defaultConfigFile     = "/etc/knfsd-agent.conf"
+	defaultHealthInterval = 10 * time.Second
+	defaultAuditLog       = "/var/log/knfsd-agent/audit.log"
+	defaultLogFile        = "/var/log/knfsd-agent/agent.log"
+
+	defaultReadTimeout       = 30 * time.Second
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultIdleTimeout       = 2 * time.Minute
+	defaultShutdownTimeout   = 10 * time.Second
+
+	defaultRatesInterval  = 10 * time.Second
+	defaultRatesRetention = 10 * time.Minute
+	defaultRatesWindow    = time.Minute
+
+	defaultCacheScanInterval = 15 * time.Minute
+	defaultCacheScanSample   = 16
+)
+
+type Config struct {
+	Server ServerConfig `ini:"server"`
+	Log    LogConfig    `ini:"log"`
+	Health HealthConfig `ini:"health"`
+	Rates  RatesConfig  `ini:"rates"`
+	Cache  CacheConfig  `ini:"cache"`
+	Drift  DriftConfig  `ini:"drift"`
+	Admin  AdminConfig  `ini:"admin"`
+}
+
+type ServerConfig struct {
+	// Listen is the list of addresses to serve plain HTTP on, such as ":80".
+	Listen []string `ini:"listen"`
+
+	// TLSListen is the list of addresses to serve HTTPS on, such as
+	// "10.0.0.2:443". The admin endpoints are only served on these addresses
+	// unless the admin config allows insecure access.
+	TLSListen []string `ini:"tls-listen"`
+
+	// TLSCert and TLSKey are the certificate used by the TLS listen addresses.
+	TLSCert string `ini:"tls-cert"`
+	TLSKey  string `ini:"tls-key"`
+
+	// TLSClientCA enables verifying client certificates signed by the CA.
+	TLSClientCA string `ini:"tls-client-ca"`
+
+	// TLSClientAuth is either "require" to reject clients without a valid
+	// certificate, or "verify-if-given" to allow clients without a
+	// certificate, such as load balancer health checks.
+	TLSClientAuth string `ini:"tls-client-auth"`
+
+	ReadTimeout       time.Duration `ini:"read-timeout"`
+	ReadHeaderTimeout time.Duration `ini:"read-header-timeout"`
+	IdleTimeout       time.Duration `ini:"idle-timeout"`
+
+	// ShutdownTimeout is how long to wait for requests to complete when the
+	// agent is stopped.
+	ShutdownTimeout time.Duration `ini:"shutdown-timeout"`
+}
+
+type LogConfig struct {
+	// Output is "file", "stderr" or "journald".
+	Output string `ini:"output"`
+
+	// File is the log file when the output is "file".
+	File string `ini:"file"`
+}
+
+type AdminConfig struct {
+	// Token is the bearer token required to call the admin endpoints. The
+	// admin endpoints are disabled if a token is not configured.
+	Token string `ini:"token"`
+
+	// TokenFile is the path to a file containing the token, so that the token
+	// does not need to be included in the config file.
+	TokenFile string `ini:"token-file"`
+
+	// ClientCert allows clients with a certificate signed by the TLS client
+	// CA to call the admin endpoints without a token.
+	ClientCert bool `ini:"client-cert"`
+
+	// AuditLog is the file recording every call to the admin endpoints.
+	AuditLog string `ini:"audit-log"`
+
+	// AllowInsecure allows using a token without TLS. Without TLS the token
+	// is sent in plain text, and can be read by anyone on the network.
+	AllowInsecure bool `ini:"allow-insecure"`
+}
+
+func (cfg *Config) Validate() error {
+	err := errors.Join(
+		cfg.Server.Validate(),
+		cfg.Log.Validate(),
+		cfg.Health.Validate(),
+		cfg.Rates.Validate(),
+		cfg.Cache.Validate(),
+		cfg.Drift.Validate(),
+		cfg.Admin.Validate(),
+	)
+	if cfg.Admin.ClientCert && cfg.Server.TLSClientCA == "" {
+		err = errors.Join(err, errors.New("\"tls-client-ca\" is required when \"client-cert\" is enabled"))
+	}
+	if cfg.Admin.hasToken() && len(cfg.Server.TLSListen) == 0 && !cfg.Admin.AllowInsecure {
+		err = errors.Join(err, errors.New("\"tls-listen\" is required when an admin token is set, or set \"allow-insecure\" to send the token without TLS"))
+	}
+	return err
+}
+
+func (cfg *ServerConfig) Validate() error {
+	var err error
+	if len(cfg.Listen) == 0 && len(cfg.TLSListen) == 0 {
+		err = errors.Join(err, errors.New("\"listen\" or \"tls-listen\" is required"))
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		err = errors.Join(err, errors.New("both \"tls-cert\" and \"tls-key\" are required to enable TLS"))
+	}
+	if len(cfg.TLSListen) > 0 && cfg.TLSCert == "" {
+		err = errors.Join(err, errors.New("\"tls-cert\" and \"tls-key\" are required when \"tls-listen\" is set"))
+	}
+	if cfg.TLSCert != "" && len(cfg.TLSListen) == 0 {
+		err = errors.Join(err, errors.New("\"tls-listen\" is required when \"tls-cert\" is set"))
+	}
+	if cfg.TLSClientCA != "" && cfg.TLSCert == "" {
+		err = errors.Join(err, errors.New("\"tls-cert\" and \"tls-key\" are required when \"tls-client-ca\" is set"))
+	}
+	switch cfg.TLSClientAuth {
+	case "require", "verify-if-given":
+	default:
+		err = errors.Join(err, fmt.Errorf("invalid \"tls-client-auth\" \"%s\", must be \"require\" or \"verify-if-given\"", cfg.TLSClientAuth))
+	}
+	return err
+}
+
+func (cfg *LogConfig) Validate() error {
+	switch cfg.Output {
+	case "file":
+		if cfg.File == "" {
+			return errors.New("\"log-file\" is required when \"log-output\" is \"file\"")
+		}
+	case "stderr", "journald":
+	default:
+		return fmt.Errorf("invalid \"log-output\" \"%s\", must be \"file\", \"stderr\" or \"journald\"", cfg.Output)
+	}
+	return nil
+}
+
+func (cfg *HealthConfig) Validate() error {
+	if cfg.Interval <= 0 {
+		return errors.New("\"health-interval\" must be greater than zero")
+	}
+	return nil
+}
+
+func (cfg *AdminConfig) Validate() error {
+	if cfg.Token != "" && cfg.TokenFile != "" {
+		return errors.New("only one of \"token\" or \"token-file\" can be set")
+	}
+	return nil
+}
+
+// Enabled returns true if the admin endpoints are enabled.
+func (cfg *AdminConfig) Enabled() bool {
+	return cfg.hasToken() || cfg.ClientCert
+}
+
+func (cfg *AdminConfig) hasToken() bool {
+	return cfg.Token != "" || cfg.TokenFile != ""
+}
+
+// ReadToken returns the configured token, reading the token file if required.
+func (cfg *AdminConfig) ReadToken() (string, error) {
+	if cfg.TokenFile == "" {
+		return cfg.Token, nil
+	}
+
+	b, err := os.ReadFile(cfg.TokenFile)
+	if err != nil {
+		return "", err
+	}
+
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("token file \"%s\" is empty", cfg.TokenFile)
+	}
+	return token, nil
+}
+
+// listFlag is a comma separated list of values.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = nil
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+func newFlagSet(name string, cfg *Config) *flag.FlagSet {
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	// setup flags before reading the config file, otherwise the flag package
+	// will overwrite the config with the default values
+	cfg.Server.Listen = []string{":80"}
+	f.Var((*listFlag)(&cfg.Server.Listen), "listen", "comma separated list of addresses to serve HTTP on")
+	f.Var((*listFlag)(&cfg.Server.TLSListen), "tls-listen", "comma separated list of addresses to serve HTTPS on")
+	f.StringVar(&cfg.Server.TLSCert, "tls-cert", "", "TLS certificate file")
+	f.StringVar(&cfg.Server.TLSKey, "tls-key", "", "TLS private key file")
+	f.StringVar(&cfg.Server.TLSClientCA, "tls-client-ca", "", "CA file to verify client certificates")
+	f.StringVar(&cfg.Server.TLSClientAuth, "tls-client-auth", "require", "require or verify-if-given")
+	f.DurationVar(&cfg.Server.ReadTimeout, "read-timeout", defaultReadTimeout, "maximum time to read a request")
+	f.DurationVar(&cfg.Server.ReadHeaderTimeout, "read-header-timeout", defaultReadHeaderTimeout, "maximum time to read the request headers")
+	f.DurationVar(&cfg.Server.IdleTimeout, "idle-timeout", defaultIdleTimeout, "maximum time to wait for the next request on a keep-alive connection")
+	f.DurationVar(&cfg.Server.ShutdownTimeout, "shutdown-timeout", defaultShutdownTimeout, "maximum time to wait for requests to complete when stopping")
+	f.StringVar(&cfg.Log.Output, "log-output", "file", "file, stderr or journald")
+	f.StringVar(&cfg.Log.File, "log-file", defaultLogFile, "log file when log-output is file")
+
+	cfg.Health.Live = defaultHealthConfig.Live
+	cfg.Health.Ready = defaultHealthConfig.Ready
+	f.DurationVar(&cfg.Health.Interval, "health-interval", defaultHealthInterval, "how often to run the status checks for /healthz and /readyz")
+	f.Var((*listFlag)(&cfg.Health.Live), "healthz-fatal", "comma separated list of `service/check` patterns that fail /healthz")
+	f.Var((*listFlag)(&cfg.Health.Ready), "readyz-fatal", "comma separated list of `service/check` patterns that fail /readyz")
+	f.DurationVar(&cfg.Rates.Interval, "rates-interval", defaultRatesInterval, "how often to sample the counters for the rate endpoints")
+	f.DurationVar(&cfg.Rates.Retention, "rates-retention", defaultRatesRetention, "how long to keep samples for the rate endpoints")
+	f.DurationVar(&cfg.Cache.ScanInterval, "cache-scan-interval", defaultCacheScanInterval, "how often to scan the FS-Cache volumes")
+	f.IntVar(&cfg.Cache.ScanSample, "cache-scan-sample", defaultCacheScanSample, "number of fan-out directories to scan in each FS-Cache volume, 0 scans all directories")
+	f.StringVar(&cfg.Drift.Source, "drift-source", "auto", "where to load the intended mounts from, auto, file, metadata or none")
+	f.StringVar(&cfg.Drift.File, "drift-file", defaultIntendedMountsFile, "intended mounts file written by proxy-startup.sh")
+	f.Var((*listFlag)(&cfg.Drift.IgnoreOptions), "drift-ignore-options", "comma separated list of mount options to ignore when detecting drift")
+	f.StringVar(&cfg.Admin.TokenFile, "admin-token-file", "", "file containing the bearer token for the admin endpoints")
+	f.BoolVar(&cfg.Admin.ClientCert, "admin-client-cert", false, "allow clients with a verified certificate to call the admin endpoints")
+	f.StringVar(&cfg.Admin.AuditLog, "audit-log", defaultAuditLog, "file to record calls to the admin endpoints")
+	f.BoolVar(&cfg.Admin.AllowInsecure, "allow-insecure-admin", false, "allow the admin token to be sent without TLS")
+
+	return f
+}
+
+// loadConfig reads the config from the config file and command line arguments
+// (in that order).
+func loadConfig(cfg *Config, f *flag.FlagSet, args []string) error {
+	// read the config file before parsing the command line arguments so
+	// that the command line arguments override any config values
+	err := readConfig(cfg, defaultConfigFile)
+	if errors.Is(err, os.ErrNotExist) {
+		// if config file does not exist, use default values
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read config: %w", err)
+	}
+
+	err = f.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	return cfg.Validate()
+}
+
+func readConfig(cfg *Config, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return parseConfig(cfg, f)
+}
+
+func parseConfig(cfg *Config, r io.Reader) error {
+	i, err := ini.Load(r)
+	if err != nil {
+		return err
+	}
+	return i.StrictMapTo(cfg)
+}
@@ -0,0 +1,152 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfig(t *testing.T) {
+	input := strings.Join([]string{
+		"[health]",
+		"interval = 30s",
+		"readyz-fatal = nfs-kernel-server/*, nfs mounts/*",
+		"",
+		"[server]",
+		"tls-listen = :443",
+		"tls-cert = /etc/knfsd-agent/tls.crt",
+		"tls-key = /etc/knfsd-agent/tls.key",
+		"",
+		"[admin]",
+		"token-file = /etc/knfsd-agent/token",
+	}, "\n")
+
+	cfg := new(Config)
+	f := newFlagSet("test", cfg)
+	require.NoError(t, parseConfig(cfg, strings.NewReader(input)))
+	require.NoError(t, f.Parse([]string{"--healthz-fatal=*/running"}))
+
+	assert.Equal(t, 30*time.Second, cfg.Health.Interval)
+	assert.Equal(t, []string{"*/running"}, cfg.Health.Live)
+	assert.Equal(t, []string{"nfs-kernel-server/*", "nfs mounts/*"}, cfg.Health.Ready)
+	assert.Equal(t, "/etc/knfsd-agent/token", cfg.Admin.TokenFile)
+	assert.Equal(t, defaultAuditLog, cfg.Admin.AuditLog)
+	assert.True(t, cfg.Admin.Enabled())
+	assert.NoError(t, cfg.Validate())
+
+	err := parseConfig(cfg, strings.NewReader("[health]\ninterval = soon"))
+	assert.Error(t, err)
+}
+
+func TestAdminToken(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(name, []byte("secret\n"), 0600))
+
+	cfg := AdminConfig{TokenFile: name}
+	token, err := cfg.ReadToken()
+	require.NoError(t, err)
+	assert.Equal(t, "secret", token)
+
+	cfg.Token = "other"
+	assert.Error(t, cfg.Validate())
+}
+
+func TestAdminTokenRequiresTLS(t *testing.T) {
+	cfg := new(Config)
+	newFlagSet("test", cfg)
+	cfg.Admin.TokenFile = "/etc/knfsd-agent/token"
+	assert.ErrorContains(t, cfg.Validate(), "allow-insecure")
+
+	cfg.Admin.AllowInsecure = true
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Admin.AllowInsecure = false
+	cfg.Server.TLSCert = "/etc/knfsd-agent/tls.crt"
+	cfg.Server.TLSKey = "/etc/knfsd-agent/tls.key"
+	assert.ErrorContains(t, cfg.Validate(), "tls-listen")
+
+	cfg.Server.TLSListen = []string{":443"}
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestServerConfig(t *testing.T) {
+	input := strings.Join([]string{
+		"[server]",
+		"listen = 127.0.0.1:80",
+		"tls-listen = 10.0.0.2:443, 10.0.0.3:443",
+		"tls-cert = /etc/knfsd-agent/tls.crt",
+		"tls-key = /etc/knfsd-agent/tls.key",
+		"read-timeout = 5s",
+		"",
+		"[log]",
+		"output = journald",
+	}, "\n")
+
+	cfg := new(Config)
+	f := newFlagSet("test", cfg)
+	require.NoError(t, parseConfig(cfg, strings.NewReader(input)))
+	require.NoError(t, f.Parse(nil))
+
+	assert.Equal(t, []string{"127.0.0.1:80"}, cfg.Server.Listen)
+	assert.Equal(t, []string{"10.0.0.2:443", "10.0.0.3:443"}, cfg.Server.TLSListen)
+	assert.Equal(t, 5*time.Second, cfg.Server.ReadTimeout)
+	assert.Equal(t, defaultReadHeaderTimeout, cfg.Server.ReadHeaderTimeout)
+	assert.Equal(t, "journald", cfg.Log.Output)
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Admin.ClientCert = true
+	assert.Error(t, cfg.Validate(), "client-cert requires tls-client-ca")
+
+	cfg.Server.TLSClientCA = "/etc/knfsd-agent/ca.crt"
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Server.TLSKey = ""
+	assert.Error(t, cfg.Validate(), "tls-cert requires tls-key")
+
+	cfg.Server.TLSKey = "/etc/knfsd-agent/tls.key"
+	cfg.Server.TLSListen = nil
+	assert.Error(t, cfg.Validate(), "tls-cert requires tls-listen")
+
+	cfg.Server.TLSCert = ""
+	cfg.Server.TLSKey = ""
+	cfg.Server.TLSClientCA = ""
+	cfg.Admin.ClientCert = false
+	cfg.Server.TLSListen = []string{":443"}
+	assert.Error(t, cfg.Validate(), "tls-listen requires tls-cert")
+
+	// Only serve HTTPS by disabling the plain HTTP listen address.
+	cfg = new(Config)
+	f = newFlagSet("test", cfg)
+	require.NoError(t, f.Parse([]string{"--listen=", "--tls-listen=:443", "--tls-cert=tls.crt", "--tls-key=tls.key"}))
+	assert.Empty(t, cfg.Server.Listen)
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Server.TLSListen = nil
+	assert.Error(t, cfg.Validate(), "listen or tls-listen is required")
+
+	cfg = new(Config)
+	newFlagSet("test", cfg)
+	cfg.Log.Output = "syslog"
+	assert.Error(t, cfg.Validate())
+}
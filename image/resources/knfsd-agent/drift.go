@@ -0,0 +1,565 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-agent/client"
+	"github.com/prometheus/procfs"
+)
+
+// defaultIntendedMountsFile is written by proxy-startup.sh, recording each
+// mount with the options it was mounted with.
+const defaultIntendedMountsFile = "/etc/knfsd-mounts.conf"
+
+type DriftConfig struct {
+	// Source is where the intended mounts are loaded from. "file" reads the
+	// file written by proxy-startup.sh, "metadata" builds the intended mounts
+	// from the instance metadata, "auto" uses the file if it exists otherwise
+	// the metadata, and "none" disables drift detection.
+	Source string `ini:"source"`
+
+	// File is the intended mounts file.
+	File string `ini:"file"`
+
+	// IgnoreOptions are mount options that are not compared, such as options
+	// passed using MOUNT_OPTIONS that the kernel does not report.
+	IgnoreOptions []string `ini:"ignore-options"`
+}
+
+func (cfg *DriftConfig) Validate() error {
+	switch cfg.Source {
+	case "auto", "file", "metadata", "none":
+	default:
+		return fmt.Errorf("invalid \"drift-source\" \"%s\", must be \"auto\", \"file\", \"metadata\" or \"none\"", cfg.Source)
+	}
+	if cfg.Source != "metadata" && cfg.Source != "none" && cfg.File == "" {
+		return errors.New("\"drift-file\" is required")
+	}
+	return nil
+}
+
+// intendedMounts is the configuration proxy-startup.sh used to mount the
+// exports.
+type intendedMounts struct {
+	// Source is either "file" or "metadata".
+	Source string
+
+	// Mounts are keyed by the export path.
+	Mounts map[string]intendedMount
+
+	// Options are the intended options for mounts that are not listed in
+	// Mounts. This is used when the exports are discovered at startup, such as
+	// EXPORT_HOST_AUTO_DETECT, as the metadata does not list the exports.
+	// If Options is nil, mounts that are not listed are reported as extra.
+	Options map[string]string
+}
+
+type intendedMount struct {
+	Device  string
+	Export  string
+	Options map[string]string
+}
+
+// DriftDetector compares the NFS mounts with the intended configuration.
+type DriftDetector struct {
+	cfg DriftConfig
+
+	// metadata can be replaced for testing.
+	metadata func(name string) (string, error)
+
+	// The metadata is only read once, as proxy-startup.sh only reads the
+	// metadata when the proxy starts.
+	mu       sync.Mutex
+	fromMeta *intendedMounts
+}
+
+func NewDriftDetector(cfg DriftConfig) *DriftDetector {
+	return &DriftDetector{
+		cfg:      cfg,
+		metadata: getAttribute,
+	}
+}
+
+// mountDrift is used by the status checks and the drift endpoint. It is
+// replaced by main using the agent's config.
+var mountDrift = NewDriftDetector(DriftConfig{
+	Source: "auto",
+	File:   defaultIntendedMountsFile,
+})
+
+// Enabled returns true if drift detection is enabled.
+func (d *DriftDetector) Enabled() bool {
+	return d.cfg.Source != "none"
+}
+
+func (d *DriftDetector) intended() (*intendedMounts, error) {
+	switch d.cfg.Source {
+	case "file":
+		return readIntendedMounts(d.cfg.File)
+	case "metadata":
+		return d.intendedFromMetadata()
+	default:
+		m, err := readIntendedMounts(d.cfg.File)
+		if errors.Is(err, os.ErrNotExist) {
+			return d.intendedFromMetadata()
+		}
+		return m, err
+	}
+}
+
+func (d *DriftDetector) intendedFromMetadata() (*intendedMounts, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.fromMeta != nil {
+		return d.fromMeta, nil
+	}
+
+	m, err := buildIntendedMounts(d.metadata)
+	if err != nil {
+		return nil, err
+	}
+	d.fromMeta = m
+	return m, nil
+}
+
+// Detect compares the NFS mounts within the NFS root with the intended mounts.
+func (d *DriftDetector) Detect(proc procfs.Proc, nfsRoot string) (*client.MountDriftResponse, error) {
+	intended, err := d.intended()
+	if err != nil {
+		return nil, fmt.Errorf("could not load intended mounts: %w", err)
+	}
+
+	actual, err := readActualMounts(proc, nfsRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.MountDriftResponse{
+		Source: intended.Source,
+		Drift:  compareMounts(intended, actual, d.cfg.IgnoreOptions),
+	}, nil
+}
+
+func handleMountDrift(*http.Request) (*client.MountDriftResponse, error) {
+	if !mountDrift.Enabled() {
+		return nil, &requestError{http.StatusNotFound, errors.New("mount drift detection is disabled")}
+	}
+
+	nfsRoot, err := getNFSRootDir()
+	if err != nil {
+		return nil, err
+	}
+
+	self, err := procfs.Self()
+	if err != nil {
+		return nil, err
+	}
+
+	return mountDrift.Detect(self, nfsRoot)
+}
+
+// checkMountDrift warns if any mounts do not match the intended configuration.
+// Drift is only a warning as the proxy can still serve clients, but the
+// performance or caching behaviour may not be as expected.
+func (sh *ServiceHealth) checkMountDrift(proc procfs.Proc, nfsRoot string) {
+	if !mountDrift.Enabled() {
+		return
+	}
+
+	res, err := mountDrift.Detect(proc, nfsRoot)
+	if err != nil {
+		sh.Warn("mount options", err)
+		return
+	}
+
+	if len(res.Drift) == 0 {
+		sh.Pass("mount options")
+		return
+	}
+
+	var errs []error
+	for _, d := range res.Drift {
+		errs = append(errs, driftError(d))
+	}
+	sh.Warn("mount options", errors.Join(errs...))
+}
+
+func driftError(d client.MountDrift) error {
+	switch d.Type {
+	case client.DRIFT_MISSING:
+		return fmt.Errorf("%s: not mounted", d.Export)
+	case client.DRIFT_EXTRA:
+		return fmt.Errorf("%s: not in the intended mounts", d.Export)
+	}
+
+	opts := make([]string, len(d.Options))
+	for i, o := range d.Options {
+		actual := o.Actual
+		if actual == "" {
+			actual = "not set"
+		}
+		opts[i] = fmt.Sprintf("%s is %s, expected %s", o.Option, actual, o.Intended)
+	}
+	return fmt.Errorf("%s: %s", d.Export, strings.Join(opts, ", "))
+}
+
+// readIntendedMounts reads the intended mounts file. Each line is the remote
+// device, the export path and the mount options, separated by tabs.
+func readIntendedMounts(name string) (*intendedMounts, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseIntendedMounts(f)
+}
+
+func parseIntendedMounts(r io.Reader) (*intendedMounts, error) {
+	m := &intendedMounts{
+		Source: "file",
+		Mounts: make(map[string]intendedMount),
+	}
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected 3 fields, got %d", n, len(fields))
+		}
+
+		export := path.Clean("/" + fields[1])
+		m.Mounts[export] = intendedMount{
+			Device:  fields[0],
+			Export:  export,
+			Options: parseMountOptions(fields[2]),
+		}
+	}
+	return m, s.Err()
+}
+
+// buildIntendedMounts builds the intended mounts from the metadata attributes
+// the same way as build_mount_options in proxy-startup.sh.
+//
+// Only the exports from EXPORT_MAP are known. If the exports are discovered
+// when the proxy starts, the other mounts are compared with the common
+// options instead of being reported as extra.
+func buildIntendedMounts(get func(string) (string, error)) (*intendedMounts, error) {
+	var errs []error
+	attr := func(name string) string {
+		v, err := get(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+		return strings.TrimSpace(v)
+	}
+
+	vers := attr("NFS_MOUNT_VERSION")
+	opts := []string{
+		"rw", "noatime", "nocto", "async", "hard", "ac",
+		"vers=" + vers,
+		"proto=tcp",
+		"timeo=600",
+		"retrans=2",
+		"lookupcache=all",
+		"local_lock=none",
+		"nconnect=" + attr("NCONNECT"),
+		"acdirmin=" + attr("ACDIRMIN"),
+		"acdirmax=" + attr("ACDIRMAX"),
+		"acregmin=" + attr("ACREGMIN"),
+		"acregmax=" + attr("ACREGMAX"),
+		"rsize=" + attr("RSIZE"),
+		"wsize=" + attr("WSIZE"),
+	}
+	if vers == "3" {
+		opts = append(opts, "mountproto=tcp")
+	}
+	if extra := attr("MOUNT_OPTIONS"); extra != "" {
+		opts = append(opts, extra)
+	}
+	options := parseMountOptions(strings.Join(opts, ","))
+
+	exportMap := attr("EXPORT_MAP")
+	discovered := attr("EXPORT_HOST_AUTO_DETECT") != "" || attr("ENABLE_NETAPP_AUTO_DETECT") == "true"
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	m := &intendedMounts{
+		Source: "metadata",
+		Mounts: make(map[string]intendedMount),
+	}
+	if discovered {
+		m.Options = options
+	}
+
+	for _, e := range strings.Fields(strings.ReplaceAll(exportMap, ",", " ")) {
+		parts := strings.Split(e, ";")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("EXPORT_MAP: invalid entry \"%s\"", e)
+		}
+		export := path.Clean("/" + parts[2])
+		m.Mounts[export] = intendedMount{
+			Device:  parts[0] + ":" + parts[1],
+			Export:  export,
+			Options: options,
+		}
+	}
+	return m, nil
+}
+
+func getAttribute(name string) (string, error) {
+	return getMetadataValue("computeMetadata/v1/instance/attributes/"+name, false)
+}
+
+// parseMountOptions parses a comma separated list of mount options. Flags,
+// such as "hard", have an empty value.
+func parseMountOptions(s string) map[string]string {
+	opts := make(map[string]string)
+	for _, o := range strings.Split(s, ",") {
+		o = strings.TrimSpace(o)
+		if o == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(o, "=")
+		opts[k] = v
+	}
+	return opts
+}
+
+// readActualMounts returns the NFS mounts within the NFS root keyed by the
+// export path, including a mount of the NFS root itself.
+func readActualMounts(proc procfs.Proc, nfsRoot string) (map[string]client.Mount, error) {
+	info, err := proc.MountInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	root := strings.TrimSuffix(nfsRoot, "/")
+	mounts := make(map[string]client.Mount)
+	for _, e := range info {
+		if !isNFS(e.FSType) {
+			continue
+		}
+		if e.MountPoint != root && !strings.HasPrefix(e.MountPoint, nfsRoot) {
+			continue
+		}
+
+		export := path.Clean("/" + strings.TrimPrefix(e.MountPoint, root))
+		mounts[export] = client.Mount{
+			Device:  e.Source,
+			Mount:   e.MountPoint,
+			Export:  export,
+			Options: combineMountOptions(e.Options, e.SuperOptions),
+		}
+	}
+	return mounts, nil
+}
+
+func compareMounts(intended *intendedMounts, actual map[string]client.Mount, ignore []string) []client.MountDrift {
+	drift := []client.MountDrift{}
+
+	for _, want := range intended.Mounts {
+		got, ok := actual[want.Export]
+		if !ok {
+			drift = append(drift, client.MountDrift{
+				Export: want.Export,
+				Device: want.Device,
+				Type:   client.DRIFT_MISSING,
+			})
+			continue
+		}
+
+		opts := compareMountOptions(want.Options, got.Options, ignore)
+		if want.Device != got.Device {
+			opts = append([]client.MountOptionDrift{{
+				Option:   "device",
+				Intended: want.Device,
+				Actual:   got.Device,
+			}}, opts...)
+		}
+		if len(opts) > 0 {
+			drift = append(drift, client.MountDrift{
+				Export:  want.Export,
+				Device:  got.Device,
+				Type:    client.DRIFT_OPTIONS,
+				Options: opts,
+			})
+		}
+	}
+
+	for export, got := range actual {
+		if _, ok := intended.Mounts[export]; ok {
+			continue
+		}
+
+		// With crossmnt the kernel mounts the submounts of an export, these
+		// are not listed in the intended mounts.
+		if isSubmount(export, intended.Mounts) {
+			continue
+		}
+
+		if intended.Options == nil {
+			drift = append(drift, client.MountDrift{
+				Export: export,
+				Device: got.Device,
+				Type:   client.DRIFT_EXTRA,
+			})
+			continue
+		}
+
+		if opts := compareMountOptions(intended.Options, got.Options, ignore); len(opts) > 0 {
+			drift = append(drift, client.MountDrift{
+				Export:  export,
+				Device:  got.Device,
+				Type:    client.DRIFT_OPTIONS,
+				Options: opts,
+			})
+		}
+	}
+
+	sort.Slice(drift, func(i, j int) bool {
+		return drift[i].Export < drift[j].Export
+	})
+	return drift
+}
+
+func isSubmount(export string, mounts map[string]intendedMount) bool {
+	for parent := path.Dir(export); ; parent = path.Dir(parent) {
+		if _, ok := mounts[parent]; ok {
+			return true
+		}
+		if parent == "/" {
+			return false
+		}
+	}
+}
+
+// mountOptionDefaults are the values the kernel uses when an option is not
+// shown in /proc/self/mountinfo.
+var mountOptionDefaults = map[string]string{
+	"nconnect":    "1",
+	"acregmin":    "3",
+	"acregmax":    "60",
+	"acdirmin":    "30",
+	"acdirmax":    "60",
+	"lookupcache": "all",
+}
+
+// mountFlagOpposites are flags that the kernel reports using a different name
+// when the flag is not set. Other flags are negated using a "no" prefix.
+var mountFlagOpposites = map[string]string{
+	"rw":    "ro",
+	"ro":    "rw",
+	"async": "sync",
+	"sync":  "async",
+	"hard":  "soft",
+	"soft":  "hard",
+}
+
+// defaultMountFlags are not shown in /proc/self/mountinfo when set.
+var defaultMountFlags = map[string]bool{
+	"async": true,
+	"hard":  true,
+	"ac":    true,
+	"cto":   true,
+}
+
+// compareMountOptions returns the intended options that do not match the
+// actual options. Options that are only in the actual options are ignored,
+// as the kernel reports options that were not set when mounting, such as
+// addr and clientaddr.
+func compareMountOptions(intended, actual map[string]string, ignore []string) []client.MountOptionDrift {
+	ignored := make(map[string]bool, len(ignore))
+	for _, o := range ignore {
+		ignored[o] = true
+	}
+
+	keys := make([]string, 0, len(intended))
+	for k := range intended {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var drift []client.MountOptionDrift
+	for _, k := range keys {
+		if ignored[k] {
+			continue
+		}
+
+		want := intended[k]
+		if want == "" {
+			if got, ok := compareMountFlag(k, actual); !ok {
+				drift = append(drift, client.MountOptionDrift{Option: k, Intended: k, Actual: got})
+			}
+			continue
+		}
+
+		got, ok := actual[k]
+		if !ok {
+			got = mountOptionDefaults[k]
+		}
+		if got == want {
+			continue
+		}
+		// vers=4 negotiates the highest minor version supported by the server
+		if k == "vers" && strings.HasPrefix(got, want+".") {
+			continue
+		}
+		drift = append(drift, client.MountOptionDrift{Option: k, Intended: want, Actual: got})
+	}
+	return drift
+}
+
+// compareMountFlag returns true if the flag is set. If the flag is not set
+// the opposite flag that was set is returned, if any.
+func compareMountFlag(flag string, actual map[string]string) (string, bool) {
+	if _, ok := actual[flag]; ok {
+		return "", true
+	}
+
+	opposite, ok := mountFlagOpposites[flag]
+	if !ok {
+		if strings.HasPrefix(flag, "no") {
+			opposite = strings.TrimPrefix(flag, "no")
+		} else {
+			opposite = "no" + flag
+		}
+	}
+	if _, ok := actual[opposite]; ok {
+		return opposite, false
+	}
+
+	return "", defaultMountFlags[flag]
+}
@@ -0,0 +1,213 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-agent/client"
+	"github.com/prometheus/procfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startupOptions are the options used by proxy-startup.sh, matching the
+// mount in testdata/proc/1/mountinfo.
+const startupOptions = "rw,noatime,nocto,async,hard,ac,vers=3,proto=tcp,timeo=600,retrans=2,lookupcache=all,local_lock=none," +
+	"nconnect=16,acdirmin=600,acdirmax=600,acregmin=600,acregmax=600,rsize=1048576,wsize=1048576,mountproto=tcp,fsc"
+
+func TestDetectMountDrift(t *testing.T) {
+	fs, err := procfs.NewFS("./testdata/proc/")
+	require.NoError(t, err)
+
+	proc, err := fs.Proc(1)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		intended string
+		expected []client.MountDrift
+	}{
+		{
+			name:     "no drift",
+			intended: "10.0.0.2:/files\t/files\t" + startupOptions + "\n",
+			expected: []client.MountDrift{},
+		},
+		{
+			name:     "options",
+			intended: "10.0.0.2:/files\t/files\t" + strings.NewReplacer("nconnect=16", "nconnect=32", "hard", "soft").Replace(startupOptions) + "\n",
+			expected: []client.MountDrift{{
+				Export: "/files",
+				Device: "10.0.0.2:/files",
+				Type:   client.DRIFT_OPTIONS,
+				Options: []client.MountOptionDrift{
+					{Option: "nconnect", Intended: "32", Actual: "16"},
+					{Option: "soft", Intended: "soft", Actual: "hard"},
+				},
+			}},
+		},
+		{
+			name: "missing and extra",
+			intended: "# comment\n" +
+				"10.0.0.2:/data\t/data\t" + startupOptions + "\n",
+			expected: []client.MountDrift{
+				{Export: "/data", Device: "10.0.0.2:/data", Type: client.DRIFT_MISSING},
+				{Export: "/files", Device: "10.0.0.2:/files", Type: client.DRIFT_EXTRA},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intended, err := parseIntendedMounts(strings.NewReader(tt.intended))
+			require.NoError(t, err)
+
+			actual, err := readActualMounts(proc, "/srv/nfs/")
+			require.NoError(t, err)
+
+			drift := compareMounts(intended, actual, nil)
+			assert.Equal(t, tt.expected, drift)
+		})
+	}
+}
+
+func TestParseIntendedMountsInvalid(t *testing.T) {
+	_, err := parseIntendedMounts(strings.NewReader("10.0.0.2:/files /files rw\n"))
+	assert.EqualError(t, err, "line 1: expected 3 fields, got 1")
+}
+
+func TestCompareMountOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		intended string
+		actual   string
+		ignore   []string
+		expected []client.MountOptionDrift
+	}{
+		{
+			name:     "kernel defaults",
+			intended: "async,hard,ac,nconnect=1,acregmin=3,lookupcache=all",
+			actual:   "rw,vers=3",
+		},
+		{
+			name:     "negotiated minor version",
+			intended: "vers=4",
+			actual:   "vers=4.2",
+		},
+		{
+			name:     "minor version",
+			intended: "vers=4.1",
+			actual:   "vers=4.2",
+			expected: []client.MountOptionDrift{{Option: "vers", Intended: "4.1", Actual: "4.2"}},
+		},
+		{
+			name:     "opposite flags",
+			intended: "hard,rw,noatime,fsc",
+			actual:   "soft,ro,atime",
+			expected: []client.MountOptionDrift{
+				{Option: "fsc", Intended: "fsc", Actual: ""},
+				{Option: "hard", Intended: "hard", Actual: "soft"},
+				{Option: "noatime", Intended: "noatime", Actual: "atime"},
+				{Option: "rw", Intended: "rw", Actual: "ro"},
+			},
+		},
+		{
+			name:     "reduced rsize",
+			intended: "rsize=1048576",
+			actual:   "rsize=524288",
+			expected: []client.MountOptionDrift{{Option: "rsize", Intended: "1048576", Actual: "524288"}},
+		},
+		{
+			name:     "ignored",
+			intended: "_netdev,nconnect=16",
+			actual:   "nconnect=16",
+			ignore:   []string{"_netdev"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drift := compareMountOptions(parseMountOptions(tt.intended), parseMountOptions(tt.actual), tt.ignore)
+			assert.Equal(t, tt.expected, drift)
+		})
+	}
+}
+
+func TestBuildIntendedMounts(t *testing.T) {
+	attrs := map[string]string{
+		"NFS_MOUNT_VERSION":         "3",
+		"NCONNECT":                  "16",
+		"ACDIRMIN":                  "600",
+		"ACDIRMAX":                  "600",
+		"ACREGMIN":                  "600",
+		"ACREGMAX":                  "600",
+		"RSIZE":                     "1048576",
+		"WSIZE":                     "1048576",
+		"MOUNT_OPTIONS":             "fsc",
+		"EXPORT_MAP":                "10.0.0.2;/files;/files,10.0.0.3;/home;/data",
+		"EXPORT_HOST_AUTO_DETECT":   "",
+		"ENABLE_NETAPP_AUTO_DETECT": "false",
+	}
+	get := func(name string) (string, error) {
+		v, ok := attrs[name]
+		if !ok {
+			return "", errors.New("not found")
+		}
+		return v, nil
+	}
+
+	m, err := buildIntendedMounts(get)
+	require.NoError(t, err)
+	assert.Equal(t, "metadata", m.Source)
+	assert.Nil(t, m.Options)
+	require.Len(t, m.Mounts, 2)
+	assert.Equal(t, "10.0.0.3:/home", m.Mounts["/data"].Device)
+	assert.Equal(t, parseMountOptions(startupOptions), m.Mounts["/files"].Options)
+
+	// Exports discovered at startup are compared with the common options.
+	attrs["EXPORT_HOST_AUTO_DETECT"] = "10.0.0.4"
+	m, err = buildIntendedMounts(get)
+	require.NoError(t, err)
+	assert.Equal(t, parseMountOptions(startupOptions), m.Options)
+
+	delete(attrs, "NCONNECT")
+	_, err = buildIntendedMounts(get)
+	assert.EqualError(t, err, "NCONNECT: not found")
+}
+
+func TestDriftConfig(t *testing.T) {
+	input := strings.Join([]string{
+		"[drift]",
+		"source = metadata",
+		"ignore-options = _netdev, x-systemd.automount",
+	}, "\n")
+
+	cfg := new(Config)
+	f := newFlagSet("test", cfg)
+	require.NoError(t, parseConfig(cfg, strings.NewReader(input)))
+	require.NoError(t, f.Parse(nil))
+
+	assert.Equal(t, "metadata", cfg.Drift.Source)
+	assert.Equal(t, defaultIntendedMountsFile, cfg.Drift.File)
+	assert.Equal(t, []string{"_netdev", "x-systemd.automount"}, cfg.Drift.IgnoreOptions)
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Drift.Source = "exports"
+	assert.Error(t, cfg.Validate())
+}
@@ -0,0 +1,110 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const exportsFile = "/etc/exports"
+
+// export is an entry from /etc/exports. The path is relative to the NFS root.
+type export struct {
+	Path    string
+	Clients []exportClient
+}
+
+// exportClient is a client and its options, such as "10.0.0.0/8(rw,async)".
+// The host is empty for the default options, such as "(ro)".
+type exportClient struct {
+	Host    string
+	Options string
+}
+
+func (c exportClient) String() string {
+	return fmt.Sprintf("%s(%s)", c.Host, c.Options)
+}
+
+func readExports(name string) ([]export, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseExports(f)
+}
+
+// parseExports parses an exports file. Only the formats written by the proxy
+// startup script are supported, lines cannot be continued using "\".
+func parseExports(r io.Reader) ([]export, error) {
+	var exports []export
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var e export
+		if strings.HasPrefix(line, `"`) {
+			end := strings.Index(line[1:], `"`)
+			if end < 0 {
+				return nil, fmt.Errorf("invalid export %q", line)
+			}
+			e.Path = line[1 : end+1]
+			line = line[end+2:]
+		} else {
+			e.Path, line, _ = strings.Cut(strings.ReplaceAll(line, "\t", " "), " ")
+		}
+
+		for _, field := range strings.Fields(line) {
+			host, options, found := strings.Cut(field, "(")
+			if found {
+				if !strings.HasSuffix(options, ")") {
+					return nil, fmt.Errorf("invalid export %q", s.Text())
+				}
+				options = strings.TrimSuffix(options, ")")
+			}
+			e.Clients = append(e.Clients, exportClient{host, options})
+		}
+
+		exports = append(exports, e)
+	}
+	return exports, s.Err()
+}
+
+func exportPaths(exports []export) []string {
+	paths := make([]string, len(exports))
+	for i, e := range exports {
+		paths[i] = e.Path
+	}
+	return paths
+}
+
+// findExport returns the export for path.
+func findExport(exports []export, path string) (export, bool) {
+	for _, e := range exports {
+		if e.Path == path {
+			return e, true
+		}
+	}
+	return export{}, false
+}
@@ -0,0 +1,52 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExports(t *testing.T) {
+	input := strings.Join([]string{
+		"# comment",
+		"/   10.0.0.0/8(rw,fsid=0,reexport=auto-fsidnum)",
+		"",
+		"/files\t10.0.0.0/8(rw,reexport=auto-fsidnum) 192.168.0.0/16(ro)",
+		`"/with space" 10.0.0.0/8(rw,reexport=auto-fsidnum)`,
+		"/default (ro) host",
+	}, "\n")
+
+	exports, err := parseExports(strings.NewReader(input))
+	require.NoError(t, err)
+	assert.Equal(t, []export{
+		{"/", []exportClient{{"10.0.0.0/8", "rw,fsid=0,reexport=auto-fsidnum"}}},
+		{"/files", []exportClient{
+			{"10.0.0.0/8", "rw,reexport=auto-fsidnum"},
+			{"192.168.0.0/16", "ro"},
+		}},
+		{"/with space", []exportClient{{"10.0.0.0/8", "rw,reexport=auto-fsidnum"}}},
+		{"/default", []exportClient{{"", "ro"}, {"host", ""}}},
+	}, exports)
+	assert.Equal(t, []string{"/", "/files", "/with space", "/default"}, exportPaths(exports))
+
+	_, err = parseExports(strings.NewReader("/files 10.0.0.0/8(rw"))
+	assert.Error(t, err)
+}
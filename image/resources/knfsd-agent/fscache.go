@@ -0,0 +1,483 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-agent/client"
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-common/fscache"
+	"github.com/prometheus/procfs"
+	"golang.org/x/sys/unix"
+)
+
+const cachefilesdConfigFile = "/etc/cachefilesd.conf"
+
+func handleFSCacheStats(*http.Request) (*client.FSCacheStatsResponse, error) {
+	f, err := os.Open(fscache.StatsFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseFSCacheStats(f)
+}
+
+// parseFSCacheStats parses /proc/fs/fscache/stats. The counters vary between
+// kernel versions, so every counter is included in the sections.
+func parseFSCacheStats(r io.Reader) (*client.FSCacheStatsResponse, error) {
+	stats, err := fscache.ParseStats(r)
+	if err != nil {
+		return nil, err
+	}
+	get := stats.Get
+
+	// Kernels before 5.17 do not have the netfs counters, leave the
+	// retrievals as zero and only include the sections.
+	netfs, _ := stats.Netfs()
+
+	return &client.FSCacheStatsResponse{
+		Cookies: client.FSCacheCookies{
+			Data:    get("Cookies", "n"),
+			Volumes: get("Cookies", "v"),
+		},
+		Acquire: client.FSCacheAcquire{
+			Requests: get("Acquire", "n"),
+			OK:       get("Acquire", "ok"),
+			OOM:      get("Acquire", "oom"),
+		},
+		LRU: client.FSCacheLRU{
+			Cookies: get("LRU", "n"),
+			Expired: get("LRU", "exp"),
+			Removed: get("LRU", "rmv"),
+			Dropped: get("LRU", "drp"),
+		},
+		Invalidations: get("Invals", "n"),
+		Updates:       get("Updates", "n"),
+		Relinquishes: client.FSCacheRelinquish{
+			Requests: get("Relinqs", "n"),
+			Retire:   get("Relinqs", "rtr"),
+			Drop:     get("Relinqs", "drop"),
+		},
+		NoSpace: client.FSCacheNoSpace{
+			Writes:  get("NoSpace", "nwr"),
+			Creates: get("NoSpace", "ncr"),
+			Culled:  get("NoSpace", "cull"),
+		},
+		IO: client.FSCacheIO{
+			Reads:  get("IO", "rd"),
+			Writes: get("IO", "wr"),
+		},
+		Retrievals: client.FSCacheRetrievals{
+			Hits:         netfs.CacheReads,
+			HitsFailed:   netfs.CacheReadsFailed,
+			Misses:       netfs.Downloads,
+			MissesFailed: netfs.DownloadsFailed,
+		},
+		Sections: stats,
+	}, nil
+}
+
+func handleCachefilesd(*http.Request) (*client.CachefilesdResponse, error) {
+	cfg, err := readCachefilesdConfig(cachefilesdConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var s unix.Statfs_t
+	err = unix.Statfs(cfg.Dir, &s)
+	if err != nil {
+		return nil, err
+	}
+
+	return cachefilesdLimits(cfg, s.Bavail, s.Blocks, s.Ffree, s.Files), nil
+}
+
+func cachefilesdLimits(cfg cachefilesdConfig, blocksFree, blocks, filesFree, files uint64) *client.CachefilesdResponse {
+	res := &client.CachefilesdResponse{
+		Dir:        cfg.Dir,
+		BRun:       cfg.BRun,
+		BCull:      cfg.BCull,
+		BStop:      cfg.BStop,
+		FRun:       cfg.FRun,
+		FCull:      cfg.FCull,
+		FStop:      cfg.FStop,
+		BlocksFree: percent(blocksFree, blocks),
+		FilesFree:  percent(filesFree, files),
+	}
+
+	switch health, _ := cacheSpaceHealth(cfg, blocksFree, blocks, filesFree, files); health {
+	case client.CHECK_FAIL:
+		res.State = "full"
+	case client.CHECK_WARN:
+		res.State = "culling"
+	default:
+		res.State = "ok"
+	}
+	return res
+}
+
+// CacheConfig configures the background scan of the FS-Cache volumes.
+type CacheConfig struct {
+	// ScanInterval is how often to scan the cache directory.
+	ScanInterval time.Duration `ini:"scan-interval"`
+
+	// ScanSample is the number of fan-out directories to scan in each volume.
+	// cachefiles spreads the files in a volume over 256 directories, scanning
+	// a sample of these directories estimates the size of the volume while
+	// reading fewer files. 0 scans every directory.
+	ScanSample int `ini:"scan-sample"`
+}
+
+func (cfg *CacheConfig) Validate() error {
+	var err error
+	if cfg.ScanInterval <= 0 {
+		err = errors.Join(err, errors.New("\"cache-scan-interval\" must be greater than zero"))
+	}
+	if cfg.ScanSample < 0 || cfg.ScanSample > 256 {
+		err = errors.Join(err, errors.New("\"cache-scan-sample\" must be between 0 and 256"))
+	}
+	return err
+}
+
+// CacheScanner periodically scans the FS-Cache volumes in the background, as
+// scanning a large cache can take several minutes.
+type CacheScanner struct {
+	cfg CacheConfig
+
+	// scan can be replaced for testing.
+	scan func() (*client.CacheVolumesResponse, error)
+
+	mu   sync.RWMutex
+	last *client.CacheVolumesResponse
+}
+
+func NewCacheScanner(cfg CacheConfig) *CacheScanner {
+	c := &CacheScanner{cfg: cfg}
+	c.scan = c.scanCache
+	return c
+}
+
+// Run scans the cache every interval until ctx is cancelled.
+func (c *CacheScanner) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.ScanInterval)
+	defer ticker.Stop()
+
+	for {
+		res, err := c.scan()
+		if err != nil {
+			log.Printf("could not scan cache: %s", err)
+		} else {
+			c.mu.Lock()
+			c.last = res
+			c.mu.Unlock()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *CacheScanner) handleCacheVolumes(*http.Request) (*client.CacheVolumesResponse, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.last == nil {
+		return nil, &requestError{http.StatusServiceUnavailable, errors.New("cache has not been scanned yet")}
+	}
+	return c.last, nil
+}
+
+func (c *CacheScanner) scanCache() (*client.CacheVolumesResponse, error) {
+	cfg, err := readCachefilesdConfig(cachefilesdConfigFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		cfg, err = defaultCachefilesdConfig, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	volumes, sampled, err := scanCacheVolumes(filepath.Join(cfg.Dir, "cache"), c.cfg.ScanSample)
+	if err != nil {
+		return nil, err
+	}
+
+	mounts, err := readCacheMounts()
+	if err != nil {
+		// The sizes are still useful without the exports.
+		log.Printf("could not read mounts: %s", err)
+	}
+	matchVolumeExports(volumes, mounts)
+
+	return &client.CacheVolumesResponse{
+		ScannedAt: start,
+		Duration:  client.Duration(time.Since(start).Round(time.Millisecond)),
+		Sampled:   sampled,
+		Volumes:   volumes,
+	}, nil
+}
+
+func readCacheMounts() ([]client.Mount, error) {
+	nfsRoot, err := getNFSRootDir()
+	if err != nil {
+		return nil, err
+	}
+
+	self, err := procfs.Self()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := readMounts(self, nfsRoot)
+	if err != nil {
+		return nil, err
+	}
+	return res.Mounts, nil
+}
+
+// scanCacheVolumes returns the size of each volume in the cachefiles cache
+// directory. The cache directory contains a directory for each volume, which
+// contains 256 fan-out directories (@00 to @ff) that contain the data files.
+func scanCacheVolumes(dir string, sample int) ([]client.CacheVolume, bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var volumes []client.CacheVolume
+	sampled := false
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		v := decodeVolumeName(e.Name())
+		s, err := scanCacheVolume(filepath.Join(dir, e.Name()), sample, &v)
+		if err != nil {
+			return nil, false, err
+		}
+		sampled = sampled || s
+		volumes = append(volumes, v)
+	}
+
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].Bytes > volumes[j].Bytes })
+	return volumes, sampled, nil
+}
+
+func scanCacheVolume(dir string, sample int, v *client.CacheVolume) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+
+	var fanout []string
+	for _, e := range entries {
+		if e.IsDir() && strings.HasPrefix(e.Name(), "@") {
+			fanout = append(fanout, e.Name())
+		}
+	}
+
+	// Scan evenly spaced directories, so that repeated scans use the same
+	// sample.
+	selected := fanout
+	if sample > 0 && sample < len(fanout) {
+		selected = make([]string, sample)
+		for i := range selected {
+			selected[i] = fanout[i*len(fanout)/sample]
+		}
+	}
+
+	var bytes, files uint64
+	for _, name := range selected {
+		err := filepath.WalkDir(filepath.Join(dir, name), func(path string, d fs.DirEntry, err error) error {
+			if errors.Is(err, fs.ErrNotExist) {
+				// culled while scanning
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+
+			info, err := d.Info()
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			// Cache files are sparse, so count the allocated blocks rather
+			// than the file size.
+			if st, ok := info.Sys().(*syscall.Stat_t); ok {
+				bytes += uint64(st.Blocks) * 512
+			} else {
+				bytes += uint64(info.Size())
+			}
+			files++
+			return nil
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if len(selected) < len(fanout) {
+		bytes = bytes * uint64(len(fanout)) / uint64(len(selected))
+		files = files * uint64(len(fanout)) / uint64(len(selected))
+	}
+	v.Bytes = bytes
+	v.Files = files
+	return len(selected) < len(fanout), nil
+}
+
+// decodeVolumeName decodes the server address, NFS version and FSID from the
+// name of an NFS volume directory.
+//
+// cachefiles names the directory "I" followed by the volume key. The NFS key
+// is created by nfs_fscache_get_super_cookie (fs/nfs/fscache.c), and starts
+// with "nfs,<version>.<minor>,<family>" followed by the port, address and
+// FSID as comma separated hex integers. The integers are written in host byte
+// order.
+//
+// Volumes that cannot be decoded only include the name.
+func decodeVolumeName(name string) client.CacheVolume {
+	v := client.CacheVolume{Name: name}
+
+	key, found := strings.CutPrefix(name, "I")
+	if !found {
+		return v
+	}
+	parts := strings.Split(key, ",")
+	if len(parts) < 3 || parts[0] != "nfs" {
+		return v
+	}
+
+	family, err := strconv.ParseUint(parts[2], 16, 16)
+	if err != nil {
+		return v
+	}
+
+	var addr netip.Addr
+	var rest []string
+	switch family {
+	case unix.AF_INET:
+		if len(parts) < 7 {
+			return v
+		}
+		var b [4]byte
+		if !decodeKeyWords(b[:], parts[4:5]) {
+			return v
+		}
+		addr = netip.AddrFrom4(b)
+		rest = parts[5:]
+	case unix.AF_INET6:
+		if len(parts) < 10 {
+			return v
+		}
+		var b [16]byte
+		if !decodeKeyWords(b[:], parts[4:8]) {
+			return v
+		}
+		addr = netip.AddrFrom16(b)
+		rest = parts[8:]
+	default:
+		return v
+	}
+
+	v.Server = addr.String()
+	v.Version = parts[1]
+	v.FSID = rest[0] + ":" + rest[1]
+	return v
+}
+
+// nativeEndian is the host's byte order.
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// decodeKeyWords decodes 32-bit words of an address in network byte order
+// that were written as host byte order integers.
+func decodeKeyWords(b []byte, words []string) bool {
+	for i, w := range words {
+		n, err := strconv.ParseUint(w, 16, 32)
+		if err != nil {
+			return false
+		}
+		nativeEndian.PutUint32(b[i*4:], uint32(n))
+	}
+	return true
+}
+
+// matchVolumeExports sets the exports that could be using each volume. NFS
+// volumes are per filesystem on the source server, and the FSID is not
+// available from the mount, so exports are matched by the server address and
+// NFS version. If multiple exports are on the same server, all the exports are
+// listed.
+func matchVolumeExports(volumes []client.CacheVolume, mounts []client.Mount) {
+	for i := range volumes {
+		v := &volumes[i]
+		v.Exports = []string{}
+		if v.Server == "" {
+			continue
+		}
+
+		for _, m := range mounts {
+			host, _, found := strings.Cut(m.Device, ":/")
+			if !found {
+				continue
+			}
+			host = strings.Trim(host, "[]")
+			if addr, err := netip.ParseAddr(host); err != nil || addr.String() != v.Server {
+				continue
+			}
+			if vers, found := m.Options["vers"]; found && nfsVersion(vers) != nfsVersion(v.Version) {
+				continue
+			}
+			v.Exports = append(v.Exports, m.Export)
+		}
+	}
+}
+
+// nfsVersion normalises an NFS version, so that "3" and "3.0" are equal.
+func nfsVersion(v string) string {
+	return strings.TrimSuffix(v, ".0")
+}
@@ -0,0 +1,143 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-agent/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFSCacheStats(t *testing.T) {
+	f, err := os.Open("testdata/proc/fs/fscache/stats")
+	require.NoError(t, err)
+	defer f.Close()
+
+	s, err := parseFSCacheStats(f)
+	require.NoError(t, err)
+
+	assert.Equal(t, client.FSCacheCookies{Data: 1532, Volumes: 2}, s.Cookies)
+	assert.Equal(t, client.FSCacheLRU{Cookies: 305, Expired: 120, Removed: 4, Dropped: 116}, s.LRU)
+	assert.Equal(t, client.FSCacheNoSpace{Writes: 12, Culled: 96}, s.NoSpace)
+	assert.Equal(t, client.FSCacheRetrievals{Hits: 48211, Misses: 9120, MissesFailed: 2}, s.Retrievals)
+	assert.Equal(t, uint64(3), s.Invalidations)
+
+	// Repeated sections are merged.
+	assert.Equal(t, uint64(9120), s.Sections["Netfs"]["UL"])
+	assert.Equal(t, uint64(5120), s.Sections["Netfs"]["RA"])
+
+	// Kernels from 6.10 name each netfs section after the operation.
+	s, err = parseFSCacheStats(strings.NewReader("DownOps: DL=10 ds=9 df=1 di=0\nCaRdOps: RD=20 rs=18 rf=2\nCaWrOps: WR=9 ws=9 wf=0\n"))
+	require.NoError(t, err)
+	assert.Equal(t, client.FSCacheRetrievals{Hits: 20, HitsFailed: 2, Misses: 10, MissesFailed: 1}, s.Retrievals)
+
+	// Older kernels use a different format, only the sections are populated.
+	s, err = parseFSCacheStats(strings.NewReader("FS-Cache statistics\nRetrvls: n=10 ok=8 wt=0 nod=2\n"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(8), s.Sections["Retrvls"]["ok"])
+
+	_, err = parseFSCacheStats(strings.NewReader("Cookies: n=abc\n"))
+	assert.Error(t, err)
+}
+
+func TestCachefilesdLimits(t *testing.T) {
+	cfg := defaultCachefilesdConfig
+	res := cachefilesdLimits(cfg, 50, 100, 90, 100)
+	assert.Equal(t, 50, res.BlocksFree)
+	assert.Equal(t, 90, res.FilesFree)
+	assert.Equal(t, "ok", res.State)
+
+	res = cachefilesdLimits(cfg, 5, 100, 90, 100)
+	assert.Equal(t, "culling", res.State)
+
+	res = cachefilesdLimits(cfg, 50, 100, 1, 100)
+	assert.Equal(t, "full", res.State)
+}
+
+func TestDecodeVolumeName(t *testing.T) {
+	// 10.0.0.3 and port 2049 written as little endian integers
+	v := decodeVolumeName("Infs,3.0,2,108,300000a,1c,0,0,0,100000,100000,3c,1e,3c")
+	assert.Equal(t, "10.0.0.3", v.Server)
+	assert.Equal(t, "3.0", v.Version)
+	assert.Equal(t, "1c:0", v.FSID)
+
+	v = decodeVolumeName("Infs,4.2,a,108,20fd,0,0,3000000,2b,0,0")
+	assert.Equal(t, "fd20::3", v.Server)
+	assert.Equal(t, "4.2", v.Version)
+	assert.Equal(t, "2b:0", v.FSID)
+
+	for _, name := range []string{
+		"Icifs,1.0,2",
+		"Infs,3.0,2,108",
+		"Infs,3.0,7,108,300000a,1c,0",
+		"Jbase64",
+	} {
+		v := decodeVolumeName(name)
+		assert.Equal(t, client.CacheVolume{Name: name}, v, name)
+	}
+}
+
+func TestScanCacheVolumes(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, size int) {
+		name = filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+		require.NoError(t, os.WriteFile(name, make([]byte, size), 0644))
+	}
+
+	write("Infs,3.0,2,108,300000a,1c,0/@00/file1", 4096)
+	write("Infs,3.0,2,108,300000a,1c,0/@01/file2", 4096)
+	write("Infs,3.0,2,108,300000a,1c,0/@02/file3", 4096)
+	write("Infs,3.0,2,108,300000a,1c,0/@03/file4", 4096)
+	write("Icifs/@00/file1", 4096)
+
+	volumes, sampled, err := scanCacheVolumes(dir, 0)
+	require.NoError(t, err)
+	assert.False(t, sampled)
+	require.Len(t, volumes, 2)
+	assert.Equal(t, "10.0.0.3", volumes[0].Server)
+	assert.Equal(t, uint64(4), volumes[0].Files)
+	assert.Greater(t, volumes[0].Bytes, volumes[1].Bytes)
+
+	volumes, sampled, err = scanCacheVolumes(dir, 2)
+	require.NoError(t, err)
+	assert.True(t, sampled)
+	assert.Equal(t, uint64(4), volumes[0].Files, "estimated from @00 and @02")
+}
+
+func TestMatchVolumeExports(t *testing.T) {
+	volumes := []client.CacheVolume{
+		{Server: "10.0.0.3", Version: "3.0"},
+		{Server: "fd20::3", Version: "4.2"},
+		{Name: "unknown"},
+	}
+	mounts := []client.Mount{
+		{Device: "10.0.0.3:/data", Export: "/data", Options: map[string]string{"vers": "3"}},
+		{Device: "10.0.0.3:/home", Export: "/home", Options: map[string]string{"vers": "4.2"}},
+		{Device: "[fd20::3]:/assets", Export: "/assets", Options: map[string]string{"vers": "4.2"}},
+	}
+
+	matchVolumeExports(volumes, mounts)
+	assert.Equal(t, []string{"/data"}, volumes[0].Exports)
+	assert.Equal(t, []string{"/assets"}, volumes[1].Exports)
+	assert.Equal(t, []string{}, volumes[2].Exports)
+}
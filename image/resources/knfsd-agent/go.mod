@@ -3,14 +3,27 @@ module github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-ag
 go 1.20
 
 require (
+	github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-common v0.0.0
 	github.com/acobaugh/osrelease v0.1.0
+	github.com/coreos/go-systemd/v22 v22.5.0
+	github.com/go-ini/ini v1.67.0
+	github.com/prometheus/client_golang v1.17.0
 	github.com/prometheus/procfs v0.12.0
 	github.com/stretchr/testify v1.8.4
 	golang.org/x/sys v0.12.0
 )
 
 require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.4 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/prometheus/client_model v0.4.1-0.20230718164431-9a2bf3000d16 // indirect
+	github.com/prometheus/common v0.44.0 // indirect
+	google.golang.org/protobuf v1.31.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-common => ../knfsd-common
@@ -0,0 +1,214 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-agent/client"
+)
+
+// HealthConfig selects which failed checks are fatal for the /healthz and
+// /readyz endpoints. Each rule is a pattern matching "service/check" using
+// path.Match syntax, for example "nfs mounts/*" or "*/running".
+//
+// Only checks that FAIL are fatal, checks that WARN never cause the endpoints
+// to report the proxy as unhealthy.
+type HealthConfig struct {
+	// Interval is how often the status checks are run.
+	Interval time.Duration `ini:"interval"`
+
+	// Live is used for /healthz, which reports whether the proxy is broken
+	// and should be replaced.
+	Live []string `ini:"healthz-fatal"`
+
+	// Ready is used for /readyz, which reports whether the proxy should
+	// receive traffic from clients.
+	Ready []string `ini:"readyz-fatal"`
+}
+
+var defaultHealthConfig = HealthConfig{
+	Interval: defaultHealthInterval,
+	Live: []string{
+		"nfs-kernel-server/running",
+		"nfs-kernel-server/threads",
+	},
+	Ready: []string{"*/*"},
+}
+
+// HealthChecker periodically runs the status checks in the background, so
+// that the /healthz and /readyz endpoints respond quickly. Load balancer
+// health checks have a short timeout, and are sent by multiple probers.
+type HealthChecker struct {
+	cfg HealthConfig
+
+	// status can be replaced for testing.
+	status func() *client.StatusResponse
+
+	// draining is set by the admin API to remove the proxy from the load
+	// balancer without stopping the NFS server.
+	draining atomic.Bool
+
+	mu        sync.RWMutex
+	last      *client.StatusResponse
+	checkedAt time.Time
+}
+
+func NewHealthChecker(cfg HealthConfig) *HealthChecker {
+	return &HealthChecker{
+		cfg:    cfg,
+		status: readStatus,
+	}
+}
+
+// Run checks the status every interval until ctx is cancelled.
+func (h *HealthChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		h.Check()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check runs the status checks and records the result.
+func (h *HealthChecker) Check() {
+	status := h.status()
+	h.mu.Lock()
+	h.last = status
+	h.checkedAt = time.Now()
+	h.mu.Unlock()
+}
+
+// Live returns nil if the proxy is live, otherwise an error explaining why the
+// proxy is not live.
+func (h *HealthChecker) Live() error {
+	return h.evaluate(h.cfg.Live)
+}
+
+// Ready returns nil if the proxy is ready, otherwise an error explaining why
+// the proxy is not ready.
+func (h *HealthChecker) Ready() error {
+	if h.draining.Load() {
+		return errors.New("draining")
+	}
+	return h.evaluate(h.cfg.Ready)
+}
+
+// SetDraining sets whether the proxy is draining. While draining /readyz
+// reports the proxy is not ready, so that load balancers stop sending new
+// connections to the proxy.
+func (h *HealthChecker) SetDraining(draining bool) {
+	h.draining.Store(draining)
+}
+
+func (h *HealthChecker) evaluate(rules []string) error {
+	h.mu.RLock()
+	status, checkedAt := h.last, h.checkedAt
+	h.mu.RUnlock()
+
+	if status == nil {
+		return fmt.Errorf("status has not been checked yet")
+	}
+
+	// If the checks are stuck, the last status cannot be trusted.
+	if age := time.Since(checkedAt); age > 3*h.cfg.Interval {
+		return fmt.Errorf("status has not been checked for %s", age.Truncate(time.Second))
+	}
+
+	var reasons []string
+	for _, s := range status.Services {
+		for _, c := range s.Checks {
+			if c.Result != client.CHECK_FAIL {
+				continue
+			}
+			if !matchHealthRule(rules, s.Name, c.Name) {
+				continue
+			}
+
+			reason := s.Name + "/" + c.Name
+			if c.Error != "" {
+				reason += ": " + c.Error
+			}
+			reasons = append(reasons, reason)
+		}
+	}
+
+	if len(reasons) > 0 {
+		return fmt.Errorf("%s", strings.Join(reasons, "\n"))
+	}
+	return nil
+}
+
+func matchHealthRule(rules []string, service, check string) bool {
+	name := service + "/" + check
+	for _, r := range rules {
+		if ok, _ := path.Match(r, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeHTTP implements the /healthz and /readyz endpoints. The endpoints
+// return 200 if the proxy is live or ready, otherwise 503. The body is a
+// short plain-text reason that is shown in the health check logs.
+func (h *HealthChecker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var err error
+	switch r.URL.Path {
+	case "/healthz":
+		err = h.Live()
+	case "/readyz":
+		err = h.Ready()
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+
+	statusCode := http.StatusOK
+	body := "ok\n"
+	if err != nil {
+		statusCode = http.StatusServiceUnavailable
+		body = err.Error() + "\n"
+	}
+
+	w.WriteHeader(statusCode)
+	if r.Method != http.MethodHead {
+		w.Write([]byte(body))
+	}
+
+	// Only log failures, as the health checks are called frequently.
+	if err != nil {
+		logRequest(r, statusCode, err)
+	}
+}
@@ -0,0 +1,108 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-agent/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthChecker(t *testing.T) {
+	status := &client.StatusResponse{
+		Services: []client.ServiceHealth{
+			{
+				Name: "nfs-kernel-server",
+				Checks: []client.ServiceCheck{
+					{Name: "running", Result: client.CHECK_PASS},
+					{Name: "thread saturation", Result: client.CHECK_WARN, Error: "busy"},
+				},
+			},
+			{
+				Name: "nfs mounts",
+				Checks: []client.ServiceCheck{
+					{Name: "mounts responsive", Result: client.CHECK_FAIL, Error: "/srv/nfs/files: not responding"},
+				},
+			},
+		},
+	}
+
+	h := NewHealthChecker(HealthConfig{
+		Interval: time.Minute,
+		Live:     defaultHealthConfig.Live,
+		Ready:    defaultHealthConfig.Ready,
+	})
+	h.status = func() *client.StatusResponse { return status }
+
+	execute := func(path string) (int, string) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		res := w.Result()
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res.StatusCode, string(body)
+	}
+
+	t.Run("not checked", func(t *testing.T) {
+		code, body := execute("/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, "status has not been checked yet\n", body)
+	})
+
+	h.Check()
+
+	t.Run("live", func(t *testing.T) {
+		code, body := execute("/healthz")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "ok\n", body)
+	})
+
+	t.Run("not ready", func(t *testing.T) {
+		code, body := execute("/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, "nfs mounts/mounts responsive: /srv/nfs/files: not responding\n", body)
+	})
+
+	t.Run("ignored", func(t *testing.T) {
+		h.cfg.Ready = []string{"nfs-kernel-server/*"}
+		defer func() { h.cfg.Ready = defaultHealthConfig.Ready }()
+
+		code, _ := execute("/readyz")
+		assert.Equal(t, http.StatusOK, code)
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		h.checkedAt = time.Now().Add(-time.Hour)
+		code, body := execute("/healthz")
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, "status has not been checked for 1h0m0s\n", body)
+	})
+}
+
+func TestMatchHealthRule(t *testing.T) {
+	assert.True(t, matchHealthRule([]string{"*/*"}, "nfs mounts", "mounts responsive"))
+	assert.True(t, matchHealthRule([]string{"*/running"}, "cachefilesd", "running"))
+	assert.False(t, matchHealthRule([]string{"*/running"}, "cachefilesd", "enabled"))
+	assert.False(t, matchHealthRule(nil, "cachefilesd", "running"))
+}
@@ -17,38 +17,136 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/coreos/go-systemd/v22/journal"
 )
 
-func configureLogging() {
-	// Create Logging Directory if it does not exist
-	err := os.MkdirAll("/var/log/knfsd-agent", os.ModePerm)
-	if err != nil {
-		log.Fatalf("Error creating logging directory: %s", err.Error())
+func configureLogging(cfg LogConfig) error {
+	switch cfg.Output {
+	case "stderr":
+		log.SetOutput(os.Stderr)
+	case "journald":
+		if !journal.Enabled() {
+			log.SetOutput(os.Stderr)
+			log.Print("journald is not available, logging to stderr")
+			return nil
+		}
+		// journald records the time of each message
+		log.SetFlags(0)
+		log.SetOutput(journalWriter{})
+	default:
+		// Create Logging Directory if it does not exist
+		err := os.MkdirAll(filepath.Dir(cfg.File), os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("error creating logging directory: %w", err)
+		}
+
+		file, err := os.OpenFile(cfg.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+		if err != nil {
+			return fmt.Errorf("error creating logging file: %w", err)
+		}
+		log.SetOutput(file)
 	}
+	return nil
+}
 
-	// Setup Logging
-	file, err := os.OpenFile("/var/log/knfsd-agent/agent.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+// journalWriter writes each log message to journald.
+type journalWriter struct{}
+
+func (journalWriter) Write(p []byte) (int, error) {
+	err := journal.Send(strings.TrimSuffix(string(p), "\n"), journal.PriInfo, nil)
 	if err != nil {
-		log.Fatalf("Error creating logging file: %s", err.Error())
+		return 0, err
 	}
-	log.SetOutput(file)
-
+	return len(p), nil
 }
 
 func main() {
-	configureLogging()
+	cfg := new(Config)
+	f := newFlagSet(os.Args[0], cfg)
+	err := loadConfig(cfg, f, os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	err = configureLogging(cfg.Log)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
 	// Populate Node Info
-	err := fetchNodeInfo()
+	err = fetchNodeInfo()
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	mountDrift = NewDriftDetector(cfg.Drift)
+
+	health := NewHealthChecker(cfg.Health)
+	go health.Run(ctx)
+
+	sampler := NewSampler(cfg.Rates)
+	go sampler.Run(ctx)
+
+	scanner := NewCacheScanner(cfg.Cache)
+	go scanner.Run(ctx)
+
+	var admin *AdminAPI
+	if cfg.Admin.Enabled() {
+		admin, err = newAdminAPI(cfg.Admin, health)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("Admin endpoints are enabled")
+	}
+
+	// The admin endpoints are only served over TLS so that the token cannot be
+	// read from the network, unless insecure access has been allowed.
+	tlsMux := http.NewServeMux()
+	registerRoutes(tlsMux, health, sampler, scanner, admin)
+	if !cfg.Admin.AllowInsecure {
+		admin = nil
+	}
 	mux := http.NewServeMux()
-	registerRoutes(mux)
-	log.Println("Knfsd Agent is listening on web server port 80...")
-	http.ListenAndServe(":80", mux)
+	registerRoutes(mux, health, sampler, scanner, admin)
+	err = serve(ctx, cfg.Server, mux, tlsMux)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Knfsd Agent stopped")
+}
+
+func newAdminAPI(cfg AdminConfig, health *HealthChecker) (*AdminAPI, error) {
+	token, err := cfg.ReadToken()
+	if err != nil {
+		return nil, err
+	}
+
+	audit, err := openAuditLog(cfg.AuditLog)
+	if err != nil {
+		return nil, err
+	}
+
+	admin := NewAdminAPI(token, audit, health)
+	admin.clientCert = cfg.ClientCert
+	return admin, nil
 }
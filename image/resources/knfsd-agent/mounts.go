@@ -17,9 +17,15 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"path"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-agent/client"
 	"github.com/prometheus/procfs"
@@ -236,3 +242,124 @@ func combineMountOptions(opts ...map[string]string) map[string]string {
 	}
 	return combined
 }
+
+// mountStatTimeout limits how long to wait for an NFS mount to respond. NFS
+// mounts use the hard option, so a stat on an unresponsive mount will block
+// until the source server responds.
+const mountStatTimeout = 5 * time.Second
+
+// pendingStats records the mounts with a stat that has not returned. A new
+// stat is not started for these mounts to avoid leaking a goroutine for every
+// status check while the mount is unresponsive.
+var pendingStats sync.Map
+
+func nfsMountsStatus() client.ServiceHealth {
+	health := ServiceHealth{Name: "nfs mounts", Health: client.CHECK_PASS}
+
+	nfsRoot, err := getNFSRootDir()
+	if err != nil {
+		health.Fail("nfs root", err)
+		return client.ServiceHealth(health)
+	}
+
+	self, err := procfs.Self()
+	if err != nil {
+		health.Fail("read mounts", err)
+		return client.ServiceHealth(health)
+	}
+
+	mounts, err := readNFSMountPoints(self, nfsRoot)
+	if err != nil {
+		health.Fail("read mounts", err)
+		return client.ServiceHealth(health)
+	}
+
+	exports, err := readExports(exportsFile)
+	if err != nil {
+		health.Fail("read exports", err)
+	} else {
+		health.Check("exports mounted", checkExportsMounted(nfsRoot, exportPaths(exports), mounts))
+	}
+
+	health.Check("mounts responsive", checkMountsResponsive(mounts, mountStatTimeout))
+	health.checkMountDrift(self, nfsRoot)
+	return client.ServiceHealth(health)
+}
+
+// readNFSMountPoints returns the NFS mounts within the NFS root, including a
+// mount of the NFS root itself.
+func readNFSMountPoints(proc procfs.Proc, nfsRoot string) ([]string, error) {
+	info, err := proc.MountInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	root := strings.TrimSuffix(nfsRoot, "/")
+	var mounts []string
+	for _, e := range info {
+		if !isNFS(e.FSType) {
+			continue
+		}
+		if e.MountPoint != root && !strings.HasPrefix(e.MountPoint, nfsRoot) {
+			continue
+		}
+		mounts = append(mounts, e.MountPoint)
+	}
+	return mounts, nil
+}
+
+// checkExportsMounted returns an error listing any exports that do not have
+// an NFS mount.
+func checkExportsMounted(nfsRoot string, exports, mounts []string) error {
+	mounted := make(map[string]bool, len(mounts))
+	for _, m := range mounts {
+		mounted[m] = true
+	}
+
+	var missing []string
+	for _, e := range exports {
+		if !mounted[path.Join(nfsRoot, e)] {
+			missing = append(missing, e)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("exports not mounted: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// checkMountsResponsive stats every mount concurrently, returning an error
+// listing the mounts that failed or did not respond within the timeout.
+func checkMountsResponsive(mounts []string, timeout time.Duration) error {
+	errs := make([]error, len(mounts))
+	var wg sync.WaitGroup
+	for i, m := range mounts {
+		wg.Add(1)
+		go func(i int, m string) {
+			defer wg.Done()
+			errs[i] = statWithTimeout(m, timeout)
+		}(i, m)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func statWithTimeout(name string, timeout time.Duration) error {
+	done := make(chan error, 1)
+	if _, pending := pendingStats.LoadOrStore(name, done); pending {
+		return fmt.Errorf("%s: not responding, previous stat has not returned", name)
+	}
+
+	go func() {
+		_, err := os.Stat(name)
+		pendingStats.Delete(name)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("%s: not responding, stat did not return after %s", name, timeout)
+	}
+}
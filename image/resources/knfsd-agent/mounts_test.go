@@ -19,7 +19,9 @@ package main
 import (
 	"encoding/json"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/prometheus/procfs"
 	"github.com/stretchr/testify/assert"
@@ -61,3 +63,35 @@ func TestReadMountStats(t *testing.T) {
 	require.NoError(t, err)
 	assert.JSONEq(t, string(expected), string(actual))
 }
+
+func TestReadNFSMountPoints(t *testing.T) {
+	fs, err := procfs.NewFS("./testdata/proc/")
+	require.NoError(t, err)
+
+	proc, err := fs.Proc(1)
+	require.NoError(t, err)
+
+	mounts, err := readNFSMountPoints(proc, "/srv/nfs/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/srv/nfs/files"}, mounts)
+}
+
+func TestCheckExportsMounted(t *testing.T) {
+	mounts := []string{"/srv/nfs", "/srv/nfs/files"}
+
+	err := checkExportsMounted("/srv/nfs/", []string{"/", "/files"}, mounts)
+	assert.NoError(t, err)
+
+	err = checkExportsMounted("/srv/nfs/", []string{"/files", "/home", "/data"}, mounts)
+	assert.EqualError(t, err, "exports not mounted: /home, /data")
+}
+
+func TestCheckMountsResponsive(t *testing.T) {
+	dir := t.TempDir()
+
+	err := checkMountsResponsive([]string{dir}, time.Second)
+	assert.NoError(t, err)
+
+	err = checkMountsResponsive([]string{dir, filepath.Join(dir, "missing")}, time.Second)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
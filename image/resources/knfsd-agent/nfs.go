@@ -17,8 +17,17 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-agent/client"
 	"github.com/prometheus/procfs"
@@ -282,3 +291,128 @@ func readNFSServerStats(fs nfs.FS) (*client.NFSServerStats, error) {
 		},
 	}, nil
 }
+
+// nfsdProcDir contains the nfsd control files, such as the number of threads.
+const nfsdProcDir = "/proc/fs/nfsd"
+
+// saturationThreshold is the fraction of packets that can be queued waiting
+// for an nfsd thread before reporting that the threads are saturated.
+const saturationThreshold = 0.1
+
+func checkNFSDThreads() error {
+	threads, err := readNFSDThreads(nfsdProcDir)
+	if err != nil {
+		return err
+	}
+	if threads == 0 {
+		return errors.New("no nfsd threads are running")
+	}
+	return nil
+}
+
+func readNFSDThreads(dir string) (int, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "threads"))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(bytes.TrimSpace(data)))
+}
+
+// nfsdPoolStats is the total of the per pool counters from
+// /proc/fs/nfsd/pool_stats.
+type nfsdPoolStats struct {
+	PacketsArrived  uint64
+	SocketsEnqueued uint64
+	ThreadsWoken    uint64
+	ThreadsTimedOut uint64
+}
+
+func readNFSDPoolStats(dir string) (nfsdPoolStats, error) {
+	f, err := os.Open(filepath.Join(dir, "pool_stats"))
+	if err != nil {
+		return nfsdPoolStats{}, err
+	}
+	defer f.Close()
+	return parseNFSDPoolStats(f)
+}
+
+func parseNFSDPoolStats(r io.Reader) (nfsdPoolStats, error) {
+	var total nfsdPoolStats
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// pool packets-arrived sockets-enqueued threads-woken threads-timedout
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			return nfsdPoolStats{}, fmt.Errorf("invalid pool_stats line %q", line)
+		}
+
+		var values [4]uint64
+		for i := range values {
+			v, err := strconv.ParseUint(fields[i+1], 10, 64)
+			if err != nil {
+				return nfsdPoolStats{}, fmt.Errorf("invalid pool_stats line %q: %w", line, err)
+			}
+			values[i] = v
+		}
+
+		total.PacketsArrived += values[0]
+		total.SocketsEnqueued += values[1]
+		total.ThreadsWoken += values[2]
+		total.ThreadsTimedOut += values[3]
+	}
+	return total, s.Err()
+}
+
+// lastPoolStats holds the pool stats from the previous status check, so that
+// saturation is reported for the period between checks. The first check
+// reports saturation since nfsd started.
+var lastPoolStats struct {
+	sync.Mutex
+	stats nfsdPoolStats
+}
+
+func (sh *ServiceHealth) checkThreadSaturation() {
+	stats, err := readNFSDPoolStats(nfsdProcDir)
+	if err != nil {
+		sh.Warn("thread saturation", err)
+		return
+	}
+
+	lastPoolStats.Lock()
+	prev := lastPoolStats.stats
+	lastPoolStats.stats = stats
+	lastPoolStats.Unlock()
+
+	if err := checkThreadSaturation(prev, stats); err != nil {
+		sh.Warn("thread saturation", err)
+	} else {
+		sh.Pass("thread saturation")
+	}
+}
+
+// checkThreadSaturation returns an error if too many packets arrived when all
+// the nfsd threads were busy. When this happens the socket is queued until a
+// thread is available, increasing the latency of the request.
+func checkThreadSaturation(prev, cur nfsdPoolStats) error {
+	if cur.PacketsArrived < prev.PacketsArrived || cur.SocketsEnqueued < prev.SocketsEnqueued {
+		// nfsd was restarted, so the counters were reset.
+		prev = nfsdPoolStats{}
+	}
+
+	arrived := cur.PacketsArrived - prev.PacketsArrived
+	enqueued := cur.SocketsEnqueued - prev.SocketsEnqueued
+	if arrived == 0 {
+		return nil
+	}
+
+	ratio := float64(enqueued) / float64(arrived)
+	if ratio > saturationThreshold {
+		return fmt.Errorf("%.1f%% of packets were queued waiting for an nfsd thread, consider increasing the number of nfsd threads", ratio*100)
+	}
+	return nil
+}
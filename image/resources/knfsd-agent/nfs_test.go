@@ -19,6 +19,7 @@ package main
 import (
 	"encoding/json"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/prometheus/procfs"
@@ -63,3 +64,40 @@ func TestReadNFSServerStats(t *testing.T) {
 	require.NoError(t, err)
 	assert.JSONEq(t, string(expected), string(actual))
 }
+
+func TestParseNFSDPoolStats(t *testing.T) {
+	input := strings.Join([]string{
+		"# pool packets-arrived sockets-enqueued threads-woken threads-timedout",
+		"0 1000 20 900 5",
+		"1 500 30 450 1",
+	}, "\n")
+
+	stats, err := parseNFSDPoolStats(strings.NewReader(input))
+	require.NoError(t, err)
+	assert.Equal(t, nfsdPoolStats{
+		PacketsArrived:  1500,
+		SocketsEnqueued: 50,
+		ThreadsWoken:    1350,
+		ThreadsTimedOut: 6,
+	}, stats)
+}
+
+func TestCheckThreadSaturation(t *testing.T) {
+	prev := nfsdPoolStats{PacketsArrived: 1000, SocketsEnqueued: 500}
+
+	// 5 of 100 packets were queued
+	err := checkThreadSaturation(prev, nfsdPoolStats{PacketsArrived: 1100, SocketsEnqueued: 505})
+	assert.NoError(t, err)
+
+	// 50 of 100 packets were queued
+	err = checkThreadSaturation(prev, nfsdPoolStats{PacketsArrived: 1100, SocketsEnqueued: 550})
+	assert.Error(t, err)
+
+	// no packets
+	err = checkThreadSaturation(prev, prev)
+	assert.NoError(t, err)
+
+	// counters reset, 1 of 100 packets were queued
+	err = checkThreadSaturation(prev, nfsdPoolStats{PacketsArrived: 100, SocketsEnqueued: 1})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,323 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-agent/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/procfs"
+	"github.com/prometheus/procfs/nfs"
+)
+
+const namespace = "knfsd"
+
+func newDesc(name, help string, labels ...string) *prometheus.Desc {
+	return prometheus.NewDesc(namespace+"_"+name, help, labels, nil)
+}
+
+var (
+	scrapeSuccessDesc = newDesc("agent_scrape_success", "Whether reading the source of the metrics succeeded.", "source")
+
+	nodeInfoDesc = newDesc("node_info", "Information about the proxy instance.", "name", "zone", "machine_type", "image")
+	osInfoDesc   = newDesc("os_info", "Information about the operating system.", "kernel", "id", "version_id")
+
+	cacheBytesDesc = newDesc("cache_bytes", "Size of the FS-Cache filesystem in bytes.", "state")
+	cacheFilesDesc = newDesc("cache_files", "Number of files (inodes) in the FS-Cache filesystem.", "state")
+
+	serverThreadsDesc     = newDesc("nfs_server_threads", "Number of nfsd threads.")
+	serverIOBytesDesc     = newDesc("nfs_server_io_bytes_total", "Bytes read and written by the NFS server.", "direction")
+	serverPacketsDesc     = newDesc("nfs_server_packets_total", "Network packets received by the NFS server.", "protocol")
+	serverConnectionsDesc = newDesc("nfs_server_tcp_connections_total", "TCP connections accepted by the NFS server.")
+	serverRPCDesc         = newDesc("nfs_server_rpc_total", "RPC requests received by the NFS server.")
+	serverRPCBadDesc      = newDesc("nfs_server_rpc_bad_total", "Invalid RPC requests received by the NFS server.", "reason")
+	serverOperationsDesc  = newDesc("nfs_server_operations_total", "NFS operations handled by the NFS server.", "version", "operation")
+
+	clientIOBytesDesc        = newDesc("nfs_client_io_bytes_total", "Bytes read and written by the NFS client from the source servers.", "direction")
+	clientRPCDesc            = newDesc("nfs_client_rpc_total", "RPC requests sent by the NFS client.")
+	clientRetransmissionDesc = newDesc("nfs_client_rpc_retransmissions_total", "RPC requests retransmitted by the NFS client.")
+	clientAuthRefreshDesc    = newDesc("nfs_client_rpc_auth_refreshes_total", "RPC credential refreshes by the NFS client.")
+	clientOperationsDesc     = newDesc("nfs_client_operations_total", "NFS operations sent by the NFS client.", "version", "operation")
+
+	mountLabels             = []string{"export", "server"}
+	mountAgeDesc            = newDesc("mount_age_seconds", "Time since the export was mounted.", mountLabels...)
+	mountReadBytesDesc      = newDesc("mount_read_bytes_total", "Bytes read from the source server.", mountLabels...)
+	mountWriteBytesDesc     = newDesc("mount_write_bytes_total", "Bytes written to the source server.", mountLabels...)
+	mountRequestsDesc       = newDesc("mount_operation_requests_total", "Requests sent to the source server.", append(mountLabels, "operation")...)
+	mountTransmissionsDesc  = newDesc("mount_operation_transmissions_total", "Requests transmitted to the source server, including retries.", append(mountLabels, "operation")...)
+	mountMajorTimeoutsDesc  = newDesc("mount_operation_major_timeouts_total", "Requests that timed out.", append(mountLabels, "operation")...)
+	mountSentBytesDesc      = newDesc("mount_operation_sent_bytes_total", "Bytes sent to the source server.", append(mountLabels, "operation")...)
+	mountReceivedBytesDesc  = newDesc("mount_operation_received_bytes_total", "Bytes received from the source server.", append(mountLabels, "operation")...)
+	mountQueueSecondsDesc   = newDesc("mount_operation_queue_seconds_total", "Time requests spent queued before being sent.", append(mountLabels, "operation")...)
+	mountRTTSecondsDesc     = newDesc("mount_operation_rtt_seconds_total", "Time waiting for a response from the source server.", append(mountLabels, "operation")...)
+	mountExecuteSecondsDesc = newDesc("mount_operation_execution_seconds_total", "Total time to execute requests, including queueing.", append(mountLabels, "operation")...)
+	mountErrorsDesc         = newDesc("mount_operation_errors_total", "Requests that completed with an error.", append(mountLabels, "operation")...)
+)
+
+// metricsCollector exports the same data as the JSON endpoints in the
+// Prometheus format. The data is read from procfs on every scrape.
+type metricsCollector struct {
+	cacheDir string
+
+	// These can be replaced for testing.
+	nfs     func() (nfs.FS, error)
+	proc    func() (procfs.Proc, error)
+	nfsRoot func() (string, error)
+	os      func() (*client.OSResponse, error)
+	node    func() client.NodeInfo
+}
+
+func newMetricsCollector() *metricsCollector {
+	return &metricsCollector{
+		cacheDir: "/var/cache/fscache",
+		nfs:      nfs.NewDefaultFS,
+		proc:     procfs.Self,
+		nfsRoot:  getNFSRootDir,
+		os:       func() (*client.OSResponse, error) { return handleOS(nil) },
+		node:     func() client.NodeInfo { return nodeInfo },
+	}
+}
+
+func metricsHandler() http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(newMetricsCollector())
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{
+		ErrorLog: log.Default(),
+	})
+}
+
+func (c *metricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		scrapeSuccessDesc,
+		nodeInfoDesc,
+		osInfoDesc,
+		cacheBytesDesc,
+		cacheFilesDesc,
+		serverThreadsDesc,
+		serverIOBytesDesc,
+		serverPacketsDesc,
+		serverConnectionsDesc,
+		serverRPCDesc,
+		serverRPCBadDesc,
+		serverOperationsDesc,
+		clientIOBytesDesc,
+		clientRPCDesc,
+		clientRetransmissionDesc,
+		clientAuthRefreshDesc,
+		clientOperationsDesc,
+		mountAgeDesc,
+		mountReadBytesDesc,
+		mountWriteBytesDesc,
+		mountRequestsDesc,
+		mountTransmissionsDesc,
+		mountMajorTimeoutsDesc,
+		mountSentBytesDesc,
+		mountReceivedBytesDesc,
+		mountQueueSecondsDesc,
+		mountRTTSecondsDesc,
+		mountExecuteSecondsDesc,
+		mountErrorsDesc,
+	} {
+		ch <- desc
+	}
+}
+
+func (c *metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	sources := []struct {
+		name    string
+		collect func(chan<- prometheus.Metric) error
+	}{
+		{"os", c.collectInfo},
+		{"cache", c.collectCache},
+		{"nfs_server", c.collectServer},
+		{"nfs_client", c.collectClient},
+		{"mounts", c.collectMounts},
+	}
+
+	// Report the errors per source instead of failing the whole scrape, as
+	// some sources are not always available (such as the NFS server stats
+	// before nfsd has started).
+	for _, s := range sources {
+		success := 1.0
+		if err := s.collect(ch); err != nil {
+			log.Printf("could not collect %s metrics: %s", s.name, err)
+			success = 0
+		}
+		ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, success, s.name)
+	}
+}
+
+func (c *metricsCollector) collectInfo(ch chan<- prometheus.Metric) error {
+	n := c.node()
+	ch <- prometheus.MustNewConstMetric(nodeInfoDesc, prometheus.GaugeValue, 1,
+		n.Name, n.Zone, n.MachineType, n.Image)
+
+	os, err := c.os()
+	if err != nil {
+		return err
+	}
+	ch <- prometheus.MustNewConstMetric(osInfoDesc, prometheus.GaugeValue, 1,
+		os.Kernel, os.OS["ID"], os.OS["VERSION_ID"])
+	return nil
+}
+
+func (c *metricsCollector) collectCache(ch chan<- prometheus.Metric) error {
+	u, err := readCacheUsage(c.cacheDir)
+	if err != nil {
+		return err
+	}
+
+	gauge := func(desc *prometheus.Desc, v uint64, state string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(v), state)
+	}
+	gauge(cacheBytesDesc, u.BytesTotal, "total")
+	gauge(cacheBytesDesc, u.BytesUsed, "used")
+	gauge(cacheBytesDesc, u.BytesFree, "free")
+	gauge(cacheBytesDesc, u.BytesAvailable, "available")
+	gauge(cacheFilesDesc, u.FilesTotal, "total")
+	gauge(cacheFilesDesc, u.FilesUsed, "used")
+	gauge(cacheFilesDesc, u.FilesFree, "free")
+	return nil
+}
+
+func (c *metricsCollector) collectServer(ch chan<- prometheus.Metric) error {
+	fs, err := c.nfs()
+	if err != nil {
+		return err
+	}
+	s, err := readNFSServerStats(fs)
+	if err != nil {
+		return err
+	}
+
+	ch <- prometheus.MustNewConstMetric(serverThreadsDesc, prometheus.GaugeValue, float64(s.Threads))
+	counter(ch, serverIOBytesDesc, s.IO.Read, "read")
+	counter(ch, serverIOBytesDesc, s.IO.Write, "write")
+	counter(ch, serverPacketsDesc, s.Network.UDPPackets, "udp")
+	counter(ch, serverPacketsDesc, s.Network.TCPPackets, "tcp")
+	counter(ch, serverConnectionsDesc, s.Network.TCPConnections)
+	counter(ch, serverRPCDesc, s.RPC.Count)
+	counter(ch, serverRPCBadDesc, s.RPC.BadFormat, "format")
+	counter(ch, serverRPCBadDesc, s.RPC.BadAuth, "auth")
+	operations(ch, serverOperationsDesc, "3", s.Proc3)
+	operations(ch, serverOperationsDesc, "4", s.Proc4Ops)
+	return nil
+}
+
+func (c *metricsCollector) collectClient(ch chan<- prometheus.Metric) error {
+	fs, err := c.nfs()
+	if err != nil {
+		return err
+	}
+	proc, err := c.proc()
+	if err != nil {
+		return err
+	}
+	nfsRoot, err := c.nfsRoot()
+	if err != nil {
+		return err
+	}
+	s, err := readNFSClientStats(fs, proc, nfsRoot)
+	if err != nil {
+		return err
+	}
+
+	counter(ch, clientIOBytesDesc, s.IO.Read, "read")
+	counter(ch, clientIOBytesDesc, s.IO.Write, "write")
+	counter(ch, clientRPCDesc, s.RPC.Count)
+	counter(ch, clientRetransmissionDesc, s.RPC.Retransmissions)
+	counter(ch, clientAuthRefreshDesc, s.RPC.AuthRefreshes)
+	operations(ch, clientOperationsDesc, "3", s.Proc3)
+	operations(ch, clientOperationsDesc, "4", s.Proc4)
+	return nil
+}
+
+func (c *metricsCollector) collectMounts(ch chan<- prometheus.Metric) error {
+	proc, err := c.proc()
+	if err != nil {
+		return err
+	}
+	nfsRoot, err := c.nfsRoot()
+	if err != nil {
+		return err
+	}
+	res, err := readMountStats(proc, nfsRoot)
+	if err != nil {
+		return err
+	}
+
+	// The same export can be mounted more than once at the same mount point,
+	// for example if the export was re-mounted without unmounting it first.
+	// Only the last mount is visible, so skip the earlier mounts to avoid
+	// reporting duplicate metrics.
+	type mountKey struct{ export, server string }
+	last := make(map[mountKey]int, len(res.Mounts))
+	for i, m := range res.Mounts {
+		last[mountKey{m.Export, m.Device}] = i
+	}
+
+	for i, m := range res.Mounts {
+		if last[mountKey{m.Export, m.Device}] != i {
+			continue
+		}
+
+		labels := []string{m.Export, m.Device}
+		ch <- prometheus.MustNewConstMetric(mountAgeDesc, prometheus.GaugeValue, time.Duration(m.Stats.Age).Seconds(), labels...)
+		counter(ch, mountReadBytesDesc, m.Stats.Bytes.ServerRead, labels...)
+		counter(ch, mountWriteBytesDesc, m.Stats.Bytes.ServerWrite, labels...)
+
+		for _, o := range m.Stats.Operations {
+			labels := append(labels[:2:2], o.Operation)
+			counter(ch, mountRequestsDesc, o.Requests, labels...)
+			counter(ch, mountTransmissionsDesc, o.Transmissions, labels...)
+			counter(ch, mountMajorTimeoutsDesc, o.MajorTimeouts, labels...)
+			counter(ch, mountSentBytesDesc, o.BytesSent, labels...)
+			counter(ch, mountReceivedBytesDesc, o.BytesReceived, labels...)
+			seconds(ch, mountQueueSecondsDesc, o.QueueMilliseconds, labels...)
+			seconds(ch, mountRTTSecondsDesc, o.RTTMilliseconds, labels...)
+			seconds(ch, mountExecuteSecondsDesc, o.ExecutionMilliseconds, labels...)
+			counter(ch, mountErrorsDesc, o.Errors, labels...)
+		}
+	}
+	return nil
+}
+
+func counter(ch chan<- prometheus.Metric, desc *prometheus.Desc, v uint64, labels ...string) {
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(v), labels...)
+}
+
+func seconds(ch chan<- prometheus.Metric, desc *prometheus.Desc, ms uint64, labels ...string) {
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(ms)/1000, labels...)
+}
+
+// operations reports a counter for each field of an NFS operations struct,
+// such as client.NFSProc3. The operation label is the field's JSON name in
+// lower case, for example "getattr".
+func operations(ch chan<- prometheus.Metric, desc *prometheus.Desc, version string, ops any) {
+	v := reflect.ValueOf(ops)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		counter(ch, desc, v.Field(i).Uint(), version, strings.ToLower(name))
+	}
+}
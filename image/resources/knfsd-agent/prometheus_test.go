@@ -0,0 +1,158 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-agent/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/procfs"
+	"github.com/prometheus/procfs/nfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMetricsCollector(t *testing.T, procDir string) *metricsCollector {
+	return &metricsCollector{
+		cacheDir: t.TempDir(),
+		nfs: func() (nfs.FS, error) {
+			return nfs.NewFS("testdata/proc")
+		},
+		proc: func() (procfs.Proc, error) {
+			fs, err := procfs.NewFS(procDir)
+			if err != nil {
+				return procfs.Proc{}, err
+			}
+			return fs.Proc(1)
+		},
+		nfsRoot: func() (string, error) {
+			return "/srv/nfs/", nil
+		},
+		os: func() (*client.OSResponse, error) {
+			return &client.OSResponse{
+				Kernel: "6.11.0-1015-gcp",
+				OS:     map[string]string{"ID": "ubuntu", "VERSION_ID": "24.04"},
+			}, nil
+		},
+		node: func() client.NodeInfo {
+			return client.NodeInfo{Name: "proxy-1", Zone: "us-central1-a"}
+		},
+	}
+}
+
+func TestMetricsCollector(t *testing.T) {
+	c := newTestMetricsCollector(t, "testdata/proc")
+
+	// The pedantic registry checks the metrics are consistent with their
+	// descriptions, and that there are no duplicate metrics.
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(c))
+
+	err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP knfsd_os_info Information about the operating system.
+# TYPE knfsd_os_info gauge
+knfsd_os_info{id="ubuntu",kernel="6.11.0-1015-gcp",version_id="24.04"} 1
+`), "knfsd_os_info")
+	assert.NoError(t, err)
+
+	metrics, err := reg.Gather()
+	require.NoError(t, err)
+
+	success := map[string]float64{}
+	names := map[string]bool{}
+	for _, mf := range metrics {
+		names[mf.GetName()] = true
+		if mf.GetName() != "knfsd_agent_scrape_success" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			success[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+		}
+	}
+
+	for _, source := range []string{"os", "cache", "nfs_server", "mounts"} {
+		assert.Equal(t, 1.0, success[source], "source %s", source)
+	}
+	for _, name := range []string{
+		"knfsd_node_info",
+		"knfsd_cache_bytes",
+		"knfsd_nfs_server_threads",
+		"knfsd_nfs_server_operations_total",
+		"knfsd_mount_read_bytes_total",
+		"knfsd_mount_operation_requests_total",
+	} {
+		assert.True(t, names[name], "missing metric %s", name)
+	}
+
+	// Mount metrics are labelled with the export.
+	for _, mf := range metrics {
+		if mf.GetName() != "knfsd_mount_read_bytes_total" {
+			continue
+		}
+		require.Len(t, mf.GetMetric(), 1)
+		labels := map[string]string{}
+		for _, l := range mf.GetMetric()[0].GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		assert.Equal(t, map[string]string{"export": "/files", "server": "10.0.0.2:/files"}, labels)
+	}
+}
+
+func TestMetricsCollectorDuplicateMounts(t *testing.T) {
+	// Mount the same export twice at the same mount point.
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "1"), 0755))
+	for _, name := range []string{"mountinfo", "mountstats"} {
+		b, err := os.ReadFile(filepath.Join("testdata/proc/1", name))
+		require.NoError(t, err)
+
+		var nfs []string
+		lines := strings.SplitAfter(string(b), "\n")
+		for i, line := range lines {
+			if strings.Contains(line, "/srv/nfs/files") {
+				nfs = lines[i:]
+				for j := 1; j < len(nfs); j++ {
+					if strings.HasPrefix(nfs[j], "device ") || name == "mountinfo" {
+						nfs = nfs[:j]
+						break
+					}
+				}
+				break
+			}
+		}
+		require.NotEmpty(t, nfs)
+
+		b = append(b, strings.Join(nfs, "")...)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "1", name), b, 0644))
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(newTestMetricsCollector(t, dir)))
+
+	metrics, err := reg.Gather()
+	require.NoError(t, err)
+	for _, mf := range metrics {
+		if mf.GetName() == "knfsd_mount_read_bytes_total" {
+			assert.Len(t, mf.GetMetric(), 1)
+		}
+	}
+}
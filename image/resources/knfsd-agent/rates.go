@@ -0,0 +1,562 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-agent/client"
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-common/sockdiag"
+	"github.com/prometheus/procfs"
+	"github.com/prometheus/procfs/nfs"
+)
+
+// RatesConfig configures how often the counters are sampled for the rate
+// endpoints, and how many samples are kept.
+type RatesConfig struct {
+	Interval  time.Duration `ini:"interval"`
+	Retention time.Duration `ini:"retention"`
+}
+
+func (cfg *RatesConfig) Validate() error {
+	var err error
+	if cfg.Interval <= 0 {
+		err = errors.Join(err, errors.New("\"rates-interval\" must be greater than zero"))
+	}
+	if cfg.Retention < cfg.Interval {
+		err = errors.Join(err, errors.New("\"rates-retention\" must be at least \"rates-interval\""))
+	}
+	return err
+}
+
+// sample is a snapshot of the counters. Any of the counters may be nil if they
+// could not be read, for example if the NFS server is not running.
+type sample struct {
+	Time   time.Time
+	Server *client.NFSServerStats
+	Client *client.NFSClientStats
+	Mounts *client.MountStatsResponse
+
+	// Connections are the connections to the NFS server, used for the per
+	// client rates.
+	Connections []sockdiag.TCPConnection
+}
+
+// Sampler reads the counters in the background, keeping the most recent
+// samples in a ring buffer so that the rates can be calculated without the
+// client needing to keep its own state.
+type Sampler struct {
+	cfg RatesConfig
+
+	// read can be replaced for testing.
+	read func() *sample
+
+	mu      sync.RWMutex
+	samples []*sample // ring buffer, oldest sample at start
+	start   int
+	count   int
+
+	// subscribers are notified after each sample, used by the event stream.
+	subscribers map[chan struct{}]struct{}
+	stopped     bool
+}
+
+func NewSampler(cfg RatesConfig) *Sampler {
+	// Keep an extra sample so that the full retention period can be used as
+	// the window.
+	size := int(cfg.Retention/cfg.Interval) + 1
+	return &Sampler{
+		cfg:         cfg,
+		read:        newSampleReader(),
+		samples:     make([]*sample, size),
+		subscribers: make(map[chan struct{}]struct{}),
+	}
+}
+
+// Run samples the counters every interval until ctx is cancelled. When Run
+// returns any event streams are closed.
+func (s *Sampler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	defer s.stop()
+
+	for {
+		s.record(s.read())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Sampler) record(v *sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.count < len(s.samples) {
+		s.samples[(s.start+s.count)%len(s.samples)] = v
+		s.count++
+	} else {
+		s.samples[s.start] = v
+		s.start = (s.start + 1) % len(s.samples)
+	}
+
+	for ch := range s.subscribers {
+		// Do not block if a subscriber is slow, the subscriber will read the
+		// latest sample when it catches up.
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *Sampler) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+	for ch := range s.subscribers {
+		close(ch)
+		delete(s.subscribers, ch)
+	}
+}
+
+// subscribe returns a channel that receives a value after each sample. The
+// channel is closed when the sampler stops.
+func (s *Sampler) subscribe() (<-chan struct{}, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch := make(chan struct{}, 1)
+	if s.stopped {
+		close(ch)
+		return ch, func() {}
+	}
+
+	s.subscribers[ch] = struct{}{}
+	unsubscribe := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, found := s.subscribers[ch]; found {
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, unsubscribe
+}
+
+// window returns the oldest and newest samples within the window where has
+// returns true. Samples are not recorded at exact intervals, so samples up to
+// half an interval older than the window are included.
+func (s *Sampler) window(window time.Duration, has func(*sample) bool) (prev, cur *sample, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	limit := window + s.cfg.Interval/2
+	for i := s.count - 1; i >= 0; i-- {
+		v := s.samples[(s.start+i)%len(s.samples)]
+		if !has(v) {
+			continue
+		}
+		if cur == nil {
+			cur = v
+			continue
+		}
+		if cur.Time.Sub(v.Time) > limit {
+			break
+		}
+		prev = v
+	}
+
+	if prev == nil {
+		return nil, nil, &requestError{http.StatusServiceUnavailable, errors.New("not enough samples")}
+	}
+	return prev, cur, nil
+}
+
+func (s *Sampler) parseWindow(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get("window")
+	if value == "" {
+		if s.cfg.Retention < defaultRatesWindow {
+			return s.cfg.Retention, nil
+		}
+		return defaultRatesWindow, nil
+	}
+
+	window, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, badRequest("invalid window \"%s\"", value)
+	}
+	if window < s.cfg.Interval || window > s.cfg.Retention {
+		return 0, badRequest("window must be between %s and %s", s.cfg.Interval, s.cfg.Retention)
+	}
+	return window, nil
+}
+
+func (s *Sampler) register(mux *http.ServeMux) {
+	mux.Handle("/api/v1/nfs/server/rates", JSONHandler(s.handleNFSServerRates))
+	mux.Handle("/api/v1/nfs/client/rates", JSONHandler(s.handleNFSClientRates))
+	mux.Handle("/api/v1/mountStats/rates", JSONHandler(s.handleMountStatsRates))
+	mux.HandleFunc("/api/v1/rates/stream", s.handleStream)
+}
+
+func (s *Sampler) handleNFSServerRates(r *http.Request) (*client.NFSServerRates, error) {
+	window, err := s.parseWindow(r)
+	if err != nil {
+		return nil, err
+	}
+	return s.nfsServerRates(window)
+}
+
+func (s *Sampler) handleNFSClientRates(r *http.Request) (*client.NFSClientRates, error) {
+	window, err := s.parseWindow(r)
+	if err != nil {
+		return nil, err
+	}
+	return s.nfsClientRates(window)
+}
+
+func (s *Sampler) handleMountStatsRates(r *http.Request) (*client.MountStatsRatesResponse, error) {
+	window, err := s.parseWindow(r)
+	if err != nil {
+		return nil, err
+	}
+	return s.mountStatsRates(window)
+}
+
+func (s *Sampler) nfsServerRates(window time.Duration) (*client.NFSServerRates, error) {
+	prev, cur, err := s.window(window, func(v *sample) bool { return v.Server != nil })
+	if err != nil {
+		return nil, err
+	}
+
+	seconds := cur.Time.Sub(prev.Time).Seconds()
+	a, b := prev.Server, cur.Server
+	res := &client.NFSServerRates{
+		Start:    prev.Time,
+		End:      cur.Time,
+		Proc3:    counterRates(a.Proc3, b.Proc3, seconds),
+		Proc4:    counterRates(a.Proc4, b.Proc4, seconds),
+		Proc4Ops: counterRates(a.Proc4Ops, b.Proc4Ops, seconds),
+	}
+	structRates(&res.IO, a.IO, b.IO, seconds)
+	structRates(&res.Network, a.Network, b.Network, seconds)
+	structRates(&res.RPC, a.RPC, b.RPC, seconds)
+	return res, nil
+}
+
+func (s *Sampler) nfsClientRates(window time.Duration) (*client.NFSClientRates, error) {
+	prev, cur, err := s.window(window, func(v *sample) bool { return v.Client != nil })
+	if err != nil {
+		return nil, err
+	}
+
+	seconds := cur.Time.Sub(prev.Time).Seconds()
+	a, b := prev.Client, cur.Client
+	res := &client.NFSClientRates{
+		Start: prev.Time,
+		End:   cur.Time,
+		Proc3: counterRates(a.Proc3, b.Proc3, seconds),
+		Proc4: counterRates(a.Proc4, b.Proc4, seconds),
+	}
+	structRates(&res.IO, a.IO, b.IO, seconds)
+	structRates(&res.Network, a.Network, b.Network, seconds)
+	structRates(&res.RPC, a.RPC, b.RPC, seconds)
+	return res, nil
+}
+
+func (s *Sampler) mountStatsRates(window time.Duration) (*client.MountStatsRatesResponse, error) {
+	prev, cur, err := s.window(window, func(v *sample) bool { return v.Mounts != nil })
+	if err != nil {
+		return nil, err
+	}
+
+	seconds := cur.Time.Sub(prev.Time).Seconds()
+	previous := make(map[string]client.NFSMountStats)
+	for _, m := range prev.Mounts.Mounts {
+		previous[m.Mount] = m.Stats
+	}
+
+	mounts := make([]client.MountRates, 0, len(cur.Mounts.Mounts))
+	for _, m := range cur.Mounts.Mounts {
+		a, b := previous[m.Mount], m.Stats
+		if b.Age < a.Age {
+			// The export was remounted, so all the counters have been reset.
+			a = client.NFSMountStats{}
+		}
+
+		mr := client.MountRates{
+			Device:     m.Device,
+			Mount:      m.Mount,
+			Export:     m.Export,
+			Events:     counterRates(a.Events, b.Events, seconds),
+			Operations: operationRates(a.Operations, b.Operations, seconds),
+		}
+		structRates(&mr.Bytes, a.Bytes, b.Bytes, seconds)
+		mounts = append(mounts, mr)
+	}
+
+	return &client.MountStatsRatesResponse{
+		Start:  prev.Time,
+		End:    cur.Time,
+		Mounts: mounts,
+	}, nil
+}
+
+func operationRates(prev, cur []client.NFSOperationStats, seconds float64) []client.NFSOperationRates {
+	previous := make(map[string]client.NFSOperationStats)
+	for _, o := range prev {
+		previous[o.Operation] = o
+	}
+
+	ops := make([]client.NFSOperationRates, len(cur))
+	for i, b := range cur {
+		a := previous[b.Operation]
+		if b.Requests < a.Requests {
+			a = client.NFSOperationStats{}
+		}
+
+		requests := float64(b.Requests - a.Requests)
+		average := func(prev, cur uint64) float64 {
+			if requests == 0 || cur < prev {
+				return 0
+			}
+			return float64(cur-prev) / requests
+		}
+
+		ops[i] = client.NFSOperationRates{
+			Operation:             b.Operation,
+			Requests:              rate(a.Requests, b.Requests, seconds),
+			Transmissions:         rate(a.Transmissions, b.Transmissions, seconds),
+			Retries:               rate(a.Retries, b.Retries, seconds),
+			MajorTimeouts:         rate(a.MajorTimeouts, b.MajorTimeouts, seconds),
+			BytesSent:             rate(a.BytesSent, b.BytesSent, seconds),
+			BytesReceived:         rate(a.BytesReceived, b.BytesReceived, seconds),
+			Errors:                rate(a.Errors, b.Errors, seconds),
+			QueueMilliseconds:     average(a.QueueMilliseconds, b.QueueMilliseconds),
+			RTTMilliseconds:       average(a.RTTMilliseconds, b.RTTMilliseconds),
+			ExecutionMilliseconds: average(a.ExecutionMilliseconds, b.ExecutionMilliseconds),
+		}
+	}
+	return ops
+}
+
+// rate returns the average rate per second between two samples of a counter.
+// If the counter decreased it was reset, such as when the NFS server is
+// restarted, so the rate is calculated from zero.
+func rate(prev, cur uint64, seconds float64) float64 {
+	if cur < prev {
+		prev = 0
+	}
+	return float64(cur-prev) / seconds
+}
+
+// counterRates returns the rate of each counter in a struct of uint64
+// counters, keyed by the field's JSON name. This is used for the NFS
+// operations, as clients treat these as a map of operation name to counter.
+func counterRates(prev, cur any, seconds float64) map[string]float64 {
+	a := reflect.ValueOf(prev)
+	b := reflect.ValueOf(cur)
+	t := b.Type()
+
+	rates := make(map[string]float64, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		rates[name] = rate(a.Field(i).Uint(), b.Field(i).Uint(), seconds)
+	}
+	return rates
+}
+
+// structRates sets each float64 field of dst to the rate of the uint64
+// counter with the same name in prev and cur, for example setting
+// NFSIORates.Read from NFSIO.Read.
+func structRates(dst any, prev, cur any, seconds float64) {
+	d := reflect.ValueOf(dst).Elem()
+	a := reflect.ValueOf(prev)
+	b := reflect.ValueOf(cur)
+	t := d.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		d.Field(i).SetFloat(rate(a.FieldByName(name).Uint(), b.FieldByName(name).Uint(), seconds))
+	}
+}
+
+// streamEvents maps the event names supported by the event stream to the
+// function that calculates the event's data.
+var streamEvents = map[string]func(*Sampler, time.Duration) (any, error){
+	"nfs-server": func(s *Sampler, w time.Duration) (any, error) { return s.nfsServerRates(w) },
+	"nfs-client": func(s *Sampler, w time.Duration) (any, error) { return s.nfsClientRates(w) },
+	"mounts":     func(s *Sampler, w time.Duration) (any, error) { return s.mountStatsRates(w) },
+}
+
+var defaultStreamEvents = []string{"nfs-server", "nfs-client"}
+
+// handleStream sends the rates as Server-Sent Events after every sample,
+// until the client disconnects or the agent is stopped.
+func (s *Sampler) handleStream(w http.ResponseWriter, r *http.Request) {
+	fail := func(statusCode int, err error) {
+		http.Error(w, err.Error(), statusCode)
+		logRequest(r, statusCode, err)
+	}
+
+	if r.Method != http.MethodGet {
+		fail(http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		fail(http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	// Unlike the other rate endpoints, default to the rate since the last
+	// sample.
+	window := s.cfg.Interval
+	if r.URL.Query().Has("window") {
+		var err error
+		window, err = s.parseWindow(r)
+		if err != nil {
+			fail(http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	events := defaultStreamEvents
+	if v := r.URL.Query().Get("events"); v != "" {
+		events = strings.Split(v, ",")
+		for _, e := range events {
+			if _, found := streamEvents[e]; !found {
+				fail(http.StatusBadRequest, fmt.Errorf("unknown event \"%s\"", e))
+				return
+			}
+		}
+	}
+
+	ch, unsubscribe := s.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	logRequest(r, http.StatusOK, nil)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		}
+
+		for _, e := range events {
+			v, err := streamEvents[e](s, window)
+			if err != nil {
+				// Skip the event until there are enough samples.
+				continue
+			}
+
+			b, err := json.Marshal(v)
+			if err != nil {
+				log.Printf("could not encode %s event: %s", e, err)
+				continue
+			}
+
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e, b)
+			if err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// newSampleReader returns a function that reads the counters. Errors are
+// logged when they change, so that a missing service does not flood the log.
+func newSampleReader() func() *sample {
+	var lastErr string
+	return func() *sample {
+		v, err := readSample()
+		msg := ""
+		if err != nil {
+			msg = err.Error()
+		}
+		if msg != lastErr {
+			if msg != "" {
+				log.Printf("could not sample counters: %s", msg)
+			}
+			lastErr = msg
+		}
+		return v
+	}
+}
+
+func readSample() (*sample, error) {
+	v := &sample{Time: time.Now()}
+
+	fs, err := nfs.NewDefaultFS()
+	if err != nil {
+		return v, err
+	}
+
+	self, err := procfs.Self()
+	if err != nil {
+		return v, err
+	}
+
+	nfsRoot, err := getNFSRootDir()
+	if err != nil {
+		return v, err
+	}
+
+	var errs []error
+	v.Server, err = readNFSServerStats(fs)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("nfs server: %w", err))
+	}
+
+	v.Client, err = readNFSClientStats(fs, self, nfsRoot)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("nfs client: %w", err))
+	}
+
+	v.Mounts, err = readMountStats(self, nfsRoot)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("mount stats: %w", err))
+	}
+
+	v.Connections, err = sockdiag.ReadTCPConnections(nfsPort)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("connections: %w", err))
+	}
+
+	return v, errors.Join(errs...)
+}
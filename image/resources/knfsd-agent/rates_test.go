@@ -0,0 +1,256 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-agent/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testRatesConfig = RatesConfig{
+	Interval:  10 * time.Second,
+	Retention: time.Minute,
+}
+
+func serverSample(t time.Time, read, rpc, getattr uint64) *sample {
+	return &sample{
+		Time: t,
+		Server: &client.NFSServerStats{
+			IO:    client.NFSIO{Read: read},
+			RPC:   client.NFSServerRPC{Count: rpc},
+			Proc3: client.NFSProc3{GetAttr: getattr},
+		},
+	}
+}
+
+func TestSamplerWindow(t *testing.T) {
+	s := NewSampler(testRatesConfig)
+	require.Len(t, s.samples, 7)
+
+	_, err := s.nfsServerRates(time.Minute)
+	assert.EqualError(t, err, "not enough samples")
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		now := start.Add(time.Duration(i) * 10 * time.Second)
+		s.record(serverSample(now, uint64(i)*1000, uint64(i)*10, uint64(i)*5))
+	}
+
+	// The oldest samples have been discarded.
+	assert.Equal(t, start.Add(30*time.Second), s.samples[s.start].Time)
+
+	r, err := s.nfsServerRates(time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, start.Add(30*time.Second), r.Start)
+	assert.Equal(t, start.Add(90*time.Second), r.End)
+	assert.Equal(t, 100.0, r.IO.Read)
+	assert.Equal(t, 1.0, r.RPC.Count)
+	assert.Equal(t, 0.5, r.Proc3["GETATTR"])
+	assert.Equal(t, 0.0, r.Proc4Ops["READ"])
+
+	r, err = s.nfsServerRates(20 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, start.Add(70*time.Second), r.Start)
+
+	// Samples without server stats are skipped.
+	s.record(&sample{Time: start.Add(100 * time.Second)})
+	r, err = s.nfsServerRates(20 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, start.Add(90*time.Second), r.End)
+
+	// NFS server restarted, counters were reset.
+	s.record(serverSample(start.Add(110*time.Second), 2000, 20, 10))
+	r, err = s.nfsServerRates(20 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 100.0, r.IO.Read)
+}
+
+func TestSamplerWindowJitter(t *testing.T) {
+	s := NewSampler(testRatesConfig)
+
+	// Samples are not recorded at exact intervals.
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	offsets := []time.Duration{
+		0,
+		10300 * time.Millisecond,
+		19800 * time.Millisecond,
+		30400 * time.Millisecond,
+	}
+	for i, offset := range offsets {
+		s.record(serverSample(start.Add(offset), uint64(i)*1000, uint64(i)*10, uint64(i)*5))
+	}
+
+	// The rate since the last sample, as used by the stream endpoint.
+	r, err := s.nfsServerRates(testRatesConfig.Interval)
+	require.NoError(t, err)
+	assert.Equal(t, start.Add(19800*time.Millisecond), r.Start)
+	assert.Equal(t, start.Add(30400*time.Millisecond), r.End)
+
+	r, err = s.nfsServerRates(20 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, start.Add(10300*time.Millisecond), r.Start)
+
+	r, err = s.nfsServerRates(30 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, start, r.Start)
+}
+
+func TestMountStatsRates(t *testing.T) {
+	mount := func(age time.Duration, read, requests, rtt uint64) client.MountStats {
+		return client.MountStats{
+			Mount:  "/srv/nfs/data",
+			Export: "/data",
+			Stats: client.NFSMountStats{
+				Age:   client.Duration(age),
+				Bytes: client.NFSByteStats{ServerRead: read},
+				Operations: []client.NFSOperationStats{
+					{Operation: "READ", Requests: requests, RTTMilliseconds: rtt},
+				},
+			},
+		}
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewSampler(testRatesConfig)
+	s.record(&sample{
+		Time:   start,
+		Mounts: &client.MountStatsResponse{Mounts: []client.MountStats{mount(time.Hour, 1000, 100, 500)}},
+	})
+	s.record(&sample{
+		Time:   start.Add(10 * time.Second),
+		Mounts: &client.MountStatsResponse{Mounts: []client.MountStats{mount(time.Hour+10*time.Second, 6000, 150, 1500)}},
+	})
+
+	r, err := s.mountStatsRates(time.Minute)
+	require.NoError(t, err)
+	require.Len(t, r.Mounts, 1)
+	m := r.Mounts[0]
+	assert.Equal(t, "/data", m.Export)
+	assert.Equal(t, 500.0, m.Bytes.ServerRead)
+	require.Len(t, m.Operations, 1)
+	assert.Equal(t, 5.0, m.Operations[0].Requests)
+	assert.Equal(t, 20.0, m.Operations[0].RTTMilliseconds)
+
+	// Remounted, the counters are lower than the previous sample.
+	s.record(&sample{
+		Time:   start.Add(20 * time.Second),
+		Mounts: &client.MountStatsResponse{Mounts: []client.MountStats{mount(5*time.Second, 500, 10, 30)}},
+	})
+	r, err = s.mountStatsRates(10 * time.Second)
+	require.NoError(t, err)
+	m = r.Mounts[0]
+	assert.Equal(t, 50.0, m.Bytes.ServerRead)
+	assert.Equal(t, 3.0, m.Operations[0].RTTMilliseconds)
+}
+
+func TestRatesEndpoints(t *testing.T) {
+	s := NewSampler(testRatesConfig)
+	mux := http.NewServeMux()
+	s.register(mux)
+
+	get := func(url string) (int, string) {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		res := w.Result()
+
+		r, err := gzip.NewReader(res.Body)
+		require.NoError(t, err)
+		var body struct {
+			Message string `json:"message"`
+		}
+		require.NoError(t, json.NewDecoder(r).Decode(&body))
+		return res.StatusCode, body.Message
+	}
+
+	code, msg := get("/api/v1/nfs/server/rates")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "not enough samples", msg)
+
+	code, msg = get("/api/v1/nfs/server/rates?window=1h")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "window must be between 10s and 1m0s", msg)
+
+	code, _ = get("/api/v1/nfs/client/rates?window=soon")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	now := time.Now()
+	s.record(serverSample(now.Add(-10*time.Second), 0, 0, 0))
+	s.record(serverSample(now, 0, 0, 0))
+	code, _ = get("/api/v1/nfs/server/rates?window=30s")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestRatesStream(t *testing.T) {
+	s := NewSampler(testRatesConfig)
+	mux := http.NewServeMux()
+	s.register(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/api/v1/rates/stream?events=unknown")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/v1/rates/stream?events=nfs-server", nil)
+	require.NoError(t, err)
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	// Wait for the handler to subscribe before recording any samples.
+	require.Eventually(t, func() bool {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return len(s.subscribers) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	now := time.Now()
+	s.record(serverSample(now.Add(-10*time.Second), 0, 0, 0))
+	s.record(serverSample(now, 1000, 0, 0))
+
+	r := bufio.NewReader(res.Body)
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "event: nfs-server\n", line)
+	line, err = r.ReadString('\n')
+	require.NoError(t, err)
+
+	var rates client.NFSServerRates
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &rates))
+	assert.Equal(t, 100.0, rates.IO.Read)
+
+	// Stopping the sampler ends the stream.
+	s.stop()
+	_, err = r.ReadString(0)
+	assert.Error(t, err)
+}
@@ -46,7 +46,10 @@ func JSONHandler[T any](handler JSONHandlerFunc[T]) http.Handler {
 
 func (handler JSONHandlerFunc[T]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	statusCode, body, err := handler.Execute(r)
+	writeJSON(w, r, statusCode, body, err)
+}
 
+func writeJSON(w http.ResponseWriter, r *http.Request, statusCode int, body []byte, err error) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Content-Encoding", "gzip")
 
@@ -99,6 +102,17 @@ func (handler JSONHandlerFunc[T]) Execute(r *http.Request) (statusCode int, body
 		}
 	}
 
+	// Errors caused by the request, such as an invalid parameter, are returned
+	// to the client.
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		statusCode = reqErr.statusCode
+		body, _ = json.Marshal(struct {
+			Message string `json:"message"`
+		}{reqErr.Error()})
+		return statusCode, body, err
+	}
+
 	// If there was an error from either the handler, or JSON conversion return
 	// a generic error message to the client and log the real error message.
 	if err != nil {
@@ -117,18 +131,43 @@ func logRequest(r *http.Request, statusCode int, err error) {
 	log.Printf("%s %s %s %d %s", r.RemoteAddr, r.Method, r.URL, statusCode, errMsg)
 }
 
-func registerRoutes(mux *http.ServeMux) {
+func registerRoutes(mux *http.ServeMux, health *HealthChecker, sampler *Sampler, scanner *CacheScanner, admin *AdminAPI) {
 	mux.Handle("/", JSONHandler(handleNodeInfo))
 
+	// Health checks for load balancers and managed instance groups.
+	mux.Handle("/healthz", health)
+	mux.Handle("/readyz", health)
+
 	// Keeping this route for historical versioning
 	mux.Handle("/api/v1.0/nodeInfo", JSONHandler(handleNodeInfo))
 
 	mux.Handle("/api/v1/cache/usage", JSONHandler(handleCacheUsage))
+	mux.Handle("/api/v1/cache/fscache/stats", JSONHandler(handleFSCacheStats))
+	mux.Handle("/api/v1/cache/cachefilesd", JSONHandler(handleCachefilesd))
 	mux.Handle("/api/v1/nodeInfo", JSONHandler(handleNodeInfo))
 	mux.Handle("/api/v1/mounts", JSONHandler(handleMounts))
+	mux.Handle("/api/v1/mounts/drift", JSONHandler(handleMountDrift))
 	mux.Handle("/api/v1/mountStats", JSONHandler(handleMountStats))
 	mux.Handle("/api/v1/nfs/client", JSONHandler(handleNFSClientStats))
 	mux.Handle("/api/v1/nfs/server", JSONHandler(handleNFSServerStats))
 	mux.Handle("/api/v1/os", JSONHandler(handleOS))
 	mux.Handle("/api/v1/status", JSONHandler(handleStatus))
+
+	// Rates calculated from the background samples.
+	if sampler != nil {
+		sampler.register(mux)
+		mux.Handle("/api/v1/nfs/server/clients", JSONHandler(sampler.handleNFSServerClients))
+	}
+
+	if scanner != nil {
+		mux.Handle("/api/v1/cache/volumes", JSONHandler(scanner.handleCacheVolumes))
+	}
+
+	// Prometheus metrics
+	mux.Handle("/metrics", metricsHandler())
+
+	// The admin endpoints are only available when a token is configured.
+	if admin != nil {
+		admin.register(mux)
+	}
 }
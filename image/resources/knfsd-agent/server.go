@@ -0,0 +1,162 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// serve serves handler on every plain HTTP listen address, and tlsHandler on
+// every TLS listen address, until ctx is cancelled, then waits for active
+// requests to complete before returning.
+//
+// If any of the servers fail, the other servers are stopped and the error is
+// returned.
+func serve(ctx context.Context, cfg ServerConfig, handler, tlsHandler http.Handler) error {
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Open all the listeners first so that configuration errors, such as an
+	// address already in use, are reported before serving any requests.
+	var listeners []listener
+	closeAll := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+	for _, addr := range cfg.Listen {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			closeAll()
+			return err
+		}
+		listeners = append(listeners, listener{l, handler})
+	}
+	for _, addr := range cfg.TLSListen {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			closeAll()
+			return err
+		}
+		listeners = append(listeners, listener{tls.NewListener(l, tlsConfig), tlsHandler})
+	}
+	return serveListeners(ctx, cfg, listeners)
+}
+
+// listener is a listener and the handler used to serve its requests.
+type listener struct {
+	net.Listener
+	handler http.Handler
+}
+
+func serveListeners(ctx context.Context, cfg ServerConfig, listeners []listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(listeners))
+	servers := make([]*http.Server, len(listeners))
+	for i, l := range listeners {
+		srv := &http.Server{
+			Handler:           l.handler,
+			ReadTimeout:       cfg.ReadTimeout,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+			ErrorLog:          log.Default(),
+		}
+		servers[i] = srv
+
+		wg.Add(1)
+		go func(i int, l listener) {
+			defer wg.Done()
+			log.Printf("Knfsd Agent is listening on %s", l.Addr())
+			err := srv.Serve(l)
+			if !errors.Is(err, http.ErrServerClosed) {
+				errs[i] = fmt.Errorf("%s: %w", l.Addr(), err)
+				// stop the other servers
+				cancel()
+			}
+		}(i, l)
+	}
+
+	<-ctx.Done()
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelShutdown()
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			err := srv.Shutdown(shutdownCtx)
+			if err != nil {
+				log.Printf("could not shutdown gracefully: %s", err)
+				srv.Close()
+			}
+		}(srv)
+	}
+
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func newTLSConfig(cfg ServerConfig) (*tls.Config, error) {
+	if cfg.TLSCert == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if cfg.TLSClientCA != "" {
+		pem, err := os.ReadFile(cfg.TLSClientCA)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in \"%s\"", cfg.TLSClientCA)
+		}
+
+		tlsConfig.ClientCAs = pool
+		if cfg.TLSClientAuth == "verify-if-given" {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		} else {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return tlsConfig, nil
+}
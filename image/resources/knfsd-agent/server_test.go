@@ -0,0 +1,292 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testServerConfig() ServerConfig {
+	return ServerConfig{
+		TLSClientAuth:     "require",
+		ReadTimeout:       defaultReadTimeout,
+		ReadHeaderTimeout: defaultReadHeaderTimeout,
+		IdleTimeout:       defaultIdleTimeout,
+		ShutdownTimeout:   defaultShutdownTimeout,
+	}
+}
+
+func TestServeGracefulShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	served := make(chan error, 1)
+	go func() { served <- serveListeners(ctx, testServerConfig(), []listener{{l, handler}}) }()
+
+	response := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://" + l.Addr().String())
+		if err != nil {
+			response <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		response <- string(b)
+	}()
+
+	<-started
+	cancel()
+
+	// The server must wait for the active request to complete.
+	select {
+	case err := <-served:
+		t.Fatalf("serve returned before the request completed: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	assert.Equal(t, "done", <-response)
+	assert.NoError(t, <-served)
+}
+
+func TestServeListenError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	cfg := testServerConfig()
+	cfg.Listen = []string{l.Addr().String()}
+	err = serve(context.Background(), cfg, http.NotFoundHandler(), http.NotFoundHandler())
+	assert.Error(t, err)
+}
+
+func TestServeTLSListen(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeTestCert(t, dir, "ca", nil, nil)
+	writeTestCert(t, dir, "server", ca, caKey)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	// Reserve two free ports, then release them for serve to listen on.
+	addrs := make([]string, 2)
+	for i := range addrs {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addrs[i] = l.Addr().String()
+		l.Close()
+	}
+
+	cfg := testServerConfig()
+	cfg.Listen = []string{addrs[0]}
+	cfg.TLSListen = []string{addrs[1]}
+	cfg.TLSCert = filepath.Join(dir, "server.crt")
+	cfg.TLSKey = filepath.Join(dir, "server.key")
+
+	handler := func(body string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, body)
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, cfg, handler("http"), handler("https")) }()
+	defer func() {
+		cancel()
+		assert.NoError(t, <-served)
+	}()
+
+	c := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{
+			RootCAs:    roots,
+			ServerName: "localhost",
+		},
+	}}
+	defer c.CloseIdleConnections()
+
+	get := func(url string) string {
+		var res *http.Response
+		var err error
+		// wait for serve to open the listeners
+		for i := 0; i < 50; i++ {
+			res, err = c.Get(url)
+			if err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		require.NoError(t, err)
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	assert.Equal(t, "http", get("http://"+addrs[0]))
+	assert.Equal(t, "https", get("https://"+addrs[1]))
+}
+
+func TestTLSClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeTestCert(t, dir, "ca", nil, nil)
+	writeTestCert(t, dir, "server", ca, caKey)
+	client, clientKey := writeTestCert(t, dir, "client", ca, caKey)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	clientCert := tls.Certificate{
+		Certificate: [][]byte{client.Raw},
+		PrivateKey:  clientKey,
+	}
+
+	cfg := testServerConfig()
+	cfg.TLSCert = filepath.Join(dir, "server.crt")
+	cfg.TLSKey = filepath.Join(dir, "server.key")
+	cfg.TLSClientCA = filepath.Join(dir, "ca.crt")
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, clientCertSubject(r))
+	})
+
+	get := func(t *testing.T, cfg ServerConfig, certs []tls.Certificate) (string, error) {
+		tlsConfig, err := newTLSConfig(cfg)
+		require.NoError(t, err)
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		served := make(chan error, 1)
+		go func() {
+			served <- serveListeners(ctx, cfg, []listener{{tls.NewListener(l, tlsConfig), handler}})
+		}()
+		defer func() {
+			cancel()
+			assert.NoError(t, <-served)
+		}()
+
+		c := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs:      roots,
+				Certificates: certs,
+				ServerName:   "localhost",
+			},
+		}}
+		defer c.CloseIdleConnections()
+
+		res, err := c.Get("https://" + l.Addr().String())
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		return string(b), err
+	}
+
+	t.Run("require", func(t *testing.T) {
+		subject, err := get(t, cfg, []tls.Certificate{clientCert})
+		require.NoError(t, err)
+		assert.Equal(t, "CN=client", subject)
+
+		_, err = get(t, cfg, nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("verify-if-given", func(t *testing.T) {
+		cfg := cfg
+		cfg.TLSClientAuth = "verify-if-given"
+
+		subject, err := get(t, cfg, []tls.Certificate{clientCert})
+		require.NoError(t, err)
+		assert.Equal(t, "CN=client", subject)
+
+		subject, err = get(t, cfg, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "", subject)
+	})
+}
+
+// writeTestCert creates a certificate signed by parent, or a self-signed CA
+// if parent is nil, and writes the certificate and key to dir.
+func writeTestCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	require.NoError(t, err)
+
+	return cert, key
+}
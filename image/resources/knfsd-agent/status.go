@@ -21,11 +21,13 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-agent/client"
 )
@@ -86,11 +88,38 @@ func (sh *ServiceHealth) Add(name string, health client.Check, err error) {
 }
 
 func handleStatus(*http.Request) (*client.StatusResponse, error) {
-	return &client.StatusResponse{
+	return readStatus(), nil
+}
+
+func readStatus() *client.StatusResponse {
+	res := &client.StatusResponse{
+		Health: client.CHECK_PASS,
 		Services: []client.ServiceHealth{
 			cachefilesdStatus(),
+			nfsServerStatus(),
+			nfsMountsStatus(),
 		},
-	}, nil
+	}
+
+	// Optional services are only reported if they have been started on this
+	// proxy, for example knfsd-fsidd is only used when FSID_MODE is external.
+	optional := []func() (client.ServiceHealth, bool){
+		knfsdFsiddStatus,
+		fsiddStatus,
+		metricsAgentStatus,
+	}
+	for _, status := range optional {
+		if health, ok := status(); ok {
+			res.Services = append(res.Services, health)
+		}
+	}
+
+	for _, s := range res.Services {
+		if s.Health < res.Health {
+			res.Health = s.Health
+		}
+	}
+	return res
 }
 
 func cachefilesdStatus() client.ServiceHealth {
@@ -99,9 +128,68 @@ func cachefilesdStatus() client.ServiceHealth {
 	health.Check("enabled", checkCachefilesdEnabled())
 	health.Check("running", checkCachefilesdRunning())
 	health.Check("fscache mounted", checkFSCacheMount())
+	health.checkCacheSpace()
 	return client.ServiceHealth(health)
 }
 
+func nfsServerStatus() client.ServiceHealth {
+	health := ServiceHealth{Name: "nfs-kernel-server", Health: client.CHECK_PASS}
+	health.ReadLog("nfs-server.service")
+	// nfs-server.service is a oneshot service that starts the kernel threads,
+	// so will be "active (exited)" rather than "active (running)".
+	health.Check("running", checkSystemdActive("nfs-server.service"))
+	health.Check("mountd running", checkSystemdRunning("nfs-mountd.service"))
+	health.Check("threads", checkNFSDThreads())
+	health.checkThreadSaturation()
+	return client.ServiceHealth(health)
+}
+
+func knfsdFsiddStatus() (client.ServiceHealth, bool) {
+	const unit = "knfsd-fsidd.service"
+	if !systemdUnitStarted(unit) {
+		return client.ServiceHealth{}, false
+	}
+
+	health := ServiceHealth{Name: "knfsd-fsidd", Health: client.CHECK_PASS}
+	health.ReadLog(unit)
+	health.Check("running", checkSystemdRunning(unit))
+	health.Check("socket", checkFsiddSocket(fsiddSocketPath))
+	health.checkFsiddHealth(unit)
+	return client.ServiceHealth(health), true
+}
+
+// fsiddStatus checks the fsidd service provided by nfs-utils, used when
+// FSID_MODE is local.
+func fsiddStatus() (client.ServiceHealth, bool) {
+	const unit = "fsidd.service"
+	if !systemdUnitStarted(unit) {
+		return client.ServiceHealth{}, false
+	}
+
+	health := ServiceHealth{Name: "fsidd", Health: client.CHECK_PASS}
+	health.ReadLog(unit)
+	health.Check("running", checkSystemdRunning(unit))
+	return client.ServiceHealth(health), true
+}
+
+func metricsAgentStatus() (client.ServiceHealth, bool) {
+	const unit = "knfsd-metrics-agent.service"
+	if !systemdUnitStarted(unit) {
+		return client.ServiceHealth{}, false
+	}
+
+	// The proxy can still serve clients without metrics, so only warn if the
+	// metrics agent is not running.
+	health := ServiceHealth{Name: "knfsd-metrics-agent", Health: client.CHECK_PASS}
+	health.ReadLog(unit)
+	if err := checkSystemdRunning(unit); err != nil {
+		health.Warn("running", err)
+	} else {
+		health.Pass("running")
+	}
+	return client.ServiceHealth(health), true
+}
+
 func checkCachefilesdEnabled() error {
 	f, err := os.Open("/etc/default/cachefilesd")
 	if err != nil {
@@ -135,7 +223,11 @@ func checkCachefilesdEnabled() error {
 }
 
 func checkCachefilesdRunning() error {
-	s, err := readSystemdState("cachefilesd.service")
+	return checkSystemdRunning("cachefilesd.service")
+}
+
+func checkSystemdRunning(unit string) error {
+	s, err := readSystemdState(unit)
 	if err != nil {
 		return err
 	}
@@ -145,6 +237,100 @@ func checkCachefilesdRunning() error {
 	return nil
 }
 
+func checkSystemdActive(unit string) error {
+	p, err := readSystemdProperties(unit, "ActiveState", "SubState")
+	if err != nil {
+		return err
+	}
+	if p["ActiveState"] != "active" {
+		return fmt.Errorf("incorrect state, expected active but was %s (%s)", p["ActiveState"], p["SubState"])
+	}
+	return nil
+}
+
+// systemdUnitStarted returns true if the unit is active, or has been active
+// since boot. Units that have never been started are not used on this proxy.
+func systemdUnitStarted(unit string) bool {
+	p, err := readSystemdProperties(unit, "ActiveState", "ActiveEnterTimestampMonotonic", "InactiveExitTimestampMonotonic")
+	if err != nil {
+		return false
+	}
+	if p["ActiveState"] != "inactive" {
+		return true
+	}
+	return !isZeroTimestamp(p["ActiveEnterTimestampMonotonic"]) ||
+		!isZeroTimestamp(p["InactiveExitTimestampMonotonic"])
+}
+
+func isZeroTimestamp(s string) bool {
+	return s == "" || s == "0"
+}
+
+// fsiddSocketPath is the default socket used by knfsd-fsidd and nfsd.
+const fsiddSocketPath = "/run/fsidd.sock"
+
+// fsiddTimeout limits how long to wait for knfsd-fsidd to respond.
+const fsiddTimeout = 5 * time.Second
+
+// checkFsiddSocket checks that knfsd-fsidd is responding to requests by sending
+// a version request.
+func checkFsiddSocket(path string) error {
+	c, err := net.DialTimeout("unixpacket", path, fsiddTimeout)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	c.SetDeadline(time.Now().Add(fsiddTimeout))
+	_, err = c.Write([]byte("version"))
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1024)
+	n, err := c.Read(buf)
+	if err != nil {
+		return err
+	}
+
+	response := string(buf[:n])
+	if !strings.HasPrefix(response, "+ ") {
+		return fmt.Errorf("invalid response %q", response)
+	}
+	return nil
+}
+
+// checkFsiddHealth reports the result of knfsd-fsidd's own health checks. The
+// health checks are reported to systemd as the status text, for example
+// "degraded; database: connection refused".
+func (sh *ServiceHealth) checkFsiddHealth(unit string) {
+	p, err := readSystemdProperties(unit, "StatusText")
+	if err != nil {
+		sh.Warn("health", err)
+		return
+	}
+	addFsiddHealth(sh, p["StatusText"])
+}
+
+func addFsiddHealth(sh *ServiceHealth, status string) {
+	if status == "" {
+		// Older versions of knfsd-fsidd do not report their health.
+		return
+	}
+
+	state, _, _ := strings.Cut(status, ";")
+	switch strings.TrimSpace(state) {
+	case "ok":
+		sh.Pass("health")
+	case "degraded":
+		sh.Warn("health", errors.New(status))
+	case "unhealthy":
+		sh.Fail("health", errors.New(status))
+	default:
+		sh.Warn("health", fmt.Errorf("unknown status %q", status))
+	}
+}
+
 func checkFSCacheMount() error {
 	cmd := exec.Command("mountpoint", "--quiet", "/var/cache/fscache")
 	err := cmd.Run()
@@ -0,0 +1,146 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+// Package fscache parses the FS-Cache statistics. The package is shared by
+// knfsd-agent and knfsd-metrics-agent.
+package fscache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// StatsFile is the FS-Cache statistics file.
+const StatsFile = "/proc/fs/fscache/stats"
+
+// ErrUnsupportedFormat is returned by Netfs if the netfs counters are missing.
+var ErrUnsupportedFormat = errors.New("unsupported fscache stats format, requires Linux 5.17 or later")
+
+// Stats are the counters from the stats file, keyed by the section and counter
+// name, for example Stats["Cookies"]["n"].
+type Stats map[string]map[string]uint64
+
+// Get returns the counter from the section, or zero if the counter is missing.
+func (s Stats) Get(section, key string) uint64 {
+	return s[section][key]
+}
+
+// Netfs are the netfs counters for reading and writing the cache.
+type Netfs struct {
+	// Downloads are reads from the server of data that was not in the cache.
+	Downloads       uint64
+	DownloadsFailed uint64
+
+	// CacheReads are reads of data from the cache.
+	CacheReads       uint64
+	CacheReadsFailed uint64
+
+	// CacheWrites are writes to the cache of data downloaded from the server.
+	CacheWrites       uint64
+	CacheWritesFailed uint64
+}
+
+// Netfs returns the netfs counters, or ErrUnsupportedFormat if the counters
+// are missing.
+//
+// The counters are found by name in any section. Kernels before 6.10 reported
+// the counters in repeated Netfs sections, later kernels name each section
+// after the operation, such as DownOps, CaRdOps and CaWrOps. Kernels before
+// 5.17 do not have the netfs counters, and report the retrievals in the
+// Retrvls section instead.
+func (s Stats) Netfs() (Netfs, error) {
+	found := true
+	lookup := func(key string) uint64 {
+		for _, section := range s {
+			if n, ok := section[key]; ok {
+				return n
+			}
+		}
+		found = false
+		return 0
+	}
+
+	n := Netfs{
+		Downloads:         lookup("DL"),
+		DownloadsFailed:   lookup("df"),
+		CacheReads:        lookup("RD"),
+		CacheReadsFailed:  lookup("rf"),
+		CacheWrites:       lookup("WR"),
+		CacheWritesFailed: lookup("wf"),
+	}
+	if !found {
+		return Netfs{}, ErrUnsupportedFormat
+	}
+	return n, nil
+}
+
+// ReadStats reads the stats file.
+func ReadStats(name string) (Stats, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseStats(f)
+}
+
+// ParseStats parses the stats file. Each line is a section name followed by
+// counters, such as:
+//
+//	Cookies: n=14 v=1 vcol=0 voom=0
+//	CaRdOps: RD=1024 rs=1024 rf=0
+//
+// The counters vary between kernel versions, so every counter is included,
+// and sections that are repeated are merged.
+func ParseStats(r io.Reader) (Stats, error) {
+	stats := make(Stats)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		name, counters, found := strings.Cut(s.Text(), ":")
+		if !found {
+			// header line
+			continue
+		}
+		name = strings.TrimSpace(name)
+
+		section := stats[name]
+		if section == nil {
+			section = make(map[string]uint64)
+			stats[name] = section
+		}
+
+		for _, field := range strings.Fields(counters) {
+			key, value, found := strings.Cut(field, "=")
+			if !found {
+				continue
+			}
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s counter %s: %w", name, key, err)
+			}
+			section[key] = n
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
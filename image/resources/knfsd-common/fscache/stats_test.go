@@ -0,0 +1,78 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package fscache
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetfs(t *testing.T) {
+	expected := Netfs{
+		Downloads:         9120,
+		DownloadsFailed:   2,
+		CacheReads:        48211,
+		CacheReadsFailed:  3,
+		CacheWrites:       9118,
+		CacheWritesFailed: 4,
+	}
+
+	// Kernels before 6.10 use repeated Netfs sections, the image uses the 6.11
+	// HWE kernel which names each section after the operation.
+	for _, kernel := range []string{"6.8", "6.11"} {
+		t.Run(kernel, func(t *testing.T) {
+			stats, err := ReadStats("testdata/stats-" + kernel)
+			require.NoError(t, err)
+
+			netfs, err := stats.Netfs()
+			require.NoError(t, err)
+			assert.Equal(t, expected, netfs)
+
+			assert.Equal(t, uint64(1532), stats.Get("Cookies", "n"))
+			assert.Equal(t, uint64(96), stats.Get("NoSpace", "cull"))
+		})
+	}
+}
+
+func TestParseStats(t *testing.T) {
+	// Repeated sections are merged.
+	stats, err := ParseStats(strings.NewReader("Netfs  : DL=10 df=1\nNetfs  : RD=20 rf=2\n"))
+	require.NoError(t, err)
+	assert.Equal(t, Stats{"Netfs": {"DL": 10, "df": 1, "RD": 20, "rf": 2}}, stats)
+
+	_, err = ParseStats(strings.NewReader("Cookies: n=abc\n"))
+	assert.Error(t, err)
+}
+
+func TestNetfsUnsupported(t *testing.T) {
+	// Kernels before 5.17 report the retrievals in the Retrvls section.
+	stats, err := ParseStats(strings.NewReader("FS-Cache statistics\nRetrvls: n=10 ok=8 wt=0 nod=2\n"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(8), stats.Get("Retrvls", "ok"))
+
+	_, err = stats.Netfs()
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+
+	// Only some of the counters.
+	stats, err = ParseStats(strings.NewReader("DownOps: DL=10 ds=9 df=1 di=0\n"))
+	require.NoError(t, err)
+	_, err = stats.Netfs()
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+}
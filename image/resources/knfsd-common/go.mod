@@ -0,0 +1,14 @@
+module github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-common
+
+go 1.20
+
+require (
+	github.com/stretchr/testify v1.8.4
+	golang.org/x/sys v0.12.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
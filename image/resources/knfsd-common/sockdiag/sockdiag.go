@@ -0,0 +1,224 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+// Package sockdiag reads the TCP connections using the sock_diag netlink
+// interface. The package is shared by knfsd-agent and knfsd-metrics-agent.
+package sockdiag
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// TCPConnection is an established TCP connection, read using the sock_diag
+// netlink interface. This avoids running ss, and includes the tcp_info
+// counters for each connection.
+type TCPConnection struct {
+	Local  netip.AddrPort
+	Remote netip.AddrPort
+
+	// BytesSent and BytesReceived are the bytes acknowledged by the peer, and
+	// received from the peer.
+	BytesSent     uint64
+	BytesReceived uint64
+	Retransmits   uint32
+
+	// RTT is the smoothed round trip time in microseconds.
+	RTT uint32
+
+	RecvQueue uint32
+	SendQueue uint32
+}
+
+// Layout of the sock_diag structs, see linux/inet_diag.h.
+const (
+	sizeofInetDiagSockID = 48
+	sizeofInetDiagReqV2  = 8 + sizeofInetDiagSockID
+	sizeofInetDiagMsg    = 4 + sizeofInetDiagSockID + 20
+
+	sockDiagByFamily = 20
+	inetDiagInfo     = 2
+	tcpEstablished   = 1
+)
+
+// ReadTCPConnections returns the established TCP connections with the local
+// port.
+func ReadTCPConnections(port uint16) ([]TCPConnection, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, unix.NETLINK_SOCK_DIAG)
+	if err != nil {
+		return nil, fmt.Errorf("could not open sock_diag socket: %w", err)
+	}
+	defer unix.Close(fd)
+
+	// Return an empty slice, rather than nil, if there are no connections so
+	// that samples can distinguish no connections from an error.
+	conns := []TCPConnection{}
+	for _, family := range []uint8{unix.AF_INET, unix.AF_INET6} {
+		c, err := dumpTCPConnections(fd, family)
+		if err != nil {
+			return nil, err
+		}
+		for _, conn := range c {
+			if conn.Local.Port() == port {
+				conns = append(conns, conn)
+			}
+		}
+	}
+	return conns, nil
+}
+
+func dumpTCPConnections(fd int, family uint8) ([]TCPConnection, error) {
+	req := make([]byte, unix.SizeofNlMsghdr+sizeofInetDiagReqV2)
+	nativeEndian.PutUint32(req[0:], uint32(len(req)))
+	nativeEndian.PutUint16(req[4:], sockDiagByFamily)
+	nativeEndian.PutUint16(req[6:], unix.NLM_F_REQUEST|unix.NLM_F_DUMP)
+
+	body := req[unix.SizeofNlMsghdr:]
+	body[0] = family
+	body[1] = unix.IPPROTO_TCP
+	body[2] = 1 << (inetDiagInfo - 1)
+	nativeEndian.PutUint32(body[4:], 1<<tcpEstablished)
+
+	err := unix.Sendto(fd, req, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK})
+	if err != nil {
+		return nil, fmt.Errorf("could not query sock_diag: %w", err)
+	}
+
+	var conns []TCPConnection
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, fmt.Errorf("could not read sock_diag: %w", err)
+		}
+
+		c, done, err := parseSockDiag(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, c...)
+		if done {
+			return conns, nil
+		}
+	}
+}
+
+// parseSockDiag parses the messages from a sock_diag dump. done is true once
+// the end of the dump has been reached.
+func parseSockDiag(b []byte) (conns []TCPConnection, done bool, err error) {
+	for len(b) >= unix.SizeofNlMsghdr {
+		length := nativeEndian.Uint32(b[0:])
+		kind := nativeEndian.Uint16(b[4:])
+		if length < unix.SizeofNlMsghdr || int(length) > len(b) {
+			return nil, false, errors.New("invalid sock_diag message")
+		}
+
+		msg := b[unix.SizeofNlMsghdr:length]
+		switch kind {
+		case unix.NLMSG_DONE:
+			return conns, true, nil
+		case unix.NLMSG_ERROR:
+			if len(msg) >= 4 {
+				errno := -int32(nativeEndian.Uint32(msg))
+				if errno != 0 {
+					return nil, false, fmt.Errorf("sock_diag: %w", unix.Errno(errno))
+				}
+			}
+		case sockDiagByFamily:
+			c, err := parseInetDiagMsg(msg)
+			if err != nil {
+				return nil, false, err
+			}
+			conns = append(conns, c)
+		}
+
+		b = b[nlmAlign(int(length)):]
+	}
+	return conns, false, nil
+}
+
+func parseInetDiagMsg(b []byte) (TCPConnection, error) {
+	if len(b) < sizeofInetDiagMsg {
+		return TCPConnection{}, errors.New("invalid inet_diag message")
+	}
+
+	var c TCPConnection
+	family := b[0]
+	id := b[4 : 4+sizeofInetDiagSockID]
+	// Ports and addresses are in network byte order.
+	sport := binary.BigEndian.Uint16(id[0:])
+	dport := binary.BigEndian.Uint16(id[2:])
+	c.Local = netip.AddrPortFrom(inetDiagAddr(family, id[4:20]), sport)
+	c.Remote = netip.AddrPortFrom(inetDiagAddr(family, id[20:36]), dport)
+	c.RecvQueue = nativeEndian.Uint32(b[56:])
+	c.SendQueue = nativeEndian.Uint32(b[60:])
+
+	// Attributes follow the message.
+	attrs := b[nlmAlign(sizeofInetDiagMsg):]
+	for len(attrs) >= unix.SizeofRtAttr {
+		length := int(nativeEndian.Uint16(attrs[0:]))
+		kind := nativeEndian.Uint16(attrs[2:])
+		if length < unix.SizeofRtAttr || length > len(attrs) {
+			break
+		}
+		if kind == inetDiagInfo {
+			info := parseTCPInfo(attrs[unix.SizeofRtAttr:length])
+			c.BytesSent = info.Bytes_acked
+			c.BytesReceived = info.Bytes_received
+			c.Retransmits = info.Total_retrans
+			c.RTT = info.Rtt
+		}
+		if nlmAlign(length) >= len(attrs) {
+			break
+		}
+		attrs = attrs[nlmAlign(length):]
+	}
+	return c, nil
+}
+
+func inetDiagAddr(family uint8, b []byte) netip.Addr {
+	if family == unix.AF_INET {
+		return netip.AddrFrom4([4]byte(b[:4]))
+	}
+	return netip.AddrFrom16([16]byte(b[:16])).Unmap()
+}
+
+// parseTCPInfo copies the tcp_info struct. Older kernels return a shorter
+// struct, in which case the missing fields are zero.
+func parseTCPInfo(b []byte) unix.TCPInfo {
+	var info unix.TCPInfo
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(&info)), unix.SizeofTCPInfo), b)
+	return info
+}
+
+// nativeEndian is the byte order of netlink messages, which use the host's
+// byte order.
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+func nlmAlign(n int) int {
+	return (n + unix.NLMSG_ALIGNTO - 1) &^ (unix.NLMSG_ALIGNTO - 1)
+}
@@ -0,0 +1,122 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package sockdiag
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestReadTCPConnections(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+
+	s := <-accepted
+	defer s.Close()
+
+	_, err = c.Write([]byte("request"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(s, make([]byte, 7))
+	require.NoError(t, err)
+	_, err = s.Write([]byte("response"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(c, make([]byte, 8))
+	require.NoError(t, err)
+
+	port := uint16(l.Addr().(*net.TCPAddr).Port)
+	conns, err := ReadTCPConnections(port)
+	if err != nil {
+		t.Skipf("sock_diag not available: %v", err)
+	}
+
+	require.Len(t, conns, 1)
+	conn := conns[0]
+	assert.Equal(t, l.Addr().String(), conn.Local.String())
+	assert.Equal(t, c.LocalAddr().String(), conn.Remote.String())
+	assert.Equal(t, uint64(8), conn.BytesSent)
+	assert.Equal(t, uint64(7), conn.BytesReceived)
+}
+
+func TestParseSockDiagError(t *testing.T) {
+	msg := make([]byte, unix.SizeofNlMsghdr+4)
+	nativeEndian.PutUint32(msg[0:], uint32(len(msg)))
+	nativeEndian.PutUint16(msg[4:], unix.NLMSG_ERROR)
+	errno := -int32(unix.EPERM)
+	nativeEndian.PutUint32(msg[unix.SizeofNlMsghdr:], uint32(errno))
+
+	_, _, err := parseSockDiag(msg)
+	assert.ErrorIs(t, err, unix.EPERM)
+
+	nativeEndian.PutUint16(msg[4:], unix.NLMSG_DONE)
+	conns, done, err := parseSockDiag(msg)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Empty(t, conns)
+}
+
+func TestParseSockDiagCapture(t *testing.T) {
+	// Captured from a sock_diag dump of the established IPv4 TCP connections,
+	// with a client connected to 127.0.0.1:2049. The connections for other
+	// ports were removed from the dump.
+	if nativeEndian != binary.LittleEndian {
+		t.Skip("capture uses little endian byte order")
+	}
+	b, err := os.ReadFile("testdata/sockdiag.bin")
+	require.NoError(t, err)
+
+	conns, done, err := parseSockDiag(b)
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, []TCPConnection{
+		{
+			Local:         netip.MustParseAddrPort("127.0.0.1:2049"),
+			Remote:        netip.MustParseAddrPort("127.0.0.1:56710"),
+			BytesSent:     16000,
+			BytesReceived: 65536,
+			RTT:           5045,
+		},
+		{
+			Local:         netip.MustParseAddrPort("127.0.0.1:56710"),
+			Remote:        netip.MustParseAddrPort("127.0.0.1:2049"),
+			BytesSent:     65537,
+			BytesReceived: 16000,
+			RTT:           11,
+			RecvQueue:     16000,
+		},
+	}, conns)
+}
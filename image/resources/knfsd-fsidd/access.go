@@ -0,0 +1,166 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"go.uber.org/multierr"
+	"golang.org/x/sys/unix"
+)
+
+// anyID allows any user or group.
+const anyID = "*"
+
+// readOnlyCommands lists the commands that do not modify the FSID table. Any
+// command not in this list is treated as a mutating command.
+var readOnlyCommands = map[string]bool{
+	"GET_FSIDNUM": true,
+	"GET_PATH":    true,
+	"PREFETCH":    true,
+	"VERSION":     true,
+}
+
+type AccessConfig struct {
+	// WriteUIDs and WriteGIDs are the users and groups allowed to execute
+	// commands that modify the FSID table, such as allocating new FSIDs.
+	WriteUIDs []string `ini:"write-uids"`
+	WriteGIDs []string `ini:"write-gids"`
+
+	// ReadUIDs and ReadGIDs are the users and groups allowed to execute
+	// read-only commands. Users and groups allowed to execute mutating commands
+	// can also execute read-only commands.
+	ReadUIDs []string `ini:"read-uids"`
+	ReadGIDs []string `ini:"read-gids"`
+}
+
+func (cfg *AccessConfig) Validate() error {
+	_, err := newAccessPolicy(*cfg)
+	return err
+}
+
+// idSet is a set of user or group IDs. A nil set allows any ID.
+type idSet map[uint32]struct{}
+
+func (s idSet) contains(id uint32) bool {
+	if s == nil {
+		return true
+	}
+	_, found := s[id]
+	return found
+}
+
+type accessPolicy struct {
+	writeUIDs, writeGIDs idSet
+	readUIDs, readGIDs   idSet
+}
+
+func newAccessPolicy(cfg AccessConfig) (*accessPolicy, error) {
+	var err error
+	p := &accessPolicy{}
+	p.writeUIDs = parseIDs(&err, "write-uids", cfg.WriteUIDs, lookupUID)
+	p.writeGIDs = parseIDs(&err, "write-gids", cfg.WriteGIDs, lookupGID)
+	p.readUIDs = parseIDs(&err, "read-uids", cfg.ReadUIDs, lookupUID)
+	p.readGIDs = parseIDs(&err, "read-gids", cfg.ReadGIDs, lookupGID)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// parseIDs resolves a list of numeric IDs or names. Any errors are appended to
+// err so that all the invalid entries are reported together.
+func parseIDs(err *error, key string, values []string, lookup func(string) (uint32, error)) idSet {
+	ids := make(idSet)
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		switch v {
+		case "":
+			continue
+		case anyID:
+			return nil
+		}
+
+		if n, e := strconv.ParseUint(v, 10, 32); e == nil {
+			ids[uint32(n)] = struct{}{}
+			continue
+		}
+
+		id, e := lookup(v)
+		if e != nil {
+			multierr.AppendInto(err, fmt.Errorf("invalid %q: %w", key, e))
+			continue
+		}
+		ids[id] = struct{}{}
+	}
+	return ids
+}
+
+func lookupUID(name string) (uint32, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(u.Uid, 10, 32)
+	return uint32(id), err
+}
+
+func lookupGID(name string) (uint32, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(g.Gid, 10, 32)
+	return uint32(id), err
+}
+
+// Allowed reports whether the peer is allowed to execute the command. Only the
+// primary group of the peer is checked, as SO_PEERCRED does not include the
+// supplementary groups.
+func (p *accessPolicy) Allowed(cred *unix.Ucred, cmd string) bool {
+	if p.writeUIDs.contains(cred.Uid) || p.writeGIDs.contains(cred.Gid) {
+		return true
+	}
+	if !readOnlyCommands[cmd] {
+		return false
+	}
+	return p.readUIDs.contains(cred.Uid) || p.readGIDs.contains(cred.Gid)
+}
+
+// peerCred returns the credentials of the process that connected to the
+// socket. The credentials are captured by the kernel when the connection was
+// established.
+func peerCred(c *net.UnixConn) (*unix.Ucred, error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var cred *unix.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cred, credErr
+}
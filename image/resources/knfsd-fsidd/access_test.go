@@ -0,0 +1,88 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestAccessPolicy(t *testing.T) {
+	p, err := newAccessPolicy(AccessConfig{
+		WriteUIDs: []string{"0", "100"},
+		WriteGIDs: []string{"200"},
+		ReadUIDs:  []string{"300"},
+		ReadGIDs:  []string{"400"},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		uid, gid uint32
+		read     bool
+		write    bool
+	}{
+		{"root", 0, 0, true, true},
+		{"write uid", 100, 1000, true, true},
+		{"write gid", 1000, 200, true, true},
+		{"read uid", 300, 1000, true, false},
+		{"read gid", 1000, 400, true, false},
+		{"other", 1000, 1000, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := &unix.Ucred{Uid: tt.uid, Gid: tt.gid}
+			assert.Equal(t, tt.read, p.Allowed(cred, "GET_PATH"), "GET_PATH")
+			assert.Equal(t, tt.write, p.Allowed(cred, "GET_OR_CREATE_FSIDNUM"), "GET_OR_CREATE_FSIDNUM")
+			// Commands that are not known to be read-only are treated as mutating.
+			assert.Equal(t, tt.write, p.Allowed(cred, "UNKNOWN"), "UNKNOWN")
+		})
+	}
+}
+
+func TestAccessPolicyAny(t *testing.T) {
+	p, err := newAccessPolicy(AccessConfig{
+		WriteUIDs: []string{"0"},
+		ReadUIDs:  []string{anyID},
+	})
+	require.NoError(t, err)
+
+	cred := &unix.Ucred{Uid: 1000, Gid: 1000}
+	assert.True(t, p.Allowed(cred, "VERSION"))
+	assert.False(t, p.Allowed(cred, "GET_OR_CREATE_FSIDNUM_MULTI"))
+}
+
+func TestAccessPolicyNames(t *testing.T) {
+	p, err := newAccessPolicy(AccessConfig{
+		WriteUIDs: []string{"root"},
+		WriteGIDs: []string{"root"},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, p.writeUIDs, uint32(0))
+	assert.Contains(t, p.writeGIDs, uint32(0))
+
+	_, err = newAccessPolicy(AccessConfig{
+		WriteUIDs: []string{"knfsd-no-such-user"},
+		ReadGIDs:  []string{"knfsd-no-such-group"},
+	})
+	assert.ErrorContains(t, err, "write-uids")
+	assert.ErrorContains(t, err, "read-gids")
+}
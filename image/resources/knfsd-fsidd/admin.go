@@ -0,0 +1,482 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/log"
+)
+
+var errConflicts = errors.New("import contains conflicts")
+
+type adminOptions struct {
+	format    string
+	dryRun    bool
+	retention time.Duration
+}
+
+type adminCommand struct {
+	args  int
+	usage string
+	run   func(ctx context.Context, b Backend, opts adminOptions, args []string) error
+}
+
+var adminCommands = map[string]adminCommand{
+	"list":     {0, "list", adminList},
+	"get":      {1, "get <path>", adminGet},
+	"get-path": {1, "get-path <fsid>", adminGetPath},
+	"reserve":  {2, "reserve <path> <fsid>", adminReserve},
+	"delete":   {1, "delete <path>", adminDelete},
+	"export":   {0, "export [--format=json|csv]", adminExport},
+	"import":   {-1, "import [--format=json|csv|exports] [--dry-run] [file]", adminImport},
+	"gc":       {0, "gc --gc-retention=<duration> [--dry-run]", adminGC},
+}
+
+// adminMain implements the "knfsd-fsidd admin" sub-command. The admin commands
+// connect directly to the database using the same configuration as the
+// service.
+//
+// NOTE: Running knfsd-fsidd services cache the FSID mappings. Changes made
+// using the admin commands (such as deleting a path) will not be visible to
+// running services until they are restarted.
+func adminMain(name string, args []string) int {
+	cfg := new(Config)
+	opts := adminOptions{}
+
+	f := newFlagSet(name, cfg)
+	f.StringVar(&opts.format, "format", "", "")
+	f.BoolVar(&opts.dryRun, "dry-run", false, "")
+	f.Usage = func() { printAdminUsage(name) }
+	loadConfig(cfg, f, args)
+	opts.retention = cfg.GC.Retention
+
+	args = f.Args()
+	if len(args) == 0 {
+		printAdminUsage(name)
+		return 2
+	}
+
+	cmd, ok := adminCommands[args[0]]
+	args = args[1:]
+	if !ok || (cmd.args >= 0 && len(args) != cmd.args) {
+		printAdminUsage(name)
+		return 2
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	b, err := openBackend(ctx, cfg.Database)
+	if err != nil {
+		log.Error.Print(err)
+		return 1
+	}
+	defer b.Close()
+
+	if n, ok := b.(namespaceChecker); ok {
+		err = n.CheckNamespace(ctx)
+		if err != nil {
+			log.Error.Print(err)
+			return 1
+		}
+	}
+
+	err = cmd.run(ctx, b, opts, args)
+	if err != nil {
+		log.Error.Print(err)
+		return 1
+	}
+	return 0
+}
+
+func printAdminUsage(name string) {
+	msg := &strings.Builder{}
+	fmt.Fprintf(msg, "usage: %s admin [flags] <command>\n\ncommands:\n", name)
+	for _, c := range []string{"list", "get", "get-path", "reserve", "delete", "export", "import", "gc"} {
+		fmt.Fprintf(msg, "  %s\n", adminCommands[c].usage)
+	}
+	fmt.Fprint(os.Stderr, msg.String())
+}
+
+func adminList(ctx context.Context, b Backend, opts adminOptions, args []string) error {
+	mappings, err := b.ListFSIDs(ctx)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	for _, m := range mappings {
+		fmt.Fprintf(w, "%d\t%s\n", m.FSID, m.Path)
+	}
+	return w.Flush()
+}
+
+func adminGet(ctx context.Context, b Backend, opts adminOptions, args []string) error {
+	fsid, err := b.GetFSID(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Println(fsid)
+	return nil
+}
+
+func adminGetPath(ctx context.Context, b Backend, opts adminOptions, args []string) error {
+	fsid, err := parseFSID(args[0])
+	if err != nil {
+		return err
+	}
+	path, err := b.GetPath(ctx, fsid)
+	if err != nil {
+		return err
+	}
+	fmt.Println(path)
+	return nil
+}
+
+func adminReserve(ctx context.Context, b Backend, opts adminOptions, args []string) error {
+	fsid, err := parseFSID(args[1])
+	if err != nil {
+		return err
+	}
+	return b.ReserveFSID(ctx, args[0], fsid)
+}
+
+func adminDelete(ctx context.Context, b Backend, opts adminOptions, args []string) error {
+	return b.DeletePath(ctx, args[0])
+}
+
+func adminExport(ctx context.Context, b Backend, opts adminOptions, args []string) error {
+	mappings, err := b.ListFSIDs(ctx)
+	if err != nil {
+		return err
+	}
+	return writeMappings(os.Stdout, opts.format, mappings)
+}
+
+func adminGC(ctx context.Context, b Backend, opts adminOptions, args []string) error {
+	if opts.retention == 0 {
+		return errors.New("gc-retention is required")
+	}
+
+	stale, err := collectGarbage(ctx, b, opts.retention, opts.dryRun)
+
+	status := "deleted"
+	if opts.dryRun {
+		status = "stale"
+	}
+	w := bufio.NewWriter(os.Stdout)
+	for _, m := range stale {
+		fmt.Fprintf(w, "%s: %d\t%s\t%s\n", status, m.FSID, m.Path, m.LastSeen.Format(time.RFC3339))
+	}
+	werr := w.Flush()
+	if err != nil {
+		return err
+	}
+	return werr
+}
+
+func adminImport(ctx context.Context, b Backend, opts adminOptions, args []string) error {
+	var r io.Reader
+	switch len(args) {
+	case 0:
+		r = os.Stdin
+	case 1:
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	default:
+		return ErrInvalidArgument
+	}
+
+	mappings, err := readMappings(r, opts.format)
+	if err != nil {
+		return err
+	}
+	return importMappings(ctx, b, mappings, opts.dryRun, os.Stdout)
+}
+
+// importMappings reserves the FSID for each mapping. This allows migrating
+// from FSID_MODE=static, or from another database, without changing the
+// FSIDs so that existing client file handles keep working.
+//
+// Mappings that already exist are skipped. If a path has already been
+// allocated a different FSID, or the FSID has been allocated to a different
+// path, the mapping is reported as a conflict. No mappings are imported if
+// there are any conflicts.
+func importMappings(ctx context.Context, b Backend, mappings []Mapping, dryRun bool, w io.Writer) error {
+	err := checkDuplicates(mappings)
+	if err != nil {
+		return err
+	}
+
+	var pending []Mapping
+	conflicts := 0
+	for _, m := range mappings {
+		fsid, err := b.GetFSID(ctx, m.Path)
+		if err == nil {
+			if fsid == m.FSID {
+				fmt.Fprintf(w, "exists: %d %s\n", m.FSID, m.Path)
+			} else {
+				fmt.Fprintf(w, "conflict: %s already has fsid %d, expected %d\n", m.Path, fsid, m.FSID)
+				conflicts++
+			}
+			continue
+		}
+		if !IsNotFound(err) {
+			return err
+		}
+
+		path, err := b.GetPath(ctx, m.FSID)
+		if err == nil {
+			fmt.Fprintf(w, "conflict: fsid %d already allocated to %s, expected %s\n", m.FSID, path, m.Path)
+			conflicts++
+			continue
+		}
+		if !IsNotFound(err) {
+			return err
+		}
+
+		pending = append(pending, m)
+	}
+
+	if conflicts > 0 {
+		return fmt.Errorf("%w: %d conflicts found", errConflicts, conflicts)
+	}
+
+	for _, m := range pending {
+		if !dryRun {
+			err = b.ReserveFSID(ctx, m.Path, m.FSID)
+			if err != nil {
+				return fmt.Errorf("could not import %d %s: %w", m.FSID, m.Path, err)
+			}
+		}
+		fmt.Fprintf(w, "imported: %d %s\n", m.FSID, m.Path)
+	}
+	return nil
+}
+
+func checkDuplicates(mappings []Mapping) error {
+	paths := make(map[string]struct{})
+	fsids := make(map[int32]struct{})
+	for _, m := range mappings {
+		if _, found := paths[m.Path]; found {
+			return fmt.Errorf("duplicate path %s", m.Path)
+		}
+		if _, found := fsids[m.FSID]; found {
+			return fmt.Errorf("duplicate fsid %d", m.FSID)
+		}
+		paths[m.Path] = struct{}{}
+		fsids[m.FSID] = struct{}{}
+	}
+	return nil
+}
+
+func writeMappings(w io.Writer, format string, mappings []Mapping) error {
+	switch format {
+	case "", "json":
+		if mappings == nil {
+			// encode an empty array rather than null
+			mappings = []Mapping{}
+		}
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(mappings)
+
+	case "csv":
+		c := csv.NewWriter(w)
+		c.Write([]string{"fsid", "path"})
+		for _, m := range mappings {
+			c.Write([]string{strconv.FormatInt(int64(m.FSID), 10), m.Path})
+		}
+		c.Flush()
+		return c.Error()
+
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func readMappings(r io.Reader, format string) ([]Mapping, error) {
+	var mappings []Mapping
+	var err error
+
+	switch format {
+	case "", "json":
+		err = json.NewDecoder(r).Decode(&mappings)
+	case "csv":
+		mappings, err = readCSVMappings(r)
+	case "exports":
+		mappings, err = readExportsMappings(r)
+	default:
+		err = fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range mappings {
+		if m.FSID < 1 {
+			return nil, fmt.Errorf("invalid fsid %d for %s", m.FSID, m.Path)
+		}
+		if m.Path == "" {
+			return nil, fmt.Errorf("missing path for fsid %d", m.FSID)
+		}
+	}
+	return mappings, nil
+}
+
+func readCSVMappings(r io.Reader) ([]Mapping, error) {
+	c := csv.NewReader(r)
+	c.FieldsPerRecord = 2
+	records, err := c.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	// skip the optional header
+	if len(records) > 0 && records[0][0] == "fsid" {
+		records = records[1:]
+	}
+
+	mappings := make([]Mapping, 0, len(records))
+	for _, r := range records {
+		fsid, err := parseFSID(r[0])
+		if err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, Mapping{FSID: fsid, Path: r[1]})
+	}
+	return mappings, nil
+}
+
+// readExportsMappings reads the explicit fsid=N options from an /etc/exports
+// file, such as the exports file generated by FSID_MODE=static.
+//
+// Exports without a numeric fsid are skipped. fsid=0 is also skipped as this
+// is reserved for the NFS v4 root, and is never allocated by knfsd-fsidd.
+func readExportsMappings(r io.Reader) ([]Mapping, error) {
+	var mappings []Mapping
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		path, rest, err := parseExportsPath(line)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, opt := range exportsOptions(rest) {
+			value, ok := strings.CutPrefix(opt, "fsid=")
+			if !ok {
+				continue
+			}
+			fsid, err := strconv.ParseInt(value, 10, 32)
+			if err != nil || fsid == 0 {
+				// Not a numeric fsid (e.g. UUID), or the NFS v4 root.
+				continue
+			}
+			mappings = append(mappings, Mapping{FSID: int32(fsid), Path: path})
+			break
+		}
+	}
+	return mappings, s.Err()
+}
+
+// parseExportsPath returns the path from an exports line, and the remainder
+// of the line. The path may be quoted, and may contain octal escapes such as
+// \040 for a space.
+func parseExportsPath(line string) (string, string, error) {
+	var raw, rest string
+	if strings.HasPrefix(line, "\"") {
+		end := strings.Index(line[1:], "\"")
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated quote: %s", line)
+		}
+		raw, rest = line[1:end+1], line[end+2:]
+	} else {
+		i := strings.IndexAny(line, " \t")
+		if i < 0 {
+			raw, rest = line, ""
+		} else {
+			raw, rest = line[:i], line[i:]
+		}
+	}
+
+	b := &strings.Builder{}
+	for i := 0; i < len(raw); i++ {
+		if raw[i] == '\\' && i+3 < len(raw) && isOctal(raw[i+1:i+4]) {
+			n, _ := strconv.ParseUint(raw[i+1:i+4], 8, 8)
+			b.WriteByte(byte(n))
+			i += 3
+		} else {
+			b.WriteByte(raw[i])
+		}
+	}
+	return b.String(), rest, nil
+}
+
+func isOctal(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '7' {
+			return false
+		}
+	}
+	return true
+}
+
+// exportsOptions returns all the options for every client of an exports line.
+func exportsOptions(clients string) []string {
+	var opts []string
+	for {
+		start := strings.Index(clients, "(")
+		if start < 0 {
+			return opts
+		}
+		end := strings.Index(clients[start:], ")")
+		if end < 0 {
+			return opts
+		}
+		opts = append(opts, strings.Split(clients[start+1:start+end], ",")...)
+		clients = clients[start+end+1:]
+	}
+}
+
+func parseFSID(s string) (int32, error) {
+	fsid, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || fsid < 1 {
+		return 0, fmt.Errorf("%w: invalid fsid %q", ErrInvalidArgument, s)
+	}
+	return int32(fsid), nil
+}
@@ -0,0 +1,145 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadMappings(t *testing.T) {
+	expected := []Mapping{
+		{1, "/files"},
+		{2, "/archive, old"},
+	}
+
+	t.Run("json", func(t *testing.T) {
+		input := `[{"fsid": 1, "path": "/files"}, {"fsid": 2, "path": "/archive, old"}]`
+		mappings, err := readMappings(strings.NewReader(input), "json")
+		require.NoError(t, err)
+		assert.Equal(t, expected, mappings)
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		input := "fsid,path\n1,/files\n2,\"/archive, old\"\n"
+		mappings, err := readMappings(strings.NewReader(input), "csv")
+		require.NoError(t, err)
+		assert.Equal(t, expected, mappings)
+	})
+
+	t.Run("exports", func(t *testing.T) {
+		input := strings.Join([]string{
+			"# comment",
+			"/   10.0.0.0/8(rw,sync,fsid=0,no_subtree_check)",
+			"/files   10.0.0.0/8(rw,sync,fsid=1,no_subtree_check)",
+			"/archive,\\040old 10.0.0.0/8(ro) 192.168.0.0/16(rw,fsid=2)",
+			"/auto   10.0.0.0/8(rw,reexport=auto-fsidnum)",
+			"/uuid   10.0.0.0/8(rw,fsid=fb9d2c1e-9a3c-4c2b-a9f2-1e9b1c0e2d3f)",
+		}, "\n")
+		mappings, err := readMappings(strings.NewReader(input), "exports")
+		require.NoError(t, err)
+		assert.Equal(t, expected, mappings)
+	})
+
+	t.Run("invalid fsid", func(t *testing.T) {
+		input := `[{"fsid": 0, "path": "/files"}]`
+		_, err := readMappings(strings.NewReader(input), "json")
+		assert.Error(t, err)
+	})
+}
+
+func TestWriteMappings(t *testing.T) {
+	mappings := []Mapping{{1, "/files"}, {2, "/archive"}}
+
+	for _, format := range []string{"json", "csv"} {
+		t.Run(format, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			require.NoError(t, writeMappings(buf, format, mappings))
+
+			actual, err := readMappings(buf, format)
+			require.NoError(t, err)
+			assert.Equal(t, mappings, actual)
+		})
+	}
+}
+
+func TestImportMappings(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("reserves fsids", func(t *testing.T) {
+		source := openBoltTest(t)
+		_, err := source.AllocateFSID(ctx, "/exists")
+		require.NoError(t, err)
+
+		err = importMappings(ctx, source, []Mapping{
+			{1, "/exists"},
+			{7, "/files"},
+		}, false, io.Discard)
+		require.NoError(t, err)
+
+		fsid, err := source.GetFSID(ctx, "/files")
+		require.NoError(t, err)
+		assert.Equal(t, int32(7), fsid)
+
+		// The next allocation must not re-use any imported FSIDs.
+		fsid, err = source.AllocateFSID(ctx, "/new")
+		require.NoError(t, err)
+		assert.Equal(t, int32(8), fsid)
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		source := openBoltTest(t)
+
+		err := importMappings(ctx, source, []Mapping{{7, "/files"}}, true, io.Discard)
+		require.NoError(t, err)
+
+		_, err = source.GetFSID(ctx, "/files")
+		assert.True(t, IsNotFound(err))
+	})
+
+	t.Run("conflicts", func(t *testing.T) {
+		source := openBoltTest(t)
+		_, err := source.AllocateFSID(ctx, "/files")
+		require.NoError(t, err)
+
+		out := &strings.Builder{}
+		err = importMappings(ctx, source, []Mapping{
+			{2, "/files"},   // path has a different fsid
+			{1, "/archive"}, // fsid allocated to a different path
+			{3, "/other"},
+		}, false, out)
+		assert.ErrorIs(t, err, errConflicts)
+		assert.Contains(t, out.String(), "conflict: /files already has fsid 1, expected 2")
+		assert.Contains(t, out.String(), "conflict: fsid 1 already allocated to /files, expected /archive")
+
+		// Nothing should be imported if there were any conflicts.
+		_, err = source.GetFSID(ctx, "/other")
+		assert.True(t, IsNotFound(err))
+	})
+
+	t.Run("duplicates", func(t *testing.T) {
+		source := openBoltTest(t)
+		err := importMappings(ctx, source, []Mapping{{1, "/files"}, {1, "/archive"}}, false, io.Discard)
+		assert.ErrorContains(t, err, "duplicate fsid 1")
+	})
+}
@@ -0,0 +1,161 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultDriver = "cloudsql"
+
+var (
+	// ErrNotFound is returned by backends that are not based on pgx when a
+	// path or FSID does not exist. Use IsNotFound to check for this error, as
+	// the PostgreSQL backends return pgx.ErrNoRows instead.
+	ErrNotFound = errors.New("not found")
+
+	// ErrConflict is returned by backends that are not based on pgx when a
+	// path has already been allocated an FSID. Use IsConflict to check for
+	// this error, as the PostgreSQL backends return a unique_violation error
+	// instead.
+	ErrConflict = errors.New("conflict")
+)
+
+// Mapping is a single path/FSID pair.
+type Mapping struct {
+	FSID int32  `json:"fsid"`
+	Path string `json:"path"`
+}
+
+// StaleMapping is a mapping that has not been used since LastSeen.
+type StaleMapping struct {
+	Mapping
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// pinger is implemented by backends that can check the connection to the
+// database without reading or modifying any mappings.
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Backend is a storage backend that persists the mappings between paths and
+// FSIDs.
+type Backend interface {
+	FSIDProvider
+
+	// ListFSIDs returns every mapping, sorted by FSID.
+	ListFSIDs(ctx context.Context) ([]Mapping, error)
+
+	// ReserveFSID creates a mapping using an explicit FSID, rather than
+	// allocating the next available FSID. AllocateFSID will never allocate an
+	// FSID that has been reserved.
+	//
+	// If either the path or the FSID is already in use, ReserveFSID returns a
+	// conflict error (see IsConflict).
+	ReserveFSID(ctx context.Context, path string, fsid int32) error
+
+	// DeletePath deletes the mapping for a path. The FSID that was allocated
+	// to the path will not be re-used by AllocateFSID.
+	DeletePath(ctx context.Context, path string) error
+
+	// TouchPaths records that the paths were used at the time seen. Paths
+	// that do not exist are ignored.
+	TouchPaths(ctx context.Context, paths []string, seen time.Time) error
+
+	// ListStale returns the mappings that have not been used since before,
+	// sorted by FSID.
+	ListStale(ctx context.Context, before time.Time) ([]StaleMapping, error)
+
+	// DeleteStale deletes the mapping for a path only if the path has not been
+	// used since before. If the path does not exist, or has been used since
+	// before, DeleteStale returns a not found error (see IsNotFound). As with
+	// DeletePath, the FSID will not be re-used.
+	DeleteStale(ctx context.Context, path string, before time.Time) error
+
+	// CreateTable creates the table (or equivalent storage) used to hold the
+	// FSIDs if it does not already exist, and upgrades the table to the latest
+	// schema.
+	CreateTable(ctx context.Context) error
+
+	Close()
+}
+
+// namespaceChecker is implemented by backends that support namespaces. The
+// namespace must be checked before using the backend.
+type namespaceChecker interface {
+	CheckNamespace(ctx context.Context) error
+}
+
+type driver struct {
+	// validate checks that the DatabaseConfig contains all the values
+	// required by the driver.
+	validate func(cfg *DatabaseConfig) error
+
+	// open connects to the backend. The context is only used while
+	// connecting, the backend will remain open until Close is called.
+	open func(ctx context.Context, cfg DatabaseConfig) (Backend, error)
+}
+
+var drivers = make(map[string]driver)
+
+// registerDriver makes a backend available by name for the [database] driver
+// config key. If a driver has already been registered with the same name,
+// registerDriver panics.
+func registerDriver(name string, d driver) {
+	if d.validate == nil || d.open == nil {
+		panic("incomplete driver " + name)
+	}
+	if _, duplicate := drivers[name]; duplicate {
+		panic("multiple registrations for driver " + name)
+	}
+	drivers[name] = d
+}
+
+func lookupDriver(name string) (driver, error) {
+	if name == "" {
+		name = defaultDriver
+	}
+	d, ok := drivers[name]
+	if !ok {
+		return driver{}, fmt.Errorf("unknown driver %q, expected one of: %s",
+			name, strings.Join(driverNames(), ", "))
+	}
+	return d, nil
+}
+
+func driverNames() []string {
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func openBackend(ctx context.Context, cfg DatabaseConfig) (Backend, error) {
+	d, err := lookupDriver(cfg.Driver)
+	if err != nil {
+		return nil, err
+	}
+	return d.open(ctx, cfg)
+}
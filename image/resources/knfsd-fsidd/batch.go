@@ -0,0 +1,228 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/log"
+)
+
+// protocolVersion is returned by the version command.
+//
+// Version 2 added the batch commands prefetch and get_or_create_fsidnum_multi.
+// The argument of a batch command is a list of paths separated by newlines.
+const protocolVersion = "2"
+
+// splitPaths splits the argument of a batch command into paths.
+func splitPaths(arg string) ([]string, error) {
+	if arg == "" {
+		return nil, ErrInvalidArgument
+	}
+	paths := strings.Split(arg, "\n")
+	for _, path := range paths {
+		if path == "" {
+			return nil, ErrInvalidArgument
+		}
+	}
+	return paths, nil
+}
+
+// getOrCreateFSIDs gets the FSIDs of the paths using a single request, then
+// allocates FSIDs for any paths that do not exist.
+//
+// Allocating an FSID might fail with a conflict if another process allocated
+// an FSID for the same path. The caller should retry the whole batch, the
+// retry will find the FSID allocated by the other process.
+func getOrCreateFSIDs(ctx context.Context, f FSIDProvider, paths []string) (map[string]int32, error) {
+	fsids, err := f.GetFSIDs(ctx, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		if _, found := fsids[path]; found {
+			continue
+		}
+		fsid, err := f.AllocateFSID(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		fsids[path] = fsid
+	}
+	return fsids, nil
+}
+
+// packPaths splits the paths into batches so that each batch command fits
+// within a single packet. Paths that cannot be sent using a batch command are
+// returned separately.
+func packPaths(cmd string, paths []string) (batches [][]string, skipped []string) {
+	// The command, plus a space between the command and the first path.
+	// Each additional path adds a newline.
+	max := PacketMaxLength - len(cmd) - 1
+
+	var batch []string
+	n := 0
+	for _, path := range paths {
+		if path == "" || strings.Contains(path, "\n") || len(path) > max {
+			skipped = append(skipped, path)
+			continue
+		}
+
+		size := len(path)
+		if len(batch) > 0 {
+			size++
+		}
+		if n+size > max {
+			batches = append(batches, batch)
+			batch, n, size = nil, 0, len(path)
+		}
+
+		batch = append(batch, path)
+		n += size
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches, skipped
+}
+
+// prefetchMain implements the "knfsd-fsidd prefetch" sub-command. The paths are
+// sent to the running service, which loads any existing FSIDs for the paths
+// into its cache before nfsd requests them.
+func prefetchMain(name string, args []string) int {
+	cfg := new(Config)
+	var format string
+
+	f := newFlagSet(name, cfg)
+	f.StringVar(&format, "format", "paths", "")
+	f.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s prefetch [--socket=<path>] [--format=paths|exports] [file]\n", name)
+	}
+	loadConfig(cfg, f, args)
+
+	var r io.Reader
+	switch f.NArg() {
+	case 0:
+		r = os.Stdin
+	case 1:
+		file, err := os.Open(f.Arg(0))
+		if err != nil {
+			log.Error.Print(err)
+			return 1
+		}
+		defer file.Close()
+		r = file
+	default:
+		f.Usage()
+		return 2
+	}
+
+	paths, err := readPrefetchPaths(r, format)
+	if err != nil {
+		log.Error.Print(err)
+		return 1
+	}
+
+	c, err := dial(cfg.SocketPath)
+	if err != nil {
+		log.Error.Print(err)
+		return 1
+	}
+	defer c.Close()
+
+	found, err := prefetch(c, paths)
+	if err != nil {
+		log.Error.Print(err)
+		return 1
+	}
+	log.Info.Printf("prefetched %d of %d paths", found, len(paths))
+	return 0
+}
+
+func readPrefetchPaths(r io.Reader, format string) ([]string, error) {
+	var paths []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		switch format {
+		case "paths":
+			if line != "" {
+				paths = append(paths, line)
+			}
+		case "exports":
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			path, _, err := parseExportsPath(line)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, path)
+		default:
+			return nil, fmt.Errorf("unknown format \"%s\"", format)
+		}
+	}
+	return paths, s.Err()
+}
+
+// prefetch sends the paths to the service in batches, and returns the number
+// of paths that had an FSID.
+func prefetch(c *net.UnixConn, paths []string) (int, error) {
+	batches, skipped := packPaths("prefetch", paths)
+	for _, path := range skipped {
+		log.Warn.Printf("cannot prefetch path %q", path)
+	}
+
+	buf := make([]byte, PacketMaxLength)
+	total := 0
+	for _, batch := range batches {
+		_, err := c.Write([]byte("prefetch " + strings.Join(batch, "\n")))
+		if err != nil {
+			return total, err
+		}
+
+		n, err := c.Read(buf)
+		if err != nil {
+			return total, err
+		}
+
+		response := string(buf[:n])
+		if msg, ok := strings.CutPrefix(response, "- "); ok {
+			return total, errors.New(msg)
+		}
+		value, ok := strings.CutPrefix(response, "+ ")
+		if !ok {
+			return total, fmt.Errorf("invalid response %q", response)
+		}
+		found, err := strconv.Atoi(value)
+		if err != nil {
+			return total, fmt.Errorf("invalid response %q", response)
+		}
+		total += found
+	}
+	return total, nil
+}
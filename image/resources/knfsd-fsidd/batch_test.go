@@ -0,0 +1,139 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitPaths(t *testing.T) {
+	paths, err := splitPaths("/foo\n/bar baz")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/foo", "/bar baz"}, paths)
+
+	_, err = splitPaths("")
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+
+	_, err = splitPaths("/foo\n\n/bar")
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+}
+
+func TestPackPaths(t *testing.T) {
+	// Each path is 1000 bytes, so 8 paths fit in a single packet along with
+	// the command and separators.
+	var paths []string
+	for i := 0; i < 20; i++ {
+		paths = append(paths, "/"+strings.Repeat(strconv.Itoa(i%10), 999))
+	}
+	paths = append(paths, "/new\nline", "/"+strings.Repeat("x", PacketMaxLength))
+
+	batches, skipped := packPaths("prefetch", paths)
+	assert.Equal(t, []string{"/new\nline", "/" + strings.Repeat("x", PacketMaxLength)}, skipped)
+
+	var packed []string
+	for _, batch := range batches {
+		msg := "prefetch " + strings.Join(batch, "\n")
+		assert.LessOrEqual(t, len(msg), PacketMaxLength)
+		packed = append(packed, batch...)
+	}
+	assert.Equal(t, paths[:20], packed)
+	assert.Len(t, batches, 3)
+}
+
+func TestReadPrefetchPaths(t *testing.T) {
+	t.Run("paths", func(t *testing.T) {
+		paths, err := readPrefetchPaths(strings.NewReader("/foo\n\n/bar baz\n"), "paths")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/foo", "/bar baz"}, paths)
+	})
+
+	t.Run("exports", func(t *testing.T) {
+		input := "# comment\n/foo 10.0.0.0/8(rw)\n\"/bar baz\" *(ro)\n"
+		paths, err := readPrefetchPaths(strings.NewReader(input), "exports")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/foo", "/bar baz"}, paths)
+	})
+}
+
+func TestGetOrCreateFSIDs(t *testing.T) {
+	ctx := context.Background()
+	source := openBoltTest(t)
+	_, err := source.AllocateFSID(ctx, "/bar")
+	require.NoError(t, err)
+
+	fsids, err := getOrCreateFSIDs(ctx, source, []string{"/foo", "/bar", "/baz"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int32{"/bar": 1, "/foo": 2, "/baz": 3}, fsids)
+}
+
+func TestFSIDCacheGetFSIDs(t *testing.T) {
+	source := FakeSource{}
+	cache := FSIDCache{source: &source}
+
+	ctx := context.Background()
+	_, err := cache.GetFSID(ctx, "/foo")
+	require.NoError(t, err)
+	source.called = nil
+
+	// Only paths missing from the cache should be requested from the source.
+	fsids, err := cache.GetFSIDs(ctx, []string{"/foo", "/bar", "/missing"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int32{"/foo": 1, "/bar": 2}, fsids)
+	assert.Equal(t, []any{OpGetFSIDs{[]string{"/bar", "/missing"}}}, source.called)
+	source.called = nil
+
+	_, err = cache.GetPath(ctx, 2)
+	require.NoError(t, err)
+	assert.Empty(t, source.called, "prefetched paths should be cached")
+}
+
+func TestPrefetch(t *testing.T) {
+	s, err := newServer("")
+	require.NoError(t, err)
+	defer s.Close()
+
+	var received [][]string
+	s.Handle("prefetch", func(ctx context.Context, arg string) (string, error) {
+		paths, err := splitPaths(arg)
+		if err != nil {
+			return "", err
+		}
+		received = append(received, paths)
+		return strconv.Itoa(len(paths) - 1), nil
+	})
+	go s.Serve()
+
+	c, err := dial(s.listener.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+
+	var paths []string
+	for i := 0; i < 10; i++ {
+		paths = append(paths, "/"+strings.Repeat(strconv.Itoa(i), 2000))
+	}
+
+	found, err := prefetch(c, paths)
+	require.NoError(t, err)
+	assert.Len(t, received, 3)
+	assert.Equal(t, 7, found)
+}
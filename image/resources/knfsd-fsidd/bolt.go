@@ -0,0 +1,436 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/log"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/multierr"
+)
+
+var errFSIDExhausted = errors.New("no more FSIDs available")
+
+func init() {
+	// The bolt driver stores the FSIDs in a local file. This is only suitable
+	// for single node proxy clusters (or for testing), as the FSIDs will not
+	// be shared with any other proxy instances.
+	registerDriver("bolt", driver{
+		validate: func(cfg *DatabaseConfig) error {
+			var err error
+			err = multierr.Append(err, required("database-path", cfg.Path))
+			err = multierr.Append(err, required("table-name", cfg.TableName))
+			err = multierr.Append(err, noNamespace(cfg))
+			return err
+		},
+		open: func(ctx context.Context, cfg DatabaseConfig) (Backend, error) {
+			return openBolt(cfg.Path, cfg.TableName)
+		},
+	})
+}
+
+// BoltSource stores the FSIDs in a bbolt database file.
+//
+// Each table is stored as a pair of buckets, one mapping paths to FSIDs, the
+// other mapping FSIDs to paths. FSIDs are allocated using the sequence of the
+// fsids bucket, so that FSIDs are never re-used. A third bucket records when
+// each path was last used.
+type BoltSource struct {
+	db    *bolt.DB
+	fsids []byte // bucket name: path => fsid
+	paths []byte // bucket name: fsid => path
+	seen  []byte // bucket name: path => last seen (unix nanoseconds)
+}
+
+func openBolt(path, tableName string) (*BoltSource, error) {
+	log.Debug.Printf("opening bolt database \"%s\"", path)
+	// bbolt uses an exclusive lock on the file, use a timeout to avoid
+	// blocking forever if another process has the file open.
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltSource{
+		db:    db,
+		fsids: []byte(tableName + "/fsids"),
+		paths: []byte(tableName + "/paths"),
+		seen:  []byte(tableName + "/last_seen"),
+	}, nil
+}
+
+func (s *BoltSource) Close() {
+	err := s.db.Close()
+	if err != nil {
+		log.Warn.Printf("could not close bolt database: %s", err)
+	}
+}
+
+func (s *BoltSource) CreateTable(ctx context.Context) error {
+	log.Debug.Printf("creating buckets \"%s\", \"%s\" and \"%s\"", s.fsids, s.paths, s.seen)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{s.fsids, s.paths, s.seen} {
+			_, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Ping checks the database file can be read.
+func (s *BoltSource) Ping(ctx context.Context) error {
+	return s.db.View(func(tx *bolt.Tx) error { return nil })
+}
+
+func (s *BoltSource) GetFSID(ctx context.Context, path string) (int32, error) {
+	var fsid int32
+	start := time.Now()
+	err := s.db.View(func(tx *bolt.Tx) error {
+		fsids, _, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+		fsid, err = decodeFSID(fsids.Get([]byte(path)))
+		return err
+	})
+	recordQuery(ctx, "get_fsid", start, err)
+	return fsid, err
+}
+
+func (s *BoltSource) GetFSIDs(ctx context.Context, paths []string) (map[string]int32, error) {
+	fsids := make(map[string]int32, len(paths))
+	start := time.Now()
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b, _, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			v := b.Get([]byte(path))
+			if v == nil {
+				continue
+			}
+			fsid, err := decodeFSID(v)
+			if err != nil {
+				return err
+			}
+			fsids[path] = fsid
+		}
+		return nil
+	})
+	recordQuery(ctx, "get_fsids", start, err)
+	return fsids, err
+}
+
+func (s *BoltSource) AllocateFSID(ctx context.Context, path string) (int32, error) {
+	var fsid int32
+	start := time.Now()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		fsids, paths, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+
+		// Match the behaviour of the PostgreSQL backend that returns a
+		// unique_violation if the path already exists.
+		if fsids.Get([]byte(path)) != nil {
+			return ErrConflict
+		}
+
+		next, err := fsids.NextSequence()
+		if err != nil {
+			return err
+		}
+		if next > math.MaxInt32 {
+			return errFSIDExhausted
+		}
+
+		fsid = int32(next)
+		return s.put(tx, fsids, paths, path, fsid)
+	})
+	if err != nil {
+		fsid = 0
+	}
+	recordQuery(ctx, "allocate_fsid", start, err)
+	return fsid, err
+}
+
+func (s *BoltSource) GetPath(ctx context.Context, fsid int32) (string, error) {
+	var path string
+	start := time.Now()
+	err := s.db.View(func(tx *bolt.Tx) error {
+		_, paths, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+		v := paths.Get(encodeFSID(fsid))
+		if v == nil {
+			return ErrNotFound
+		}
+		path = string(v)
+		return nil
+	})
+	recordQuery(ctx, "get_path", start, err)
+	return path, err
+}
+
+func (s *BoltSource) ListFSIDs(ctx context.Context) ([]Mapping, error) {
+	var mappings []Mapping
+	start := time.Now()
+	err := s.db.View(func(tx *bolt.Tx) error {
+		_, paths, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+		// The keys are big endian, so the cursor returns the FSIDs in order.
+		return paths.ForEach(func(k, v []byte) error {
+			fsid, err := decodeFSID(k)
+			if err != nil {
+				return err
+			}
+			mappings = append(mappings, Mapping{FSID: fsid, Path: string(v)})
+			return nil
+		})
+	})
+	recordQuery(ctx, "list_fsids", start, err)
+	return mappings, err
+}
+
+func (s *BoltSource) ReserveFSID(ctx context.Context, path string, fsid int32) error {
+	start := time.Now()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		fsids, paths, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+
+		key := encodeFSID(fsid)
+		if fsids.Get([]byte(path)) != nil || paths.Get(key) != nil {
+			return ErrConflict
+		}
+
+		// Advance the sequence past the reserved FSID so that AllocateFSID
+		// does not try to allocate the same FSID.
+		if uint64(fsid) > fsids.Sequence() {
+			err = fsids.SetSequence(uint64(fsid))
+			if err != nil {
+				return err
+			}
+		}
+
+		return s.put(tx, fsids, paths, path, fsid)
+	})
+	recordQuery(ctx, "reserve_fsid", start, err)
+	return err
+}
+
+func (s *BoltSource) DeletePath(ctx context.Context, path string) error {
+	start := time.Now()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		fsids, paths, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+
+		return s.delete(tx, fsids, paths, path)
+	})
+	recordQuery(ctx, "delete_path", start, err)
+	return err
+}
+
+func (s *BoltSource) TouchPaths(ctx context.Context, paths []string, seen time.Time) error {
+	start := time.Now()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		fsids, _, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+		last, err := s.seenBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, path := range paths {
+			key := []byte(path)
+			if fsids.Get(key) == nil {
+				continue
+			}
+			if decodeTime(last.Get(key)).After(seen) {
+				continue
+			}
+			err = last.Put(key, encodeTime(seen))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	recordQuery(ctx, "touch_paths", start, err)
+	return err
+}
+
+func (s *BoltSource) ListStale(ctx context.Context, before time.Time) ([]StaleMapping, error) {
+	var mappings []StaleMapping
+	start := time.Now()
+	err := s.db.View(func(tx *bolt.Tx) error {
+		_, paths, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+		last, err := s.seenBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		return paths.ForEach(func(k, v []byte) error {
+			seen := decodeTime(last.Get(v))
+			if !seen.Before(before) {
+				return nil
+			}
+			fsid, err := decodeFSID(k)
+			if err != nil {
+				return err
+			}
+			mappings = append(mappings, StaleMapping{
+				Mapping:  Mapping{FSID: fsid, Path: string(v)},
+				LastSeen: seen,
+			})
+			return nil
+		})
+	})
+	recordQuery(ctx, "list_stale", start, err)
+	return mappings, err
+}
+
+func (s *BoltSource) DeleteStale(ctx context.Context, path string, before time.Time) error {
+	start := time.Now()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		fsids, paths, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+		last, err := s.seenBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		if !decodeTime(last.Get([]byte(path))).Before(before) {
+			return ErrNotFound
+		}
+		return s.delete(tx, fsids, paths, path)
+	})
+	recordQuery(ctx, "delete_stale", start, err)
+	return err
+}
+
+// put stores a new mapping, and marks the path as last seen now.
+func (s *BoltSource) put(tx *bolt.Tx, fsids, paths *bolt.Bucket, path string, fsid int32) error {
+	last, err := s.seenBucket(tx)
+	if err != nil {
+		return err
+	}
+
+	key := encodeFSID(fsid)
+	err = fsids.Put([]byte(path), key)
+	if err != nil {
+		return err
+	}
+	err = paths.Put(key, []byte(path))
+	if err != nil {
+		return err
+	}
+	return last.Put([]byte(path), encodeTime(time.Now()))
+}
+
+func (s *BoltSource) delete(tx *bolt.Tx, fsids, paths *bolt.Bucket, path string) error {
+	last, err := s.seenBucket(tx)
+	if err != nil {
+		return err
+	}
+
+	v := fsids.Get([]byte(path))
+	if v == nil {
+		return ErrNotFound
+	}
+
+	// Copy the value as it's only valid for the life of the transaction
+	// and may be invalidated by the delete.
+	key := append([]byte(nil), v...)
+	err = fsids.Delete([]byte(path))
+	if err != nil {
+		return err
+	}
+	err = paths.Delete(key)
+	if err != nil {
+		return err
+	}
+	return last.Delete([]byte(path))
+}
+
+func (s *BoltSource) buckets(tx *bolt.Tx) (fsids, paths *bolt.Bucket, err error) {
+	fsids = tx.Bucket(s.fsids)
+	paths = tx.Bucket(s.paths)
+	if fsids == nil || paths == nil {
+		return nil, nil, errors.New("bucket not found, enable create-table to create the buckets")
+	}
+	return fsids, paths, nil
+}
+
+func (s *BoltSource) seenBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	b := tx.Bucket(s.seen)
+	if b == nil {
+		return nil, errors.New("bucket not found, enable create-table to create the buckets")
+	}
+	return b, nil
+}
+
+func encodeFSID(fsid int32) []byte {
+	// Use big endian so that the keys are sorted by FSID.
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(fsid))
+	return b
+}
+
+func decodeFSID(b []byte) (int32, error) {
+	if b == nil {
+		return 0, ErrNotFound
+	}
+	if len(b) != 4 {
+		return 0, errors.New("invalid fsid value")
+	}
+	return int32(binary.BigEndian.Uint32(b)), nil
+}
+
+func encodeTime(t time.Time) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(t.UnixNano()))
+	return b
+}
+
+// decodeTime returns the zero time if the value is missing or invalid.
+func decodeTime(b []byte) time.Time {
+	if len(b) != 8 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b)))
+}
@@ -0,0 +1,227 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openBoltTest(t *testing.T) *BoltSource {
+	source, err := openBolt(filepath.Join(t.TempDir(), "fsids.db"), "fsid-test")
+	require.NoError(t, err)
+	t.Cleanup(source.Close)
+
+	err = source.CreateTable(context.Background())
+	require.NoError(t, err)
+	return source
+}
+
+func TestBoltSource(t *testing.T) {
+	t.Run("Basic", func(t *testing.T) {
+		source := openBoltTest(t)
+
+		ctx := context.Background()
+		allocated_fsid, err := source.AllocateFSID(ctx, "/foo")
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), allocated_fsid)
+
+		fsid, err := source.GetFSID(ctx, "/foo")
+		if assert.NoError(t, err) {
+			assert.Equal(t, allocated_fsid, fsid)
+		}
+
+		path, err := source.GetPath(ctx, allocated_fsid)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "/foo", path)
+		}
+
+		fsid, err = source.AllocateFSID(ctx, "/bar")
+		if assert.NoError(t, err) {
+			assert.Equal(t, int32(2), fsid)
+		}
+	})
+
+	t.Run("MissingFSID", func(t *testing.T) {
+		source := openBoltTest(t)
+
+		path, err := source.GetPath(context.Background(), 1)
+		assert.Equal(t, "", path)
+		assert.True(t, IsNotFound(err))
+	})
+
+	t.Run("MissingPath", func(t *testing.T) {
+		source := openBoltTest(t)
+
+		fsid, err := source.GetFSID(context.Background(), "/foo")
+		assert.Equal(t, int32(0), fsid)
+		assert.True(t, IsNotFound(err))
+	})
+
+	t.Run("IsConflict", func(t *testing.T) {
+		source := openBoltTest(t)
+
+		ctx := context.Background()
+		_, err := source.AllocateFSID(ctx, "/foo")
+		require.NoError(t, err)
+
+		_, err = source.AllocateFSID(ctx, "/foo")
+		require.Error(t, err)
+		require.True(t, IsConflict(err))
+		require.True(t, ShouldRetry(err))
+	})
+
+	t.Run("ReserveFSID", func(t *testing.T) {
+		source := openBoltTest(t)
+
+		ctx := context.Background()
+		require.NoError(t, source.ReserveFSID(ctx, "/foo", 5))
+
+		err := source.ReserveFSID(ctx, "/foo", 6)
+		assert.True(t, IsConflict(err), "path already reserved")
+
+		err = source.ReserveFSID(ctx, "/bar", 5)
+		assert.True(t, IsConflict(err), "fsid already reserved")
+
+		fsid, err := source.AllocateFSID(ctx, "/bar")
+		if assert.NoError(t, err) {
+			assert.Equal(t, int32(6), fsid)
+		}
+	})
+
+	t.Run("DeletePath", func(t *testing.T) {
+		source := openBoltTest(t)
+
+		ctx := context.Background()
+		_, err := source.AllocateFSID(ctx, "/foo")
+		require.NoError(t, err)
+		_, err = source.AllocateFSID(ctx, "/bar")
+		require.NoError(t, err)
+
+		require.NoError(t, source.DeletePath(ctx, "/foo"))
+		assert.True(t, IsNotFound(source.DeletePath(ctx, "/foo")))
+
+		_, err = source.GetPath(ctx, 1)
+		assert.True(t, IsNotFound(err))
+
+		mappings, err := source.ListFSIDs(ctx)
+		if assert.NoError(t, err) {
+			assert.Equal(t, []Mapping{{2, "/bar"}}, mappings)
+		}
+
+		// Deleted FSIDs should not be re-used.
+		fsid, err := source.AllocateFSID(ctx, "/foo")
+		if assert.NoError(t, err) {
+			assert.Equal(t, int32(3), fsid)
+		}
+	})
+
+	t.Run("LastSeen", func(t *testing.T) {
+		source := openBoltTest(t)
+
+		ctx := context.Background()
+		_, err := source.AllocateFSID(ctx, "/foo")
+		require.NoError(t, err)
+		_, err = source.AllocateFSID(ctx, "/bar")
+		require.NoError(t, err)
+
+		now := time.Now()
+		stale, err := source.ListStale(ctx, now.Add(time.Minute))
+		require.NoError(t, err)
+		assert.Len(t, stale, 2)
+
+		// Touching a missing path should be ignored.
+		err = source.TouchPaths(ctx, []string{"/foo", "/missing"}, now.Add(time.Hour))
+		require.NoError(t, err)
+
+		// Touching with an earlier time should not move last seen backwards.
+		err = source.TouchPaths(ctx, []string{"/foo"}, now)
+		require.NoError(t, err)
+
+		before := now.Add(30 * time.Minute)
+		stale, err = source.ListStale(ctx, before)
+		require.NoError(t, err)
+		if assert.Len(t, stale, 1) {
+			assert.Equal(t, Mapping{2, "/bar"}, stale[0].Mapping)
+		}
+
+		err = source.DeleteStale(ctx, "/foo", before)
+		assert.True(t, IsNotFound(err), "path was seen after before")
+		require.NoError(t, source.DeleteStale(ctx, "/bar", before))
+
+		mappings, err := source.ListFSIDs(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []Mapping{{1, "/foo"}}, mappings)
+
+		// Deleted FSIDs should not be re-used.
+		fsid, err := source.AllocateFSID(ctx, "/bar")
+		if assert.NoError(t, err) {
+			assert.Equal(t, int32(3), fsid)
+		}
+	})
+
+	t.Run("MissingTable", func(t *testing.T) {
+		source, err := openBolt(filepath.Join(t.TempDir(), "fsids.db"), "fsid-test")
+		require.NoError(t, err)
+		defer source.Close()
+
+		_, err = source.GetFSID(context.Background(), "/foo")
+		assert.Error(t, err)
+		assert.False(t, IsNotFound(err))
+	})
+}
+
+func TestDatabaseConfigValidate(t *testing.T) {
+	t.Run("default driver", func(t *testing.T) {
+		cfg := DatabaseConfig{URL: "user=fsids", Instance: "p:r:i", TableName: "fsids"}
+		assert.NoError(t, cfg.Validate())
+	})
+
+	t.Run("postgres does not require instance", func(t *testing.T) {
+		cfg := DatabaseConfig{Driver: "postgres", URL: "host=localhost", TableName: "fsids"}
+		assert.NoError(t, cfg.Validate())
+	})
+
+	t.Run("bolt requires path", func(t *testing.T) {
+		cfg := DatabaseConfig{Driver: "bolt", TableName: "fsids"}
+		assert.Error(t, cfg.Validate())
+	})
+
+	t.Run("namespace", func(t *testing.T) {
+		cfg := DatabaseConfig{Driver: "postgres", URL: "host=localhost", TableName: "fsids", Namespace: "cluster1", SharedFSIDs: true}
+		assert.NoError(t, cfg.Validate())
+
+		cfg.Namespace = strings.Repeat("x", 64)
+		assert.ErrorContains(t, cfg.Validate(), "namespace")
+	})
+
+	t.Run("bolt does not support namespaces", func(t *testing.T) {
+		cfg := DatabaseConfig{Driver: "bolt", Path: "fsids.db", TableName: "fsids", Namespace: "cluster1"}
+		assert.ErrorContains(t, cfg.Validate(), "does not support namespaces")
+	})
+
+	t.Run("unknown driver", func(t *testing.T) {
+		cfg := DatabaseConfig{Driver: "mysql"}
+		assert.ErrorContains(t, cfg.Validate(), "unknown driver \"mysql\"")
+	})
+}
@@ -18,7 +18,11 @@ package main
 
 import (
 	"context"
+	"sort"
 	"sync"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/internal/metrics"
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/log"
 )
 
 // FSIDCache provides a simple read cache improve read performance by avoiding
@@ -27,10 +31,33 @@ import (
 // If there are concurrent requests to get or allocate an FSID, let those
 // requests race each other. Concurrency and consistency will be handled by the
 // database.
+//
+// If a journal is provided, any new mappings are written to the journal so
+// that the cache can be re-populated after a restart using Load.
 type FSIDCache struct {
-	source FSIDProvider
-	fsids  sync.Map // path => fsid
-	paths  sync.Map // fsid => path
+	source  FSIDProvider
+	journal *Journal
+	fsids   sync.Map // path => fsid
+	paths   sync.Map // fsid => path
+}
+
+// Load populates the cache with entries read from the journal, without
+// writing them back to the journal.
+func (c *FSIDCache) Load(entries []Mapping) {
+	for _, e := range entries {
+		c.fsids.Store(e.Path, e.FSID)
+		c.paths.Store(e.FSID, e.Path)
+	}
+}
+
+// Len returns the number of mappings in the cache.
+func (c *FSIDCache) Len() int {
+	n := 0
+	c.fsids.Range(func(_, _ any) bool {
+		n++
+		return true
+	})
+	return n
 }
 
 func (c *FSIDCache) GetFSID(ctx context.Context, path string) (int32, error) {
@@ -66,11 +93,142 @@ func (c *FSIDCache) GetPath(ctx context.Context, fsid int32) (string, error) {
 	return path, err
 }
 
+// GetFSIDs returns the cached FSIDs, and fetches any paths that are not cached
+// from the source in a single request.
+func (c *FSIDCache) GetFSIDs(ctx context.Context, paths []string) (map[string]int32, error) {
+	fsids := make(map[string]int32, len(paths))
+	var missing []string
+	for _, path := range paths {
+		if fsid, ok := c.fsids.Load(path); ok {
+			fsids[path] = fsid.(int32)
+		} else {
+			missing = append(missing, path)
+		}
+	}
+	if len(missing) == 0 {
+		return fsids, nil
+	}
+
+	found, err := c.source.GetFSIDs(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	for path, fsid := range found {
+		c.store(fsid, path)
+		fsids[path] = fsid
+	}
+	return fsids, nil
+}
+
 func (c *FSIDCache) store(fsid int32, path string) {
 	// Concurrent requests will all result in the same fsid, path pair so just
 	// blindly store the results.
 	// There may be some initial contention on the keys but that will quickly
 	// resolve itself, after which the fsid/path combination will be readonly.
-	c.fsids.Store(path, fsid)
+	prev, loaded := c.fsids.Swap(path, fsid)
 	c.paths.Store(fsid, path)
+
+	if c.journal != nil && (!loaded || prev.(int32) != fsid) {
+		// Failing to write to the journal is not fatal, the mapping will be
+		// fetched from the database again after a restart.
+		err := c.journal.Append(fsid, path)
+		if err != nil {
+			log.Warn.Printf("could not write to journal: %s", err)
+		}
+	}
+}
+
+func (c *FSIDCache) remove(fsid int32, path string) {
+	c.fsids.CompareAndDelete(path, fsid)
+	c.paths.CompareAndDelete(fsid, path)
+}
+
+// entries returns a snapshot of the cache sorted by FSID.
+func (c *FSIDCache) entries() []Mapping {
+	var entries []Mapping
+	c.fsids.Range(func(key, value any) bool {
+		entries = append(entries, Mapping{FSID: value.(int32), Path: key.(string)})
+		return true
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].FSID < entries[j].FSID
+	})
+	return entries
+}
+
+// Reconcile checks every cached mapping against the source. This is used after
+// the cache has been loaded from the journal to detect mappings that have
+// been changed in the database while the service was not running, or was
+// running in degraded mode.
+//
+// The database is the source of truth. If the database has a different FSID
+// for a path, or the FSID has been allocated to a different path, the cached
+// entry is replaced (or removed). Mappings that are missing from the database
+// have been deleted (e.g. by an admin or garbage collection) so are removed
+// from the cache.
+//
+// Every conflict is reported using metrics, and the journal is compacted to
+// match the reconciled cache.
+func (c *FSIDCache) Reconcile(ctx context.Context) error {
+	for _, e := range c.entries() {
+		result, err := c.reconcile(ctx, e)
+		if err != nil {
+			return err
+		}
+		metrics.JournalReconcile(ctx, result)
+	}
+	return c.Compact()
+}
+
+// Compact rewrites the journal to match the cache.
+func (c *FSIDCache) Compact() error {
+	if c.journal == nil {
+		return nil
+	}
+	return c.journal.Rewrite(c.entries)
+}
+
+func (c *FSIDCache) reconcile(ctx context.Context, e Mapping) (string, error) {
+	var fsid int32
+	err := withRetry(ctx, func(ctx context.Context) error {
+		var err error
+		fsid, err = c.source.GetFSID(ctx, e.Path)
+		return err
+	})
+
+	if err == nil {
+		if fsid == e.FSID {
+			return "ok", nil
+		}
+		log.Warn.Printf("journal conflict: path %q has fsid %d in the journal, but fsid %d in the database", e.Path, e.FSID, fsid)
+		c.remove(e.FSID, e.Path)
+		c.store(fsid, e.Path)
+		return "fsid_mismatch", nil
+	}
+	if !IsNotFound(err) {
+		return "", err
+	}
+
+	var path string
+	err = withRetry(ctx, func(ctx context.Context) error {
+		var err error
+		path, err = c.source.GetPath(ctx, e.FSID)
+		return err
+	})
+
+	if err == nil {
+		log.Warn.Printf("journal conflict: fsid %d is allocated to %q in the journal, but to %q in the database", e.FSID, e.Path, path)
+		c.remove(e.FSID, e.Path)
+		c.store(e.FSID, path)
+		return "fsid_reused", nil
+	}
+	if !IsNotFound(err) {
+		return "", err
+	}
+
+	// Neither the path or FSID exist in the database, so the mapping has been
+	// deleted. Do not restore the mapping as that would undo the deletion.
+	log.Warn.Printf("journal conflict: path %q with fsid %d is missing from the database, removing from the journal", e.Path, e.FSID)
+	c.remove(e.FSID, e.Path)
+	return "missing", nil
 }
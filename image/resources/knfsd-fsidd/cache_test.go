@@ -18,10 +18,12 @@ package main
 
 import (
 	"context"
+	"path/filepath"
 	"testing"
 
 	"github.com/jackc/pgx/v4"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type any = interface{}
@@ -29,6 +31,11 @@ type any = interface{}
 type OpGetFSID struct{ path string }
 type OpAllocateFSID struct{ path string }
 type OpGetPath struct{ fsid int32 }
+type OpGetFSIDs struct{ paths []string }
+type OpReserveFSID struct {
+	path string
+	fsid int32
+}
 
 type FakeSource struct {
 	called []any
@@ -70,6 +77,25 @@ func (s *FakeSource) GetPath(ctx context.Context, fsid int32) (string, error) {
 	}
 }
 
+func (s *FakeSource) GetFSIDs(ctx context.Context, paths []string) (map[string]int32, error) {
+	s.called = append(s.called, OpGetFSIDs{paths})
+	fsids := make(map[string]int32)
+	for _, path := range paths {
+		switch path {
+		case "/foo":
+			fsids[path] = 1
+		case "/bar":
+			fsids[path] = 2
+		}
+	}
+	return fsids, nil
+}
+
+func (s *FakeSource) ReserveFSID(ctx context.Context, path string, fsid int32) error {
+	s.called = append(s.called, OpReserveFSID{path, fsid})
+	return nil
+}
+
 type CacheTester struct {
 	t      *testing.T
 	source *FakeSource
@@ -196,3 +222,39 @@ func TestFSIDCache(t *testing.T) {
 		test.GetPath(0).Err().WasCalled(OpGetPath{0})
 	})
 }
+
+func TestFSIDCacheReconcile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "fsids.journal")
+	journal, _, err := openJournal(name)
+	require.NoError(t, err)
+	defer journal.Close()
+
+	source := FakeSource{}
+	cache := FSIDCache{source: &source, journal: journal}
+	cache.Load([]Mapping{
+		{1, "/foo"},     // matches the source
+		{2, "/old"},     // fsid has been allocated to a different path
+		{5, "/bar"},     // path has a different fsid
+		{9, "/missing"}, // missing from the source, should be removed
+	})
+
+	err = cache.Reconcile(context.Background())
+	require.NoError(t, err)
+
+	expected := []Mapping{
+		{1, "/foo"},
+		{2, "/bar"},
+	}
+	assert.Equal(t, expected, cache.entries())
+
+	_, ok := cache.paths.Load(int32(5))
+	assert.False(t, ok, "fsid 5 should have been removed from the cache")
+
+	_, ok = cache.paths.Load(int32(9))
+	assert.False(t, ok, "fsid 9 should have been removed from the cache")
+	assert.NotContains(t, source.called, OpReserveFSID{"/missing", 9})
+
+	entries, err := readJournal(name)
+	require.NoError(t, err)
+	assert.Equal(t, expected, entries)
+}
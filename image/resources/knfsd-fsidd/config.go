@@ -23,8 +23,10 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/internal/metrics"
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/internal/tracing"
 	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/log"
 
 	"github.com/go-ini/ini"
@@ -32,46 +34,163 @@ import (
 )
 
 const (
-	defaultConfigFile = "/etc/knfsd-fsidd.conf"
-	defaultSocketPath = "/run/fsidd.sock"
+	defaultConfigFile    = "/etc/knfsd-fsidd.conf"
+	defaultSocketPath    = "/run/fsidd.sock"
+	defaultTouchInterval = time.Hour
+
+	defaultHealthInterval      = 30 * time.Second
+	defaultMaxDatabaseDowntime = 10 * time.Minute
+
+	defaultTraceSampleRatio = 1.0
+
+	// minGCRetention guards against deleting mappings that are still in use,
+	// for example if the retention was set to "90m" instead of "2160h".
+	minGCRetention = 24 * time.Hour
 )
 
 type Config struct {
 	SocketPath string         `ini:"socket"`
 	Database   DatabaseConfig `ini:"database"`
 	Metrics    metrics.Config `ini:"metrics"`
+	Tracing    tracing.Config `ini:"tracing"`
 	Debug      bool           `ini:"debug"`
 	Cache      bool           `ini:"cache"`
+	Journal    string         `ini:"journal"`
+	GC         GCConfig       `ini:"gc"`
+	Access     AccessConfig   `ini:"access"`
+	Health     HealthConfig   `ini:"health"`
+}
+
+type HealthConfig struct {
+	// Interval is how often the health checks are run. If the systemd
+	// watchdog is enabled the checks will run at least twice per watchdog
+	// interval.
+	Interval time.Duration `ini:"interval"`
+
+	// Listen is the address of the optional HTTP health endpoint, either a
+	// TCP address or a unix socket such as "unix:///run/knfsd-fsidd-health.sock".
+	Listen string `ini:"listen"`
+
+	// MaxDatabaseDowntime is how long the database can be unreachable before
+	// the service is considered unhealthy, and the systemd watchdog will
+	// restart the service. Zero disables restarting the service due to
+	// database errors.
+	MaxDatabaseDowntime time.Duration `ini:"max-database-downtime"`
+}
+
+type GCConfig struct {
+	// TouchInterval is how often the last seen time of paths used by the
+	// proxy is written to the database.
+	TouchInterval time.Duration `ini:"touch-interval"`
+
+	// Retention is how long a path can be unused before its mapping is
+	// deleted. A retention of zero disables garbage collection.
+	Retention time.Duration `ini:"retention"`
+
+	// Interval is how often the service deletes stale mappings. An interval
+	// of zero disables the background garbage collection, stale mappings can
+	// still be deleted using "knfsd-fsidd admin gc".
+	Interval time.Duration `ini:"interval"`
+
+	// DryRun logs the stale mappings without deleting them.
+	DryRun bool `ini:"dry-run"`
 }
 
 type DatabaseConfig struct {
+	// Driver selects the storage backend, see registerDriver.
+	Driver string `ini:"driver"`
+
 	URL       string `ini:"url"`
 	Instance  string `ini:"instance"`
 	IAMAuth   bool   `ini:"iam-auth"`
 	PrivateIP bool   `ini:"private-ip"`
 
+	// Path is the database file used by the bolt driver.
+	Path string `ini:"path"`
+
+	// Project, DatabaseID and Endpoint are used by the firestore driver.
+	// Endpoint is optional, and allows using the Firestore emulator or another
+	// Firestore compatible service.
+	Project    string `ini:"project"`
+	DatabaseID string `ini:"database-id"`
+	Endpoint   string `ini:"endpoint"`
+
 	TableName   string `ini:"table-name"`
 	CreateTable bool   `ini:"create-table"`
+
+	// Namespace allows multiple proxy clusters to share the same table. Only
+	// supported by the PostgreSQL drivers.
+	Namespace string `ini:"namespace"`
+
+	// SharedFSIDs allocates FSIDs for the namespace from the FSID space shared
+	// by all namespaces, instead of the namespace having its own FSID space.
+	SharedFSIDs bool `ini:"shared-fsids"`
 }
 
 func (cfg *Config) Validate() error {
 	var err error
 	err = multierr.Append(err, required("socket-path", cfg.SocketPath))
 	err = multierr.Append(err, cfg.Database.Validate())
+	err = multierr.Append(err, cfg.GC.Validate())
+	err = multierr.Append(err, cfg.Access.Validate())
+	err = multierr.Append(err, cfg.Health.Validate())
 	// No validation for the metrics, if there's errors in the config then the
 	// service will still start, just without metrics. Metrics are considered
-	// best effort, and errors do not prevent the app from running.
+	// best effort, and errors do not prevent the app from running. The same
+	// applies to tracing.
 	return err
 }
 
 func (cfg *DatabaseConfig) Validate() error {
+	d, err := lookupDriver(cfg.Driver)
+	if err != nil {
+		return err
+	}
+	return d.validate(cfg)
+}
+
+func (cfg *GCConfig) Validate() error {
 	var err error
-	err = multierr.Append(err, required("database-url", cfg.URL))
-	err = multierr.Append(err, required("database-instance", cfg.Instance))
-	err = multierr.Append(err, required("table-name", cfg.TableName))
+	if cfg.TouchInterval <= 0 {
+		err = multierr.Append(err, errors.New("\"touch-interval\" must be greater than zero"))
+	}
+	if cfg.Retention != 0 && cfg.Retention < minGCRetention {
+		err = multierr.Append(err, fmt.Errorf("\"gc-retention\" must be at least %s", minGCRetention))
+	}
+	if cfg.Interval > 0 && cfg.Retention == 0 {
+		err = multierr.Append(err, required("gc-retention", ""))
+	}
 	return err
 }
 
+func (cfg *HealthConfig) Validate() error {
+	var err error
+	if cfg.Interval <= 0 {
+		err = multierr.Append(err, errors.New("\"health-interval\" must be greater than zero"))
+	}
+	if cfg.MaxDatabaseDowntime < 0 {
+		err = multierr.Append(err, errors.New("\"max-database-downtime\" must not be negative"))
+	}
+	return err
+}
+
+// validateNamespace checks the namespace for drivers that support namespaces.
+func validateNamespace(cfg *DatabaseConfig) error {
+	if len(cfg.Namespace) > 63 {
+		return errors.New("\"namespace\" must not be longer than 63 characters")
+	}
+	return nil
+}
+
+// noNamespace returns an error if a namespace is configured for a driver that
+// does not support namespaces.
+func noNamespace(cfg *DatabaseConfig) error {
+	if cfg.Namespace != "" || cfg.SharedFSIDs {
+		return fmt.Errorf("driver \"%s\" does not support namespaces", cfg.Driver)
+	}
+	return nil
+}
+
 func readDefaultConfig(cfg *Config) error {
 	err := readConfig(cfg, defaultConfigFile)
 	if errors.Is(err, os.ErrNotExist) {
@@ -101,13 +220,32 @@ func parseConfig(cfg *Config, r io.Reader) error {
 func readEnv(cfg *Config) error {
 	var err error
 	envString(&cfg.SocketPath, "FSID_SOCKET")
+	envString(&cfg.Database.Driver, "FSID_DATABASE_DRIVER")
 	envString(&cfg.Database.URL, "FSID_DATABASE_URL")
 	envString(&cfg.Database.Instance, "FSID_DATABASE_INSTANCE")
+	envString(&cfg.Database.Path, "FSID_DATABASE_PATH")
+	envString(&cfg.Database.Project, "FSID_DATABASE_PROJECT")
+	envString(&cfg.Database.DatabaseID, "FSID_DATABASE_ID")
+	envString(&cfg.Database.Endpoint, "FSID_DATABASE_ENDPOINT")
 	envString(&cfg.Database.TableName, "FSID_TABLE_NAME")
+	envString(&cfg.Database.Namespace, "FSID_NAMESPACE")
+	err = multierr.Append(err, envBool(&cfg.Database.SharedFSIDs, "FSID_SHARED_FSIDS"))
 	err = multierr.Append(err, envBool(&cfg.Database.IAMAuth, "FSID_IAM_AUTH"))
 	err = multierr.Append(err, envBool(&cfg.Database.PrivateIP, "FSID_PRIVATE_IP"))
 	err = multierr.Append(err, envBool(&cfg.Debug, "FSID_DEBUG"))
 	err = multierr.Append(err, envBool(&cfg.Debug, "FSID_CACHE"))
+	envString(&cfg.Journal, "FSID_JOURNAL")
+	err = multierr.Append(err, envDuration(&cfg.GC.TouchInterval, "FSID_TOUCH_INTERVAL"))
+	err = multierr.Append(err, envDuration(&cfg.GC.Retention, "FSID_GC_RETENTION"))
+	err = multierr.Append(err, envDuration(&cfg.GC.Interval, "FSID_GC_INTERVAL"))
+	err = multierr.Append(err, envBool(&cfg.GC.DryRun, "FSID_GC_DRY_RUN"))
+	envList(&cfg.Access.WriteUIDs, "FSID_WRITE_UIDS")
+	envList(&cfg.Access.WriteGIDs, "FSID_WRITE_GIDS")
+	envList(&cfg.Access.ReadUIDs, "FSID_READ_UIDS")
+	envList(&cfg.Access.ReadGIDs, "FSID_READ_GIDS")
+	envString(&cfg.Health.Listen, "FSID_HEALTH_LISTEN")
+	err = multierr.Append(err, envDuration(&cfg.Health.Interval, "FSID_HEALTH_INTERVAL"))
+	err = multierr.Append(err, envDuration(&cfg.Health.MaxDatabaseDowntime, "FSID_MAX_DATABASE_DOWNTIME"))
 	return err
 }
 
@@ -117,6 +255,12 @@ func envString(value *string, key string) {
 	}
 }
 
+func envList(value *[]string, key string) {
+	if s, _ := os.LookupEnv(key); s != "" {
+		*value = strings.Split(s, ",")
+	}
+}
+
 func envBool(value *bool, key string) error {
 	if s, _ := os.LookupEnv(key); s != "" {
 		b, err := strconv.ParseBool(s)
@@ -128,6 +272,17 @@ func envBool(value *bool, key string) error {
 	return nil
 }
 
+func envDuration(value *time.Duration, key string) error {
+	if s, _ := os.LookupEnv(key); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid argument %q for %q: %w", s, key, err)
+		}
+		*value = d
+	}
+	return nil
+}
+
 func required(name, value string) error {
 	if value == "" {
 		return fmt.Errorf("required: %q", name)
@@ -0,0 +1,204 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/log"
+	"github.com/googleapis/gax-go/v2"
+)
+
+// ErrDegraded is returned while the database is unavailable. ErrDegraded is
+// not retried so that the kernel is not stalled waiting for the database.
+var ErrDegraded = errors.New("database unavailable")
+
+// DegradedSource allows the service to start before the database is
+// available. While the database is unavailable all requests fail immediately
+// with ErrDegraded. The service can still respond to requests for FSIDs held
+// in the FSIDCache (as loaded from the journal).
+//
+// DegradedSource keeps trying to connect to the database in the background.
+// Once connected all requests are forwarded to the backend.
+type DegradedSource struct {
+	mu      sync.RWMutex
+	backend Backend
+	done    chan struct{}
+}
+
+func newDegradedSource() *DegradedSource {
+	return &DegradedSource{done: make(chan struct{})}
+}
+
+// Start tries to connect to the database in the background using open. Once
+// connected, requests are forwarded to the backend and onConnect is called.
+func (s *DegradedSource) Start(ctx context.Context, open func(context.Context) (Backend, error), onConnect func(context.Context)) {
+	go s.connect(ctx, open, onConnect)
+}
+
+func (s *DegradedSource) connect(ctx context.Context, open func(context.Context) (Backend, error), onConnect func(context.Context)) {
+	defer close(s.done)
+
+	backoff := &gax.Backoff{
+		Initial:    1 * time.Second,
+		Max:        60 * time.Second,
+		Multiplier: 2,
+	}
+
+	for {
+		b, err := open(ctx)
+		if err == nil {
+			log.Info.Print("connected to database, leaving degraded mode")
+			s.mu.Lock()
+			s.backend = b
+			s.mu.Unlock()
+			if onConnect != nil {
+				onConnect(ctx)
+			}
+			return
+		}
+
+		pause := backoff.Pause()
+		log.Warn.Printf("could not connect to database, retrying in %s: %s", pause, err)
+		if sleep(ctx, pause) != nil {
+			return
+		}
+	}
+}
+
+// Degraded reports whether the database is still unavailable.
+func (s *DegradedSource) Degraded() bool {
+	return s.current() == nil
+}
+
+func (s *DegradedSource) current() Backend {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.backend
+}
+
+func (s *DegradedSource) Ping(ctx context.Context) error {
+	b := s.current()
+	if b == nil {
+		return ErrDegraded
+	}
+	if p, ok := b.(pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (s *DegradedSource) GetFSID(ctx context.Context, path string) (int32, error) {
+	b := s.current()
+	if b == nil {
+		return 0, ErrDegraded
+	}
+	return b.GetFSID(ctx, path)
+}
+
+func (s *DegradedSource) AllocateFSID(ctx context.Context, path string) (int32, error) {
+	b := s.current()
+	if b == nil {
+		return 0, ErrDegraded
+	}
+	return b.AllocateFSID(ctx, path)
+}
+
+func (s *DegradedSource) GetPath(ctx context.Context, fsid int32) (string, error) {
+	b := s.current()
+	if b == nil {
+		return "", ErrDegraded
+	}
+	return b.GetPath(ctx, fsid)
+}
+
+func (s *DegradedSource) ListFSIDs(ctx context.Context) ([]Mapping, error) {
+	b := s.current()
+	if b == nil {
+		return nil, ErrDegraded
+	}
+	return b.ListFSIDs(ctx)
+}
+
+func (s *DegradedSource) ReserveFSID(ctx context.Context, path string, fsid int32) error {
+	b := s.current()
+	if b == nil {
+		return ErrDegraded
+	}
+	return b.ReserveFSID(ctx, path, fsid)
+}
+
+func (s *DegradedSource) DeletePath(ctx context.Context, path string) error {
+	b := s.current()
+	if b == nil {
+		return ErrDegraded
+	}
+	return b.DeletePath(ctx, path)
+}
+
+func (s *DegradedSource) GetFSIDs(ctx context.Context, paths []string) (map[string]int32, error) {
+	b := s.current()
+	if b == nil {
+		return nil, ErrDegraded
+	}
+	return b.GetFSIDs(ctx, paths)
+}
+
+func (s *DegradedSource) TouchPaths(ctx context.Context, paths []string, seen time.Time) error {
+	b := s.current()
+	if b == nil {
+		return ErrDegraded
+	}
+	return b.TouchPaths(ctx, paths, seen)
+}
+
+func (s *DegradedSource) ListStale(ctx context.Context, before time.Time) ([]StaleMapping, error) {
+	b := s.current()
+	if b == nil {
+		return nil, ErrDegraded
+	}
+	return b.ListStale(ctx, before)
+}
+
+func (s *DegradedSource) DeleteStale(ctx context.Context, path string, before time.Time) error {
+	b := s.current()
+	if b == nil {
+		return ErrDegraded
+	}
+	return b.DeleteStale(ctx, path, before)
+}
+
+func (s *DegradedSource) CreateTable(ctx context.Context) error {
+	b := s.current()
+	if b == nil {
+		return ErrDegraded
+	}
+	return b.CreateTable(ctx)
+}
+
+// Close waits for the background connection to stop before closing the
+// backend. The context passed to Start must be cancelled before calling Close,
+// otherwise Close will block until the database is available.
+func (s *DegradedSource) Close() {
+	<-s.done
+	if b := s.current(); b != nil {
+		b.Close()
+	}
+}
@@ -0,0 +1,595 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/log"
+	"go.uber.org/multierr"
+	firestore "google.golang.org/api/firestore/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+const defaultFirestoreDatabase = "(default)"
+
+func init() {
+	registerDriver("firestore", driver{
+		validate: func(cfg *DatabaseConfig) error {
+			var err error
+			err = multierr.Append(err, required("database-project", cfg.Project))
+			err = multierr.Append(err, required("table-name", cfg.TableName))
+			err = multierr.Append(err, noNamespace(cfg))
+			return err
+		},
+		open: func(ctx context.Context, cfg DatabaseConfig) (Backend, error) {
+			return openFirestore(ctx, cfg)
+		},
+	})
+}
+
+// FirestoreSource stores the FSIDs in Firestore (native mode), or any service
+// compatible with the Firestore v1 REST API such as the Firestore emulator.
+//
+// Firestore does not support looking up a document by a unique field, so each
+// mapping is stored twice:
+//
+//	{table}-paths/{sha256(path)} => {path: path, fsid: fsid, lastSeen: unix}
+//	{table}-fsids/{fsid}         => {path: path}
+//
+// The next FSID to allocate is stored in {table}-meta/sequence. As Firestore
+// transactions are serializable, concurrent allocations will conflict on the
+// sequence document ensuring FSIDs are unique. FSIDs are never re-used.
+type FirestoreSource struct {
+	svc       *firestore.ProjectsDatabasesDocumentsService
+	database  string
+	documents string
+	tableName string
+}
+
+func openFirestore(ctx context.Context, cfg DatabaseConfig) (*FirestoreSource, error) {
+	var opts []option.ClientOption
+	if cfg.Endpoint != "" {
+		// A custom endpoint is normally the Firestore emulator, which does not
+		// support authentication.
+		opts = append(opts,
+			option.WithEndpoint(cfg.Endpoint),
+			option.WithoutAuthentication(),
+		)
+	}
+
+	log.Debug.Print("Creating Firestore client")
+	svc, err := firestore.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	databaseID := cfg.DatabaseID
+	if databaseID == "" {
+		databaseID = defaultFirestoreDatabase
+	}
+
+	database := fmt.Sprintf("projects/%s/databases/%s", cfg.Project, databaseID)
+	return &FirestoreSource{
+		svc:       svc.Projects.Databases.Documents,
+		database:  database,
+		documents: database + "/documents",
+		tableName: cfg.TableName,
+	}, nil
+}
+
+func (s *FirestoreSource) Close() {}
+
+// CreateTable is a no-op, Firestore creates collections automatically when the
+// first document is written.
+func (s *FirestoreSource) CreateTable(ctx context.Context) error {
+	return nil
+}
+
+func (s *FirestoreSource) GetFSID(ctx context.Context, path string) (int32, error) {
+	start := time.Now()
+	fsid, err := s.getFSID(ctx, path, "")
+	recordQuery(ctx, "get_fsid", start, err)
+	return fsid, err
+}
+
+// GetFSIDs gets each document individually, as the REST client does not
+// support the streaming response of BatchGet.
+func (s *FirestoreSource) GetFSIDs(ctx context.Context, paths []string) (map[string]int32, error) {
+	fsids := make(map[string]int32, len(paths))
+	start := time.Now()
+	var err error
+	for _, path := range paths {
+		var fsid int32
+		fsid, err = s.getFSID(ctx, path, "")
+		if IsNotFound(err) {
+			err = nil
+			continue
+		}
+		if err != nil {
+			break
+		}
+		fsids[path] = fsid
+	}
+	recordQuery(ctx, "get_fsids", start, err)
+	return fsids, err
+}
+
+func (s *FirestoreSource) AllocateFSID(ctx context.Context, path string) (int32, error) {
+	start := time.Now()
+	fsid, err := s.allocateFSID(ctx, path)
+	recordQuery(ctx, "allocate_fsid", start, err)
+	return fsid, err
+}
+
+func (s *FirestoreSource) GetPath(ctx context.Context, fsid int32) (string, error) {
+	var path string
+	start := time.Now()
+	doc, err := s.get(ctx, s.fsidDoc(fsid), "")
+	if err == nil {
+		path, err = stringField(doc, "path")
+	}
+	recordQuery(ctx, "get_path", start, err)
+	return path, err
+}
+
+func (s *FirestoreSource) ListFSIDs(ctx context.Context) ([]Mapping, error) {
+	var mappings []Mapping
+	start := time.Now()
+	err := s.svc.List(s.documents, s.tableName+"-fsids").
+		PageSize(300).
+		Pages(ctx, func(r *firestore.ListDocumentsResponse) error {
+			for _, doc := range r.Documents {
+				id := doc.Name[strings.LastIndex(doc.Name, "/")+1:]
+				fsid, err := strconv.ParseInt(id, 10, 32)
+				if err != nil {
+					return fmt.Errorf("invalid document %s: %w", doc.Name, err)
+				}
+				path, err := stringField(doc, "path")
+				if err != nil {
+					return err
+				}
+				mappings = append(mappings, Mapping{FSID: int32(fsid), Path: path})
+			}
+			return nil
+		})
+	err = firestoreError(err)
+	if err == nil {
+		sort.Slice(mappings, func(i, j int) bool {
+			return mappings[i].FSID < mappings[j].FSID
+		})
+	}
+	recordQuery(ctx, "list_fsids", start, err)
+	return mappings, err
+}
+
+func (s *FirestoreSource) ReserveFSID(ctx context.Context, path string, fsid int32) error {
+	start := time.Now()
+	err := s.runTransaction(ctx, func(tx string) ([]*firestore.Write, error) {
+		err := s.checkAvailable(ctx, tx, path, fsid)
+		if err != nil {
+			return nil, err
+		}
+
+		next, err := s.nextFSID(ctx, tx)
+		if err != nil {
+			return nil, err
+		}
+
+		// Advance the sequence past the reserved FSID so that AllocateFSID
+		// does not try to allocate the same FSID.
+		if int64(fsid) >= next {
+			next = int64(fsid) + 1
+		}
+		return s.mappingWrites(path, fsid, next), nil
+	})
+	recordQuery(ctx, "reserve_fsid", start, err)
+	return err
+}
+
+func (s *FirestoreSource) DeletePath(ctx context.Context, path string) error {
+	start := time.Now()
+	err := s.runTransaction(ctx, func(tx string) ([]*firestore.Write, error) {
+		fsid, err := s.getFSID(ctx, path, tx)
+		if err != nil {
+			return nil, err
+		}
+		writes := []*firestore.Write{
+			{Delete: s.pathDoc(path)},
+			{Delete: s.fsidDoc(fsid)},
+		}
+		return writes, nil
+	})
+	recordQuery(ctx, "delete_path", start, err)
+	return err
+}
+
+// touchBatchSize is the maximum number of writes in a single BatchWrite.
+const touchBatchSize = 500
+
+func (s *FirestoreSource) TouchPaths(ctx context.Context, paths []string, seen time.Time) error {
+	start := time.Now()
+	var err error
+	for len(paths) > 0 && err == nil {
+		n := len(paths)
+		if n > touchBatchSize {
+			n = touchBatchSize
+		}
+		err = s.touchPaths(ctx, paths[:n], seen)
+		paths = paths[n:]
+	}
+	recordQuery(ctx, "touch_paths", start, err)
+	return err
+}
+
+func (s *FirestoreSource) touchPaths(ctx context.Context, paths []string, seen time.Time) error {
+	writes := make([]*firestore.Write, 0, len(paths))
+	for _, path := range paths {
+		// Use a maximum transform so that a delayed update from one proxy
+		// cannot move the last seen time backwards.
+		writes = append(writes, &firestore.Write{
+			Transform: &firestore.DocumentTransform{
+				Document: s.pathDoc(path),
+				FieldTransforms: []*firestore.FieldTransform{{
+					FieldPath: "lastSeen",
+					Maximum:   &firestore.Value{IntegerValue: seen.Unix()},
+				}},
+			},
+			CurrentDocument: &firestore.Precondition{Exists: true},
+		})
+	}
+
+	// BatchWrite applies each write independently, so a path that has been
+	// deleted does not prevent updating the other paths.
+	r, err := s.svc.BatchWrite(s.database, &firestore.BatchWriteRequest{
+		Writes: writes,
+	}).Context(ctx).Do()
+	if err != nil {
+		return firestoreError(err)
+	}
+	for i, status := range r.Status {
+		switch status.Code {
+		case 0, 5, 9: // OK, NOT_FOUND, FAILED_PRECONDITION
+		default:
+			return fmt.Errorf("could not update %s: %s", writes[i].Transform.Document, status.Message)
+		}
+	}
+	return nil
+}
+
+func (s *FirestoreSource) ListStale(ctx context.Context, before time.Time) ([]StaleMapping, error) {
+	var mappings []StaleMapping
+	start := time.Now()
+	err := s.svc.List(s.documents, s.tableName+"-paths").
+		PageSize(300).
+		Pages(ctx, func(r *firestore.ListDocumentsResponse) error {
+			for _, doc := range r.Documents {
+				seen, err := lastSeen(doc)
+				if err != nil {
+					return err
+				}
+				if !seen.Before(before) {
+					continue
+				}
+				path, err := stringField(doc, "path")
+				if err != nil {
+					return err
+				}
+				fsid, err := integerField(doc, "fsid")
+				if err != nil {
+					return err
+				}
+				mappings = append(mappings, StaleMapping{
+					Mapping:  Mapping{FSID: int32(fsid), Path: path},
+					LastSeen: seen,
+				})
+			}
+			return nil
+		})
+	err = firestoreError(err)
+	if err == nil {
+		sort.Slice(mappings, func(i, j int) bool {
+			return mappings[i].FSID < mappings[j].FSID
+		})
+	}
+	recordQuery(ctx, "list_stale", start, err)
+	return mappings, err
+}
+
+func (s *FirestoreSource) DeleteStale(ctx context.Context, path string, before time.Time) error {
+	start := time.Now()
+	err := s.runTransaction(ctx, func(tx string) ([]*firestore.Write, error) {
+		doc, err := s.get(ctx, s.pathDoc(path), tx)
+		if err != nil {
+			return nil, err
+		}
+		seen, err := lastSeen(doc)
+		if err != nil {
+			return nil, err
+		}
+		if !seen.Before(before) {
+			return nil, ErrNotFound
+		}
+		fsid, err := integerField(doc, "fsid")
+		if err != nil {
+			return nil, err
+		}
+		writes := []*firestore.Write{
+			{Delete: s.pathDoc(path)},
+			{Delete: s.fsidDoc(int32(fsid))},
+		}
+		return writes, nil
+	})
+	recordQuery(ctx, "delete_stale", start, err)
+	return err
+}
+
+func (s *FirestoreSource) allocateFSID(ctx context.Context, path string) (int32, error) {
+	var fsid int32
+	err := s.runTransaction(ctx, func(tx string) ([]*firestore.Write, error) {
+		// Match the behaviour of the PostgreSQL backend that returns a
+		// unique_violation if the path already exists.
+		_, err := s.getFSID(ctx, path, tx)
+		if err == nil {
+			return nil, ErrConflict
+		}
+		if !IsNotFound(err) {
+			return nil, err
+		}
+
+		next, err := s.nextFSID(ctx, tx)
+		if err != nil {
+			return nil, err
+		}
+		if next > math.MaxInt32 {
+			return nil, errFSIDExhausted
+		}
+
+		fsid = int32(next)
+		return s.mappingWrites(path, fsid, next+1), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return fsid, nil
+}
+
+// runTransaction runs fn inside a read-write transaction, then commits the
+// writes returned by fn. If fn returns an error the transaction is rolled
+// back.
+func (s *FirestoreSource) runTransaction(ctx context.Context, fn func(tx string) ([]*firestore.Write, error)) error {
+	tx, err := s.svc.BeginTransaction(s.database, &firestore.BeginTransactionRequest{}).Context(ctx).Do()
+	if err != nil {
+		return firestoreError(err)
+	}
+
+	writes, err := fn(tx.Transaction)
+	if err != nil {
+		// Use a new context for rollback in case ctx was cancelled.
+		rctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_, rerr := s.svc.Rollback(s.database, &firestore.RollbackRequest{
+			Transaction: tx.Transaction,
+		}).Context(rctx).Do()
+		if rerr != nil {
+			log.Debug.Printf("[%d] firestore rollback failed: %s", log.ID(ctx), rerr)
+		}
+		return err
+	}
+
+	_, err = s.svc.Commit(s.database, &firestore.CommitRequest{
+		Transaction: tx.Transaction,
+		Writes:      writes,
+	}).Context(ctx).Do()
+	return firestoreError(err)
+}
+
+// checkAvailable returns a conflict error if either the path or the FSID is
+// already in use.
+func (s *FirestoreSource) checkAvailable(ctx context.Context, tx, path string, fsid int32) error {
+	_, err := s.getFSID(ctx, path, tx)
+	if err == nil {
+		return ErrConflict
+	}
+	if !IsNotFound(err) {
+		return err
+	}
+
+	_, err = s.get(ctx, s.fsidDoc(fsid), tx)
+	if err == nil {
+		return ErrConflict
+	}
+	if !IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *FirestoreSource) nextFSID(ctx context.Context, tx string) (int64, error) {
+	seq, err := s.get(ctx, s.sequenceDoc(), tx)
+	if IsNotFound(err) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return integerField(seq, "next")
+}
+
+// mappingWrites creates the documents for a new mapping, and updates the
+// sequence to next.
+func (s *FirestoreSource) mappingWrites(path string, fsid int32, next int64) []*firestore.Write {
+	return []*firestore.Write{
+		{
+			Update: &firestore.Document{
+				Name:   s.sequenceDoc(),
+				Fields: map[string]firestore.Value{"next": {IntegerValue: next}},
+			},
+		},
+		{
+			Update: &firestore.Document{
+				Name: s.pathDoc(path),
+				Fields: map[string]firestore.Value{
+					"path":     {StringValue: path},
+					"fsid":     {IntegerValue: int64(fsid)},
+					"lastSeen": {IntegerValue: time.Now().Unix()},
+				},
+			},
+			CurrentDocument: mustNotExist(),
+		},
+		{
+			Update: &firestore.Document{
+				Name:   s.fsidDoc(fsid),
+				Fields: map[string]firestore.Value{"path": {StringValue: path}},
+			},
+			CurrentDocument: mustNotExist(),
+		},
+	}
+}
+
+func (s *FirestoreSource) getFSID(ctx context.Context, path, transaction string) (int32, error) {
+	doc, err := s.get(ctx, s.pathDoc(path), transaction)
+	if err != nil {
+		return 0, err
+	}
+	stored, err := stringField(doc, "path")
+	if err != nil {
+		return 0, err
+	}
+	if stored != path {
+		return 0, fmt.Errorf("document %s contains path %q, expected %q", doc.Name, stored, path)
+	}
+	fsid, err := integerField(doc, "fsid")
+	return int32(fsid), err
+}
+
+// Ping checks that Firestore can be reached by reading the sequence document.
+// The sequence document does not exist until the first FSID is allocated.
+func (s *FirestoreSource) Ping(ctx context.Context) error {
+	_, err := s.get(ctx, s.sequenceDoc(), "")
+	if IsNotFound(err) {
+		err = nil
+	}
+	return err
+}
+
+func (s *FirestoreSource) get(ctx context.Context, name, transaction string) (*firestore.Document, error) {
+	call := s.svc.Get(name).Context(ctx)
+	if transaction != "" {
+		call = call.Transaction(transaction)
+	}
+	doc, err := call.Do()
+	return doc, firestoreError(err)
+}
+
+func (s *FirestoreSource) pathDoc(path string) string {
+	// Document IDs cannot contain a forward slash and are limited to 1500
+	// bytes, so use a hash of the path instead.
+	sum := sha256.Sum256([]byte(path))
+	id := hex.EncodeToString(sum[:])
+	return fmt.Sprintf("%s/%s-paths/%s", s.documents, s.tableName, id)
+}
+
+func (s *FirestoreSource) fsidDoc(fsid int32) string {
+	return fmt.Sprintf("%s/%s-fsids/%d", s.documents, s.tableName, fsid)
+}
+
+func (s *FirestoreSource) sequenceDoc() string {
+	return fmt.Sprintf("%s/%s-meta/sequence", s.documents, s.tableName)
+}
+
+func mustNotExist() *firestore.Precondition {
+	return &firestore.Precondition{
+		Exists:          false,
+		ForceSendFields: []string{"Exists"},
+	}
+}
+
+func integerField(doc *firestore.Document, name string) (int64, error) {
+	v, ok := doc.Fields[name]
+	if !ok {
+		return 0, fmt.Errorf("document %s is missing field %q", doc.Name, name)
+	}
+	return v.IntegerValue, nil
+}
+
+func stringField(doc *firestore.Document, name string) (string, error) {
+	v, ok := doc.Fields[name]
+	if !ok {
+		return "", fmt.Errorf("document %s is missing field %q", doc.Name, name)
+	}
+	return v.StringValue, nil
+}
+
+// lastSeen returns the last time a path was used. If the document does not
+// have a lastSeen field, the time the document was created is used instead.
+func lastSeen(doc *firestore.Document) (time.Time, error) {
+	if v, ok := doc.Fields["lastSeen"]; ok {
+		return time.Unix(v.IntegerValue, 0), nil
+	}
+	return time.Parse(time.RFC3339Nano, doc.CreateTime)
+}
+
+// firestoreError converts Firestore errors to the standard ErrNotFound and
+// ErrConflict errors.
+func firestoreError(err error) error {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		return err
+	}
+	switch gerr.Code {
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: %s", ErrNotFound, gerr.Message)
+	case http.StatusConflict:
+		// ABORTED, the transaction conflicted with another transaction
+		return fmt.Errorf("%w: %s", ErrConflict, gerr.Message)
+	case http.StatusBadRequest:
+		// FAILED_PRECONDITION, the document already exists
+		if strings.Contains(gerr.Body, "FAILED_PRECONDITION") {
+			return fmt.Errorf("%w: %s", ErrConflict, gerr.Message)
+		}
+	}
+	return err
+}
+
+var retryableHTTPCodes = map[int]struct{}{
+	http.StatusTooManyRequests:     {},
+	http.StatusInternalServerError: {},
+	http.StatusBadGateway:          {},
+	http.StatusServiceUnavailable:  {},
+	http.StatusGatewayTimeout:      {},
+}
+
+func isRetryableHTTPError(err error) bool {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		_, retry := retryableHTTPCodes[gerr.Code]
+		return retry
+	}
+	return false
+}
@@ -0,0 +1,364 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	firestore "google.golang.org/api/firestore/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// fakeFirestore implements the parts of the Firestore v1 REST API used by
+// FirestoreSource. Transactions use optimistic concurrency, a commit is
+// aborted if any document read by the transaction has changed since it was
+// read.
+type fakeFirestore struct {
+	mu        sync.Mutex
+	docs      map[string]*firestore.Document
+	versions  map[string]int
+	txs       map[string]map[string]int // transaction => document => version
+	nextTx    int
+	rollbacks int
+
+	// beforeCommit is called while holding the lock, before the writes are
+	// applied.
+	beforeCommit func(f *fakeFirestore)
+}
+
+func newFakeFirestore(t *testing.T) (*fakeFirestore, *FirestoreSource) {
+	f := &fakeFirestore{
+		docs:     make(map[string]*firestore.Document),
+		versions: make(map[string]int),
+		txs:      make(map[string]map[string]int),
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	source, err := openFirestore(context.Background(), DatabaseConfig{
+		Project:   "test",
+		TableName: "fsids",
+		Endpoint:  srv.URL + "/",
+	})
+	require.NoError(t, err)
+	return f, source
+}
+
+func (f *fakeFirestore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name := strings.TrimPrefix(r.URL.Path, "/v1/")
+	database, method, _ := strings.Cut(name, "/documents:")
+	switch {
+	case r.Method == http.MethodPost && method == "beginTransaction":
+		f.nextTx++
+		tx := fmt.Sprintf("tx%d", f.nextTx)
+		f.txs[tx] = make(map[string]int)
+		writeFake(w, &firestore.BeginTransactionResponse{Transaction: tx})
+
+	case r.Method == http.MethodPost && method == "rollback":
+		var req firestore.RollbackRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		delete(f.txs, req.Transaction)
+		f.rollbacks++
+		writeFake(w, &firestore.Empty{})
+
+	case r.Method == http.MethodPost && method == "commit":
+		var req firestore.CommitRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		f.commit(w, database, &req)
+
+	case r.Method == http.MethodGet && strings.Count(name, "/") == 5:
+		// projects/{p}/databases/{d}/documents/{collection}
+		f.list(w, name)
+
+	case r.Method == http.MethodGet:
+		if tx := r.URL.Query().Get("transaction"); tx != "" {
+			f.txs[tx][name] = f.versions[name]
+		}
+		doc, ok := f.docs[name]
+		if !ok {
+			fakeError(w, http.StatusNotFound, "NOT_FOUND", "document not found")
+			return
+		}
+		writeFake(w, doc)
+
+	default:
+		fakeError(w, http.StatusNotImplemented, "UNIMPLEMENTED", r.Method+" "+r.URL.Path)
+	}
+}
+
+func (f *fakeFirestore) commit(w http.ResponseWriter, database string, req *firestore.CommitRequest) {
+	reads, ok := f.txs[req.Transaction]
+	if !ok {
+		fakeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "unknown transaction")
+		return
+	}
+	delete(f.txs, req.Transaction)
+
+	if f.beforeCommit != nil {
+		f.beforeCommit(f)
+	}
+
+	for name, version := range reads {
+		if f.versions[name] != version {
+			fakeError(w, http.StatusConflict, "ABORTED", "transaction conflict")
+			return
+		}
+	}
+
+	for _, wr := range req.Writes {
+		name := wr.Delete
+		if wr.Update != nil {
+			name = wr.Update.Name
+		}
+		if p := wr.CurrentDocument; p != nil {
+			if _, exists := f.docs[name]; exists != p.Exists {
+				fakeError(w, http.StatusBadRequest, "FAILED_PRECONDITION", "precondition failed for "+name)
+				return
+			}
+		}
+	}
+
+	for _, wr := range req.Writes {
+		if wr.Update != nil {
+			f.put(wr.Update.Name, wr.Update.Fields)
+		} else {
+			delete(f.docs, wr.Delete)
+			f.versions[wr.Delete]++
+		}
+	}
+	writeFake(w, &firestore.CommitResponse{})
+}
+
+func (f *fakeFirestore) put(name string, fields map[string]firestore.Value) {
+	f.docs[name] = &firestore.Document{Name: name, Fields: fields}
+	f.versions[name]++
+}
+
+func (f *fakeFirestore) list(w http.ResponseWriter, collection string) {
+	var docs []*firestore.Document
+	for name, doc := range f.docs {
+		if strings.HasPrefix(name, collection+"/") {
+			docs = append(docs, doc)
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Name < docs[j].Name })
+	writeFake(w, &firestore.ListDocumentsResponse{Documents: docs})
+}
+
+func writeFake(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func fakeError(w http.ResponseWriter, code int, status, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, `{"error":{"code":%d,"message":%q,"status":%q}}`, code, message, status)
+}
+
+func TestFirestoreSource(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Allocate", func(t *testing.T) {
+		f, source := newFakeFirestore(t)
+
+		fsid, err := source.AllocateFSID(ctx, "/foo")
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), fsid)
+
+		fsid, err = source.AllocateFSID(ctx, "/bar")
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), fsid)
+
+		fsid, err = source.GetFSID(ctx, "/foo")
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), fsid)
+
+		path, err := source.GetPath(ctx, 2)
+		require.NoError(t, err)
+		assert.Equal(t, "/bar", path)
+
+		// Allocating an existing path conflicts, and rolls back the
+		// transaction.
+		_, err = source.AllocateFSID(ctx, "/foo")
+		assert.True(t, IsConflict(err), "path already allocated")
+		assert.Equal(t, 1, f.rollbacks)
+
+		_, err = source.GetFSID(ctx, "/unknown")
+		assert.True(t, IsNotFound(err))
+		_, err = source.GetPath(ctx, 99)
+		assert.True(t, IsNotFound(err))
+	})
+
+	t.Run("Reserve", func(t *testing.T) {
+		f, source := newFakeFirestore(t)
+
+		err := source.ReserveFSID(ctx, "/foo", 5)
+		require.NoError(t, err)
+
+		err = source.ReserveFSID(ctx, "/foo", 6)
+		assert.True(t, IsConflict(err), "path already reserved")
+
+		err = source.ReserveFSID(ctx, "/bar", 5)
+		assert.True(t, IsConflict(err), "fsid already reserved")
+		assert.Equal(t, 2, f.rollbacks)
+
+		// The sequence should have been advanced past the reserved FSID.
+		fsid, err := source.AllocateFSID(ctx, "/bar")
+		require.NoError(t, err)
+		assert.Equal(t, int32(6), fsid)
+
+		// Reserving a lower FSID must not move the sequence backwards.
+		err = source.ReserveFSID(ctx, "/baz", 2)
+		require.NoError(t, err)
+		fsid, err = source.AllocateFSID(ctx, "/qux")
+		require.NoError(t, err)
+		assert.Equal(t, int32(7), fsid)
+
+		mappings, err := source.ListFSIDs(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []Mapping{{2, "/baz"}, {5, "/foo"}, {6, "/bar"}, {7, "/qux"}}, mappings)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		_, source := newFakeFirestore(t)
+
+		_, err := source.AllocateFSID(ctx, "/foo")
+		require.NoError(t, err)
+
+		err = source.DeletePath(ctx, "/foo")
+		require.NoError(t, err)
+
+		_, err = source.GetFSID(ctx, "/foo")
+		assert.True(t, IsNotFound(err))
+		_, err = source.GetPath(ctx, 1)
+		assert.True(t, IsNotFound(err))
+
+		err = source.DeletePath(ctx, "/foo")
+		assert.True(t, IsNotFound(err))
+
+		// FSIDs are never re-used.
+		fsid, err := source.AllocateFSID(ctx, "/foo")
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), fsid)
+	})
+
+	t.Run("Precondition", func(t *testing.T) {
+		f, source := newFakeFirestore(t)
+
+		// Another process creates the FSID document after the transaction
+		// has checked it does not exist, without the transaction seeing
+		// the change.
+		f.beforeCommit = func(f *fakeFirestore) {
+			name := source.fsidDoc(1)
+			f.docs[name] = &firestore.Document{
+				Name:   name,
+				Fields: map[string]firestore.Value{"path": {StringValue: "/other"}},
+			}
+		}
+		_, err := source.AllocateFSID(ctx, "/foo")
+		assert.True(t, IsConflict(err), "fsid document already exists")
+		assert.True(t, ShouldRetry(err))
+	})
+
+	t.Run("Aborted", func(t *testing.T) {
+		f, source := newFakeFirestore(t)
+
+		// Another transaction allocates an FSID between this transaction
+		// reading and committing the sequence.
+		f.beforeCommit = func(f *fakeFirestore) {
+			f.beforeCommit = nil
+			f.put(source.sequenceDoc(), map[string]firestore.Value{"next": {IntegerValue: 2}})
+		}
+		_, err := source.AllocateFSID(ctx, "/foo")
+		assert.True(t, IsConflict(err), "transaction aborted")
+		assert.True(t, ShouldRetry(err))
+
+		fsid, err := source.AllocateFSID(ctx, "/foo")
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), fsid)
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		_, source := newFakeFirestore(t)
+
+		var wg sync.WaitGroup
+		fsids := make([]int32, 8)
+		errs := make([]error, len(fsids))
+		for i := range fsids {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = withRetry(ctx, func(ctx context.Context) error {
+					var err error
+					fsids[i], err = source.AllocateFSID(ctx, fmt.Sprintf("/path%d", i))
+					return err
+				})
+			}(i)
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+		sort.Slice(fsids, func(i, j int) bool { return fsids[i] < fsids[j] })
+		assert.Equal(t, []int32{1, 2, 3, 4, 5, 6, 7, 8}, fsids)
+	})
+}
+
+func TestFirestoreError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		notFound bool
+		conflict bool
+		retry    bool
+	}{
+		{"not found", &googleapi.Error{Code: 404, Body: `{"error":{"status":"NOT_FOUND"}}`}, true, false, false},
+		{"aborted", &googleapi.Error{Code: 409, Body: `{"error":{"status":"ABORTED"}}`}, false, true, true},
+		{"precondition", &googleapi.Error{Code: 400, Body: `{"error":{"status":"FAILED_PRECONDITION"}}`}, false, true, true},
+		{"invalid argument", &googleapi.Error{Code: 400, Body: `{"error":{"status":"INVALID_ARGUMENT"}}`}, false, false, false},
+		{"permission denied", &googleapi.Error{Code: 403, Body: `{"error":{"status":"PERMISSION_DENIED"}}`}, false, false, false},
+		{"unavailable", &googleapi.Error{Code: 503, Body: `{"error":{"status":"UNAVAILABLE"}}`}, false, false, true},
+		{"too many requests", &googleapi.Error{Code: 429, Body: `{"error":{"status":"RESOURCE_EXHAUSTED"}}`}, false, false, true},
+		{"other", errors.New("other"), false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := firestoreError(tt.err)
+			assert.Equal(t, tt.notFound, IsNotFound(err), "IsNotFound")
+			assert.Equal(t, tt.conflict, IsConflict(err), "IsConflict")
+			assert.Equal(t, tt.retry, ShouldRetry(err), "ShouldRetry")
+		})
+	}
+
+	assert.NoError(t, firestoreError(nil))
+}
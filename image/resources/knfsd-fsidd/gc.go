@@ -0,0 +1,104 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/internal/metrics"
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/log"
+	"go.uber.org/multierr"
+)
+
+// collectGarbage deletes the mappings for paths that have not been used within
+// the retention period, and returns the deleted mappings. If dryRun is true
+// the stale mappings are returned without being deleted.
+//
+// FSIDs are never re-used, so if a deleted path is exported again it will be
+// allocated a new FSID.
+func collectGarbage(ctx context.Context, b Backend, retention time.Duration, dryRun bool) ([]StaleMapping, error) {
+	before := time.Now().Add(-retention)
+	stale, err := b.ListStale(ctx, before)
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		for range stale {
+			metrics.GC(ctx, "stale")
+		}
+		return stale, nil
+	}
+
+	var deleted []StaleMapping
+	var errs error
+	for _, m := range stale {
+		// DeleteStale checks the last seen time again in case the path was
+		// used after listing the stale mappings.
+		err := b.DeleteStale(ctx, m.Path, before)
+		if err == nil {
+			log.Info.Printf("deleted fsid %d for path \"%s\", last seen %s", m.FSID, m.Path, m.LastSeen.Format(time.RFC3339))
+			metrics.GC(ctx, "deleted")
+			deleted = append(deleted, m)
+		} else if IsNotFound(err) {
+			metrics.GC(ctx, "skipped")
+		} else {
+			metrics.GC(ctx, "error")
+			errs = multierr.Append(errs, err)
+		}
+	}
+	return deleted, errs
+}
+
+// runGC periodically deletes stale mappings until the context is cancelled.
+// Deleted mappings are also removed from the cache and journal. Other proxies
+// remove the deleted mappings from their journal when the journal is reconciled.
+func runGC(ctx context.Context, b Backend, cfg GCConfig, cache *FSIDCache) {
+	t := time.NewTicker(cfg.Interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+
+		stale, err := collectGarbage(ctx, b, cfg.Retention, cfg.DryRun)
+		if err != nil {
+			log.Error.Printf("could not delete stale FSIDs: %s", err)
+		}
+
+		if cfg.DryRun {
+			for _, m := range stale {
+				log.Info.Printf("stale fsid %d for path \"%s\", last seen %s", m.FSID, m.Path, m.LastSeen.Format(time.RFC3339))
+			}
+			continue
+		}
+
+		if cache != nil && len(stale) > 0 {
+			for _, m := range stale {
+				cache.remove(m.FSID, m.Path)
+			}
+			err = cache.Compact()
+			if err != nil {
+				log.Warn.Printf("could not rewrite journal: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,165 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	ctx := context.Background()
+	retention := 24 * time.Hour
+
+	setup := func(t *testing.T) *BoltSource {
+		source := openBoltTest(t)
+		for _, path := range []string{"/old", "/active", "/new"} {
+			_, err := source.AllocateFSID(ctx, path)
+			require.NoError(t, err)
+		}
+
+		// Mark the existing paths as last seen before the retention period,
+		// then touch /active to simulate a recent request.
+		now := time.Now()
+		err := setLastSeen(source, now.Add(-48*time.Hour), "/old", "/active")
+		require.NoError(t, err)
+		err = source.TouchPaths(ctx, []string{"/active"}, now)
+		require.NoError(t, err)
+		return source
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		source := setup(t)
+
+		stale, err := collectGarbage(ctx, source, retention, true)
+		require.NoError(t, err)
+		if assert.Len(t, stale, 1) {
+			assert.Equal(t, Mapping{1, "/old"}, stale[0].Mapping)
+		}
+
+		mappings, err := source.ListFSIDs(ctx)
+		require.NoError(t, err)
+		assert.Len(t, mappings, 3)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		source := setup(t)
+
+		deleted, err := collectGarbage(ctx, source, retention, false)
+		require.NoError(t, err)
+		if assert.Len(t, deleted, 1) {
+			assert.Equal(t, Mapping{1, "/old"}, deleted[0].Mapping)
+		}
+
+		mappings, err := source.ListFSIDs(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []Mapping{{2, "/active"}, {3, "/new"}}, mappings)
+
+		// The FSID of the deleted path must not be re-used.
+		fsid, err := source.AllocateFSID(ctx, "/old")
+		require.NoError(t, err)
+		assert.Equal(t, int32(4), fsid)
+	})
+}
+
+func TestCollectGarbageJournal(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	source := openBoltTest(t)
+
+	// Simulate two proxies sharing the same database, each with their own
+	// journal containing the same mappings.
+	openCache := func(name string) (*FSIDCache, *Journal) {
+		journal, entries, err := openJournal(filepath.Join(dir, name))
+		require.NoError(t, err)
+		cache := &FSIDCache{source: source, journal: journal}
+		cache.Load(entries)
+		return cache, journal
+	}
+
+	for _, name := range []string{"a.journal", "b.journal"} {
+		cache, journal := openCache(name)
+		for _, path := range []string{"/old", "/active"} {
+			_, err := cache.GetFSID(ctx, path)
+			if IsNotFound(err) {
+				_, err = cache.AllocateFSID(ctx, path)
+			}
+			require.NoError(t, err)
+		}
+		require.NoError(t, journal.Close())
+	}
+
+	err := setLastSeen(source, time.Now().Add(-48*time.Hour), "/old")
+	require.NoError(t, err)
+
+	deleted, err := collectGarbage(ctx, source, 24*time.Hour, false)
+	require.NoError(t, err)
+	if assert.Len(t, deleted, 1) {
+		assert.Equal(t, Mapping{1, "/old"}, deleted[0].Mapping)
+	}
+
+	// The second proxy restarts and reconciles its journal, which still
+	// contains the deleted mapping.
+	cache, journal := openCache("b.journal")
+	defer journal.Close()
+	assert.Equal(t, []Mapping{{1, "/old"}, {2, "/active"}}, cache.entries())
+
+	err = cache.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []Mapping{{2, "/active"}}, cache.entries())
+
+	entries, err := readJournal(filepath.Join(dir, "b.journal"))
+	require.NoError(t, err)
+	assert.Equal(t, []Mapping{{2, "/active"}}, entries)
+
+	// The deleted mapping must not have been restored to the database.
+	mappings, err := source.ListFSIDs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []Mapping{{2, "/active"}}, mappings)
+}
+
+func TestGCConfigValidate(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		cfg := GCConfig{TouchInterval: time.Hour}
+		assert.NoError(t, cfg.Validate())
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		cfg := GCConfig{TouchInterval: time.Hour, Retention: 90 * 24 * time.Hour, Interval: time.Hour}
+		assert.NoError(t, cfg.Validate())
+	})
+
+	t.Run("interval requires retention", func(t *testing.T) {
+		cfg := GCConfig{TouchInterval: time.Hour, Interval: time.Hour}
+		assert.ErrorContains(t, cfg.Validate(), "gc-retention")
+	})
+
+	t.Run("minimum retention", func(t *testing.T) {
+		cfg := GCConfig{TouchInterval: time.Hour, Retention: 90 * time.Minute}
+		assert.ErrorContains(t, cfg.Validate(), "must be at least")
+	})
+
+	t.Run("touch interval", func(t *testing.T) {
+		cfg := GCConfig{}
+		assert.ErrorContains(t, cfg.Validate(), "touch-interval")
+	})
+}
@@ -0,0 +1,354 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/log"
+	"github.com/coreos/go-systemd/v22/daemon"
+)
+
+// healthCheckTimeout limits how long each individual check can take.
+const healthCheckTimeout = 10 * time.Second
+
+type HealthState string
+
+const (
+	// HealthOK means every check passed.
+	HealthOK HealthState = "ok"
+
+	// HealthDegraded means the service is running but cannot reach the
+	// database. Requests for FSIDs in the cache will still succeed.
+	HealthDegraded HealthState = "degraded"
+
+	// HealthUnhealthy means the service is not working and should be
+	// restarted.
+	HealthUnhealthy HealthState = "unhealthy"
+)
+
+type CheckResult struct {
+	Name     string  `json:"name"`
+	OK       bool    `json:"ok"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration_ms"`
+}
+
+type CacheStats struct {
+	Entries int `json:"entries"`
+}
+
+type HealthStatus struct {
+	State     HealthState   `json:"state"`
+	CheckedAt time.Time     `json:"checked_at"`
+	Checks    []CheckResult `json:"checks"`
+	Cache     *CacheStats   `json:"cache,omitempty"`
+}
+
+// Live reports whether the service is working. A degraded service is still
+// live as it can serve FSIDs from the cache, and will reconnect to the
+// database by itself.
+func (s HealthStatus) Live() bool {
+	return s.State != HealthUnhealthy
+}
+
+// Ready reports whether the service can handle any request.
+func (s HealthStatus) Ready() bool {
+	return s.State == HealthOK
+}
+
+// String formats the status for the systemd STATUS= notification.
+func (s HealthStatus) String() string {
+	msg := &strings.Builder{}
+	msg.WriteString(string(s.State))
+	for _, c := range s.Checks {
+		if !c.OK {
+			// Each notification is a single line, so errors cannot contain
+			// newlines.
+			fmt.Fprintf(msg, "; %s: %s", c.Name, strings.ReplaceAll(c.Error, "\n", " "))
+		}
+	}
+	if s.Cache != nil {
+		fmt.Fprintf(msg, "; %d cached FSIDs", s.Cache.Entries)
+	}
+	return msg.String()
+}
+
+// HealthChecker periodically checks the database connection and that the
+// service is responding to requests on its socket.
+//
+// The result of each check is reported to systemd using STATUS=, and while the
+// service is live WATCHDOG=1 is sent so that systemd can restart the service if
+// it stops responding, or if the database connection has been broken for
+// longer than max-database-downtime.
+type HealthChecker struct {
+	cfg        HealthConfig
+	db         pinger
+	socketPath string
+	cache      *FSIDCache
+
+	// now and notify can be replaced for testing.
+	now    func() time.Time
+	notify func(state string) (bool, error)
+
+	mu             sync.RWMutex
+	status         HealthStatus
+	dbFailingSince time.Time
+}
+
+func newHealthChecker(cfg HealthConfig, source Backend, socketPath string, cache *FSIDCache) *HealthChecker {
+	h := &HealthChecker{
+		cfg:        cfg,
+		socketPath: socketPath,
+		cache:      cache,
+		now:        time.Now,
+		notify: func(state string) (bool, error) {
+			return daemon.SdNotify(false, state)
+		},
+	}
+	if p, ok := source.(pinger); ok {
+		h.db = p
+	}
+	return h
+}
+
+// Status returns the result of the last check.
+func (h *HealthChecker) Status() HealthStatus {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.status
+}
+
+// Run checks the health of the service every interval until ctx is
+// cancelled.
+func (h *HealthChecker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.report(h.Check(ctx))
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check runs all the checks and records the result.
+func (h *HealthChecker) Check(ctx context.Context) HealthStatus {
+	status := HealthStatus{
+		State:     HealthOK,
+		CheckedAt: h.now(),
+	}
+
+	if h.db != nil {
+		result, err := runCheck(ctx, "database", h.db.Ping)
+		status.Checks = append(status.Checks, result)
+		status.State = worst(status.State, h.databaseState(err))
+	}
+
+	result, err := runCheck(ctx, "socket", h.pingSocket)
+	status.Checks = append(status.Checks, result)
+	if err != nil {
+		status.State = HealthUnhealthy
+	}
+
+	if h.cache != nil {
+		status.Cache = &CacheStats{Entries: h.cache.Len()}
+	}
+
+	h.mu.Lock()
+	h.status = status
+	h.mu.Unlock()
+	return status
+}
+
+// databaseState treats database errors as degraded until the database has been
+// failing for longer than max-database-downtime. A restart creates a new
+// connection pool, which recovers from a broken pool. Errors while the service
+// is in degraded mode never become unhealthy, as the service is already trying
+// to connect.
+func (h *HealthChecker) databaseState(err error) HealthState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err == nil {
+		h.dbFailingSince = time.Time{}
+		return HealthOK
+	}
+	if errors.Is(err, ErrDegraded) {
+		return HealthDegraded
+	}
+
+	now := h.now()
+	if h.dbFailingSince.IsZero() {
+		h.dbFailingSince = now
+	}
+	max := h.cfg.MaxDatabaseDowntime
+	if max > 0 && now.Sub(h.dbFailingSince) >= max {
+		return HealthUnhealthy
+	}
+	return HealthDegraded
+}
+
+// pingSocket sends a version request to the service's own socket to check
+// that the service is accepting and responding to requests.
+func (h *HealthChecker) pingSocket(ctx context.Context) error {
+	c, err := dial(h.socketPath)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	}
+
+	_, err = c.Write([]byte("version"))
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, PacketMaxLength)
+	n, err := c.Read(buf)
+	if err != nil {
+		return err
+	}
+
+	response := string(buf[:n])
+	if !strings.HasPrefix(response, "+ ") {
+		return fmt.Errorf("invalid response %q", response)
+	}
+	return nil
+}
+
+func (h *HealthChecker) report(status HealthStatus) {
+	if status.State != HealthOK {
+		log.Warn.Printf("health check: %s", status)
+	}
+
+	state := "STATUS=" + status.String()
+	if status.Live() {
+		state += "\n" + daemon.SdNotifyWatchdog
+	}
+	_, err := h.notify(state)
+	if err != nil {
+		log.Warn.Printf("could not notify systemd: %s", err)
+	}
+}
+
+// ServeHTTP implements the /healthz (live) and /readyz (ready) endpoints.
+// Both endpoints return the last status as JSON, with a 503 status code if
+// the service is not live or ready.
+func (h *HealthChecker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := h.Status()
+
+	var ok bool
+	switch r.URL.Path {
+	case "/healthz":
+		ok = status.Live()
+	case "/readyz":
+		ok = status.Ready()
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// Serve serves the health endpoints on addr until ctx is cancelled. The
+// address is either a TCP address such as "127.0.0.1:8081", or a unix socket
+// such as "unix:///run/knfsd-fsidd-health.sock".
+func (h *HealthChecker) Serve(ctx context.Context, addr string) error {
+	l, err := listenHealth(addr)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Handler:     h,
+		ReadTimeout: healthCheckTimeout,
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	err = srv.Serve(l)
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}
+
+func listenHealth(addr string) (net.Listener, error) {
+	path, found := strings.CutPrefix(addr, "unix://")
+	if found && path == "" {
+		return nil, fmt.Errorf("invalid health address \"%s\"", addr)
+	}
+	if !found {
+		return net.Listen("tcp", addr)
+	}
+
+	// Delete the old socket file if it already exists in case a previous
+	// process was not gracefully terminated.
+	err := os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return net.Listen("unix", path)
+}
+
+func runCheck(ctx context.Context, name string, check func(context.Context) error) (CheckResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	result := CheckResult{
+		Name:     name,
+		OK:       err == nil,
+		Duration: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result, err
+}
+
+func worst(a, b HealthState) HealthState {
+	rank := map[HealthState]int{HealthOK: 0, HealthDegraded: 1, HealthUnhealthy: 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
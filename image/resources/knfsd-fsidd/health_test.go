@@ -0,0 +1,159 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePinger struct {
+	err error
+}
+
+func (p *fakePinger) Ping(context.Context) error {
+	return p.err
+}
+
+func startHealthServer(t *testing.T) string {
+	s, err := newServer("")
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	s.Handle("version", func(ctx context.Context, arg string) (string, error) {
+		return protocolVersion, nil
+	})
+	go s.Serve()
+	return s.listener.Addr().String()
+}
+
+func TestHealthChecker(t *testing.T) {
+	ctx := context.Background()
+	socketPath := startHealthServer(t)
+
+	cache := &FSIDCache{source: &FakeSource{}}
+	cache.Load([]Mapping{{1, "/foo"}, {2, "/bar"}})
+
+	db := &fakePinger{}
+	now := time.Now()
+	var notified []string
+	h := &HealthChecker{
+		cfg:        HealthConfig{MaxDatabaseDowntime: 10 * time.Minute},
+		db:         db,
+		socketPath: socketPath,
+		cache:      cache,
+		now:        func() time.Time { return now },
+		notify: func(state string) (bool, error) {
+			notified = append(notified, state)
+			return true, nil
+		},
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		status := h.Check(ctx)
+		assert.Equal(t, HealthOK, status.State)
+		assert.Equal(t, &CacheStats{Entries: 2}, status.Cache)
+		assert.True(t, status.Live())
+		assert.True(t, status.Ready())
+
+		h.report(status)
+		assert.Equal(t, "STATUS=ok; 2 cached FSIDs\nWATCHDOG=1", notified[len(notified)-1])
+	})
+
+	t.Run("DatabaseFailing", func(t *testing.T) {
+		db.err = errors.New("connection refused")
+		status := h.Check(ctx)
+		assert.Equal(t, HealthDegraded, status.State)
+		assert.True(t, status.Live())
+		assert.False(t, status.Ready())
+
+		// Once the database has been failing for too long the service is
+		// unhealthy, and the watchdog notification should not be sent.
+		now = now.Add(10 * time.Minute)
+		status = h.Check(ctx)
+		assert.Equal(t, HealthUnhealthy, status.State)
+
+		h.report(status)
+		assert.Equal(t, "STATUS=unhealthy; database: connection refused; 2 cached FSIDs", notified[len(notified)-1])
+	})
+
+	t.Run("DatabaseRecovered", func(t *testing.T) {
+		db.err = nil
+		assert.Equal(t, HealthOK, h.Check(ctx).State)
+
+		// The downtime should be reset after the database recovered.
+		db.err = errors.New("connection refused")
+		now = now.Add(10 * time.Minute)
+		assert.Equal(t, HealthDegraded, h.Check(ctx).State)
+		db.err = nil
+	})
+
+	t.Run("DegradedMode", func(t *testing.T) {
+		db.err = ErrDegraded
+		h.Check(ctx)
+		now = now.Add(time.Hour)
+		assert.Equal(t, HealthDegraded, h.Check(ctx).State)
+		db.err = nil
+	})
+
+	t.Run("SocketFailing", func(t *testing.T) {
+		h := &HealthChecker{socketPath: "/nonexistent/fsidd.sock", now: time.Now}
+		status := h.Check(ctx)
+		assert.Equal(t, HealthUnhealthy, status.State)
+		assert.False(t, status.Live())
+		require.Len(t, status.Checks, 1)
+		assert.Equal(t, "socket", status.Checks[0].Name)
+		assert.False(t, status.Checks[0].OK)
+	})
+}
+
+func TestHealthEndpoint(t *testing.T) {
+	h := &HealthChecker{now: time.Now}
+
+	get := func(path string) (int, HealthStatus) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var status HealthStatus
+		if w.Code != http.StatusNotFound {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+		}
+		return w.Code, status
+	}
+
+	h.status = HealthStatus{State: HealthDegraded}
+	code, status := get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, HealthDegraded, status.State)
+
+	code, _ = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	h.status = HealthStatus{State: HealthUnhealthy}
+	code, _ = get("/healthz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	code, _ = get("/unknown")
+	assert.Equal(t, http.StatusNotFound, code)
+}
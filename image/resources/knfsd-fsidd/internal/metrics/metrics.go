@@ -44,6 +44,12 @@ var (
 
 	sqlQueryCount    = counter("fsid.sql.query.count", dimensionless)
 	sqlQueryDuration = duration("fsid.sql.query.duration", milliseconds)
+
+	journalReconcileCount = counter("fsid.journal.reconcile.count", dimensionless)
+
+	gcCount = counter("fsid.gc.count", dimensionless)
+
+	accessDeniedCount = counter("fsid.access.denied.count", dimensionless)
 )
 
 func Request(ctx context.Context, command, result string, retries int64, duration time.Duration) {
@@ -75,6 +81,22 @@ func SQLOperation(ctx context.Context, query, result string, duration time.Durat
 	sqlQueryDuration.Record(ctx, ms(duration), attrs...)
 }
 
+// JournalReconcile records the result of checking a journal entry against the
+// database. Any result other than "ok" is a conflict.
+func JournalReconcile(ctx context.Context, result string) {
+	journalReconcileCount.Add(ctx, 1, attribute.String("result", result))
+}
+
+func GC(ctx context.Context, result string) {
+	gcCount.Add(ctx, 1, attribute.String("result", result))
+}
+
+// AccessDenied records a command that was rejected because the peer was not
+// allowed to execute the command.
+func AccessDenied(ctx context.Context, command string) {
+	accessDeniedCount.Add(ctx, 1, attribute.String("command", command))
+}
+
 func counter(name string, opts ...instrument.Int64Option) instrument.Int64Counter {
 	m, err := meter.Int64Counter(name, opts...)
 	if err != nil {
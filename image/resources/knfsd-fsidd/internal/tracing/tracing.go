@@ -0,0 +1,163 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Config struct {
+	Enabled  bool   `ini:"enabled"`
+	Endpoint string `ini:"endpoint"`
+	Insecure bool   `ini:"insecure"`
+
+	// SampleRatio is the fraction of requests that are traced, between 0 and
+	// 1. A ratio of 0 never samples any requests, and a ratio of 1 samples
+	// every request.
+	SampleRatio float64 `ini:"sample-ratio"`
+}
+
+type Provider interface {
+	Shutdown(context.Context) error
+}
+
+type empty struct{}
+
+func (empty) Shutdown(context.Context) error {
+	return nil
+}
+
+// The tracer is resolved from the global provider when each span is started,
+// so spans started before Start is called are not recorded.
+func tracer() trace.Tracer {
+	return otel.Tracer("fsid")
+}
+
+func Start(ctx context.Context, cfg Config) Provider {
+	var err error = nil
+	if !cfg.Enabled {
+		return empty{}
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithHost(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(
+			semconv.ServiceName("knfsd-fsidd"),
+		),
+	)
+	if err != nil {
+		log.Warn.Printf("could not load all otel resources: %v", err)
+	}
+
+	exporter, err := newExporter(ctx, cfg)
+	if err != nil {
+		log.Warn.Printf("could not initialize trace exporter: %v", err)
+		return empty{}
+	}
+
+	var sampler sdktrace.Sampler
+	switch {
+	case cfg.SampleRatio <= 0:
+		sampler = sdktrace.NeverSample()
+	case cfg.SampleRatio >= 1:
+		sampler = sdktrace.AlwaysSample()
+	default:
+		sampler = sdktrace.TraceIDRatioBased(cfg.SampleRatio)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithResource(res),
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+	)
+
+	otel.SetTracerProvider(provider)
+	return provider
+}
+
+func newExporter(ctx context.Context, cfg Config) (sdktrace.SpanExporter, error) {
+	var opts []otlptracegrpc.Option
+	if cfg.Endpoint != "" {
+		opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	return otlptracegrpc.New(ctx, opts...)
+}
+
+// StartRequest starts the span for a command received on the socket.
+func StartRequest(ctx context.Context, command string, connection uint64) (context.Context, trace.Span) {
+	return tracer().Start(ctx, command,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("fsid.command", command),
+			attribute.Int64("fsid.connection_id", int64(connection)),
+		),
+	)
+}
+
+// StartAttempt starts the span for a single attempt of a request that might
+// be retried.
+func StartAttempt(ctx context.Context, attempt int) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "attempt",
+		trace.WithAttributes(attribute.Int("fsid.attempt", attempt)),
+	)
+}
+
+// Query records a span for a database operation that has already completed.
+// The span is recorded after the operation so that every backend can report
+// its operations in the same way as the SQL metrics.
+func Query(ctx context.Context, operation, result string, start time.Time, err error) {
+	_, span := tracer().Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(start),
+		trace.WithAttributes(
+			attribute.String("db.operation", operation),
+			attribute.String("db.result", result),
+		),
+	)
+	End(span, err)
+}
+
+// SetAttributes adds attributes, such as the path or FSID, to the current
+// span.
+func SetAttributes(ctx context.Context, attrs ...attribute.KeyValue) {
+	trace.SpanFromContext(ctx).SetAttributes(attrs...)
+}
+
+// End records the error (if any) and ends the span.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
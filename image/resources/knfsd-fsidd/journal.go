@@ -0,0 +1,204 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/log"
+)
+
+const defaultJournalPath = "/var/lib/knfsd-fsidd/fsids.journal"
+
+// Journal is an append-only file of known path/FSID mappings. The journal is
+// used to populate the FSIDCache on start up so that known FSIDs can be served
+// even if the database is unavailable.
+//
+// Each line of the journal contains a single mapping in the format:
+//
+//	<fsid> <quoted path>
+//
+// If the same path appears multiple times, the last entry wins.
+type Journal struct {
+	mu   sync.Mutex
+	name string
+	f    *os.File
+}
+
+// openJournal reads all the entries from an existing journal and opens the
+// journal for appending new entries. If the journal does not exist a new empty
+// journal is created.
+func openJournal(name string) (*Journal, []Mapping, error) {
+	entries, err := readJournal(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, err
+	}
+
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &Journal{name: name, f: f}, entries, nil
+}
+
+func readJournal(name string) ([]Mapping, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseJournal(name, f)
+}
+
+func parseJournal(name string, r io.Reader) ([]Mapping, error) {
+	var entries []Mapping
+	index := make(map[string]int)
+
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, PacketMaxLength*2)
+	line := 0
+	for s.Scan() {
+		line++
+		e, err := parseJournalEntry(s.Text())
+		if err != nil {
+			// Most likely the last line was only partially written when the
+			// service was stopped. Skip the line rather than refusing to start,
+			// the mapping will be fetched from the database again if required.
+			log.Warn.Printf("%s:%d: ignoring invalid journal entry: %s", name, line, err)
+			continue
+		}
+
+		if i, found := index[e.Path]; found {
+			entries[i] = e
+		} else {
+			index[e.Path] = len(entries)
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, s.Err()
+}
+
+func parseJournalEntry(line string) (Mapping, error) {
+	s, quoted, found := cut(line, " ")
+	if !found {
+		return Mapping{}, errors.New("missing path")
+	}
+
+	fsid, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return Mapping{}, err
+	}
+	if fsid < 1 {
+		return Mapping{}, fmt.Errorf("invalid fsid %d", fsid)
+	}
+
+	path, err := strconv.Unquote(quoted)
+	if err != nil {
+		return Mapping{}, err
+	}
+	if path == "" {
+		return Mapping{}, errors.New("missing path")
+	}
+
+	return Mapping{FSID: int32(fsid), Path: path}, nil
+}
+
+func formatJournalEntry(e Mapping) string {
+	return strconv.FormatInt(int64(e.FSID), 10) + " " + strconv.Quote(e.Path) + "\n"
+}
+
+// Append writes a single entry to the journal. Append does not return until
+// the entry has been synced to disk.
+func (j *Journal) Append(fsid int32, path string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	_, err := io.WriteString(j.f, formatJournalEntry(Mapping{fsid, path}))
+	if err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+// Rewrite atomically replaces the contents of the journal with the entries
+// returned by snapshot. This is used to compact the journal, and to remove
+// entries that no longer match the database.
+//
+// snapshot is called while holding the journal's lock, so any entry appended
+// concurrently is either included in the snapshot, or appended to the new
+// journal after the rewrite has completed.
+func (j *Journal) Rewrite(snapshot func() []Mapping) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entries := snapshot()
+
+	tmp, err := os.CreateTemp(filepath.Dir(j.name), filepath.Base(j.name)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tmp != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(tmp)
+	for _, e := range entries {
+		_, err = w.WriteString(formatJournalEntry(e))
+		if err != nil {
+			return err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), j.name); err != nil {
+		return err
+	}
+	tmp = nil
+
+	f, err := os.OpenFile(j.name, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	j.f.Close()
+	j.f = f
+	return nil
+}
+
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.f.Close()
+}
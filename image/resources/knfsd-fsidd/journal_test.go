@@ -0,0 +1,105 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseJournal(t *testing.T) {
+	input := strings.Join([]string{
+		`1 "/foo"`,
+		`2 "/bar baz"`,
+		`3 "/foo"`, // last entry wins
+		`x "/invalid"`,
+		`0 "/invalid"`,
+		`4`,
+		`5 "/truncated`,
+	}, "\n")
+
+	entries, err := parseJournal("test", strings.NewReader(input))
+	require.NoError(t, err)
+	assert.Equal(t, []Mapping{
+		{3, "/foo"},
+		{2, "/bar baz"},
+	}, entries)
+}
+
+func TestJournal(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "fsids.journal")
+
+	j, entries, err := openJournal(name)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	require.NoError(t, j.Append(1, "/foo"))
+	require.NoError(t, j.Append(2, "/bar\nbaz"))
+	require.NoError(t, j.Close())
+
+	j, entries, err = openJournal(name)
+	require.NoError(t, err)
+	assert.Equal(t, []Mapping{{1, "/foo"}, {2, "/bar\nbaz"}}, entries)
+
+	// Rewrite should replace the contents, and further appends should be
+	// written to the new file.
+	require.NoError(t, j.Rewrite(func() []Mapping { return []Mapping{{2, "/bar\nbaz"}} }))
+	require.NoError(t, j.Append(3, "/qux"))
+	require.NoError(t, j.Close())
+
+	b, err := os.ReadFile(name)
+	require.NoError(t, err)
+	assert.Equal(t, "2 \"/bar\\nbaz\"\n3 \"/qux\"\n", string(b))
+
+	// Rewrite should not leave behind any temporary files.
+	files, err := os.ReadDir(filepath.Dir(name))
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestJournalRewriteConcurrentAppend(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "fsids.journal")
+	j, _, err := openJournal(name)
+	require.NoError(t, err)
+	defer j.Close()
+
+	require.NoError(t, j.Append(1, "/foo"))
+
+	// Append a new entry after the snapshot has been taken, but before the
+	// journal has been replaced. The entry must not be lost.
+	var wg sync.WaitGroup
+	err = j.Rewrite(func() []Mapping {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, j.Append(2, "/bar"))
+		}()
+		return []Mapping{{1, "/foo"}}
+	})
+	require.NoError(t, err)
+	wg.Wait()
+
+	entries, err := readJournal(name)
+	require.NoError(t, err)
+	assert.Equal(t, []Mapping{{1, "/foo"}, {2, "/bar"}}, entries)
+}
@@ -0,0 +1,150 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/log"
+)
+
+// lastSeenBatchSize is the maximum number of paths updated by a single call to
+// TouchPaths.
+const lastSeenBatchSize = 500
+
+// LastSeenRecorder records which paths have been used, and periodically writes
+// the last seen time of those paths to the database.
+//
+// Updating the database on every request would add a write to the critical
+// path of every request, and most requests are served from the FSIDCache.
+// Instead the paths are collected in memory, and written in batches in the
+// background.
+type LastSeenRecorder struct {
+	backend  Backend
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending map[string]struct{}
+}
+
+func newLastSeenRecorder(backend Backend, interval time.Duration) *LastSeenRecorder {
+	return &LastSeenRecorder{
+		backend:  backend,
+		interval: interval,
+		pending:  make(map[string]struct{}),
+	}
+}
+
+// Touch records that a path has been used. Touch does not block on the
+// database.
+func (r *LastSeenRecorder) Touch(path string) {
+	r.mu.Lock()
+	r.pending[path] = struct{}{}
+	r.mu.Unlock()
+}
+
+// Run writes the pending paths to the database every interval until the
+// context is cancelled. Any remaining paths are written before Run returns.
+func (r *LastSeenRecorder) Run(ctx context.Context) {
+	t := time.NewTicker(r.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			r.flush(ctx)
+		case <-ctx.Done():
+			// Use a new context as ctx has been cancelled.
+			fctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			r.flush(fctx)
+			cancel()
+			return
+		}
+	}
+}
+
+func (r *LastSeenRecorder) flush(ctx context.Context) {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = make(map[string]struct{})
+	r.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	paths := make([]string, 0, len(pending))
+	for path := range pending {
+		paths = append(paths, path)
+	}
+
+	seen := time.Now()
+	for len(paths) > 0 {
+		n := len(paths)
+		if n > lastSeenBatchSize {
+			n = lastSeenBatchSize
+		}
+
+		err := r.backend.TouchPaths(ctx, paths[:n], seen)
+		if err != nil {
+			// Keep the paths so that they are retried on the next flush.
+			log.Warn.Printf("could not update last seen time of %d paths: %s", len(paths), err)
+			r.mu.Lock()
+			for _, path := range paths {
+				r.pending[path] = struct{}{}
+			}
+			r.mu.Unlock()
+			return
+		}
+		paths = paths[n:]
+	}
+	log.Debug.Printf("updated last seen time of %d paths", len(pending))
+}
+
+// lastSeenProvider records the paths returned by successful lookups.
+// AllocateFSID does not need to record the path as new mappings are created
+// with the current time.
+type lastSeenProvider struct {
+	FSIDProvider
+	seen *LastSeenRecorder
+}
+
+func (p lastSeenProvider) GetFSID(ctx context.Context, path string) (int32, error) {
+	fsid, err := p.FSIDProvider.GetFSID(ctx, path)
+	if err == nil {
+		p.seen.Touch(path)
+	}
+	return fsid, err
+}
+
+func (p lastSeenProvider) GetFSIDs(ctx context.Context, paths []string) (map[string]int32, error) {
+	fsids, err := p.FSIDProvider.GetFSIDs(ctx, paths)
+	for path := range fsids {
+		p.seen.Touch(path)
+	}
+	return fsids, err
+}
+
+func (p lastSeenProvider) GetPath(ctx context.Context, fsid int32) (string, error) {
+	path, err := p.FSIDProvider.GetPath(ctx, fsid)
+	if err == nil {
+		p.seen.Touch(path)
+	}
+	return path, err
+}
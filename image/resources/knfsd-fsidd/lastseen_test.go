@@ -0,0 +1,97 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+// setLastSeen overwrites the last seen time of the paths, allowing tests to
+// move the last seen time backwards.
+func setLastSeen(s *BoltSource, seen time.Time, paths ...string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := s.seenBucket(tx)
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			err = b.Put([]byte(path), encodeTime(seen))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func TestLastSeenRecorder(t *testing.T) {
+	ctx := context.Background()
+	source := openBoltTest(t)
+	for _, path := range []string{"/foo", "/bar"} {
+		_, err := source.AllocateFSID(ctx, path)
+		require.NoError(t, err)
+	}
+
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, setLastSeen(source, past, "/foo", "/bar"))
+
+	r := newLastSeenRecorder(source, time.Hour)
+	p := lastSeenProvider{source, r}
+
+	_, err := p.GetFSID(ctx, "/foo")
+	require.NoError(t, err)
+	_, err = p.GetFSID(ctx, "/missing")
+	require.True(t, IsNotFound(err))
+
+	// Nothing should be written until the recorder is flushed.
+	stale, err := source.ListStale(ctx, past.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Len(t, stale, 2)
+
+	r.flush(ctx)
+	assert.Empty(t, r.pending)
+
+	stale, err = source.ListStale(ctx, past.Add(time.Minute))
+	require.NoError(t, err)
+	if assert.Len(t, stale, 1) {
+		assert.Equal(t, Mapping{2, "/bar"}, stale[0].Mapping)
+	}
+
+	_, err = p.GetPath(ctx, 2)
+	require.NoError(t, err)
+	r.flush(ctx)
+
+	stale, err = source.ListStale(ctx, past.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, stale)
+}
+
+func TestLastSeenRecorderRetry(t *testing.T) {
+	ctx := context.Background()
+	r := newLastSeenRecorder(newDegradedSource(), time.Hour)
+	r.Touch("/foo")
+
+	// Paths should be kept if the backend is unavailable.
+	r.flush(ctx)
+	assert.Contains(t, r.pending, "/foo")
+}
@@ -22,42 +22,103 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/internal/metrics"
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/internal/tracing"
 	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/log"
 	"github.com/coreos/go-systemd/v22/daemon"
-	"github.com/jackc/pgx/v4"
 	"github.com/spf13/pflag"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type FSIDProvider interface {
 	GetFSID(ctx context.Context, path string) (int32, error)
 	AllocateFSID(ctx context.Context, path string) (int32, error)
 	GetPath(ctx context.Context, fsid int32) (string, error)
+
+	// GetFSIDs returns the FSIDs of multiple paths, using a single query where
+	// supported by the backend. Paths without an FSID are omitted from the
+	// result.
+	GetFSIDs(ctx context.Context, paths []string) (map[string]int32, error)
 }
 
 func main() {
 	var err error
 
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		os.Exit(adminMain(os.Args[0], os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "prefetch" {
+		os.Exit(prefetchMain(os.Args[0], os.Args[2:]))
+	}
+
 	cfg := new(Config)
-	f := pflag.NewFlagSet(os.Args[0], pflag.ContinueOnError)
+	f := newFlagSet(os.Args[0], cfg)
+	migrate := f.Bool("migrate", false, "")
+	loadConfig(cfg, f, os.Args[1:])
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if *migrate {
+		err = runMigrate(ctx, cfg)
+	} else {
+		err = run(ctx, cfg)
+	}
+	if err != nil {
+		log.Error.Print(err)
+		os.Exit(1)
+	}
+}
+
+func newFlagSet(name string, cfg *Config) *pflag.FlagSet {
+	f := pflag.NewFlagSet(name, pflag.ContinueOnError)
 
 	// setup flags before reading the config files , otherwise the pflag package
 	// will overwrite the config with the default values
 	f.StringVar(&cfg.SocketPath, "socket", defaultSocketPath, "")
+	f.StringVar(&cfg.Database.Driver, "driver", "", "")
 	f.StringVar(&cfg.Database.URL, "database-url", "", "")
 	f.StringVar(&cfg.Database.Instance, "database-instance", "", "")
+	f.StringVar(&cfg.Database.Path, "database-path", "", "")
+	f.StringVar(&cfg.Database.Project, "database-project", "", "")
+	f.StringVar(&cfg.Database.DatabaseID, "database-id", "", "")
+	f.StringVar(&cfg.Database.Endpoint, "database-endpoint", "", "")
 	f.StringVar(&cfg.Database.TableName, "table-name", "", "")
+	f.StringVar(&cfg.Database.Namespace, "namespace", "", "")
+	f.BoolVar(&cfg.Database.SharedFSIDs, "shared-fsids", false, "")
 	f.BoolVar(&cfg.Database.IAMAuth, "iam-auth", false, "")
 	f.BoolVar(&cfg.Database.PrivateIP, "private-ip", false, "")
 	f.BoolVar(&cfg.Debug, "debug", false, "")
 	f.BoolVar(&cfg.Cache, "cache", true, "")
+	f.StringVar(&cfg.Journal, "journal", defaultJournalPath, "")
+	f.DurationVar(&cfg.GC.TouchInterval, "touch-interval", defaultTouchInterval, "")
+	f.DurationVar(&cfg.GC.Retention, "gc-retention", 0, "")
+	f.DurationVar(&cfg.GC.Interval, "gc-interval", 0, "")
+	f.BoolVar(&cfg.GC.DryRun, "gc-dry-run", false, "")
+	f.StringSliceVar(&cfg.Access.WriteUIDs, "write-uids", []string{"0"}, "")
+	f.StringSliceVar(&cfg.Access.WriteGIDs, "write-gids", nil, "")
+	f.StringSliceVar(&cfg.Access.ReadUIDs, "read-uids", []string{anyID}, "")
+	f.StringSliceVar(&cfg.Access.ReadGIDs, "read-gids", nil, "")
+	f.DurationVar(&cfg.Health.Interval, "health-interval", defaultHealthInterval, "")
+	f.StringVar(&cfg.Health.Listen, "health-listen", "", "")
+	f.DurationVar(&cfg.Health.MaxDatabaseDowntime, "max-database-downtime", defaultMaxDatabaseDowntime, "")
+	f.Float64Var(&cfg.Tracing.SampleRatio, "trace-sample-ratio", defaultTraceSampleRatio, "")
+
+	return f
+}
 
+// loadConfig reads the config from the config file, environment and command
+// line arguments (in that order). If the config is invalid, loadConfig prints
+// the errors and exits.
+func loadConfig(cfg *Config, f *pflag.FlagSet, args []string) {
 	// read the config file before parsing the command line arguments so
 	// that the command line arguments override any config values
-	err = readDefaultConfig(cfg)
+	err := readDefaultConfig(cfg)
 	if err != nil {
 		log.Error.Printf("could not read config: %s", err)
 		os.Exit(2)
@@ -71,7 +132,7 @@ func main() {
 	}
 
 	// command line arguments overrides all other sources
-	err = f.Parse(os.Args[1:])
+	err = f.Parse(args)
 	if errors.Is(err, pflag.ErrHelp) {
 		os.Exit(0)
 	}
@@ -89,15 +150,24 @@ func main() {
 		printConfigError(err)
 		os.Exit(2)
 	}
+}
 
-	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer cancel()
+// runMigrate creates the FSID table and applies any pending migrations, then
+// exits without starting the service.
+func runMigrate(ctx context.Context, cfg *Config) error {
+	b, err := openBackend(ctx, cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
 
-	err = run(ctx, cfg)
+	err = b.CreateTable(ctx)
 	if err != nil {
-		log.Error.Print(err)
-		os.Exit(1)
+		return err
 	}
+
+	log.Info.Printf("table \"%s\" is up to date", cfg.Database.TableName)
+	return nil
 }
 
 func run(ctx context.Context, cfg *Config) error {
@@ -114,36 +184,131 @@ func run(ctx context.Context, cfg *Config) error {
 		}
 	}()
 
-	db, err := connect(ctx, cfg.Database)
-	if err != nil {
-		return err
+	t := tracing.Start(ctx, cfg.Tracing)
+	defer func() {
+		deadline, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		err := t.Shutdown(deadline)
+		if err != nil {
+			log.Warn.Printf("tracing did not shutdown gracefully: %s", err)
+		}
+	}()
+
+	open := func(ctx context.Context) (Backend, error) {
+		b, err := openBackend(ctx, cfg.Database)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Database.CreateTable {
+			err = b.CreateTable(ctx)
+		} else if c, ok := b.(schemaChecker); ok {
+			err = c.CheckSchema(ctx)
+		}
+		if n, ok := b.(namespaceChecker); ok && err == nil {
+			err = n.CheckNamespace(ctx)
+		}
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+		return b, nil
 	}
-	defer db.Close()
 
-	source := FSIDSource{
-		db:        db,
-		tableName: cfg.Database.TableName,
+	var journal *Journal
+	var entries []Mapping
+	if cfg.Cache && cfg.Journal != "" {
+		journal, entries, err = openJournal(cfg.Journal)
+		if err != nil {
+			log.Warn.Printf("could not open journal, FSIDs will not be persisted locally: %s", err)
+			journal = nil
+		} else {
+			defer journal.Close()
+			log.Info.Printf("loaded %d FSIDs from journal", len(entries))
+		}
 	}
 
-	if cfg.Database.CreateTable {
-		err = source.CreateTable(ctx)
+	var cache *FSIDCache
+	reconcile := func(ctx context.Context) {
+		if len(entries) == 0 {
+			return
+		}
+		err := cache.Reconcile(ctx)
 		if err != nil {
-			return err
+			log.Error.Printf("could not reconcile journal with database: %s", err)
 		}
 	}
 
+	// Start any background work once the cache has been initialized.
+	var start func()
+	var source Backend
+	source, err = open(ctx)
+	if err == nil {
+		defer source.Close()
+		start = func() { go reconcile(ctx) }
+	} else if journal != nil {
+		// The journal allows serving known FSIDs, so start in degraded mode
+		// rather than stalling the kernel until the database is available.
+		log.Warn.Printf("could not connect to database, starting in degraded mode: %s", err)
+		dctx, cancel := context.WithCancel(ctx)
+		degraded := newDegradedSource()
+		defer func() {
+			cancel()
+			degraded.Close()
+		}()
+		source = degraded
+		start = func() { degraded.Start(dctx, open, reconcile) }
+	} else {
+		return err
+	}
+
 	var f FSIDProvider
 	if cfg.Cache {
-		f = &FSIDCache{source: source}
+		cache = &FSIDCache{source: source, journal: journal}
+		cache.Load(entries)
+		f = cache
 	} else {
 		f = source
 	}
+	start()
+
+	// Record when each path was last used so that stale mappings can be
+	// deleted. The background tasks must finish before the source is closed.
+	seen := newLastSeenRecorder(source, cfg.GC.TouchInterval)
+	f = lastSeenProvider{f, seen}
+
+	var wg sync.WaitGroup
+	bctx, stop := context.WithCancel(ctx)
+	defer func() {
+		stop()
+		wg.Wait()
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		seen.Run(bctx)
+	}()
+
+	if cfg.GC.Interval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runGC(bctx, source, cfg.GC, cache)
+		}()
+	}
+
+	access, err := newAccessPolicy(cfg.Access)
+	if err != nil {
+		return err
+	}
 
 	s, err := resolveSocket(cfg.SocketPath)
 	if err != nil {
 		return err
 	}
 	defer s.Close()
+	s.Restrict(access)
 
 	s.Handle("get_fsidnum", func(ctx context.Context, path string) (string, error) {
 		rec := metrics.StartRequest("get_fsidnum")
@@ -151,9 +316,10 @@ func run(ctx context.Context, cfg *Config) error {
 			rec.End(ctx, "error")
 			return "", ErrInvalidArgument
 		}
+		tracing.SetAttributes(ctx, attribute.String("fsid.path", path))
 
 		var fsid int32
-		err := withRetry(ctx, func() error {
+		err := withRetry(ctx, func(ctx context.Context) error {
 			var err error
 			rec := rec.StartOperation()
 			fsid, err = f.GetFSID(ctx, path)
@@ -163,6 +329,7 @@ func run(ctx context.Context, cfg *Config) error {
 
 		rec.End(ctx, SQLMetricResult(err))
 		if err == nil {
+			tracing.SetAttributes(ctx, attribute.Int64("fsid.fsid", int64(fsid)))
 			return strconv.FormatInt(int64(fsid), 10), nil
 		} else if IsNotFound(err) {
 			return "", nil
@@ -177,13 +344,14 @@ func run(ctx context.Context, cfg *Config) error {
 			rec.End(ctx, "error")
 			return "", ErrInvalidArgument
 		}
+		tracing.SetAttributes(ctx, attribute.String("fsid.path", path))
 
 		var fsid int32
-		err = withRetry(ctx, func() error {
+		err = withRetry(ctx, func(ctx context.Context) error {
 			var err error
 			rec := rec.StartOperation()
 			fsid, err = f.GetFSID(ctx, path)
-			if errors.Is(err, pgx.ErrNoRows) {
+			if IsNotFound(err) {
 				// FSID not found for path, so try and allocate one.
 				// This might fail with a 23505 unique_violation if the path has
 				// already been allocated an FSID by different process. withRetry
@@ -196,6 +364,9 @@ func run(ctx context.Context, cfg *Config) error {
 		})
 
 		rec.End(ctx, SQLMetricResult(err))
+		if err == nil {
+			tracing.SetAttributes(ctx, attribute.Int64("fsid.fsid", int64(fsid)))
+		}
 		return strconv.FormatInt(int64(fsid), 10), err
 	})
 
@@ -210,9 +381,10 @@ func run(ctx context.Context, cfg *Config) error {
 			rec.End(ctx, "error")
 			return "", ErrInvalidArgument
 		}
+		tracing.SetAttributes(ctx, attribute.Int64("fsid.fsid", fsid))
 
 		var path string
-		err = withRetry(ctx, func() error {
+		err = withRetry(ctx, func(ctx context.Context) error {
 			var err error
 			rec := rec.StartOperation()
 			path, err = f.GetPath(ctx, int32(fsid))
@@ -221,12 +393,71 @@ func run(ctx context.Context, cfg *Config) error {
 		})
 
 		rec.End(ctx, SQLMetricResult(err))
+		if err == nil {
+			tracing.SetAttributes(ctx, attribute.String("fsid.path", path))
+		}
 		return path, err
 	})
 
+	s.Handle("get_or_create_fsidnum_multi", func(ctx context.Context, arg string) (string, error) {
+		rec := metrics.StartRequest("get_or_create_fsidnum_multi")
+		paths, err := splitPaths(arg)
+		if err != nil {
+			rec.End(ctx, "error")
+			return "", err
+		}
+		tracing.SetAttributes(ctx, attribute.Int("fsid.paths", len(paths)))
+
+		var fsids map[string]int32
+		err = withRetry(ctx, func(ctx context.Context) error {
+			var err error
+			rec := rec.StartOperation()
+			fsids, err = getOrCreateFSIDs(ctx, f, paths)
+			rec.End(ctx, SQLMetricResult(err))
+			return err
+		})
+
+		rec.End(ctx, SQLMetricResult(err))
+		if err != nil {
+			return "", err
+		}
+
+		// Return the FSIDs in the same order as the paths.
+		response := make([]string, len(paths))
+		for i, path := range paths {
+			response[i] = strconv.FormatInt(int64(fsids[path]), 10)
+		}
+		return strings.Join(response, "\n"), nil
+	})
+
+	s.Handle("prefetch", func(ctx context.Context, arg string) (string, error) {
+		rec := metrics.StartRequest("prefetch")
+		paths, err := splitPaths(arg)
+		if err != nil {
+			rec.End(ctx, "error")
+			return "", err
+		}
+		tracing.SetAttributes(ctx, attribute.Int("fsid.paths", len(paths)))
+
+		var fsids map[string]int32
+		err = withRetry(ctx, func(ctx context.Context) error {
+			var err error
+			rec := rec.StartOperation()
+			fsids, err = f.GetFSIDs(ctx, paths)
+			rec.End(ctx, SQLMetricResult(err))
+			return err
+		})
+
+		rec.End(ctx, SQLMetricResult(err))
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(len(fsids)), nil
+	})
+
 	s.Handle("version", func(ctx context.Context, arg string) (string, error) {
 		metrics.Request(ctx, "version", "ok", 0, 0)
-		return "1", nil
+		return protocolVersion, nil
 	})
 
 	go func() {
@@ -249,6 +480,31 @@ func run(ctx context.Context, cfg *Config) error {
 	}
 	log.Info.Print("service ready")
 
+	// The health checks send the watchdog notifications, so must run at least
+	// twice per watchdog interval.
+	health := newHealthChecker(cfg.Health, source, cfg.SocketPath, cache)
+	interval := cfg.Health.Interval
+	if wd, _ := daemon.SdWatchdogEnabled(false); wd > 0 && wd/2 < interval {
+		interval = wd / 2
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		health.Run(bctx, interval)
+	}()
+
+	if cfg.Health.Listen != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := health.Serve(bctx, cfg.Health.Listen)
+			if err != nil {
+				log.Error.Printf("could not serve health endpoint: %s", err)
+			}
+		}()
+	}
+
 	err = s.Serve()
 	if errors.Is(err, ErrServerClosed) {
 		err = nil
@@ -0,0 +1,236 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/log"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// Migrations are named <version>_<description>.sql, for example
+// 0001_create_table.sql. Versions must start at 1 and be contiguous.
+//
+// Each migration is a text/template that is executed with the table name.
+// Migrations that have been released must never be changed, instead add a new
+// migration. Migrations should be backwards compatible with the previous
+// release so that proxies can be upgraded one at a time.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrations = mustLoadMigrations(migrationFiles, "migrations")
+
+// The schema_version table is shared by all the FSID tables in the same
+// database, recording the last migration applied to each table.
+const schemaVersionTable = `
+CREATE TABLE IF NOT EXISTS schema_version (
+    table_name VARCHAR(63) NOT NULL PRIMARY KEY,
+    version    INTEGER NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+)`
+
+// migrationLockID is the key for the PostgreSQL advisory lock held while
+// applying migrations. A single lock is used for all tables as the
+// schema_version table is shared.
+const migrationLockID int64 = 0x6b6e667364 // "knfsd"
+
+// schemaChecker is implemented by backends that can check whether the schema
+// is up to date without modifying the database.
+type schemaChecker interface {
+	CheckSchema(ctx context.Context) error
+}
+
+type migration struct {
+	version int
+	name    string
+	tmpl    *template.Template
+}
+
+func (m migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.version, m.name)
+}
+
+func (m migration) render(tableName string) (string, error) {
+	w := &strings.Builder{}
+	err := m.tmpl.Execute(w, tableName)
+	return w.String(), err
+}
+
+func mustLoadMigrations(fsys fs.FS, dir string) []migration {
+	m, err := loadMigrations(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]migration, error) {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	for _, file := range files {
+		base := strings.TrimSuffix(path.Base(file), ".sql")
+		version, name, found := cut(base, "_")
+		if !found {
+			return nil, fmt.Errorf("invalid migration name \"%s\"", file)
+		}
+
+		v, err := strconv.Atoi(version)
+		if err != nil || v < 1 {
+			return nil, fmt.Errorf("invalid migration version \"%s\"", file)
+		}
+
+		b, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		tmpl, err := template.New(base).Parse(string(b))
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, migration{v, name, tmpl})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	for i, m := range migrations {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("missing migration version %d", i+1)
+		}
+	}
+
+	return migrations, nil
+}
+
+func latestSchemaVersion() int {
+	return len(migrations)
+}
+
+// Migrate applies any pending migrations to the FSID table. The migrations are
+// applied in a single transaction while holding an advisory lock, so multiple
+// proxies can safely call Migrate at the same time.
+func (s FSIDSource) Migrate(ctx context.Context) error {
+	return withRetry(ctx, func(ctx context.Context) error {
+		return s.db.BeginTxFunc(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+			// The lock is released when the transaction commits or rolls back.
+			_, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLockID)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(ctx, schemaVersionTable)
+			if err != nil {
+				return err
+			}
+
+			current, err := s.schemaVersion(ctx, tx)
+			if err != nil {
+				return err
+			}
+
+			latest := latestSchemaVersion()
+			if current > latest {
+				log.Warn.Printf("table \"%s\" schema version %d is newer than the latest known version %d", s.tableName, current, latest)
+				return nil
+			}
+			if current == latest {
+				log.Debug.Printf("table \"%s\" schema is up to date (version %d)", s.tableName, current)
+				return nil
+			}
+
+			for _, m := range migrations[current:] {
+				log.Info.Printf("applying migration %s to table \"%s\"", m, s.tableName)
+				sql, err := m.render(s.tableName)
+				if err != nil {
+					return err
+				}
+				_, err = tx.Exec(ctx, sql)
+				if err != nil {
+					return fmt.Errorf("migration %s failed: %w", m, err)
+				}
+			}
+
+			_, err = tx.Exec(ctx, `
+				INSERT INTO schema_version (table_name, version) VALUES ($1, $2)
+				ON CONFLICT (table_name) DO UPDATE
+				SET version = EXCLUDED.version, updated_at = now()`,
+				s.tableName, latest)
+			return err
+		})
+	})
+}
+
+// CheckSchema returns an error if there are migrations that have not been
+// applied to the FSID table.
+func (s FSIDSource) CheckSchema(ctx context.Context) error {
+	var current int
+	err := withRetry(ctx, func(ctx context.Context) error {
+		var err error
+		current, err = s.schemaVersion(ctx, s.db)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	latest := latestSchemaVersion()
+	if current < latest {
+		return fmt.Errorf("table \"%s\" schema version %d is older than the required version %d, run \"knfsd-fsidd --migrate\" to upgrade the schema", s.tableName, current, latest)
+	}
+	if current > latest {
+		log.Warn.Printf("table \"%s\" schema version %d is newer than the latest known version %d", s.tableName, current, latest)
+	}
+	return nil
+}
+
+// schemaVersion returns the last migration applied to the FSID table. Tables
+// created before migrations were introduced (or that have not been created)
+// have a version of 0.
+func (s FSIDSource) schemaVersion(ctx context.Context, db interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}) (int, error) {
+	var version int
+	row := db.QueryRow(ctx, "SELECT version FROM schema_version WHERE table_name = $1", s.tableName)
+	err := row.Scan(&version)
+	if errors.Is(err, pgx.ErrNoRows) || isUndefinedTable(err) {
+		return 0, nil
+	}
+	return version, err
+}
+
+func isUndefinedTable(err error) bool {
+	var pgerr *pgconn.PgError
+	return errors.As(err, &pgerr) && pgerr.Code == "42P01"
+}
@@ -0,0 +1,76 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	t.Run("embedded", func(t *testing.T) {
+		m, err := loadMigrations(migrationFiles, "migrations")
+		require.NoError(t, err)
+		require.NotEmpty(t, m)
+		assert.Equal(t, "0001_create_table", m[0].String())
+
+		for _, m := range m {
+			_, err := m.render("fsids")
+			assert.NoError(t, err, m.String())
+		}
+	})
+
+	t.Run("sorted", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"m/0002_second.sql": {Data: []byte("ALTER TABLE \"{{.}}\"")},
+			"m/0001_first.sql":  {Data: []byte("CREATE TABLE \"{{.}}\"")},
+		}
+		m, err := loadMigrations(fsys, "m")
+		require.NoError(t, err)
+		require.Len(t, m, 2)
+		assert.Equal(t, "0001_first", m[0].String())
+		assert.Equal(t, "0002_second", m[1].String())
+
+		sql, err := m[1].render("fsids")
+		require.NoError(t, err)
+		assert.Equal(t, "ALTER TABLE \"fsids\"", sql)
+	})
+
+	t.Run("missing version", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"m/0001_first.sql": {},
+			"m/0003_third.sql": {},
+		}
+		_, err := loadMigrations(fsys, "m")
+		assert.ErrorContains(t, err, "missing migration version 2")
+	})
+
+	t.Run("invalid name", func(t *testing.T) {
+		fsys := fstest.MapFS{"m/first.sql": {}}
+		_, err := loadMigrations(fsys, "m")
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid version", func(t *testing.T) {
+		fsys := fstest.MapFS{"m/0000_zero.sql": {}}
+		_, err := loadMigrations(fsys, "m")
+		assert.Error(t, err)
+	})
+}
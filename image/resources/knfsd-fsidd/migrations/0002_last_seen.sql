@@ -0,0 +1,4 @@
+ALTER TABLE "{{.}}"
+    ADD COLUMN IF NOT EXISTS last_seen TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS "{{.}}_last_seen" ON "{{.}}" (last_seen);
@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS "{{.}}_namespaces" (
+    name       VARCHAR(63) COLLATE ucs_basic NOT NULL PRIMARY KEY,
+    shared     BOOLEAN NOT NULL,
+    next_fsid  INTEGER NOT NULL DEFAULT 1 CHECK (next_fsid > 0),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+-- Existing FSIDs belong to the default namespace. The default namespace uses
+-- the shared FSID space, allocated from the identity sequence of the FSID
+-- table.
+INSERT INTO "{{.}}_namespaces" (name, shared) VALUES ('', true)
+ON CONFLICT (name) DO NOTHING;
+
+ALTER TABLE "{{.}}"
+    ADD COLUMN IF NOT EXISTS namespace VARCHAR(63) COLLATE ucs_basic NOT NULL DEFAULT ''
+        REFERENCES "{{.}}_namespaces" (name);
+
+-- Replace the primary key and the unique constraint on path so that FSIDs and
+-- paths only need to be unique within a namespace. The constraint names are
+-- looked up as they depend on the table name.
+DO $$
+DECLARE
+    c record;
+BEGIN
+    FOR c IN
+        SELECT conname FROM pg_constraint
+        WHERE conrelid = '"{{.}}"'::regclass AND contype IN ('p', 'u')
+    LOOP
+        EXECUTE format('ALTER TABLE %s DROP CONSTRAINT %I', '"{{.}}"'::regclass, c.conname);
+    END LOOP;
+END
+$$;
+
+ALTER TABLE "{{.}}"
+    ADD PRIMARY KEY (namespace, fsid),
+    ADD UNIQUE (namespace, path);
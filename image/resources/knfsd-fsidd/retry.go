@@ -21,12 +21,14 @@ import (
 	"errors"
 	"time"
 
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/internal/tracing"
 	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/log"
 	"github.com/googleapis/gax-go/v2"
 	"github.com/jackc/pgconn"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func withRetry(ctx context.Context, fn func() error) error {
+func withRetry(ctx context.Context, fn func(ctx context.Context) error) error {
 	deadline := time.Now().Add(5 * time.Minute)
 	backoff := &gax.Backoff{
 		// Because of jitter, this will pick a time between 1ns and Interval
@@ -40,24 +42,33 @@ func withRetry(ctx context.Context, fn func() error) error {
 
 	// Keep retrying until the deadline is reached.
 	var err error
-	for {
+	for attempt := 1; ; attempt++ {
 		// Not interrupting an attempt once it has started, the deadline only
 		// applies to the sleep/retry loop.
-		err = fn()
+		actx, span := tracing.StartAttempt(ctx, attempt)
+		err = fn(actx)
 		if err == nil {
+			span.End()
 			return nil
 		}
 		if !ShouldRetry(err) {
+			tracing.End(span, err)
 			return err
 		}
 
 		pause := backoff.Pause()
 		// Check there's enough time remaining before the deadline for another attempt.
 		if !time.Now().Add(pause).Before(deadline) {
+			tracing.End(span, err)
 			return err
 		}
 
 		log.Debug.Printf("[%d] RETRY (%s): %v", log.ID(ctx), pause, err)
+		span.SetAttributes(
+			attribute.String("fsid.retry.reason", retryReason(err)),
+			attribute.Int64("fsid.retry.pause_ms", pause.Milliseconds()),
+		)
+		tracing.End(span, err)
 
 		// Allow sleep to be interrupted by the context being cancelled to allow
 		// for graceful shutdown.
@@ -109,7 +120,29 @@ var retryableErrorCodes = map[string]struct{}{
 	"53300": {}, // too_many_connections
 }
 
+// retryReason describes why an error was retried, using the PostgreSQL error
+// code when available.
+func retryReason(err error) string {
+	var pgerr *pgconn.PgError
+	switch {
+	case errors.Is(err, ErrConflict):
+		return "conflict"
+	case errors.As(err, &pgerr):
+		return pgerr.Code
+	case pgconn.Timeout(err):
+		return "timeout"
+	default:
+		return err.Error()
+	}
+}
+
 func ShouldRetry(err error) bool {
+	if errors.Is(err, ErrConflict) {
+		return true
+	}
+	if isRetryableHTTPError(err) {
+		return true
+	}
 	if pgconn.SafeToRetry(err) {
 		return true
 	}
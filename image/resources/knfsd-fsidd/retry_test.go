@@ -17,10 +17,18 @@
 package main
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/jackc/pgx/v4"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestShouldRetry(t *testing.T) {
@@ -30,3 +38,40 @@ func TestShouldRetry(t *testing.T) {
 		assert.False(t, ShouldRetry(pgx.ErrNoRows))
 	})
 }
+
+func TestWithRetryTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(prev)
+
+	ctx := context.Background()
+	attempts := 0
+	err := withRetry(ctx, func(ctx context.Context) error {
+		attempts++
+		recordQuery(ctx, "allocate_fsid", time.Now(), nil)
+		if attempts == 1 {
+			return ErrConflict
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 4)
+
+	// Each attempt should have a child span for the query.
+	first, second := spans[1], spans[3]
+	assert.Equal(t, "attempt", first.Name())
+	assert.Equal(t, "allocate_fsid", spans[0].Name())
+	assert.Equal(t, first.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Equal(t, second.SpanContext().SpanID(), spans[2].Parent().SpanID())
+
+	assert.Equal(t, codes.Error, first.Status().Code)
+	assert.Contains(t, first.Attributes(), attribute.String("fsid.retry.reason", "conflict"))
+	assert.Contains(t, first.Attributes(), attribute.Int("fsid.attempt", 1))
+
+	assert.Equal(t, codes.Unset, second.Status().Code)
+	assert.Contains(t, second.Attributes(), attribute.Int("fsid.attempt", 2))
+}
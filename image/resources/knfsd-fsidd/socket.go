@@ -27,6 +27,8 @@ import (
 	"sync"
 	"sync/atomic"
 
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/internal/metrics"
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/internal/tracing"
 	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/log"
 	"github.com/coreos/go-systemd/v22/activation"
 
@@ -40,6 +42,7 @@ var (
 	ErrInvalidNetwork        = errors.New("invalid network: socket must be unixpacket")
 	ErrInvalidFileDescriptor = errors.New("invalid file descriptor")
 	ErrTooManySockets        = errors.New("too many of socket activations from systemd")
+	ErrPermissionDenied      = errors.New("permission denied")
 	ErrServerClosed          = net.ErrClosed
 )
 
@@ -51,6 +54,10 @@ type server struct {
 	listener *net.UnixListener
 	handlers map[string]Handler
 
+	// access restricts which peers can execute each command. If access is nil
+	// any peer can execute any command.
+	access *accessPolicy
+
 	connectionID atomic.Uint64
 	connections  map[*connection]struct{}
 
@@ -149,6 +156,12 @@ func (s *server) Handle(cmd string, handler Handler) {
 	s.handlers[cmd] = handler
 }
 
+// Restrict sets the access policy used to authorize commands. Restrict must be
+// called before Serve.
+func (s *server) Restrict(access *accessPolicy) {
+	s.access = access
+}
+
 // Serve accepts incoming connections.
 //
 // Serve always returns a non-nil error. After Shutdown or Close the returned
@@ -275,6 +288,7 @@ type connection struct {
 	handlers   map[string]Handler
 	inShutdown atomic.Bool
 	cancel     context.CancelFunc
+	cred       *unix.Ucred
 }
 
 func (c *connection) shuttingDown() bool {
@@ -291,7 +305,13 @@ func (c *connection) Serve() {
 		log.Debug.Printf("[%d] connection closed", c.id)
 	}()
 
-	log.Debug.Printf("[%d] received connection", c.id)
+	cred, err := peerCred(c.rw)
+	if err != nil {
+		log.Error.Printf("[%d] could not read peer credentials: %s", c.id, err)
+		return
+	}
+	c.cred = cred
+	log.Debug.Printf("[%d] received connection from pid=%d uid=%d gid=%d", c.id, cred.Pid, cred.Uid, cred.Gid)
 
 	// Make the buffer 1 byte larger than the max allowed packet length to
 	// detect truncated packets. If we read > PacketMaxLength bytes then the
@@ -351,7 +371,16 @@ func (c *connection) execute(ctx context.Context, cmd, arg string) error {
 		return c.writeError(fmt.Sprintf("unknown command %q", cmd))
 	}
 
+	ctx, span := tracing.StartRequest(ctx, strings.ToLower(cmd), c.id)
+	if c.s.access != nil && !c.s.access.Allowed(c.cred, cmd) {
+		log.Warn.Printf("[%d] permission denied: pid=%d uid=%d gid=%d command %q", c.id, c.cred.Pid, c.cred.Uid, c.cred.Gid, cmd)
+		metrics.AccessDenied(ctx, strings.ToLower(cmd))
+		tracing.End(span, ErrPermissionDenied)
+		return c.writeError(ErrPermissionDenied.Error())
+	}
+
 	response, err := h(ctx, arg)
+	tracing.End(span, err)
 	if err != nil {
 		// TODO: figure out if error is recoverable, for now assume it is
 		return c.writeError(err.Error())
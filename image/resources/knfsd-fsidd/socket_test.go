@@ -19,6 +19,8 @@ package main
 import (
 	"context"
 	"net"
+	"os"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -107,3 +109,42 @@ func TestNewServerFromFile(t *testing.T) {
 
 	assert.Equal(t, "+ 123", string(buf[0:n]))
 }
+
+func TestServerAccess(t *testing.T) {
+	s, err := newServer("")
+	require.NoError(t, err, "newServer(...)")
+	defer s.Close()
+
+	handler := func(ctx context.Context, arg string) (string, error) {
+		return arg, nil
+	}
+	s.Handle("VERSION", handler)
+	s.Handle("GET_OR_CREATE_FSIDNUM", handler)
+
+	// Only allow read-only commands for the current user.
+	access, err := newAccessPolicy(AccessConfig{
+		ReadUIDs: []string{strconv.Itoa(os.Getuid())},
+	})
+	require.NoError(t, err)
+	s.Restrict(access)
+	go s.Serve()
+
+	c, err := dial(s.listener.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+
+	buf := make([]byte, PacketMaxLength)
+	execute := func(t *testing.T, msg string) string {
+		_, err = c.Write([]byte(msg))
+		require.NoError(t, err, "c.Write(%q)", msg)
+		n, err := c.Read(buf)
+		require.NoError(t, err)
+		return string(buf[0:n])
+	}
+
+	assert.Equal(t, "+ 1", execute(t, "VERSION 1"))
+	assert.Equal(t, "- permission denied", execute(t, "GET_OR_CREATE_FSIDNUM /foo"))
+
+	// The connection is not closed after a command is denied.
+	assert.Equal(t, "+ 2", execute(t, "VERSION 2"))
+}
@@ -18,28 +18,25 @@ package main
 
 import (
 	"context"
-	_ "embed"
 	"errors"
 	"fmt"
 	"net"
-	"strings"
-	"text/template"
 	"time"
 
 	"cloud.google.com/go/cloudsqlconn"
 	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/internal/metrics"
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/internal/tracing"
 	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-fsidd/log"
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"go.uber.org/multierr"
 )
 
-//go:embed schema.sql
-var tableSchema string
-
 type DB interface {
 	BeginTxFunc(ctx context.Context, txOptions pgx.TxOptions, f func(pgx.Tx) error) error
 	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
 	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
 	Close()
 }
@@ -66,10 +63,54 @@ func (w *DBWrapper) Exec(ctx context.Context, sql string, arguments ...interface
 	return w.db.Exec(ctx, sql, arguments...)
 }
 
+func (w *DBWrapper) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return w.db.Query(ctx, sql, args...)
+}
+
 func (w *DBWrapper) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
 	return w.db.QueryRow(ctx, sql, args...)
 }
 
+func init() {
+	registerDriver("cloudsql", driver{
+		validate: func(cfg *DatabaseConfig) error {
+			var err error
+			err = multierr.Append(err, required("database-url", cfg.URL))
+			err = multierr.Append(err, required("database-instance", cfg.Instance))
+			err = multierr.Append(err, required("table-name", cfg.TableName))
+			err = multierr.Append(err, validateNamespace(cfg))
+			return err
+		},
+		open: func(ctx context.Context, cfg DatabaseConfig) (Backend, error) {
+			db, err := connect(ctx, cfg)
+			if err != nil {
+				return nil, err
+			}
+			return newFSIDSource(db, cfg), nil
+		},
+	})
+
+	// The postgres driver connects directly to PostgreSQL without using the
+	// Cloud SQL connector. This is for PostgreSQL servers that are not hosted
+	// by Cloud SQL, or when connecting via the Cloud SQL Auth Proxy.
+	registerDriver("postgres", driver{
+		validate: func(cfg *DatabaseConfig) error {
+			var err error
+			err = multierr.Append(err, required("database-url", cfg.URL))
+			err = multierr.Append(err, required("table-name", cfg.TableName))
+			err = multierr.Append(err, validateNamespace(cfg))
+			return err
+		},
+		open: func(ctx context.Context, cfg DatabaseConfig) (Backend, error) {
+			db, err := connectPostgres(ctx, cfg)
+			if err != nil {
+				return nil, err
+			}
+			return newFSIDSource(db, cfg), nil
+		},
+	})
+}
+
 func connect(ctx context.Context, config DatabaseConfig) (DB, error) {
 	pgConfig, err := pgxpool.ParseConfig(config.URL)
 	if err != nil {
@@ -96,6 +137,21 @@ func connect(ctx context.Context, config DatabaseConfig) (DB, error) {
 	return &DBWrapper{dialer, db}, err
 }
 
+func connectPostgres(ctx context.Context, config DatabaseConfig) (DB, error) {
+	pgConfig, err := pgxpool.ParseConfig(config.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug.Print("Creating pgxpool")
+	db, err := pgxpool.ConnectConfig(ctx, pgConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DBWrapper{db: db}, nil
+}
+
 func newDialer(ctx context.Context, config DatabaseConfig) (*cloudsqlconn.Dialer, error) {
 	var dialOptions []cloudsqlconn.DialOption
 	var options []cloudsqlconn.Option
@@ -128,63 +184,356 @@ func newDialer(ctx context.Context, config DatabaseConfig) (*cloudsqlconn.Dialer
 	return dialer, nil
 }
 
+// FSIDSource stores the FSIDs in a PostgreSQL table.
+//
+// Multiple proxy clusters can share the same table by using a different
+// namespace for each cluster. Each namespace either has its own FSID space,
+// allocated using the next_fsid counter in the namespaces table, or uses the
+// shared FSID space, allocated using the identity sequence of the FSID table.
+// The default namespace ("") always uses the shared FSID space.
 type FSIDSource struct {
 	db        DB
 	tableName string
+	namespace string
+	shared    bool
 }
 
-func (s FSIDSource) CreateTable(ctx context.Context) error {
-	log.Debug.Printf("creating table \"%s\"", s.tableName)
-
-	t, err := template.New("schema").Parse(tableSchema)
-	if err != nil {
-		return err
+func newFSIDSource(db DB, cfg DatabaseConfig) FSIDSource {
+	return FSIDSource{
+		db:        db,
+		tableName: cfg.TableName,
+		namespace: cfg.Namespace,
+		shared:    cfg.SharedFSIDs,
 	}
+}
 
-	w := &strings.Builder{}
-	err = t.Execute(w, s.tableName)
-	if err != nil {
-		return err
-	}
+// sharedFSIDs returns true if the namespace uses the shared FSID space.
+func (s FSIDSource) sharedFSIDs() bool {
+	return s.shared || s.namespace == ""
+}
 
-	sql := w.String()
-	return withRetry(ctx, func() error {
-		_, err = s.db.Exec(ctx, sql)
-		return err
-	})
+func (s FSIDSource) namespacesTable() string {
+	return s.tableName + "_namespaces"
+}
+
+func (s FSIDSource) Close() {
+	s.db.Close()
+}
+
+// CreateTable creates the FSID table if it does not exist, and applies any
+// pending migrations.
+func (s FSIDSource) CreateTable(ctx context.Context) error {
+	return s.Migrate(ctx)
 }
 
 func (s FSIDSource) GetFSID(ctx context.Context, path string) (int32, error) {
 	var fsid int32
 	start := time.Now()
-	sql := fmt.Sprintf("SELECT fsid FROM \"%s\" WHERE path = $1", s.tableName)
-	row := s.db.QueryRow(ctx, sql, path)
+	sql := fmt.Sprintf("SELECT fsid FROM \"%s\" WHERE namespace = $1 AND path = $2", s.tableName)
+	row := s.db.QueryRow(ctx, sql, s.namespace, path)
 	err := row.Scan(&fsid)
-	metrics.SQLOperation(ctx, "get_fsid", SQLMetricResult(err), time.Since(start))
+	recordQuery(ctx, "get_fsid", start, err)
 	return fsid, err
 }
 
+func (s FSIDSource) GetFSIDs(ctx context.Context, paths []string) (map[string]int32, error) {
+	fsids := make(map[string]int32, len(paths))
+	start := time.Now()
+	sql := fmt.Sprintf("SELECT path, fsid FROM \"%s\" WHERE namespace = $1 AND path = ANY($2)", s.tableName)
+	rows, err := s.db.Query(ctx, sql, s.namespace, paths)
+	if err == nil {
+		for rows.Next() {
+			var path string
+			var fsid int32
+			err = rows.Scan(&path, &fsid)
+			if err != nil {
+				break
+			}
+			fsids[path] = fsid
+		}
+		rows.Close()
+		if err == nil {
+			err = rows.Err()
+		}
+	}
+	recordQuery(ctx, "get_fsids", start, err)
+	return fsids, err
+}
+
 func (s FSIDSource) AllocateFSID(ctx context.Context, path string) (int32, error) {
 	var fsid int32
 	start := time.Now()
-	sql := fmt.Sprintf("INSERT INTO \"%s\" (path) VALUES ($1) RETURNING fsid", s.tableName)
-	row := s.db.QueryRow(ctx, sql, path)
-	err := row.Scan(&fsid)
-	metrics.SQLOperation(ctx, "allocate_fsid", SQLMetricResult(err), time.Since(start))
+	var err error
+	if s.sharedFSIDs() {
+		fsid, err = s.allocateSharedFSID(ctx, path)
+	} else {
+		fsid, err = s.allocateNamespaceFSID(ctx, path)
+	}
+	recordQuery(ctx, "allocate_fsid", start, err)
 	return fsid, err
 }
 
 func (s FSIDSource) GetPath(ctx context.Context, fsid int32) (string, error) {
 	var path string
 	start := time.Now()
-	sql := fmt.Sprintf("SELECT path FROM \"%s\" WHERE fsid = $1", s.tableName)
-	row := s.db.QueryRow(ctx, sql, fsid)
+	sql := fmt.Sprintf("SELECT path FROM \"%s\" WHERE namespace = $1 AND fsid = $2", s.tableName)
+	row := s.db.QueryRow(ctx, sql, s.namespace, fsid)
 	err := row.Scan(&path)
-	metrics.SQLOperation(ctx, "get_path", SQLMetricResult(err), time.Since(start))
+	recordQuery(ctx, "get_path", start, err)
 	return path, err
 }
 
+func (s FSIDSource) ListFSIDs(ctx context.Context) ([]Mapping, error) {
+	var mappings []Mapping
+	start := time.Now()
+	sql := fmt.Sprintf("SELECT fsid, path FROM \"%s\" WHERE namespace = $1 ORDER BY fsid", s.tableName)
+	rows, err := s.db.Query(ctx, sql, s.namespace)
+	if err == nil {
+		for rows.Next() {
+			var m Mapping
+			err = rows.Scan(&m.FSID, &m.Path)
+			if err != nil {
+				break
+			}
+			mappings = append(mappings, m)
+		}
+		rows.Close()
+		if err == nil {
+			err = rows.Err()
+		}
+	}
+	recordQuery(ctx, "list_fsids", start, err)
+	return mappings, err
+}
+
+func (s FSIDSource) ReserveFSID(ctx context.Context, path string, fsid int32) error {
+	start := time.Now()
+	err := s.db.BeginTxFunc(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		if s.sharedFSIDs() {
+			return s.reserveSharedFSID(ctx, tx, path, fsid)
+		} else {
+			return s.reserveNamespaceFSID(ctx, tx, path, fsid)
+		}
+	})
+	recordQuery(ctx, "reserve_fsid", start, err)
+	return err
+}
+
+func (s FSIDSource) reserveSharedFSID(ctx context.Context, tx pgx.Tx, path string, fsid int32) error {
+	// FSIDs in the shared FSID space must be unique across every namespace
+	// using the shared FSID space. This cannot be enforced by a constraint as
+	// the primary key is per namespace.
+	//
+	// Lock the rows of every namespace using the shared FSID space so that
+	// concurrent reservations and allocations (see allocateSharedFSID) are
+	// serialized until the transaction completes, otherwise two transactions
+	// could both see the FSID as unused.
+	_, err := tx.Exec(ctx, fmt.Sprintf(`SELECT 1 FROM "%s" WHERE shared FOR UPDATE`, s.namespacesTable()))
+	if err != nil {
+		return err
+	}
+
+	var exists bool
+	err = tx.QueryRow(ctx, fmt.Sprintf(`
+		SELECT EXISTS (
+			SELECT 1 FROM "%s" f JOIN "%s" n ON n.name = f.namespace
+			WHERE n.shared AND f.fsid = $1
+		)`, s.tableName, s.namespacesTable()), fsid).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrConflict
+	}
+
+	sql := fmt.Sprintf("INSERT INTO \"%s\" (namespace, fsid, path) OVERRIDING SYSTEM VALUE VALUES ($1, $2, $3) ON CONFLICT DO NOTHING", s.tableName)
+	tag, err := tx.Exec(ctx, sql, s.namespace, fsid, path)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		// The path or FSID is already in use in this namespace.
+		return ErrConflict
+	}
+
+	// Advance the identity sequence past the reserved FSID so that
+	// AllocateFSID does not try to allocate the same FSID. Never move the
+	// sequence backwards, otherwise FSIDs from deleted paths would be
+	// re-used.
+	_, err = tx.Exec(ctx, `
+		SELECT setval(seq, GREATEST($2::integer, COALESCE(pg_sequence_last_value(seq), 0)))
+		FROM (SELECT pg_get_serial_sequence($1, 'fsid')::regclass AS seq) AS s`,
+		quoteIdentifier(s.tableName), fsid)
+	return err
+}
+
+func (s FSIDSource) reserveNamespaceFSID(ctx context.Context, tx pgx.Tx, path string, fsid int32) error {
+	sql := fmt.Sprintf("INSERT INTO \"%s\" (namespace, fsid, path) OVERRIDING SYSTEM VALUE VALUES ($1, $2, $3)", s.tableName)
+	_, err := tx.Exec(ctx, sql, s.namespace, fsid, path)
+	if err != nil {
+		return err
+	}
+
+	// Advance the namespace's counter past the reserved FSID, as with the
+	// shared FSID space the counter never moves backwards.
+	sql = fmt.Sprintf("UPDATE \"%s\" SET next_fsid = GREATEST(next_fsid, $2::integer + 1) WHERE name = $1", s.namespacesTable())
+	tag, err := tx.Exec(ctx, sql, s.namespace, fsid)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = s.errNamespaceNotFound()
+	}
+	return err
+}
+
+// allocateSharedFSID allocates the next FSID from the shared FSID space's
+// identity sequence.
+//
+// The rows of the namespaces using the shared FSID space are locked in share
+// mode, so that allocations can run concurrently with each other but not with
+// reserveSharedFSID. Otherwise a reservation could check an FSID is unused
+// while a concurrent allocation in another namespace is allocating the same
+// FSID from the sequence.
+func (s FSIDSource) allocateSharedFSID(ctx context.Context, path string) (int32, error) {
+	var fsid int32
+	err := s.db.BeginTxFunc(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, fmt.Sprintf(`SELECT 1 FROM "%s" WHERE shared FOR SHARE`, s.namespacesTable()))
+		if err != nil {
+			return err
+		}
+
+		sql := fmt.Sprintf("INSERT INTO \"%s\" (namespace, path) VALUES ($1, $2) RETURNING fsid", s.tableName)
+		return tx.QueryRow(ctx, sql, s.namespace, path).Scan(&fsid)
+	})
+	if err != nil {
+		fsid = 0
+	}
+	return fsid, err
+}
+
+// allocateNamespaceFSID allocates the next FSID from the namespace's counter.
+// The update locks the namespace's row, so concurrent allocations in the same
+// namespace are serialized until the transaction completes.
+func (s FSIDSource) allocateNamespaceFSID(ctx context.Context, path string) (int32, error) {
+	var fsid int32
+	err := s.db.BeginTxFunc(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		sql := fmt.Sprintf("UPDATE \"%s\" SET next_fsid = next_fsid + 1 WHERE name = $1 RETURNING next_fsid - 1", s.namespacesTable())
+		err := tx.QueryRow(ctx, sql, s.namespace).Scan(&fsid)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return s.errNamespaceNotFound()
+		}
+		if err != nil {
+			return err
+		}
+
+		sql = fmt.Sprintf("INSERT INTO \"%s\" (namespace, fsid, path) OVERRIDING SYSTEM VALUE VALUES ($1, $2, $3)", s.tableName)
+		_, err = tx.Exec(ctx, sql, s.namespace, fsid, path)
+		return err
+	})
+	if err != nil {
+		fsid = 0
+	}
+	return fsid, err
+}
+
+// CheckNamespace registers the namespace if it does not exist, and returns an
+// error if the namespace has been registered with different settings. For
+// example, if one proxy cluster is configured to use the shared FSID space but
+// another proxy cluster in the same namespace is not.
+func (s FSIDSource) CheckNamespace(ctx context.Context) error {
+	var shared bool
+	err := withRetry(ctx, func(ctx context.Context) error {
+		sql := fmt.Sprintf("INSERT INTO \"%s\" (name, shared) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING", s.namespacesTable())
+		_, err := s.db.Exec(ctx, sql, s.namespace, s.sharedFSIDs())
+		if err != nil {
+			return err
+		}
+
+		sql = fmt.Sprintf("SELECT shared FROM \"%s\" WHERE name = $1", s.namespacesTable())
+		return s.db.QueryRow(ctx, sql, s.namespace).Scan(&shared)
+	})
+	if err != nil {
+		return err
+	}
+
+	if shared != s.sharedFSIDs() {
+		return fmt.Errorf("namespace \"%s\" is configured with shared-fsids=%t, but the database has shared-fsids=%t", s.namespace, s.sharedFSIDs(), shared)
+	}
+	return nil
+}
+
+// Ping checks the connection to the database.
+func (s FSIDSource) Ping(ctx context.Context) error {
+	var n int
+	return s.db.QueryRow(ctx, "SELECT 1").Scan(&n)
+}
+
+func (s FSIDSource) errNamespaceNotFound() error {
+	return fmt.Errorf("namespace \"%s\" does not exist in table \"%s\"", s.namespace, s.namespacesTable())
+}
+
+func (s FSIDSource) DeletePath(ctx context.Context, path string) error {
+	start := time.Now()
+	sql := fmt.Sprintf("DELETE FROM \"%s\" WHERE namespace = $1 AND path = $2", s.tableName)
+	tag, err := s.db.Exec(ctx, sql, s.namespace, path)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = pgx.ErrNoRows
+	}
+	recordQuery(ctx, "delete_path", start, err)
+	return err
+}
+
+func (s FSIDSource) TouchPaths(ctx context.Context, paths []string, seen time.Time) error {
+	start := time.Now()
+	// Use GREATEST so that a delayed update from one proxy cannot move the
+	// last seen time backwards.
+	sql := fmt.Sprintf("UPDATE \"%s\" SET last_seen = GREATEST(last_seen, $3) WHERE namespace = $1 AND path = ANY($2)", s.tableName)
+	_, err := s.db.Exec(ctx, sql, s.namespace, paths, seen)
+	recordQuery(ctx, "touch_paths", start, err)
+	return err
+}
+
+func (s FSIDSource) ListStale(ctx context.Context, before time.Time) ([]StaleMapping, error) {
+	var mappings []StaleMapping
+	start := time.Now()
+	sql := fmt.Sprintf("SELECT fsid, path, last_seen FROM \"%s\" WHERE namespace = $1 AND last_seen < $2 ORDER BY fsid", s.tableName)
+	rows, err := s.db.Query(ctx, sql, s.namespace, before)
+	if err == nil {
+		for rows.Next() {
+			var m StaleMapping
+			err = rows.Scan(&m.FSID, &m.Path, &m.LastSeen)
+			if err != nil {
+				break
+			}
+			mappings = append(mappings, m)
+		}
+		rows.Close()
+		if err == nil {
+			err = rows.Err()
+		}
+	}
+	recordQuery(ctx, "list_stale", start, err)
+	return mappings, err
+}
+
+func (s FSIDSource) DeleteStale(ctx context.Context, path string, before time.Time) error {
+	start := time.Now()
+	sql := fmt.Sprintf("DELETE FROM \"%s\" WHERE namespace = $1 AND path = $2 AND last_seen < $3", s.tableName)
+	tag, err := s.db.Exec(ctx, sql, s.namespace, path, before)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = pgx.ErrNoRows
+	}
+	recordQuery(ctx, "delete_stale", start, err)
+	return err
+}
+
+func quoteIdentifier(name string) string {
+	return pgx.Identifier{name}.Sanitize()
+}
+
 func IsConflict(err error) bool {
+	if errors.Is(err, ErrConflict) {
+		return true
+	}
+
 	var pgerr *pgconn.PgError
 	if errors.As(err, &pgerr) {
 		// unique constraint violation
@@ -195,7 +544,19 @@ func IsConflict(err error) bool {
 }
 
 func IsNotFound(err error) bool {
-	return errors.Is(err, pgx.ErrNoRows)
+	return errors.Is(err, pgx.ErrNoRows) || errors.Is(err, ErrNotFound)
+}
+
+// recordQuery reports the metrics and trace span for a database operation.
+func recordQuery(ctx context.Context, operation string, start time.Time, err error) {
+	result := SQLMetricResult(err)
+	metrics.SQLOperation(ctx, operation, result, time.Since(start))
+	if IsNotFound(err) {
+		// Not found is an expected result, and should not mark the trace as
+		// failed.
+		err = nil
+	}
+	tracing.Query(ctx, operation, result, start, err)
 }
 
 func SQLMetricResult(err error) string {
@@ -205,6 +566,8 @@ func SQLMetricResult(err error) string {
 		return "not_found"
 	} else if IsConflict(err) {
 		return "conflict"
+	} else if errors.Is(err, ErrDegraded) {
+		return "degraded"
 	} else {
 		return "error"
 	}
@@ -24,6 +24,7 @@ import (
 	"os"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v4"
@@ -56,7 +57,7 @@ func connectTest() (FSIDSource, error) {
 	source := FSIDSource{db: pool, tableName: "fsid-test"}
 
 	// clean up after previous test
-	_, err = pool.Exec(ctx, "DROP TABLE IF EXISTS \"fsid-test\"")
+	_, err = pool.Exec(ctx, "DROP TABLE IF EXISTS \"fsid-test\", \"fsid-test_namespaces\", schema_version")
 	if err != nil {
 		goto fail
 	}
@@ -117,6 +118,294 @@ func TestFSIDSource(t *testing.T) {
 	})
 }
 
+func TestMigrate(t *testing.T) {
+	t.Run("Concurrent", func(t *testing.T) {
+		source, err := connectTest()
+		require.NoError(t, err)
+		defer source.db.Close()
+
+		// Reset the database so that every migration has to be applied.
+		ctx := context.Background()
+		_, err = source.db.Exec(ctx, "DROP TABLE \"fsid-test\", \"fsid-test_namespaces\", schema_version")
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		errs := make([]error, 10)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = source.Migrate(ctx)
+			}(i)
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			assert.NoError(t, err)
+		}
+
+		version, err := source.schemaVersion(ctx, source.db)
+		require.NoError(t, err)
+		assert.Equal(t, latestSchemaVersion(), version)
+		assert.NoError(t, source.CheckSchema(ctx))
+	})
+
+	t.Run("Legacy", func(t *testing.T) {
+		source, err := connectTest()
+		require.NoError(t, err)
+		defer source.db.Close()
+
+		// Tables created before migrations were introduced only have the
+		// original schema, and do not have a schema_version.
+		ctx := context.Background()
+		_, err = source.db.Exec(ctx, "DROP TABLE \"fsid-test\", \"fsid-test_namespaces\", schema_version")
+		require.NoError(t, err)
+		sql, err := migrations[0].render("fsid-test")
+		require.NoError(t, err)
+		_, err = source.db.Exec(ctx, sql)
+		require.NoError(t, err)
+		_, err = source.db.Exec(ctx, "INSERT INTO \"fsid-test\" (path) VALUES ('/foo')")
+		require.NoError(t, err)
+
+		err = source.CheckSchema(ctx)
+		assert.ErrorContains(t, err, "older than the required version")
+
+		err = source.Migrate(ctx)
+		require.NoError(t, err)
+		assert.NoError(t, source.CheckSchema(ctx))
+
+		// Existing FSIDs must be kept.
+		fsid, err := source.GetFSID(ctx, "/foo")
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), fsid)
+	})
+}
+
+// connectNamespace returns a copy of the source using a different namespace.
+func connectNamespace(t *testing.T, source FSIDSource, namespace string, shared bool) FSIDSource {
+	source.namespace = namespace
+	source.shared = shared
+	err := source.CheckNamespace(context.Background())
+	require.NoError(t, err)
+	return source
+}
+
+func TestNamespaces(t *testing.T) {
+	t.Run("Isolated", func(t *testing.T) {
+		source, err := connectTest()
+		require.NoError(t, err)
+		defer source.db.Close()
+
+		ctx := context.Background()
+		a := connectNamespace(t, source, "a", false)
+		b := connectNamespace(t, source, "b", false)
+
+		// Each namespace has its own FSID space.
+		for _, ns := range []FSIDSource{source, a, b} {
+			fsid, err := ns.AllocateFSID(ctx, "/foo")
+			require.NoError(t, err)
+			assert.Equal(t, int32(1), fsid, ns.namespace)
+		}
+
+		fsid, err := a.AllocateFSID(ctx, "/bar")
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), fsid)
+
+		_, err = b.GetFSID(ctx, "/bar")
+		assert.True(t, IsNotFound(err))
+
+		_, err = a.AllocateFSID(ctx, "/bar")
+		assert.True(t, IsConflict(err))
+
+		// Reserving an FSID advances the namespace's counter.
+		err = b.ReserveFSID(ctx, "/baz", 10)
+		require.NoError(t, err)
+		fsid, err = b.AllocateFSID(ctx, "/qux")
+		require.NoError(t, err)
+		assert.Equal(t, int32(11), fsid)
+
+		mappings, err := a.ListFSIDs(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []Mapping{{1, "/foo"}, {2, "/bar"}}, mappings)
+
+		// Deleted FSIDs should not be re-used.
+		err = a.DeletePath(ctx, "/bar")
+		require.NoError(t, err)
+		fsid, err = a.AllocateFSID(ctx, "/bar")
+		require.NoError(t, err)
+		assert.Equal(t, int32(3), fsid)
+	})
+
+	t.Run("Shared", func(t *testing.T) {
+		source, err := connectTest()
+		require.NoError(t, err)
+		defer source.db.Close()
+
+		ctx := context.Background()
+		a := connectNamespace(t, source, "a", true)
+		b := connectNamespace(t, source, "b", true)
+
+		// Shared namespaces allocate FSIDs from the same sequence.
+		fsid, err := source.AllocateFSID(ctx, "/foo")
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), fsid)
+		fsid, err = a.AllocateFSID(ctx, "/foo")
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), fsid)
+		fsid, err = b.AllocateFSID(ctx, "/foo")
+		require.NoError(t, err)
+		assert.Equal(t, int32(3), fsid)
+
+		err = b.ReserveFSID(ctx, "/bar", 2)
+		assert.True(t, IsConflict(err), "fsid already used by another shared namespace")
+
+		// Concurrent reservations of the same FSID in different shared
+		// namespaces must not both succeed.
+		var wg sync.WaitGroup
+		errs := make([]error, 2)
+		for i, ns := range []FSIDSource{a, b} {
+			wg.Add(1)
+			go func(i int, ns FSIDSource) {
+				defer wg.Done()
+				errs[i] = ns.ReserveFSID(ctx, "/baz", 10)
+			}(i, ns)
+		}
+		wg.Wait()
+		if errs[0] == nil {
+			assert.True(t, IsConflict(errs[1]), "fsid reserved concurrently by another shared namespace")
+		} else {
+			assert.True(t, IsConflict(errs[0]), "fsid reserved concurrently by another shared namespace")
+			assert.NoError(t, errs[1])
+		}
+
+		// Reserving the next FSID from the sequence while another shared
+		// namespace allocates an FSID must not result in the same FSID being
+		// used twice.
+		var reserveErr, allocateErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			reserveErr = a.ReserveFSID(ctx, "/reserved", 11)
+		}()
+		go func() {
+			defer wg.Done()
+			fsid, allocateErr = b.AllocateFSID(ctx, "/allocated")
+		}()
+		wg.Wait()
+		require.NoError(t, allocateErr)
+		if reserveErr == nil {
+			assert.NotEqual(t, int32(11), fsid)
+		} else {
+			assert.True(t, IsConflict(reserveErr))
+			assert.Equal(t, int32(11), fsid)
+		}
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		source, err := connectTest()
+		require.NoError(t, err)
+		defer source.db.Close()
+
+		connectNamespace(t, source, "a", false)
+
+		source.namespace = "a"
+		source.shared = true
+		err = source.CheckNamespace(context.Background())
+		assert.ErrorContains(t, err, "shared-fsids")
+	})
+}
+
+func TestLastSeen(t *testing.T) {
+	source, err := connectTest()
+	require.NoError(t, err)
+	defer source.db.Close()
+
+	ctx := context.Background()
+	_, err = source.AllocateFSID(ctx, "/foo")
+	require.NoError(t, err)
+	_, err = source.AllocateFSID(ctx, "/bar")
+	require.NoError(t, err)
+
+	// Move the last seen time into the past so that both paths are stale.
+	past := time.Now().Add(-48 * time.Hour)
+	_, err = source.db.Exec(ctx, "UPDATE \"fsid-test\" SET last_seen = $1", past)
+	require.NoError(t, err)
+
+	before := time.Now().Add(-24 * time.Hour)
+	err = source.TouchPaths(ctx, []string{"/foo", "/missing"}, time.Now())
+	require.NoError(t, err)
+
+	stale, err := source.ListStale(ctx, before)
+	require.NoError(t, err)
+	if assert.Len(t, stale, 1) {
+		assert.Equal(t, Mapping{2, "/bar"}, stale[0].Mapping)
+		assert.WithinDuration(t, past, stale[0].LastSeen, time.Second)
+	}
+
+	err = source.DeleteStale(ctx, "/foo", before)
+	assert.True(t, IsNotFound(err), "path was seen after before")
+	err = source.DeleteStale(ctx, "/bar", before)
+	require.NoError(t, err)
+
+	// Deleted FSIDs should not be re-used.
+	fsid, err := source.AllocateFSID(ctx, "/bar")
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), fsid)
+}
+
+func TestReserveFSID(t *testing.T) {
+	source, err := connectTest()
+	require.NoError(t, err)
+	defer source.db.Close()
+
+	ctx := context.Background()
+	err = source.ReserveFSID(ctx, "/foo", 5)
+	require.NoError(t, err)
+
+	err = source.ReserveFSID(ctx, "/foo", 6)
+	assert.True(t, IsConflict(err), "path already reserved")
+
+	err = source.ReserveFSID(ctx, "/bar", 5)
+	assert.True(t, IsConflict(err), "fsid already reserved")
+
+	// The sequence should have been advanced past the reserved FSID.
+	fsid, err := source.AllocateFSID(ctx, "/bar")
+	require.NoError(t, err)
+	assert.Equal(t, int32(6), fsid)
+
+	// Reserving a lower FSID must not move the sequence backwards.
+	err = source.ReserveFSID(ctx, "/baz", 2)
+	require.NoError(t, err)
+	fsid, err = source.AllocateFSID(ctx, "/qux")
+	require.NoError(t, err)
+	assert.Equal(t, int32(7), fsid)
+
+	mappings, err := source.ListFSIDs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []Mapping{{2, "/baz"}, {5, "/foo"}, {6, "/bar"}, {7, "/qux"}}, mappings)
+
+	err = source.DeletePath(ctx, "/qux")
+	require.NoError(t, err)
+	err = source.DeletePath(ctx, "/qux")
+	assert.True(t, IsNotFound(err))
+}
+
+func TestGetFSIDs(t *testing.T) {
+	source, err := connectTest()
+	require.NoError(t, err)
+	defer source.db.Close()
+
+	ctx := context.Background()
+	foo, err := source.AllocateFSID(ctx, "/foo")
+	require.NoError(t, err)
+	bar, err := source.AllocateFSID(ctx, "/bar")
+	require.NoError(t, err)
+
+	fsids, err := source.GetFSIDs(ctx, []string{"/foo", "/missing", "/bar"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int32{"/foo": foo, "/bar": bar}, fsids)
+}
+
 func TestAllocateFSID(t *testing.T) {
 	source, err := connectTest()
 	require.NoError(t, err)
@@ -19,6 +19,7 @@ package main
 import (
 	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-metrics-agent/internal/connections"
 	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-metrics-agent/internal/exports"
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-metrics-agent/internal/fscache"
 	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-metrics-agent/internal/mounts"
 	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-metrics-agent/internal/oldestfile"
 	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-metrics-agent/internal/slab"
@@ -62,6 +63,7 @@ func components() (component.Factories, error) {
 		exports.NewFactory(),
 		slab.NewFactory(),
 		oldestfile.NewFactory(),
+		fscache.NewFactory(),
 	)
 	errs = multierr.Append(errs, err)
 
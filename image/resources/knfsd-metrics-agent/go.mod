@@ -3,6 +3,7 @@ module github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-me
 go 1.20
 
 require (
+	github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-common v0.0.0
 	github.com/open-telemetry/opentelemetry-collector-contrib/cmd/mdatagen v0.44.0
 	github.com/open-telemetry/opentelemetry-collector-contrib/exporter/elasticexporter v0.44.0
 	github.com/open-telemetry/opentelemetry-collector-contrib/exporter/elasticsearchexporter v0.44.0
@@ -24,6 +25,7 @@ require (
 	go.opentelemetry.io/collector/model v0.44.0
 	go.uber.org/multierr v1.8.0
 	go.uber.org/zap v1.20.0
+	golang.org/x/sys v0.20.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -145,7 +147,6 @@ require (
 	golang.org/x/net v0.15.0 // indirect
 	golang.org/x/oauth2 v0.12.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect
-	golang.org/x/sys v0.20.0 // indirect
 	golang.org/x/term v0.12.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
 	golang.org/x/time v0.3.0 // indirect; indirect	google.golang.org/api v0.66.0 // indirect
@@ -168,3 +169,5 @@ require (
 	sigs.k8s.io/structured-merge-diff/v4 v4.2.1 // indirect
 	sigs.k8s.io/yaml v1.2.0 // indirect
 )
+
+replace github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-common => ../knfsd-common
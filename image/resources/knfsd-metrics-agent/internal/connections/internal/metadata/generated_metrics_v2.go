@@ -31,15 +31,237 @@ type MetricSettings struct {
 
 // MetricsSettings provides settings for connections metrics.
 type MetricsSettings struct {
-	NfsConnections MetricSettings `mapstructure:"nfs.connections"`
+	NfsClientConnections    MetricSettings `mapstructure:"nfs.client.connections"`
+	NfsClientRetransmits    MetricSettings `mapstructure:"nfs.client.retransmits"`
+	NfsClientRtt            MetricSettings `mapstructure:"nfs.client.rtt"`
+	NfsClients              MetricSettings `mapstructure:"nfs.clients"`
+	NfsConnections          MetricSettings `mapstructure:"nfs.connections"`
+	NfsConnectionsQueueSize MetricSettings `mapstructure:"nfs.connections.queue_size"`
 }
 
 func DefaultMetricsSettings() MetricsSettings {
 	return MetricsSettings{
+		NfsClientConnections: MetricSettings{
+			Enabled: false,
+		},
+		NfsClientRetransmits: MetricSettings{
+			Enabled: false,
+		},
+		NfsClientRtt: MetricSettings{
+			Enabled: false,
+		},
+		NfsClients: MetricSettings{
+			Enabled: true,
+		},
 		NfsConnections: MetricSettings{
 			Enabled: true,
 		},
+		NfsConnectionsQueueSize: MetricSettings{
+			Enabled: true,
+		},
+	}
+}
+
+type metricNfsClientConnections struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills nfs.client.connections metric with initial data.
+func (m *metricNfsClientConnections) init() {
+	m.data.SetName("nfs.client.connections")
+	m.data.SetDescription("The number of connections from each NFS client")
+	m.data.SetUnit("1")
+	m.data.SetDataType(pdata.MetricDataTypeGauge)
+	m.data.Gauge().DataPoints().EnsureCapacity(m.capacity)
+}
+
+func (m *metricNfsClientConnections) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val int64, clientAttributeValue string) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Gauge().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetIntVal(val)
+	dp.Attributes().Insert(A.Client, pdata.NewAttributeValueString(clientAttributeValue))
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricNfsClientConnections) updateCapacity() {
+	if m.data.Gauge().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Gauge().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricNfsClientConnections) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Gauge().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricNfsClientConnections(settings MetricSettings) metricNfsClientConnections {
+	m := metricNfsClientConnections{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
+}
+
+type metricNfsClientRetransmits struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills nfs.client.retransmits metric with initial data.
+func (m *metricNfsClientRetransmits) init() {
+	m.data.SetName("nfs.client.retransmits")
+	m.data.SetDescription("Total TCP segments retransmitted to each NFS client")
+	m.data.SetUnit("1")
+	m.data.SetDataType(pdata.MetricDataTypeGauge)
+	m.data.Gauge().DataPoints().EnsureCapacity(m.capacity)
+}
+
+func (m *metricNfsClientRetransmits) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val int64, clientAttributeValue string) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Gauge().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetIntVal(val)
+	dp.Attributes().Insert(A.Client, pdata.NewAttributeValueString(clientAttributeValue))
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricNfsClientRetransmits) updateCapacity() {
+	if m.data.Gauge().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Gauge().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricNfsClientRetransmits) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Gauge().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricNfsClientRetransmits(settings MetricSettings) metricNfsClientRetransmits {
+	m := metricNfsClientRetransmits{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
+}
+
+type metricNfsClientRtt struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills nfs.client.rtt metric with initial data.
+func (m *metricNfsClientRtt) init() {
+	m.data.SetName("nfs.client.rtt")
+	m.data.SetDescription("Average smoothed TCP round trip time to each NFS client")
+	m.data.SetUnit("ms")
+	m.data.SetDataType(pdata.MetricDataTypeGauge)
+	m.data.Gauge().DataPoints().EnsureCapacity(m.capacity)
+}
+
+func (m *metricNfsClientRtt) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val float64, clientAttributeValue string) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Gauge().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetDoubleVal(val)
+	dp.Attributes().Insert(A.Client, pdata.NewAttributeValueString(clientAttributeValue))
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricNfsClientRtt) updateCapacity() {
+	if m.data.Gauge().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Gauge().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricNfsClientRtt) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Gauge().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricNfsClientRtt(settings MetricSettings) metricNfsClientRtt {
+	m := metricNfsClientRtt{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
+}
+
+type metricNfsClients struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills nfs.clients metric with initial data.
+func (m *metricNfsClients) init() {
+	m.data.SetName("nfs.clients")
+	m.data.SetDescription("The number of unique NFS clients (by IP address) connected to the Knfsd filer")
+	m.data.SetUnit("1")
+	m.data.SetDataType(pdata.MetricDataTypeGauge)
+}
+
+func (m *metricNfsClients) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val int64) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Gauge().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetIntVal(val)
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricNfsClients) updateCapacity() {
+	if m.data.Gauge().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Gauge().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricNfsClients) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Gauge().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricNfsClients(settings MetricSettings) metricNfsClients {
+	m := metricNfsClients{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
 	}
+	return m
 }
 
 type metricNfsConnections struct {
@@ -91,11 +313,67 @@ func newMetricNfsConnections(settings MetricSettings) metricNfsConnections {
 	return m
 }
 
+type metricNfsConnectionsQueueSize struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills nfs.connections.queue_size metric with initial data.
+func (m *metricNfsConnectionsQueueSize) init() {
+	m.data.SetName("nfs.connections.queue_size")
+	m.data.SetDescription("Total bytes waiting in the socket queues of NFS client connections")
+	m.data.SetUnit("By")
+	m.data.SetDataType(pdata.MetricDataTypeGauge)
+	m.data.Gauge().DataPoints().EnsureCapacity(m.capacity)
+}
+
+func (m *metricNfsConnectionsQueueSize) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val int64, queueAttributeValue string) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Gauge().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetIntVal(val)
+	dp.Attributes().Insert(A.Queue, pdata.NewAttributeValueString(queueAttributeValue))
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricNfsConnectionsQueueSize) updateCapacity() {
+	if m.data.Gauge().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Gauge().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricNfsConnectionsQueueSize) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Gauge().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricNfsConnectionsQueueSize(settings MetricSettings) metricNfsConnectionsQueueSize {
+	m := metricNfsConnectionsQueueSize{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
+}
+
 // MetricsBuilder provides an interface for scrapers to report metrics while taking care of all the transformations
 // required to produce metric representation defined in metadata and user settings.
 type MetricsBuilder struct {
-	startTime            pdata.Timestamp
-	metricNfsConnections metricNfsConnections
+	startTime                     pdata.Timestamp
+	metricNfsClientConnections    metricNfsClientConnections
+	metricNfsClientRetransmits    metricNfsClientRetransmits
+	metricNfsClientRtt            metricNfsClientRtt
+	metricNfsClients              metricNfsClients
+	metricNfsConnections          metricNfsConnections
+	metricNfsConnectionsQueueSize metricNfsConnectionsQueueSize
 }
 
 // metricBuilderOption applies changes to default metrics builder.
@@ -110,8 +388,13 @@ func WithStartTime(startTime pdata.Timestamp) metricBuilderOption {
 
 func NewMetricsBuilder(settings MetricsSettings, options ...metricBuilderOption) *MetricsBuilder {
 	mb := &MetricsBuilder{
-		startTime:            pdata.NewTimestampFromTime(time.Now()),
-		metricNfsConnections: newMetricNfsConnections(settings.NfsConnections),
+		startTime:                     pdata.NewTimestampFromTime(time.Now()),
+		metricNfsClientConnections:    newMetricNfsClientConnections(settings.NfsClientConnections),
+		metricNfsClientRetransmits:    newMetricNfsClientRetransmits(settings.NfsClientRetransmits),
+		metricNfsClientRtt:            newMetricNfsClientRtt(settings.NfsClientRtt),
+		metricNfsClients:              newMetricNfsClients(settings.NfsClients),
+		metricNfsConnections:          newMetricNfsConnections(settings.NfsConnections),
+		metricNfsConnectionsQueueSize: newMetricNfsConnectionsQueueSize(settings.NfsConnectionsQueueSize),
 	}
 	for _, op := range options {
 		op(mb)
@@ -123,7 +406,32 @@ func NewMetricsBuilder(settings MetricsSettings, options ...metricBuilderOption)
 // another set of data points. This function will be doing all transformations required to produce metric representation
 // defined in metadata and user settings, e.g. delta/cumulative translation.
 func (mb *MetricsBuilder) Emit(metrics pdata.MetricSlice) {
+	mb.metricNfsClientConnections.emit(metrics)
+	mb.metricNfsClientRetransmits.emit(metrics)
+	mb.metricNfsClientRtt.emit(metrics)
+	mb.metricNfsClients.emit(metrics)
 	mb.metricNfsConnections.emit(metrics)
+	mb.metricNfsConnectionsQueueSize.emit(metrics)
+}
+
+// RecordNfsClientConnectionsDataPoint adds a data point to nfs.client.connections metric.
+func (mb *MetricsBuilder) RecordNfsClientConnectionsDataPoint(ts pdata.Timestamp, val int64, clientAttributeValue string) {
+	mb.metricNfsClientConnections.recordDataPoint(mb.startTime, ts, val, clientAttributeValue)
+}
+
+// RecordNfsClientRetransmitsDataPoint adds a data point to nfs.client.retransmits metric.
+func (mb *MetricsBuilder) RecordNfsClientRetransmitsDataPoint(ts pdata.Timestamp, val int64, clientAttributeValue string) {
+	mb.metricNfsClientRetransmits.recordDataPoint(mb.startTime, ts, val, clientAttributeValue)
+}
+
+// RecordNfsClientRttDataPoint adds a data point to nfs.client.rtt metric.
+func (mb *MetricsBuilder) RecordNfsClientRttDataPoint(ts pdata.Timestamp, val float64, clientAttributeValue string) {
+	mb.metricNfsClientRtt.recordDataPoint(mb.startTime, ts, val, clientAttributeValue)
+}
+
+// RecordNfsClientsDataPoint adds a data point to nfs.clients metric.
+func (mb *MetricsBuilder) RecordNfsClientsDataPoint(ts pdata.Timestamp, val int64) {
+	mb.metricNfsClients.recordDataPoint(mb.startTime, ts, val)
 }
 
 // RecordNfsConnectionsDataPoint adds a data point to nfs.connections metric.
@@ -131,6 +439,11 @@ func (mb *MetricsBuilder) RecordNfsConnectionsDataPoint(ts pdata.Timestamp, val
 	mb.metricNfsConnections.recordDataPoint(mb.startTime, ts, val)
 }
 
+// RecordNfsConnectionsQueueSizeDataPoint adds a data point to nfs.connections.queue_size metric.
+func (mb *MetricsBuilder) RecordNfsConnectionsQueueSizeDataPoint(ts pdata.Timestamp, val int64, queueAttributeValue string) {
+	mb.metricNfsConnectionsQueueSize.recordDataPoint(mb.startTime, ts, val, queueAttributeValue)
+}
+
 // Reset resets metrics builder to its initial state. It should be used when external metrics source is restarted,
 // and metrics builder should update its startTime and reset it's internal state accordingly.
 func (mb *MetricsBuilder) Reset(options ...metricBuilderOption) {
@@ -142,7 +455,23 @@ func (mb *MetricsBuilder) Reset(options ...metricBuilderOption) {
 
 // Attributes contains the possible metric attributes that can be used.
 var Attributes = struct {
-}{}
+	// Client (NFS client IP address)
+	Client string
+	// Queue (Socket queue)
+	Queue string
+}{
+	"client",
+	"queue",
+}
 
 // A is an alias for Attributes.
 var A = Attributes
+
+// AttributeQueue are the possible values that the attribute "queue" can have.
+var AttributeQueue = struct {
+	Send    string
+	Receive string
+}{
+	"send",
+	"receive",
+}
@@ -17,19 +17,19 @@
 package connections
 
 import (
-	"bufio"
-	"bytes"
 	"context"
-	"errors"
-	"fmt"
-	"os/exec"
+	"net/netip"
 	"time"
 
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-common/sockdiag"
 	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-metrics-agent/internal/connections/internal/metadata"
 	"go.opentelemetry.io/collector/model/pdata"
 	"go.opentelemetry.io/collector/receiver/scraperhelper"
 )
 
+// nfsPort is the port used by the NFS server.
+const nfsPort = 2049
+
 type scraper struct {
 	mb *metadata.MetricsBuilder
 }
@@ -45,8 +45,7 @@ func (s *scraper) scrape(ctx context.Context) (pdata.Metrics, error) {
 	md := pdata.NewMetrics()
 	now := pdata.NewTimestampFromTime(time.Now())
 
-	// TODO: Consider counting unique clients (by IP) as well as total connections
-	count, err := countConnectedClients(ctx)
+	conns, err := sockdiag.ReadTCPConnections(nfsPort)
 	if err != nil {
 		return md, err
 	}
@@ -55,41 +54,67 @@ func (s *scraper) scrape(ctx context.Context) (pdata.Metrics, error) {
 		InstrumentationLibraryMetrics().AppendEmpty().
 		Metrics()
 
-	s.mb.RecordNfsConnectionsDataPoint(now, count)
+	stats := summarizeConnections(conns)
+	s.mb.RecordNfsConnectionsDataPoint(now, int64(len(conns)))
+	s.mb.RecordNfsClientsDataPoint(now, int64(len(stats.clients)))
+	s.mb.RecordNfsConnectionsQueueSizeDataPoint(now, int64(stats.sendQueue), metadata.AttributeQueue.Send)
+	s.mb.RecordNfsConnectionsQueueSizeDataPoint(now, int64(stats.recvQueue), metadata.AttributeQueue.Receive)
+
+	for _, c := range stats.clients {
+		addr := c.addr.String()
+		s.mb.RecordNfsClientConnectionsDataPoint(now, c.connections, addr)
+		s.mb.RecordNfsClientRetransmitsDataPoint(now, c.retransmits, addr)
+		s.mb.RecordNfsClientRttDataPoint(now, c.rtt(), addr)
+	}
+
 	s.mb.Emit(metrics)
 	return md, nil
 }
 
-func countConnectedClients(ctx context.Context) (int64, error) {
-	var stdout, stderr bytes.Buffer
-	cmd := exec.CommandContext(ctx, "ss",
-		"--no-header", "--oneline", "--numeric",
-		"--tcp", "--udp",
-		"state", "established",
-		"sport", "2049",
-	)
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	err := cmd.Run()
+type connectionStats struct {
+	clients   []*clientStats
+	sendQueue uint64
+	recvQueue uint64
+}
 
-	if err != nil {
-		var exit *exec.ExitError
-		if errors.As(err, &exit) {
-			err = fmt.Errorf("command terminated with exit code %d\n%s", exit.ExitCode(), stderr.String())
-		}
-		return 0, err
-	}
+type clientStats struct {
+	addr        netip.Addr
+	connections int64
+	retransmits int64
+	// totalRTT is the sum of the smoothed RTT of each connection in
+	// microseconds.
+	totalRTT uint64
+}
 
-	var count int64
-	s := bufio.NewScanner(&stdout)
-	for s.Scan() {
-		count++
+// rtt returns the average RTT across the client's connections in
+// milliseconds.
+func (c *clientStats) rtt() float64 {
+	if c.connections == 0 {
+		return 0
 	}
+	return float64(c.totalRTT) / float64(c.connections) / 1000
+}
 
-	err = s.Err()
-	if err != nil {
-		return 0, err
-	}
+// summarizeConnections groups the connections by the client's IP address.
+// Clients are returned in the order they were first seen.
+func summarizeConnections(conns []sockdiag.TCPConnection) connectionStats {
+	var stats connectionStats
+	clients := make(map[netip.Addr]*clientStats)
+	for _, conn := range conns {
+		addr := conn.Remote.Addr()
+		c, found := clients[addr]
+		if !found {
+			c = &clientStats{addr: addr}
+			clients[addr] = c
+			stats.clients = append(stats.clients, c)
+		}
+
+		c.connections++
+		c.retransmits += int64(conn.Retransmits)
+		c.totalRTT += uint64(conn.RTT)
 
-	return count, nil
+		stats.sendQueue += uint64(conn.SendQueue)
+		stats.recvQueue += uint64(conn.RecvQueue)
+	}
+	return stats
 }
@@ -0,0 +1,74 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package connections
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-common/sockdiag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarizeConnections(t *testing.T) {
+	conns := []sockdiag.TCPConnection{
+		{
+			Remote:      netip.MustParseAddrPort("10.0.0.2:800"),
+			Retransmits: 2,
+			RTT:         1000,
+			SendQueue:   100,
+		},
+		{
+			Remote:    netip.MustParseAddrPort("10.0.0.3:800"),
+			RTT:       500,
+			RecvQueue: 20,
+		},
+		{
+			Remote:      netip.MustParseAddrPort("10.0.0.2:801"),
+			Retransmits: 3,
+			RTT:         2000,
+			SendQueue:   50,
+			RecvQueue:   10,
+		},
+	}
+
+	stats := summarizeConnections(conns)
+	assert.Equal(t, uint64(150), stats.sendQueue)
+	assert.Equal(t, uint64(30), stats.recvQueue)
+
+	require.Len(t, stats.clients, 2)
+
+	c := stats.clients[0]
+	assert.Equal(t, "10.0.0.2", c.addr.String())
+	assert.Equal(t, int64(2), c.connections)
+	assert.Equal(t, int64(5), c.retransmits)
+	assert.Equal(t, 1.5, c.rtt())
+
+	c = stats.clients[1]
+	assert.Equal(t, "10.0.0.3", c.addr.String())
+	assert.Equal(t, int64(1), c.connections)
+	assert.Equal(t, int64(0), c.retransmits)
+	assert.Equal(t, 0.5, c.rtt())
+}
+
+func TestSummarizeConnectionsEmpty(t *testing.T) {
+	stats := summarizeConnections(nil)
+	assert.Empty(t, stats.clients)
+	assert.Zero(t, stats.sendQueue)
+	assert.Zero(t, stats.recvQueue)
+}
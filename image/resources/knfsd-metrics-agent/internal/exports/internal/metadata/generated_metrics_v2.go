@@ -31,13 +31,37 @@ type MetricSettings struct {
 
 // MetricsSettings provides settings for exports metrics.
 type MetricsSettings struct {
-	NfsExportsTotalOperations MetricSettings `mapstructure:"nfs.exports.total_operations"`
-	NfsExportsTotalReadBytes  MetricSettings `mapstructure:"nfs.exports.total_read_bytes"`
-	NfsExportsTotalWriteBytes MetricSettings `mapstructure:"nfs.exports.total_write_bytes"`
+	NfsExportsNetworkPackets        MetricSettings `mapstructure:"nfs.exports.network.packets"`
+	NfsExportsNetworkTCPConnections MetricSettings `mapstructure:"nfs.exports.network.tcp_connections"`
+	NfsExportsOperations            MetricSettings `mapstructure:"nfs.exports.operations"`
+	NfsExportsReplyCache            MetricSettings `mapstructure:"nfs.exports.reply_cache"`
+	NfsExportsStaleFileHandles      MetricSettings `mapstructure:"nfs.exports.stale_file_handles"`
+	NfsExportsThreads               MetricSettings `mapstructure:"nfs.exports.threads"`
+	NfsExportsTotalOperations       MetricSettings `mapstructure:"nfs.exports.total_operations"`
+	NfsExportsTotalReadBytes        MetricSettings `mapstructure:"nfs.exports.total_read_bytes"`
+	NfsExportsTotalWriteBytes       MetricSettings `mapstructure:"nfs.exports.total_write_bytes"`
 }
 
 func DefaultMetricsSettings() MetricsSettings {
 	return MetricsSettings{
+		NfsExportsNetworkPackets: MetricSettings{
+			Enabled: true,
+		},
+		NfsExportsNetworkTCPConnections: MetricSettings{
+			Enabled: true,
+		},
+		NfsExportsOperations: MetricSettings{
+			Enabled: false,
+		},
+		NfsExportsReplyCache: MetricSettings{
+			Enabled: true,
+		},
+		NfsExportsStaleFileHandles: MetricSettings{
+			Enabled: true,
+		},
+		NfsExportsThreads: MetricSettings{
+			Enabled: true,
+		},
 		NfsExportsTotalOperations: MetricSettings{
 			Enabled: true,
 		},
@@ -50,6 +74,317 @@ func DefaultMetricsSettings() MetricsSettings {
 	}
 }
 
+type metricNfsExportsNetworkPackets struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills nfs.exports.network.packets metric with initial data.
+func (m *metricNfsExportsNetworkPackets) init() {
+	m.data.SetName("nfs.exports.network.packets")
+	m.data.SetDescription("Number of packets received by the NFS server")
+	m.data.SetUnit("{packets}")
+	m.data.SetDataType(pdata.MetricDataTypeSum)
+	m.data.Sum().SetIsMonotonic(true)
+	m.data.Sum().SetAggregationTemporality(pdata.MetricAggregationTemporalityCumulative)
+	m.data.Sum().DataPoints().EnsureCapacity(m.capacity)
+}
+
+func (m *metricNfsExportsNetworkPackets) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val int64, protocolAttributeValue string) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Sum().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetIntVal(val)
+	dp.Attributes().Insert(A.Protocol, pdata.NewAttributeValueString(protocolAttributeValue))
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricNfsExportsNetworkPackets) updateCapacity() {
+	if m.data.Sum().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Sum().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricNfsExportsNetworkPackets) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Sum().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricNfsExportsNetworkPackets(settings MetricSettings) metricNfsExportsNetworkPackets {
+	m := metricNfsExportsNetworkPackets{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
+}
+
+type metricNfsExportsNetworkTCPConnections struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills nfs.exports.network.tcp_connections metric with initial data.
+func (m *metricNfsExportsNetworkTCPConnections) init() {
+	m.data.SetName("nfs.exports.network.tcp_connections")
+	m.data.SetDescription("Number of TCP connections accepted by the NFS server")
+	m.data.SetUnit("{connections}")
+	m.data.SetDataType(pdata.MetricDataTypeSum)
+	m.data.Sum().SetIsMonotonic(true)
+	m.data.Sum().SetAggregationTemporality(pdata.MetricAggregationTemporalityCumulative)
+}
+
+func (m *metricNfsExportsNetworkTCPConnections) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val int64) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Sum().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetIntVal(val)
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricNfsExportsNetworkTCPConnections) updateCapacity() {
+	if m.data.Sum().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Sum().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricNfsExportsNetworkTCPConnections) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Sum().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricNfsExportsNetworkTCPConnections(settings MetricSettings) metricNfsExportsNetworkTCPConnections {
+	m := metricNfsExportsNetworkTCPConnections{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
+}
+
+type metricNfsExportsOperations struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills nfs.exports.operations metric with initial data.
+func (m *metricNfsExportsOperations) init() {
+	m.data.SetName("nfs.exports.operations")
+	m.data.SetDescription("Number of NFS operations received from clients")
+	m.data.SetUnit("{operations}")
+	m.data.SetDataType(pdata.MetricDataTypeSum)
+	m.data.Sum().SetIsMonotonic(true)
+	m.data.Sum().SetAggregationTemporality(pdata.MetricAggregationTemporalityCumulative)
+	m.data.Sum().DataPoints().EnsureCapacity(m.capacity)
+}
+
+func (m *metricNfsExportsOperations) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val int64, versionAttributeValue string, operationAttributeValue string) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Sum().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetIntVal(val)
+	dp.Attributes().Insert(A.Version, pdata.NewAttributeValueString(versionAttributeValue))
+	dp.Attributes().Insert(A.Operation, pdata.NewAttributeValueString(operationAttributeValue))
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricNfsExportsOperations) updateCapacity() {
+	if m.data.Sum().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Sum().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricNfsExportsOperations) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Sum().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricNfsExportsOperations(settings MetricSettings) metricNfsExportsOperations {
+	m := metricNfsExportsOperations{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
+}
+
+type metricNfsExportsReplyCache struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills nfs.exports.reply_cache metric with initial data.
+func (m *metricNfsExportsReplyCache) init() {
+	m.data.SetName("nfs.exports.reply_cache")
+	m.data.SetDescription("Number of requests checked against the NFS server's duplicate reply cache")
+	m.data.SetUnit("{requests}")
+	m.data.SetDataType(pdata.MetricDataTypeSum)
+	m.data.Sum().SetIsMonotonic(true)
+	m.data.Sum().SetAggregationTemporality(pdata.MetricAggregationTemporalityCumulative)
+	m.data.Sum().DataPoints().EnsureCapacity(m.capacity)
+}
+
+func (m *metricNfsExportsReplyCache) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val int64, resultAttributeValue string) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Sum().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetIntVal(val)
+	dp.Attributes().Insert(A.Result, pdata.NewAttributeValueString(resultAttributeValue))
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricNfsExportsReplyCache) updateCapacity() {
+	if m.data.Sum().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Sum().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricNfsExportsReplyCache) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Sum().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricNfsExportsReplyCache(settings MetricSettings) metricNfsExportsReplyCache {
+	m := metricNfsExportsReplyCache{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
+}
+
+type metricNfsExportsStaleFileHandles struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills nfs.exports.stale_file_handles metric with initial data.
+func (m *metricNfsExportsStaleFileHandles) init() {
+	m.data.SetName("nfs.exports.stale_file_handles")
+	m.data.SetDescription("Number of requests that used a stale file handle")
+	m.data.SetUnit("{requests}")
+	m.data.SetDataType(pdata.MetricDataTypeSum)
+	m.data.Sum().SetIsMonotonic(true)
+	m.data.Sum().SetAggregationTemporality(pdata.MetricAggregationTemporalityCumulative)
+}
+
+func (m *metricNfsExportsStaleFileHandles) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val int64) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Sum().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetIntVal(val)
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricNfsExportsStaleFileHandles) updateCapacity() {
+	if m.data.Sum().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Sum().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricNfsExportsStaleFileHandles) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Sum().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricNfsExportsStaleFileHandles(settings MetricSettings) metricNfsExportsStaleFileHandles {
+	m := metricNfsExportsStaleFileHandles{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
+}
+
+type metricNfsExportsThreads struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills nfs.exports.threads metric with initial data.
+func (m *metricNfsExportsThreads) init() {
+	m.data.SetName("nfs.exports.threads")
+	m.data.SetDescription("Number of nfsd threads")
+	m.data.SetUnit("{threads}")
+	m.data.SetDataType(pdata.MetricDataTypeGauge)
+}
+
+func (m *metricNfsExportsThreads) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val int64) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Gauge().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetIntVal(val)
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricNfsExportsThreads) updateCapacity() {
+	if m.data.Gauge().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Gauge().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricNfsExportsThreads) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Gauge().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricNfsExportsThreads(settings MetricSettings) metricNfsExportsThreads {
+	m := metricNfsExportsThreads{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
+}
+
 type metricNfsExportsTotalOperations struct {
 	data     pdata.Metric   // data buffer for generated metric.
 	settings MetricSettings // metric settings provided by user.
@@ -206,10 +541,16 @@ func newMetricNfsExportsTotalWriteBytes(settings MetricSettings) metricNfsExport
 // MetricsBuilder provides an interface for scrapers to report metrics while taking care of all the transformations
 // required to produce metric representation defined in metadata and user settings.
 type MetricsBuilder struct {
-	startTime                       pdata.Timestamp
-	metricNfsExportsTotalOperations metricNfsExportsTotalOperations
-	metricNfsExportsTotalReadBytes  metricNfsExportsTotalReadBytes
-	metricNfsExportsTotalWriteBytes metricNfsExportsTotalWriteBytes
+	startTime                             pdata.Timestamp
+	metricNfsExportsNetworkPackets        metricNfsExportsNetworkPackets
+	metricNfsExportsNetworkTCPConnections metricNfsExportsNetworkTCPConnections
+	metricNfsExportsOperations            metricNfsExportsOperations
+	metricNfsExportsReplyCache            metricNfsExportsReplyCache
+	metricNfsExportsStaleFileHandles      metricNfsExportsStaleFileHandles
+	metricNfsExportsThreads               metricNfsExportsThreads
+	metricNfsExportsTotalOperations       metricNfsExportsTotalOperations
+	metricNfsExportsTotalReadBytes        metricNfsExportsTotalReadBytes
+	metricNfsExportsTotalWriteBytes       metricNfsExportsTotalWriteBytes
 }
 
 // metricBuilderOption applies changes to default metrics builder.
@@ -224,10 +565,16 @@ func WithStartTime(startTime pdata.Timestamp) metricBuilderOption {
 
 func NewMetricsBuilder(settings MetricsSettings, options ...metricBuilderOption) *MetricsBuilder {
 	mb := &MetricsBuilder{
-		startTime:                       pdata.NewTimestampFromTime(time.Now()),
-		metricNfsExportsTotalOperations: newMetricNfsExportsTotalOperations(settings.NfsExportsTotalOperations),
-		metricNfsExportsTotalReadBytes:  newMetricNfsExportsTotalReadBytes(settings.NfsExportsTotalReadBytes),
-		metricNfsExportsTotalWriteBytes: newMetricNfsExportsTotalWriteBytes(settings.NfsExportsTotalWriteBytes),
+		startTime:                             pdata.NewTimestampFromTime(time.Now()),
+		metricNfsExportsNetworkPackets:        newMetricNfsExportsNetworkPackets(settings.NfsExportsNetworkPackets),
+		metricNfsExportsNetworkTCPConnections: newMetricNfsExportsNetworkTCPConnections(settings.NfsExportsNetworkTCPConnections),
+		metricNfsExportsOperations:            newMetricNfsExportsOperations(settings.NfsExportsOperations),
+		metricNfsExportsReplyCache:            newMetricNfsExportsReplyCache(settings.NfsExportsReplyCache),
+		metricNfsExportsStaleFileHandles:      newMetricNfsExportsStaleFileHandles(settings.NfsExportsStaleFileHandles),
+		metricNfsExportsThreads:               newMetricNfsExportsThreads(settings.NfsExportsThreads),
+		metricNfsExportsTotalOperations:       newMetricNfsExportsTotalOperations(settings.NfsExportsTotalOperations),
+		metricNfsExportsTotalReadBytes:        newMetricNfsExportsTotalReadBytes(settings.NfsExportsTotalReadBytes),
+		metricNfsExportsTotalWriteBytes:       newMetricNfsExportsTotalWriteBytes(settings.NfsExportsTotalWriteBytes),
 	}
 	for _, op := range options {
 		op(mb)
@@ -239,11 +586,47 @@ func NewMetricsBuilder(settings MetricsSettings, options ...metricBuilderOption)
 // another set of data points. This function will be doing all transformations required to produce metric representation
 // defined in metadata and user settings, e.g. delta/cumulative translation.
 func (mb *MetricsBuilder) Emit(metrics pdata.MetricSlice) {
+	mb.metricNfsExportsNetworkPackets.emit(metrics)
+	mb.metricNfsExportsNetworkTCPConnections.emit(metrics)
+	mb.metricNfsExportsOperations.emit(metrics)
+	mb.metricNfsExportsReplyCache.emit(metrics)
+	mb.metricNfsExportsStaleFileHandles.emit(metrics)
+	mb.metricNfsExportsThreads.emit(metrics)
 	mb.metricNfsExportsTotalOperations.emit(metrics)
 	mb.metricNfsExportsTotalReadBytes.emit(metrics)
 	mb.metricNfsExportsTotalWriteBytes.emit(metrics)
 }
 
+// RecordNfsExportsNetworkPacketsDataPoint adds a data point to nfs.exports.network.packets metric.
+func (mb *MetricsBuilder) RecordNfsExportsNetworkPacketsDataPoint(ts pdata.Timestamp, val int64, protocolAttributeValue string) {
+	mb.metricNfsExportsNetworkPackets.recordDataPoint(mb.startTime, ts, val, protocolAttributeValue)
+}
+
+// RecordNfsExportsNetworkTCPConnectionsDataPoint adds a data point to nfs.exports.network.tcp_connections metric.
+func (mb *MetricsBuilder) RecordNfsExportsNetworkTCPConnectionsDataPoint(ts pdata.Timestamp, val int64) {
+	mb.metricNfsExportsNetworkTCPConnections.recordDataPoint(mb.startTime, ts, val)
+}
+
+// RecordNfsExportsOperationsDataPoint adds a data point to nfs.exports.operations metric.
+func (mb *MetricsBuilder) RecordNfsExportsOperationsDataPoint(ts pdata.Timestamp, val int64, versionAttributeValue string, operationAttributeValue string) {
+	mb.metricNfsExportsOperations.recordDataPoint(mb.startTime, ts, val, versionAttributeValue, operationAttributeValue)
+}
+
+// RecordNfsExportsReplyCacheDataPoint adds a data point to nfs.exports.reply_cache metric.
+func (mb *MetricsBuilder) RecordNfsExportsReplyCacheDataPoint(ts pdata.Timestamp, val int64, resultAttributeValue string) {
+	mb.metricNfsExportsReplyCache.recordDataPoint(mb.startTime, ts, val, resultAttributeValue)
+}
+
+// RecordNfsExportsStaleFileHandlesDataPoint adds a data point to nfs.exports.stale_file_handles metric.
+func (mb *MetricsBuilder) RecordNfsExportsStaleFileHandlesDataPoint(ts pdata.Timestamp, val int64) {
+	mb.metricNfsExportsStaleFileHandles.recordDataPoint(mb.startTime, ts, val)
+}
+
+// RecordNfsExportsThreadsDataPoint adds a data point to nfs.exports.threads metric.
+func (mb *MetricsBuilder) RecordNfsExportsThreadsDataPoint(ts pdata.Timestamp, val int64) {
+	mb.metricNfsExportsThreads.recordDataPoint(mb.startTime, ts, val)
+}
+
 // RecordNfsExportsTotalOperationsDataPoint adds a data point to nfs.exports.total_operations metric.
 func (mb *MetricsBuilder) RecordNfsExportsTotalOperationsDataPoint(ts pdata.Timestamp, val int64) {
 	mb.metricNfsExportsTotalOperations.recordDataPoint(mb.startTime, ts, val)
@@ -270,7 +653,40 @@ func (mb *MetricsBuilder) Reset(options ...metricBuilderOption) {
 
 // Attributes contains the possible metric attributes that can be used.
 var Attributes = struct {
-}{}
+	// Operation (NFS operation name)
+	Operation string
+	// Protocol (Network protocol)
+	Protocol string
+	// Result (Reply cache result)
+	Result string
+	// Version (NFS protocol version)
+	Version string
+}{
+	"operation",
+	"protocol",
+	"result",
+	"version",
+}
 
 // A is an alias for Attributes.
 var A = Attributes
+
+// AttributeProtocol are the possible values that the attribute "protocol" can have.
+var AttributeProtocol = struct {
+	Tcp string
+	Udp string
+}{
+	"tcp",
+	"udp",
+}
+
+// AttributeResult are the possible values that the attribute "result" can have.
+var AttributeResult = struct {
+	Hit     string
+	Miss    string
+	Nocache string
+}{
+	"hit",
+	"miss",
+	"nocache",
+}
@@ -56,83 +56,118 @@ func (s *exportScraper) scrape(context.Context) (pdata.Metrics, error) {
 		InstrumentationLibraryMetrics().AppendEmpty().
 		Metrics()
 
-	ops := totalOperations(stats)
+	ops := operations(stats)
 
-	s.mb.RecordNfsExportsTotalOperationsDataPoint(now, convert.Int64(ops))
+	s.mb.RecordNfsExportsTotalOperationsDataPoint(now, convert.Int64(totalOperations(ops)))
 	s.mb.RecordNfsExportsTotalReadBytesDataPoint(now, convert.Int64(stats.InputOutput.Read))
 	s.mb.RecordNfsExportsTotalWriteBytesDataPoint(now, convert.Int64(stats.InputOutput.Write))
+
+	for _, op := range ops {
+		s.mb.RecordNfsExportsOperationsDataPoint(now, convert.Int64(op.count), op.version, op.name)
+	}
+
+	s.mb.RecordNfsExportsThreadsDataPoint(now, convert.Int64(stats.Threads.Threads))
+
+	s.mb.RecordNfsExportsReplyCacheDataPoint(now, convert.Int64(stats.ReplyCache.Hits), metadata.AttributeResult.Hit)
+	s.mb.RecordNfsExportsReplyCacheDataPoint(now, convert.Int64(stats.ReplyCache.Misses), metadata.AttributeResult.Miss)
+	s.mb.RecordNfsExportsReplyCacheDataPoint(now, convert.Int64(stats.ReplyCache.NoCache), metadata.AttributeResult.Nocache)
+
+	s.mb.RecordNfsExportsStaleFileHandlesDataPoint(now, convert.Int64(stats.FileHandles.Stale))
+
+	s.mb.RecordNfsExportsNetworkPacketsDataPoint(now, convert.Int64(stats.Network.TCPCount), metadata.AttributeProtocol.Tcp)
+	s.mb.RecordNfsExportsNetworkPacketsDataPoint(now, convert.Int64(stats.Network.UDPCount), metadata.AttributeProtocol.Udp)
+	s.mb.RecordNfsExportsNetworkTCPConnectionsDataPoint(now, convert.Int64(stats.Network.TCPConnect))
+
 	s.mb.Emit(metrics)
 	return md, nil
 }
 
-func totalOperations(stats *nfs.ServerRPCStats) uint64 {
-	var ops uint64
+type operation struct {
+	version string
+	name    string
+	count   uint64
+}
 
-	// Total up all the operations. Not using stats.ServerRPC.RPCCount because
-	// NFSv4 can have multiple operations in a single RPC call as NFSv4 only has
-	// two RPC calls, NULL and COMPOUND.
+// operations lists the count of each NFS operation, using the operation names
+// from the NFS RFCs.
+func operations(stats *nfs.ServerRPCStats) []operation {
+	// Not using stats.ServerRPC.RPCCount because NFSv4 can have multiple
+	// operations in a single RPC call as NFSv4 only has two RPC calls, NULL and
+	// COMPOUND.
 	// ignore V2Stats, NFS v2 is obsolete
+	v3 := stats.V3Stats
+	v4 := stats.V4Ops
+	return []operation{
+		{"3", "NULL", v3.Null},
+		{"3", "GETATTR", v3.GetAttr},
+		{"3", "SETATTR", v3.SetAttr},
+		{"3", "LOOKUP", v3.Lookup},
+		{"3", "ACCESS", v3.Access},
+		{"3", "READLINK", v3.ReadLink},
+		{"3", "READ", v3.Read},
+		{"3", "WRITE", v3.Write},
+		{"3", "CREATE", v3.Create},
+		{"3", "MKDIR", v3.MkDir},
+		{"3", "SYMLINK", v3.SymLink},
+		{"3", "MKNOD", v3.MkNod},
+		{"3", "REMOVE", v3.Remove},
+		{"3", "RMDIR", v3.RmDir},
+		{"3", "RENAME", v3.Rename},
+		{"3", "LINK", v3.Link},
+		{"3", "READDIR", v3.ReadDir},
+		{"3", "READDIRPLUS", v3.ReadDirPlus},
+		{"3", "FSSTAT", v3.FsStat},
+		{"3", "FSINFO", v3.FsInfo},
+		{"3", "PATHCONF", v3.PathConf},
+		{"3", "COMMIT", v3.Commit},
+
+		{"4", "NULL", stats.ServerV4Stats.Null},
+		// ignore ServerV4Stats.Compound as it only groups the operations below
+		{"4", "ACCESS", v4.Access},
+		{"4", "CLOSE", v4.Close},
+		{"4", "COMMIT", v4.Commit},
+		{"4", "CREATE", v4.Create},
+		{"4", "DELEGPURGE", v4.DelegPurge},
+		{"4", "DELEGRETURN", v4.DelegReturn},
+		{"4", "GETATTR", v4.GetAttr},
+		{"4", "GETFH", v4.GetFH},
+		{"4", "LINK", v4.Link},
+		{"4", "LOCK", v4.Lock},
+		{"4", "LOCKT", v4.Lockt},
+		{"4", "LOCKU", v4.Locku},
+		{"4", "LOOKUP", v4.Lookup},
+		// procfs names operation 16 LookupRoot, but it is LOOKUPP (lookup parent)
+		{"4", "LOOKUPP", v4.LookupRoot},
+		{"4", "NVERIFY", v4.Nverify},
+		{"4", "OPEN", v4.Open},
+		{"4", "OPENATTR", v4.OpenAttr},
+		{"4", "OPEN_CONFIRM", v4.OpenConfirm},
+		{"4", "OPEN_DOWNGRADE", v4.OpenDgrd},
+		{"4", "PUTFH", v4.PutFH},
+		{"4", "PUTPUBFH", v4.PutPubFH},
+		{"4", "PUTROOTFH", v4.PutRootFH},
+		{"4", "READ", v4.Read},
+		{"4", "READDIR", v4.ReadDir},
+		{"4", "READLINK", v4.ReadLink},
+		{"4", "REMOVE", v4.Remove},
+		{"4", "RENAME", v4.Rename},
+		{"4", "RENEW", v4.Renew},
+		{"4", "RESTOREFH", v4.RestoreFH},
+		{"4", "SAVEFH", v4.SaveFH},
+		{"4", "SECINFO", v4.SecInfo},
+		{"4", "SETATTR", v4.SetAttr},
+		{"4", "SETCLIENTID", v4.SetClientID},
+		{"4", "SETCLIENTID_CONFIRM", v4.SetClientIDConfirm},
+		{"4", "VERIFY", v4.Verify},
+		{"4", "WRITE", v4.Write},
+		{"4", "RELEASE_LOCKOWNER", v4.RelLockOwner},
+	}
+}
 
-	ops += stats.V3Stats.Null
-	ops += stats.V3Stats.GetAttr
-	ops += stats.V3Stats.SetAttr
-	ops += stats.V3Stats.Lookup
-	ops += stats.V3Stats.Access
-	ops += stats.V3Stats.ReadLink
-	ops += stats.V3Stats.Read
-	ops += stats.V3Stats.Write
-	ops += stats.V3Stats.Create
-	ops += stats.V3Stats.MkDir
-	ops += stats.V3Stats.SymLink
-	ops += stats.V3Stats.MkNod
-	ops += stats.V3Stats.Remove
-	ops += stats.V3Stats.RmDir
-	ops += stats.V3Stats.Rename
-	ops += stats.V3Stats.Link
-	ops += stats.V3Stats.ReadDir
-	ops += stats.V3Stats.ReadDirPlus
-	ops += stats.V3Stats.FsStat
-	ops += stats.V3Stats.FsInfo
-	ops += stats.V3Stats.PathConf
-	ops += stats.V3Stats.Commit
-
-	ops += stats.ServerV4Stats.Null
-	// ignore ServerV4Stats.Compound as it only groups the operations below
-	ops += stats.V4Ops.Access
-	ops += stats.V4Ops.Close
-	ops += stats.V4Ops.Commit
-	ops += stats.V4Ops.Create
-	ops += stats.V4Ops.DelegPurge
-	ops += stats.V4Ops.DelegReturn
-	ops += stats.V4Ops.GetAttr
-	ops += stats.V4Ops.GetFH
-	ops += stats.V4Ops.Link
-	ops += stats.V4Ops.Lock
-	ops += stats.V4Ops.Lockt
-	ops += stats.V4Ops.Locku
-	ops += stats.V4Ops.Lookup
-	ops += stats.V4Ops.LookupRoot
-	ops += stats.V4Ops.Nverify
-	ops += stats.V4Ops.Open
-	ops += stats.V4Ops.OpenAttr
-	ops += stats.V4Ops.OpenConfirm
-	ops += stats.V4Ops.OpenDgrd
-	ops += stats.V4Ops.PutFH
-	ops += stats.V4Ops.PutPubFH
-	ops += stats.V4Ops.PutRootFH
-	ops += stats.V4Ops.Read
-	ops += stats.V4Ops.ReadDir
-	ops += stats.V4Ops.ReadLink
-	ops += stats.V4Ops.Remove
-	ops += stats.V4Ops.Rename
-	ops += stats.V4Ops.Renew
-	ops += stats.V4Ops.RestoreFH
-	ops += stats.V4Ops.SaveFH
-	ops += stats.V4Ops.SecInfo
-	ops += stats.V4Ops.SetAttr
-	ops += stats.V4Ops.Verify
-	ops += stats.V4Ops.Write
-	ops += stats.V4Ops.RelLockOwner
-
-	return ops
+func totalOperations(ops []operation) uint64 {
+	var total uint64
+	for _, op := range ops {
+		total += op.count
+	}
+	return total
 }
@@ -0,0 +1,56 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package exports
+
+import (
+	"testing"
+
+	"github.com/prometheus/procfs/nfs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperations(t *testing.T) {
+	stats := &nfs.ServerRPCStats{}
+	stats.V3Stats.GetAttr = 10
+	stats.V3Stats.ReadDirPlus = 5
+	stats.ServerV4Stats.Null = 1
+	stats.ServerV4Stats.Compound = 100
+	stats.V4Ops.GetAttr = 20
+	stats.V4Ops.LookupRoot = 2
+	stats.V4Ops.RelLockOwner = 3
+
+	counts := make(map[string]uint64)
+	ops := operations(stats)
+	for _, op := range ops {
+		key := op.version + "/" + op.name
+		_, found := counts[key]
+		assert.False(t, found, "duplicate operation %s", key)
+		counts[key] = op.count
+	}
+
+	assert.Equal(t, uint64(10), counts["3/GETATTR"])
+	assert.Equal(t, uint64(5), counts["3/READDIRPLUS"])
+	assert.Equal(t, uint64(0), counts["3/READ"])
+	assert.Equal(t, uint64(1), counts["4/NULL"])
+	assert.Equal(t, uint64(20), counts["4/GETATTR"])
+	assert.Equal(t, uint64(2), counts["4/LOOKUPP"])
+	assert.Equal(t, uint64(3), counts["4/RELEASE_LOCKOWNER"])
+
+	// COMPOUND only groups the other operations, so is not counted.
+	assert.NotContains(t, counts, "4/COMPOUND")
+	assert.Equal(t, uint64(41), totalOperations(ops))
+}
@@ -0,0 +1,557 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+ */
+
+// Code generated by mdatagen. DO NOT EDIT.
+
+package metadata
+
+import (
+	"time"
+
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+// MetricSettings provides common settings for a particular metric.
+type MetricSettings struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// MetricsSettings provides settings for fscache metrics.
+type MetricsSettings struct {
+	FscacheAllocationFailures MetricSettings `mapstructure:"fscache.allocation_failures"`
+	FscacheCookies            MetricSettings `mapstructure:"fscache.cookies"`
+	FscacheCulls              MetricSettings `mapstructure:"fscache.culls"`
+	FscacheHitRatio           MetricSettings `mapstructure:"fscache.hit_ratio"`
+	FscacheIoErrors           MetricSettings `mapstructure:"fscache.io_errors"`
+	FscacheRetrievals         MetricSettings `mapstructure:"fscache.retrievals"`
+	FscacheStores             MetricSettings `mapstructure:"fscache.stores"`
+}
+
+func DefaultMetricsSettings() MetricsSettings {
+	return MetricsSettings{
+		FscacheAllocationFailures: MetricSettings{
+			Enabled: true,
+		},
+		FscacheCookies: MetricSettings{
+			Enabled: true,
+		},
+		FscacheCulls: MetricSettings{
+			Enabled: true,
+		},
+		FscacheHitRatio: MetricSettings{
+			Enabled: true,
+		},
+		FscacheIoErrors: MetricSettings{
+			Enabled: true,
+		},
+		FscacheRetrievals: MetricSettings{
+			Enabled: true,
+		},
+		FscacheStores: MetricSettings{
+			Enabled: true,
+		},
+	}
+}
+
+type metricFscacheAllocationFailures struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills fscache.allocation_failures metric with initial data.
+func (m *metricFscacheAllocationFailures) init() {
+	m.data.SetName("fscache.allocation_failures")
+	m.data.SetDescription("Number of times cachefiles could not write or create a file because the cache was out of space")
+	m.data.SetUnit("{failures}")
+	m.data.SetDataType(pdata.MetricDataTypeSum)
+	m.data.Sum().SetIsMonotonic(true)
+	m.data.Sum().SetAggregationTemporality(pdata.MetricAggregationTemporalityCumulative)
+	m.data.Sum().DataPoints().EnsureCapacity(m.capacity)
+}
+
+func (m *metricFscacheAllocationFailures) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val int64, operationAttributeValue string) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Sum().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetIntVal(val)
+	dp.Attributes().Insert(A.Operation, pdata.NewAttributeValueString(operationAttributeValue))
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricFscacheAllocationFailures) updateCapacity() {
+	if m.data.Sum().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Sum().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricFscacheAllocationFailures) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Sum().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricFscacheAllocationFailures(settings MetricSettings) metricFscacheAllocationFailures {
+	m := metricFscacheAllocationFailures{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
+}
+
+type metricFscacheCookies struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills fscache.cookies metric with initial data.
+func (m *metricFscacheCookies) init() {
+	m.data.SetName("fscache.cookies")
+	m.data.SetDescription("Number of data cookies (cached files) in use")
+	m.data.SetUnit("{cookies}")
+	m.data.SetDataType(pdata.MetricDataTypeGauge)
+}
+
+func (m *metricFscacheCookies) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val int64) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Gauge().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetIntVal(val)
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricFscacheCookies) updateCapacity() {
+	if m.data.Gauge().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Gauge().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricFscacheCookies) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Gauge().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricFscacheCookies(settings MetricSettings) metricFscacheCookies {
+	m := metricFscacheCookies{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
+}
+
+type metricFscacheCulls struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills fscache.culls metric with initial data.
+func (m *metricFscacheCulls) init() {
+	m.data.SetName("fscache.culls")
+	m.data.SetDescription("Number of files culled from the cache to free space")
+	m.data.SetUnit("{files}")
+	m.data.SetDataType(pdata.MetricDataTypeSum)
+	m.data.Sum().SetIsMonotonic(true)
+	m.data.Sum().SetAggregationTemporality(pdata.MetricAggregationTemporalityCumulative)
+}
+
+func (m *metricFscacheCulls) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val int64) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Sum().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetIntVal(val)
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricFscacheCulls) updateCapacity() {
+	if m.data.Sum().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Sum().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricFscacheCulls) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Sum().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricFscacheCulls(settings MetricSettings) metricFscacheCulls {
+	m := metricFscacheCulls{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
+}
+
+type metricFscacheHitRatio struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills fscache.hit_ratio metric with initial data.
+func (m *metricFscacheHitRatio) init() {
+	m.data.SetName("fscache.hit_ratio")
+	m.data.SetDescription("Ratio of reads that were read from FS-Cache since the previous scrape")
+	m.data.SetUnit("1")
+	m.data.SetDataType(pdata.MetricDataTypeGauge)
+}
+
+func (m *metricFscacheHitRatio) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val float64) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Gauge().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetDoubleVal(val)
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricFscacheHitRatio) updateCapacity() {
+	if m.data.Gauge().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Gauge().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricFscacheHitRatio) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Gauge().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricFscacheHitRatio(settings MetricSettings) metricFscacheHitRatio {
+	m := metricFscacheHitRatio{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
+}
+
+type metricFscacheIoErrors struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills fscache.io_errors metric with initial data.
+func (m *metricFscacheIoErrors) init() {
+	m.data.SetName("fscache.io_errors")
+	m.data.SetDescription("Number of failed reads from, or writes to, FS-Cache")
+	m.data.SetUnit("{errors}")
+	m.data.SetDataType(pdata.MetricDataTypeSum)
+	m.data.Sum().SetIsMonotonic(true)
+	m.data.Sum().SetAggregationTemporality(pdata.MetricAggregationTemporalityCumulative)
+	m.data.Sum().DataPoints().EnsureCapacity(m.capacity)
+}
+
+func (m *metricFscacheIoErrors) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val int64, operationAttributeValue string) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Sum().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetIntVal(val)
+	dp.Attributes().Insert(A.Operation, pdata.NewAttributeValueString(operationAttributeValue))
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricFscacheIoErrors) updateCapacity() {
+	if m.data.Sum().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Sum().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricFscacheIoErrors) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Sum().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricFscacheIoErrors(settings MetricSettings) metricFscacheIoErrors {
+	m := metricFscacheIoErrors{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
+}
+
+type metricFscacheRetrievals struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills fscache.retrievals metric with initial data.
+func (m *metricFscacheRetrievals) init() {
+	m.data.SetName("fscache.retrievals")
+	m.data.SetDescription("Number of reads from FS-Cache, and reads from the NFS server for data not in the cache")
+	m.data.SetUnit("{reads}")
+	m.data.SetDataType(pdata.MetricDataTypeSum)
+	m.data.Sum().SetIsMonotonic(true)
+	m.data.Sum().SetAggregationTemporality(pdata.MetricAggregationTemporalityCumulative)
+	m.data.Sum().DataPoints().EnsureCapacity(m.capacity)
+}
+
+func (m *metricFscacheRetrievals) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val int64, resultAttributeValue string) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Sum().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetIntVal(val)
+	dp.Attributes().Insert(A.Result, pdata.NewAttributeValueString(resultAttributeValue))
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricFscacheRetrievals) updateCapacity() {
+	if m.data.Sum().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Sum().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricFscacheRetrievals) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Sum().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricFscacheRetrievals(settings MetricSettings) metricFscacheRetrievals {
+	m := metricFscacheRetrievals{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
+}
+
+type metricFscacheStores struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills fscache.stores metric with initial data.
+func (m *metricFscacheStores) init() {
+	m.data.SetName("fscache.stores")
+	m.data.SetDescription("Number of writes to FS-Cache of data downloaded from the NFS server")
+	m.data.SetUnit("{writes}")
+	m.data.SetDataType(pdata.MetricDataTypeSum)
+	m.data.Sum().SetIsMonotonic(true)
+	m.data.Sum().SetAggregationTemporality(pdata.MetricAggregationTemporalityCumulative)
+}
+
+func (m *metricFscacheStores) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val int64) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Sum().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetIntVal(val)
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricFscacheStores) updateCapacity() {
+	if m.data.Sum().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Sum().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricFscacheStores) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Sum().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricFscacheStores(settings MetricSettings) metricFscacheStores {
+	m := metricFscacheStores{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
+}
+
+// MetricsBuilder provides an interface for scrapers to report metrics while taking care of all the transformations
+// required to produce metric representation defined in metadata and user settings.
+type MetricsBuilder struct {
+	startTime                       pdata.Timestamp
+	metricFscacheAllocationFailures metricFscacheAllocationFailures
+	metricFscacheCookies            metricFscacheCookies
+	metricFscacheCulls              metricFscacheCulls
+	metricFscacheHitRatio           metricFscacheHitRatio
+	metricFscacheIoErrors           metricFscacheIoErrors
+	metricFscacheRetrievals         metricFscacheRetrievals
+	metricFscacheStores             metricFscacheStores
+}
+
+// metricBuilderOption applies changes to default metrics builder.
+type metricBuilderOption func(*MetricsBuilder)
+
+// WithStartTime sets startTime on the metrics builder.
+func WithStartTime(startTime pdata.Timestamp) metricBuilderOption {
+	return func(mb *MetricsBuilder) {
+		mb.startTime = startTime
+	}
+}
+
+func NewMetricsBuilder(settings MetricsSettings, options ...metricBuilderOption) *MetricsBuilder {
+	mb := &MetricsBuilder{
+		startTime:                       pdata.NewTimestampFromTime(time.Now()),
+		metricFscacheAllocationFailures: newMetricFscacheAllocationFailures(settings.FscacheAllocationFailures),
+		metricFscacheCookies:            newMetricFscacheCookies(settings.FscacheCookies),
+		metricFscacheCulls:              newMetricFscacheCulls(settings.FscacheCulls),
+		metricFscacheHitRatio:           newMetricFscacheHitRatio(settings.FscacheHitRatio),
+		metricFscacheIoErrors:           newMetricFscacheIoErrors(settings.FscacheIoErrors),
+		metricFscacheRetrievals:         newMetricFscacheRetrievals(settings.FscacheRetrievals),
+		metricFscacheStores:             newMetricFscacheStores(settings.FscacheStores),
+	}
+	for _, op := range options {
+		op(mb)
+	}
+	return mb
+}
+
+// Emit appends generated metrics to a pdata.MetricsSlice and updates the internal state to be ready for recording
+// another set of data points. This function will be doing all transformations required to produce metric representation
+// defined in metadata and user settings, e.g. delta/cumulative translation.
+func (mb *MetricsBuilder) Emit(metrics pdata.MetricSlice) {
+	mb.metricFscacheAllocationFailures.emit(metrics)
+	mb.metricFscacheCookies.emit(metrics)
+	mb.metricFscacheCulls.emit(metrics)
+	mb.metricFscacheHitRatio.emit(metrics)
+	mb.metricFscacheIoErrors.emit(metrics)
+	mb.metricFscacheRetrievals.emit(metrics)
+	mb.metricFscacheStores.emit(metrics)
+}
+
+// RecordFscacheAllocationFailuresDataPoint adds a data point to fscache.allocation_failures metric.
+func (mb *MetricsBuilder) RecordFscacheAllocationFailuresDataPoint(ts pdata.Timestamp, val int64, operationAttributeValue string) {
+	mb.metricFscacheAllocationFailures.recordDataPoint(mb.startTime, ts, val, operationAttributeValue)
+}
+
+// RecordFscacheCookiesDataPoint adds a data point to fscache.cookies metric.
+func (mb *MetricsBuilder) RecordFscacheCookiesDataPoint(ts pdata.Timestamp, val int64) {
+	mb.metricFscacheCookies.recordDataPoint(mb.startTime, ts, val)
+}
+
+// RecordFscacheCullsDataPoint adds a data point to fscache.culls metric.
+func (mb *MetricsBuilder) RecordFscacheCullsDataPoint(ts pdata.Timestamp, val int64) {
+	mb.metricFscacheCulls.recordDataPoint(mb.startTime, ts, val)
+}
+
+// RecordFscacheHitRatioDataPoint adds a data point to fscache.hit_ratio metric.
+func (mb *MetricsBuilder) RecordFscacheHitRatioDataPoint(ts pdata.Timestamp, val float64) {
+	mb.metricFscacheHitRatio.recordDataPoint(mb.startTime, ts, val)
+}
+
+// RecordFscacheIoErrorsDataPoint adds a data point to fscache.io_errors metric.
+func (mb *MetricsBuilder) RecordFscacheIoErrorsDataPoint(ts pdata.Timestamp, val int64, operationAttributeValue string) {
+	mb.metricFscacheIoErrors.recordDataPoint(mb.startTime, ts, val, operationAttributeValue)
+}
+
+// RecordFscacheRetrievalsDataPoint adds a data point to fscache.retrievals metric.
+func (mb *MetricsBuilder) RecordFscacheRetrievalsDataPoint(ts pdata.Timestamp, val int64, resultAttributeValue string) {
+	mb.metricFscacheRetrievals.recordDataPoint(mb.startTime, ts, val, resultAttributeValue)
+}
+
+// RecordFscacheStoresDataPoint adds a data point to fscache.stores metric.
+func (mb *MetricsBuilder) RecordFscacheStoresDataPoint(ts pdata.Timestamp, val int64) {
+	mb.metricFscacheStores.recordDataPoint(mb.startTime, ts, val)
+}
+
+// Reset resets metrics builder to its initial state. It should be used when external metrics source is restarted,
+// and metrics builder should update its startTime and reset it's internal state accordingly.
+func (mb *MetricsBuilder) Reset(options ...metricBuilderOption) {
+	mb.startTime = pdata.NewTimestampFromTime(time.Now())
+	for _, op := range options {
+		op(mb)
+	}
+}
+
+// Attributes contains the possible metric attributes that can be used.
+var Attributes = struct {
+	// Operation (Cache operation)
+	Operation string
+	// Result (Whether the data was read from the cache (hit) or downloaded from the NFS server (miss))
+	Result string
+}{
+	"operation",
+	"result",
+}
+
+// A is an alias for Attributes.
+var A = Attributes
+
+// AttributeOperation are the possible values that the attribute "operation" can have.
+var AttributeOperation = struct {
+	Read   string
+	Write  string
+	Create string
+}{
+	"read",
+	"write",
+	"create",
+}
+
+// AttributeResult are the possible values that the attribute "result" can have.
+var AttributeResult = struct {
+	Hit  string
+	Miss string
+}{
+	"hit",
+	"miss",
+}
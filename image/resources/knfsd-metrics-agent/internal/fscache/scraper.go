@@ -0,0 +1,154 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package fscache
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	fscachestats "github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-common/fscache"
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-metrics-agent/convert"
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-metrics-agent/internal/fscache/internal/metadata"
+	"go.opentelemetry.io/collector/model/pdata"
+	"go.opentelemetry.io/collector/receiver/scraperhelper"
+)
+
+type fscacheScraper struct {
+	path string
+	mb   *metadata.MetricsBuilder
+	// prev is used to calculate the hit ratio since the previous scrape.
+	prev *fscacheStats
+}
+
+func newScraper(cfg *Config) (scraperhelper.Scraper, error) {
+	s := &fscacheScraper{
+		path: fscachestats.StatsFile,
+		mb:   metadata.NewMetricsBuilder(cfg.Metrics),
+	}
+	return scraperhelper.NewScraper(typeStr, s.scrape)
+}
+
+func (s *fscacheScraper) scrape(context.Context) (pdata.Metrics, error) {
+	md := pdata.NewMetrics()
+
+	stats, err := readStats(s.path)
+	if err != nil {
+		return md, err
+	}
+
+	now := pdata.NewTimestampFromTime(time.Now())
+	metrics := md.ResourceMetrics().AppendEmpty().
+		InstrumentationLibraryMetrics().AppendEmpty().
+		Metrics()
+
+	s.mb.RecordFscacheRetrievalsDataPoint(now, convert.Int64(stats.hits), metadata.AttributeResult.Hit)
+	s.mb.RecordFscacheRetrievalsDataPoint(now, convert.Int64(stats.misses), metadata.AttributeResult.Miss)
+	if ratio, ok := hitRatio(s.prev, stats); ok {
+		s.mb.RecordFscacheHitRatioDataPoint(now, ratio)
+	}
+
+	s.mb.RecordFscacheStoresDataPoint(now, convert.Int64(stats.stores))
+	s.mb.RecordFscacheCullsDataPoint(now, convert.Int64(stats.culls))
+	s.mb.RecordFscacheAllocationFailuresDataPoint(now, convert.Int64(stats.noSpaceWrites), metadata.AttributeOperation.Write)
+	s.mb.RecordFscacheAllocationFailuresDataPoint(now, convert.Int64(stats.noSpaceCreates), metadata.AttributeOperation.Create)
+	s.mb.RecordFscacheIoErrorsDataPoint(now, convert.Int64(stats.readErrors), metadata.AttributeOperation.Read)
+	s.mb.RecordFscacheIoErrorsDataPoint(now, convert.Int64(stats.writeErrors), metadata.AttributeOperation.Write)
+	s.mb.RecordFscacheCookiesDataPoint(now, convert.Int64(stats.cookies))
+
+	s.prev = stats
+	s.mb.Emit(metrics)
+	return md, nil
+}
+
+// fscacheStats are the counters from /proc/fs/fscache/stats used for the
+// metrics. The cachefiles backend reports the culling and out of space events
+// using the same file.
+type fscacheStats struct {
+	cookies uint64
+
+	// hits are reads from the cache, misses are downloads from the NFS server.
+	hits   uint64
+	misses uint64
+	// stores are writes to the cache of data downloaded from the NFS server.
+	// These are the WR counters, the UL counters are uploads to the NFS
+	// server.
+	stores uint64
+
+	readErrors  uint64
+	writeErrors uint64
+
+	culls          uint64
+	noSpaceWrites  uint64
+	noSpaceCreates uint64
+}
+
+func readStats(path string) (*fscacheStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseStats(f)
+}
+
+// parseStats parses the stats file, returning the counters used for the
+// metrics.
+func parseStats(r io.Reader) (*fscacheStats, error) {
+	stats, err := fscachestats.ParseStats(r)
+	if err != nil {
+		return nil, err
+	}
+
+	netfs, err := stats.Netfs()
+	if err != nil {
+		return nil, err
+	}
+
+	return &fscacheStats{
+		cookies:        stats.Get("Cookies", "n"),
+		hits:           netfs.CacheReads,
+		misses:         netfs.Downloads,
+		stores:         netfs.CacheWrites,
+		readErrors:     netfs.CacheReadsFailed,
+		writeErrors:    netfs.CacheWritesFailed,
+		culls:          stats.Get("NoSpace", "cull"),
+		noSpaceWrites:  stats.Get("NoSpace", "nwr"),
+		noSpaceCreates: stats.Get("NoSpace", "ncr"),
+	}, nil
+}
+
+// hitRatio returns the ratio of reads that were cache hits between the two
+// samples. ok is false if there is no previous sample, or there were no reads.
+func hitRatio(prev, cur *fscacheStats) (ratio float64, ok bool) {
+	if prev == nil {
+		return 0, false
+	}
+	// A counter going backwards means the module was reloaded, treat this
+	// like the first sample.
+	if cur.hits < prev.hits || cur.misses < prev.misses {
+		return 0, false
+	}
+
+	hits := cur.hits - prev.hits
+	total := hits + (cur.misses - prev.misses)
+	if total == 0 {
+		return 0, false
+	}
+	return float64(hits) / float64(total), true
+}
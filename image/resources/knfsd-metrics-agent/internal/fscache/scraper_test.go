@@ -0,0 +1,117 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package fscache
+
+import (
+	"strings"
+	"testing"
+
+	fscachestats "github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-common/fscache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStats is /proc/fs/fscache/stats from a proxy running a 6.8 kernel, the
+// netfs counters are in repeated Netfs sections.
+const testStats = `Netfs  : DR=0 RA=20544 RF=0 WB=0 WBZ=0
+Netfs  : BW=0 WT=0 DW=0 WP=0
+Netfs  : ZR=12 sh=0 sk=0
+Netfs  : DL=9120 ds=9118 df=2 di=0
+Netfs  : RD=48211 rs=48208 rf=3
+Netfs  : UL=0 us=0 uf=0
+Netfs  : WR=9118 ws=9114 wf=4
+Netfs  : rr=0 sr=0 wsc=0
+-- FS-Cache statistics --
+Cookies: n=1532 v=2 vcol=0 voom=0
+Acquire: n=1840 ok=1840 oom=0
+LRU    : n=305 exp=120 rmv=4 drp=116 at=2
+Invals : n=3
+Updates: rsz=0 rsn=0
+Relinqs: n=308 rtr=0 drop=308
+NoSpace: nwr=12 ncr=1 cull=96
+IO     : rd=48211 wr=9118 mis=0
+`
+
+// testStatsHWE is /proc/fs/fscache/stats from a proxy running the 6.11 HWE
+// kernel used by the image. Kernels from 6.10 name each netfs section after the
+// operation.
+const testStatsHWE = `Reads  : DR=0 RA=20544 RF=0 RS=0 WB=0 WBZ=0
+Writes : BW=0 WT=0 DW=0 WP=0 2C=0
+ZeroOps: ZR=12 sh=0 sk=0
+DownOps: DL=9120 ds=9118 df=2 di=0
+CaRdOps: RD=48211 rs=48208 rf=3
+UpldOps: UL=0 us=0 uf=0
+CaWrOps: WR=9118 ws=9114 wf=4
+Objs   : rr=0 sr=0 foq=0 wsc=0
+WbLock : skip=0 wait=0
+-- FS-Cache statistics --
+Cookies: n=1532 v=2 vcol=0 voom=0
+Acquire: n=1840 ok=1840 oom=0
+LRU    : n=305 exp=120 rmv=4 drp=116 at=2
+Invals : n=3
+Updates: rsz=0 rsn=0
+Relinqs: n=308 rtr=0 drop=308
+NoSpace: nwr=12 ncr=1 cull=96
+IO     : rd=48211 wr=9118 mis=0
+`
+
+func TestParseStats(t *testing.T) {
+	expected := &fscacheStats{
+		cookies:        1532,
+		hits:           48211,
+		misses:         9120,
+		stores:         9118,
+		readErrors:     3,
+		writeErrors:    4,
+		culls:          96,
+		noSpaceWrites:  12,
+		noSpaceCreates: 1,
+	}
+
+	stats, err := parseStats(strings.NewReader(testStats))
+	require.NoError(t, err)
+	assert.Equal(t, expected, stats)
+
+	stats, err = parseStats(strings.NewReader(testStatsHWE))
+	require.NoError(t, err)
+	assert.Equal(t, expected, stats)
+}
+
+func TestParseStatsUnsupported(t *testing.T) {
+	_, err := parseStats(strings.NewReader("FS-Cache statistics\nRetrvls: n=10 ok=8 wt=0 nod=2\n"))
+	assert.ErrorIs(t, err, fscachestats.ErrUnsupportedFormat)
+
+	_, err = parseStats(strings.NewReader("Netfs  : RD=abc\n"))
+	assert.Error(t, err)
+}
+
+func TestHitRatio(t *testing.T) {
+	prev := &fscacheStats{hits: 100, misses: 50}
+
+	_, ok := hitRatio(nil, prev)
+	assert.False(t, ok, "first sample")
+
+	ratio, ok := hitRatio(prev, &fscacheStats{hits: 130, misses: 60})
+	assert.True(t, ok)
+	assert.Equal(t, 0.75, ratio)
+
+	_, ok = hitRatio(prev, prev)
+	assert.False(t, ok, "no reads")
+
+	_, ok = hitRatio(prev, &fscacheStats{hits: 10, misses: 60})
+	assert.False(t, ok, "counter reset")
+}
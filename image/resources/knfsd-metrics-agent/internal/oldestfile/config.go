@@ -19,6 +19,10 @@ package oldestfile
 //go:generate go run github.com/open-telemetry/opentelemetry-collector-contrib/cmd/mdatagen --experimental-gen metadata.yaml
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-metrics-agent/internal/oldestfile/internal/metadata"
 	"go.opentelemetry.io/collector/receiver/scraperhelper"
 )
@@ -27,4 +31,45 @@ type Config struct {
 	scraperhelper.ScraperControllerSettings `mapstructure:",squash"`
 	Metrics                                 metadata.MetricsSettings `mapstructure:"metrics"`
 	CachePath                               string                   `mapstructure:"cache_path"`
+
+	// IndexPath is where the index of the cached files is saved. This allows
+	// the metrics to be reported when the agent restarts without waiting for
+	// a full scan of the cache. Set to an empty string to disable.
+	IndexPath string `mapstructure:"index_path"`
+
+	// ScanInterval is how often to start a full scan of the cache to refresh
+	// the index.
+	ScanInterval time.Duration `mapstructure:"scan_interval"`
+
+	// ScanRate limits the number of files per second read by the scan, so
+	// that the scan does not compete with the NFS server for disk I/O.
+	// Set to 0 to disable the limit.
+	ScanRate int `mapstructure:"scan_rate"`
+
+	// Watch the cache using inotify to update the index between scans.
+	Watch bool `mapstructure:"watch"`
+
+	// AgeBuckets are the upper bounds of the age buckets, in ascending order.
+	// Files older than the last bucket are reported in the "inf" bucket.
+	AgeBuckets []time.Duration `mapstructure:"age_buckets"`
+}
+
+func (cfg *Config) Validate() error {
+	if cfg.CachePath == "" {
+		return errors.New("cache_path is required")
+	}
+	if cfg.ScanInterval <= 0 {
+		return errors.New("scan_interval must be greater than zero")
+	}
+	if cfg.ScanRate < 0 {
+		return errors.New("scan_rate must not be negative")
+	}
+	var prev time.Duration
+	for _, b := range cfg.AgeBuckets {
+		if b <= prev {
+			return fmt.Errorf("age_buckets must be positive and in ascending order, found %s after %s", b, prev)
+		}
+		prev = b
+	}
+	return nil
 }
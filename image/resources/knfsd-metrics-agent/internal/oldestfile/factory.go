@@ -47,8 +47,19 @@ func createDefaultConfig() config.Receiver {
 			ReceiverSettings:   config.NewReceiverSettings(config.NewComponentID(typeStr)),
 			CollectionInterval: 10 * time.Minute,
 		},
-		Metrics:   metadata.DefaultMetricsSettings(),
-		CachePath: "/var/cache/fscache/cache",
+		Metrics:      metadata.DefaultMetricsSettings(),
+		CachePath:    "/var/cache/fscache/cache",
+		IndexPath:    "/var/lib/knfsd-metrics-agent/oldestfile.index",
+		ScanInterval: 6 * time.Hour,
+		ScanRate:     1000,
+		Watch:        true,
+		AgeBuckets: []time.Duration{
+			time.Hour,
+			6 * time.Hour,
+			24 * time.Hour,
+			7 * 24 * time.Hour,
+			30 * 24 * time.Hour,
+		},
 	}
 }
 
@@ -0,0 +1,278 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package oldestfile
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// indexVersion is incremented if the format of the saved index changes.
+const indexVersion = 1
+
+// fileIndex tracks the modification time and size of every file in the cache.
+// The index is refreshed by periodically scanning the cache, and updated by
+// the watcher between scans.
+type fileIndex struct {
+	mu    sync.Mutex
+	files map[string]fileEntry
+	// gen is incremented at the start of each scan, and is used to find the
+	// files that were not seen by the scan.
+	gen uint64
+	// ready is true once the index has been loaded or a scan has completed.
+	// Until then the index may only contain some of the files.
+	ready bool
+}
+
+type fileEntry struct {
+	// MTime is the modification time in nanoseconds since the Unix epoch.
+	MTime int64
+	// Bytes is the disk space used by the file. Files in the cache are sparse
+	// so this may be less than the size of the file.
+	Bytes int64
+
+	gen uint64
+}
+
+func newFileIndex() *fileIndex {
+	return &fileIndex{
+		files: make(map[string]fileEntry),
+	}
+}
+
+func (x *fileIndex) update(path string, info fs.FileInfo) {
+	e := fileEntry{
+		MTime: info.ModTime().UnixNano(),
+		Bytes: fileBytes(info),
+	}
+
+	x.mu.Lock()
+	e.gen = x.gen
+	x.files[path] = e
+	x.mu.Unlock()
+}
+
+func (x *fileIndex) remove(path string) {
+	x.mu.Lock()
+	delete(x.files, path)
+	x.mu.Unlock()
+}
+
+// removeDir removes all the files within a directory.
+func (x *fileIndex) removeDir(dir string) {
+	prefix := dir + string(filepath.Separator)
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for path := range x.files {
+		if strings.HasPrefix(path, prefix) {
+			delete(x.files, path)
+		}
+	}
+}
+
+// beginScan starts a new generation, any file that is not updated before
+// endScan is called will be removed from the index.
+func (x *fileIndex) beginScan() uint64 {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.gen++
+	return x.gen
+}
+
+// endScan removes the files that were not seen since beginScan.
+func (x *fileIndex) endScan(gen uint64) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for path, e := range x.files {
+		if e.gen < gen {
+			delete(x.files, path)
+		}
+	}
+	x.ready = true
+}
+
+func (x *fileIndex) len() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.files)
+}
+
+type savedIndex struct {
+	Version int
+	Root    string
+	Files   map[string]fileEntry
+}
+
+// load reads an index saved by save. The index is ignored if it was saved for
+// a different cache directory.
+func (x *fileIndex) load(path, root string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var saved savedIndex
+	err = gob.NewDecoder(f).Decode(&saved)
+	if err != nil {
+		return fmt.Errorf("could not decode index %s: %w", path, err)
+	}
+	if saved.Version != indexVersion || saved.Root != root {
+		return nil
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if saved.Files != nil {
+		x.files = saved.Files
+	}
+	x.ready = true
+	return nil
+}
+
+// save writes the index to path. The index is written to a temporary file
+// first so that a partially written index is never loaded.
+func (x *fileIndex) save(path, root string) error {
+	x.mu.Lock()
+	if !x.ready {
+		x.mu.Unlock()
+		return errors.New("index is not ready")
+	}
+	saved := savedIndex{
+		Version: indexVersion,
+		Root:    root,
+		Files:   make(map[string]fileEntry, len(x.files)),
+	}
+	for k, v := range x.files {
+		saved.Files[k] = v
+	}
+	x.mu.Unlock()
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	err = gob.NewEncoder(f).Encode(&saved)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+type ageBucket struct {
+	// max is the upper bound of the bucket, zero for the last bucket.
+	max   time.Duration
+	files int64
+	bytes int64
+}
+
+type indexSummary struct {
+	oldest        time.Duration
+	p50, p90, p99 time.Duration
+	buckets       []ageBucket
+}
+
+// summarize calculates the age distribution of the files. ok is false if the
+// index is not ready.
+func (x *fileIndex) summarize(now time.Time, bounds []time.Duration) (summary indexSummary, ok bool) {
+	summary.buckets = make([]ageBucket, len(bounds)+1)
+	for i, b := range bounds {
+		summary.buckets[i].max = b
+	}
+
+	x.mu.Lock()
+	if !x.ready {
+		x.mu.Unlock()
+		return summary, false
+	}
+	ages := make([]time.Duration, 0, len(x.files))
+	for _, e := range x.files {
+		age := now.Sub(time.Unix(0, e.MTime))
+		if age < 0 {
+			age = 0
+		}
+		ages = append(ages, age)
+
+		i := sort.Search(len(bounds), func(i int) bool { return age <= bounds[i] })
+		summary.buckets[i].files++
+		summary.buckets[i].bytes += e.Bytes
+	}
+	x.mu.Unlock()
+
+	if len(ages) == 0 {
+		return summary, true
+	}
+
+	sort.Slice(ages, func(i, j int) bool { return ages[i] < ages[j] })
+	summary.oldest = ages[len(ages)-1]
+	summary.p50 = percentile(ages, 50)
+	summary.p90 = percentile(ages, 90)
+	summary.p99 = percentile(ages, 99)
+	return summary, true
+}
+
+// percentile returns the nearest rank percentile of the sorted values.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// formatAge formats the upper bound of an age bucket, using the largest whole
+// unit such as 1d or 6h.
+func formatAge(d time.Duration) string {
+	const day = 24 * time.Hour
+	switch {
+	case d == 0:
+		return "inf"
+	case d%day == 0:
+		return strconv.FormatInt(int64(d/day), 10) + "d"
+	case d%time.Hour == 0:
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	case d%time.Minute == 0:
+		return strconv.FormatInt(int64(d/time.Minute), 10) + "m"
+	default:
+		return d.String()
+	}
+}
+
+func fileBytes(info fs.FileInfo) int64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return stat.Blocks * 512
+	}
+	return info.Size()
+}
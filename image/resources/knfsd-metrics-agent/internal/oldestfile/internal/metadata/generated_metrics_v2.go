@@ -31,17 +31,182 @@ type MetricSettings struct {
 
 // MetricsSettings provides settings for oldestfile metrics.
 type MetricsSettings struct {
-	FscacheOldestFile MetricSettings `mapstructure:"fscache.oldest_file"`
+	FscacheAgeBucketBytes MetricSettings `mapstructure:"fscache.age_bucket.bytes"`
+	FscacheAgeBucketFiles MetricSettings `mapstructure:"fscache.age_bucket.files"`
+	FscacheFileAge        MetricSettings `mapstructure:"fscache.file_age"`
+	FscacheOldestFile     MetricSettings `mapstructure:"fscache.oldest_file"`
 }
 
 func DefaultMetricsSettings() MetricsSettings {
 	return MetricsSettings{
+		FscacheAgeBucketBytes: MetricSettings{
+			Enabled: true,
+		},
+		FscacheAgeBucketFiles: MetricSettings{
+			Enabled: true,
+		},
+		FscacheFileAge: MetricSettings{
+			Enabled: true,
+		},
 		FscacheOldestFile: MetricSettings{
 			Enabled: true,
 		},
 	}
 }
 
+type metricFscacheAgeBucketBytes struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills fscache.age_bucket.bytes metric with initial data.
+func (m *metricFscacheAgeBucketBytes) init() {
+	m.data.SetName("fscache.age_bucket.bytes")
+	m.data.SetDescription("Disk space used by the files in FS-Cache in each age bucket")
+	m.data.SetUnit("By")
+	m.data.SetDataType(pdata.MetricDataTypeGauge)
+	m.data.Gauge().DataPoints().EnsureCapacity(m.capacity)
+}
+
+func (m *metricFscacheAgeBucketBytes) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val int64, maxAgeAttributeValue string) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Gauge().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetIntVal(val)
+	dp.Attributes().Insert(A.MaxAge, pdata.NewAttributeValueString(maxAgeAttributeValue))
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricFscacheAgeBucketBytes) updateCapacity() {
+	if m.data.Gauge().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Gauge().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricFscacheAgeBucketBytes) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Gauge().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricFscacheAgeBucketBytes(settings MetricSettings) metricFscacheAgeBucketBytes {
+	m := metricFscacheAgeBucketBytes{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
+}
+
+type metricFscacheAgeBucketFiles struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills fscache.age_bucket.files metric with initial data.
+func (m *metricFscacheAgeBucketFiles) init() {
+	m.data.SetName("fscache.age_bucket.files")
+	m.data.SetDescription("Number of files in FS-Cache in each age bucket")
+	m.data.SetUnit("{files}")
+	m.data.SetDataType(pdata.MetricDataTypeGauge)
+	m.data.Gauge().DataPoints().EnsureCapacity(m.capacity)
+}
+
+func (m *metricFscacheAgeBucketFiles) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val int64, maxAgeAttributeValue string) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Gauge().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetIntVal(val)
+	dp.Attributes().Insert(A.MaxAge, pdata.NewAttributeValueString(maxAgeAttributeValue))
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricFscacheAgeBucketFiles) updateCapacity() {
+	if m.data.Gauge().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Gauge().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricFscacheAgeBucketFiles) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Gauge().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricFscacheAgeBucketFiles(settings MetricSettings) metricFscacheAgeBucketFiles {
+	m := metricFscacheAgeBucketFiles{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
+}
+
+type metricFscacheFileAge struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills fscache.file_age metric with initial data.
+func (m *metricFscacheFileAge) init() {
+	m.data.SetName("fscache.file_age")
+	m.data.SetDescription("Percentiles of the age of the files in FS-Cache")
+	m.data.SetUnit("s")
+	m.data.SetDataType(pdata.MetricDataTypeGauge)
+	m.data.Gauge().DataPoints().EnsureCapacity(m.capacity)
+}
+
+func (m *metricFscacheFileAge) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val int64, percentileAttributeValue string) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Gauge().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetIntVal(val)
+	dp.Attributes().Insert(A.Percentile, pdata.NewAttributeValueString(percentileAttributeValue))
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricFscacheFileAge) updateCapacity() {
+	if m.data.Gauge().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Gauge().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricFscacheFileAge) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Gauge().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricFscacheFileAge(settings MetricSettings) metricFscacheFileAge {
+	m := metricFscacheFileAge{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
+}
+
 type metricFscacheOldestFile struct {
 	data     pdata.Metric   // data buffer for generated metric.
 	settings MetricSettings // metric settings provided by user.
@@ -94,8 +259,11 @@ func newMetricFscacheOldestFile(settings MetricSettings) metricFscacheOldestFile
 // MetricsBuilder provides an interface for scrapers to report metrics while taking care of all the transformations
 // required to produce metric representation defined in metadata and user settings.
 type MetricsBuilder struct {
-	startTime               pdata.Timestamp
-	metricFscacheOldestFile metricFscacheOldestFile
+	startTime                   pdata.Timestamp
+	metricFscacheAgeBucketBytes metricFscacheAgeBucketBytes
+	metricFscacheAgeBucketFiles metricFscacheAgeBucketFiles
+	metricFscacheFileAge        metricFscacheFileAge
+	metricFscacheOldestFile     metricFscacheOldestFile
 }
 
 // metricBuilderOption applies changes to default metrics builder.
@@ -110,8 +278,11 @@ func WithStartTime(startTime pdata.Timestamp) metricBuilderOption {
 
 func NewMetricsBuilder(settings MetricsSettings, options ...metricBuilderOption) *MetricsBuilder {
 	mb := &MetricsBuilder{
-		startTime:               pdata.NewTimestampFromTime(time.Now()),
-		metricFscacheOldestFile: newMetricFscacheOldestFile(settings.FscacheOldestFile),
+		startTime:                   pdata.NewTimestampFromTime(time.Now()),
+		metricFscacheAgeBucketBytes: newMetricFscacheAgeBucketBytes(settings.FscacheAgeBucketBytes),
+		metricFscacheAgeBucketFiles: newMetricFscacheAgeBucketFiles(settings.FscacheAgeBucketFiles),
+		metricFscacheFileAge:        newMetricFscacheFileAge(settings.FscacheFileAge),
+		metricFscacheOldestFile:     newMetricFscacheOldestFile(settings.FscacheOldestFile),
 	}
 	for _, op := range options {
 		op(mb)
@@ -123,9 +294,27 @@ func NewMetricsBuilder(settings MetricsSettings, options ...metricBuilderOption)
 // another set of data points. This function will be doing all transformations required to produce metric representation
 // defined in metadata and user settings, e.g. delta/cumulative translation.
 func (mb *MetricsBuilder) Emit(metrics pdata.MetricSlice) {
+	mb.metricFscacheAgeBucketBytes.emit(metrics)
+	mb.metricFscacheAgeBucketFiles.emit(metrics)
+	mb.metricFscacheFileAge.emit(metrics)
 	mb.metricFscacheOldestFile.emit(metrics)
 }
 
+// RecordFscacheAgeBucketBytesDataPoint adds a data point to fscache.age_bucket.bytes metric.
+func (mb *MetricsBuilder) RecordFscacheAgeBucketBytesDataPoint(ts pdata.Timestamp, val int64, maxAgeAttributeValue string) {
+	mb.metricFscacheAgeBucketBytes.recordDataPoint(mb.startTime, ts, val, maxAgeAttributeValue)
+}
+
+// RecordFscacheAgeBucketFilesDataPoint adds a data point to fscache.age_bucket.files metric.
+func (mb *MetricsBuilder) RecordFscacheAgeBucketFilesDataPoint(ts pdata.Timestamp, val int64, maxAgeAttributeValue string) {
+	mb.metricFscacheAgeBucketFiles.recordDataPoint(mb.startTime, ts, val, maxAgeAttributeValue)
+}
+
+// RecordFscacheFileAgeDataPoint adds a data point to fscache.file_age metric.
+func (mb *MetricsBuilder) RecordFscacheFileAgeDataPoint(ts pdata.Timestamp, val int64, percentileAttributeValue string) {
+	mb.metricFscacheFileAge.recordDataPoint(mb.startTime, ts, val, percentileAttributeValue)
+}
+
 // RecordFscacheOldestFileDataPoint adds a data point to fscache.oldest_file metric.
 func (mb *MetricsBuilder) RecordFscacheOldestFileDataPoint(ts pdata.Timestamp, val int64) {
 	mb.metricFscacheOldestFile.recordDataPoint(mb.startTime, ts, val)
@@ -142,7 +331,25 @@ func (mb *MetricsBuilder) Reset(options ...metricBuilderOption) {
 
 // Attributes contains the possible metric attributes that can be used.
 var Attributes = struct {
-}{}
+	// MaxAge (Upper bound of the age bucket, such as 1d, or inf for the last bucket)
+	MaxAge string
+	// Percentile (Percentile of the file ages)
+	Percentile string
+}{
+	"max_age",
+	"percentile",
+}
 
 // A is an alias for Attributes.
 var A = Attributes
+
+// AttributePercentile are the possible values that the attribute "percentile" can have.
+var AttributePercentile = struct {
+	P50 string
+	P90 string
+	P99 string
+}{
+	"p50",
+	"p90",
+	"p99",
+}
@@ -0,0 +1,121 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package oldestfile
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// scan walks the cache directory, refreshing the index. Directories are added
+// to the watcher (if any) so that new directories created since the previous
+// scan are watched.
+//
+// If the scan is cancelled the files seen so far are updated, but files that
+// were deleted are not removed from the index until a scan completes.
+func scan(ctx context.Context, root string, index *fileIndex, w *watcher, rate int) error {
+	gen := index.beginScan()
+	lim := newLimiter(rate)
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// if there's an error reading a file or directory, just skip it
+			return nil
+		}
+
+		if d.IsDir() {
+			if w != nil {
+				w.add(path)
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		if err := lim.wait(ctx); err != nil {
+			// abort walking the tree with the context's error
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			// if there's an error querying file, just skip the file
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		index.update(rel, info)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	index.endScan(gen)
+	return nil
+}
+
+// limiter limits the rate of the scan to a number of files per second.
+type limiter struct {
+	rate  int
+	start time.Time
+	count int
+}
+
+func newLimiter(rate int) *limiter {
+	return &limiter{
+		rate:  rate,
+		start: time.Now(),
+	}
+}
+
+func (l *limiter) wait(ctx context.Context) error {
+	l.count++
+
+	// Avoiding checking the context or sleeping on every single file. This is
+	// because checking the context has to lock a mutex.
+	// No heuristics for a good value here, so just chose 100 arbitrarily.
+	if l.count%100 != 0 {
+		return nil
+	}
+
+	if l.rate <= 0 {
+		return ctx.Err()
+	}
+
+	due := l.start.Add(time.Duration(l.count) * time.Second / time.Duration(l.rate))
+	delay := time.Until(due)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -18,126 +18,151 @@ package oldestfile
 
 import (
 	"context"
+	"errors"
 	"io/fs"
-	"os"
-	"path/filepath"
 	"time"
 
 	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-metrics-agent/internal/oldestfile/internal/metadata"
+	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/model/pdata"
 	"go.opentelemetry.io/collector/receiver/scraperhelper"
 	"go.uber.org/zap"
 )
 
 type oldestFileScraper struct {
-	mb        *metadata.MetricsBuilder
-	cachePath string
-	last      oldestFile
-}
-
-type oldestFile struct {
-	path  string
-	mtime time.Time
+	cfg     *Config
+	logger  *zap.Logger
+	mb      *metadata.MetricsBuilder
+	index   *fileIndex
+	watcher *watcher
+
+	cancel context.CancelFunc
+	done   chan struct{}
 }
 
 func newScraper(cfg *Config, logger *zap.Logger) (scraperhelper.Scraper, error) {
 	s := &oldestFileScraper{
-		mb:        metadata.NewMetricsBuilder(cfg.Metrics),
-		cachePath: cfg.CachePath,
+		cfg:    cfg,
+		logger: logger,
+		mb:     metadata.NewMetricsBuilder(cfg.Metrics),
+		index:  newFileIndex(),
 	}
 	return scraperhelper.NewScraper(
 		typeStr,
 		s.scrape,
+		scraperhelper.WithStart(s.start),
+		scraperhelper.WithShutdown(s.shutdown),
 	)
 }
 
-func (s *oldestFileScraper) scrape(ctx context.Context) (pdata.Metrics, error) {
-	md := pdata.NewMetrics()
-	age, err := s.findOldest(ctx)
-	if err != nil {
-		return md, nil
+func (s *oldestFileScraper) start(context.Context, component.Host) error {
+	if s.cfg.IndexPath != "" {
+		err := s.index.load(s.cfg.IndexPath, s.cfg.CachePath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			s.logger.Warn("could not load index, the metrics will not be reported until the cache has been scanned", zap.Error(err))
+		}
 	}
 
-	metrics := md.ResourceMetrics().AppendEmpty().
-		InstrumentationLibraryMetrics().AppendEmpty().
-		Metrics()
-
-	now := pdata.NewTimestampFromTime(time.Now())
-	s.mb.RecordFscacheOldestFileDataPoint(now, int64(age.Seconds()))
-	s.mb.Emit(metrics)
+	if s.cfg.Watch {
+		w, err := newWatcher(s.cfg.CachePath, s.index, s.logger)
+		if err != nil {
+			s.logger.Warn("could not watch cache, changes will only be detected by scanning the cache", zap.Error(err))
+		} else {
+			s.watcher = w
+			go w.run()
+		}
+	}
 
-	return md, nil
+	// The context passed to start should not be used for background work,
+	// the scan is cancelled by shutdown.
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	go s.run(ctx)
+	return nil
 }
 
-func (s *oldestFileScraper) findOldest(ctx context.Context) (time.Duration, error) {
-	oldest, err := s.findOldestFile(ctx)
-	if err != nil {
-		s.last = oldestFile{}
-		return 0, err
-	}
-	s.last = oldest
-
-	if oldest.mtime.IsZero() {
-		return 0, nil
+func (s *oldestFileScraper) shutdown(context.Context) error {
+	if s.cancel == nil {
+		return nil
 	}
+	s.cancel()
+	<-s.done
 
-	now := time.Now()
-	age := now.Sub(oldest.mtime)
-	if age < 0 {
-		age = 0
+	if s.watcher != nil {
+		s.watcher.flush()
+		s.watcher.Close()
 	}
-
-	return age, nil
+	s.save()
+	return nil
 }
 
-func (s *oldestFileScraper) findOldestFile(ctx context.Context) (oldestFile, error) {
-	// optimistic check if the oldest file from a previous scrape still exists
-	if s.last.path != "" {
-		stat, err := os.Stat(s.last.path)
-		if err == nil && stat.ModTime() == s.last.mtime {
-			// assume the file is still the oldest
-			return s.last, nil
+// run scans the cache every ScanInterval until the context is cancelled.
+func (s *oldestFileScraper) run(ctx context.Context) {
+	defer close(s.done)
+	for {
+		start := time.Now()
+		err := scan(ctx, s.cfg.CachePath, s.index, s.watcher, s.cfg.ScanRate)
+		if ctx.Err() != nil {
+			return
 		}
-	}
-
-	count := 0
-	found := oldestFile{}
-	err := filepath.WalkDir(s.cachePath, func(path string, d fs.DirEntry, err error) error {
-		// Avoiding checking the context on every single file. This is because
-		// checking the context has to lock a mutex.
-		// No heuristics for a good value here, so just chose 100 arbitrarily.
-		count++
-		if count > 100 {
-			count = 0
-			if err := ctx.Err(); err != nil {
-				// abort walking the tree with the context's error
-				return err
-			}
+		if err != nil {
+			s.logger.Warn("could not scan cache", zap.Error(err))
+		} else {
+			s.logger.Debug("scanned cache",
+				zap.Duration("duration", time.Since(start)),
+				zap.Int("files", s.index.len()))
+			s.save()
 		}
 
-		if !d.Type().IsRegular() {
-			return nil
+		t := time.NewTimer(time.Until(start.Add(s.cfg.ScanInterval)))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
 		}
+	}
+}
 
-		info, err := d.Info()
-		if err != nil {
-			// if there's an error querying file, just skip the file
-			return nil
-		}
+func (s *oldestFileScraper) save() {
+	if s.cfg.IndexPath == "" {
+		return
+	}
+	err := s.index.save(s.cfg.IndexPath, s.cfg.CachePath)
+	if err != nil {
+		s.logger.Debug("could not save index", zap.Error(err))
+	}
+}
 
-		mtime := info.ModTime()
-		if mtime.IsZero() {
-			return nil
-		}
+func (s *oldestFileScraper) scrape(ctx context.Context) (pdata.Metrics, error) {
+	md := pdata.NewMetrics()
+	if s.watcher != nil {
+		s.watcher.flush()
+	}
 
-		if found.mtime.IsZero() || mtime.Before(found.mtime) {
-			found = oldestFile{
-				path:  path,
-				mtime: mtime,
-			}
-		}
+	now := time.Now()
+	summary, ok := s.index.summarize(now, s.cfg.AgeBuckets)
+	if !ok {
+		// The first scan has not completed yet.
+		return md, nil
+	}
 
-		return nil
-	})
-	return found, err
+	metrics := md.ResourceMetrics().AppendEmpty().
+		InstrumentationLibraryMetrics().AppendEmpty().
+		Metrics()
+
+	ts := pdata.NewTimestampFromTime(now)
+	s.mb.RecordFscacheOldestFileDataPoint(ts, int64(summary.oldest.Seconds()))
+	s.mb.RecordFscacheFileAgeDataPoint(ts, int64(summary.p50.Seconds()), metadata.AttributePercentile.P50)
+	s.mb.RecordFscacheFileAgeDataPoint(ts, int64(summary.p90.Seconds()), metadata.AttributePercentile.P90)
+	s.mb.RecordFscacheFileAgeDataPoint(ts, int64(summary.p99.Seconds()), metadata.AttributePercentile.P99)
+	for _, b := range summary.buckets {
+		age := formatAge(b.max)
+		s.mb.RecordFscacheAgeBucketFilesDataPoint(ts, b.files, age)
+		s.mb.RecordFscacheAgeBucketBytesDataPoint(ts, b.bytes, age)
+	}
+	s.mb.Emit(metrics)
+
+	return md, nil
 }
@@ -0,0 +1,177 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package oldestfile
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func writeFile(t *testing.T, path string, mtime time.Time) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte("data"), 0644))
+	require.NoError(t, os.Chtimes(path, mtime, mtime))
+}
+
+func TestScan(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	writeFile(t, filepath.Join(root, "Infs", "@00", "a"), now.Add(-2*time.Hour))
+	writeFile(t, filepath.Join(root, "Infs", "@01", "b"), now.Add(-30*time.Minute))
+	writeFile(t, filepath.Join(root, "Infs", "@01", "c"), now.Add(-10*24*time.Hour))
+
+	index := newFileIndex()
+	_, ok := index.summarize(now, nil)
+	assert.False(t, ok, "index should not be ready before the first scan")
+
+	require.NoError(t, scan(context.Background(), root, index, nil, 0))
+	assert.Equal(t, 3, index.len())
+
+	summary, ok := index.summarize(now, []time.Duration{time.Hour, 24 * time.Hour})
+	require.True(t, ok)
+	assert.Equal(t, 240*time.Hour, summary.oldest.Round(time.Second))
+	assert.Equal(t, 2*time.Hour, summary.p50.Round(time.Second))
+	assert.Equal(t, 240*time.Hour, summary.p99.Round(time.Second))
+
+	require.Len(t, summary.buckets, 3)
+	assert.Equal(t, int64(1), summary.buckets[0].files)
+	assert.Equal(t, int64(1), summary.buckets[1].files)
+	assert.Equal(t, int64(1), summary.buckets[2].files)
+	assert.Equal(t, time.Duration(0), summary.buckets[2].max)
+
+	// Files that are removed are dropped from the index by the next scan.
+	require.NoError(t, os.Remove(filepath.Join(root, "Infs", "@01", "c")))
+	require.NoError(t, scan(context.Background(), root, index, nil, 0))
+	summary, ok = index.summarize(now, nil)
+	require.True(t, ok)
+	assert.Equal(t, 2*time.Hour, summary.oldest.Round(time.Second))
+}
+
+func TestScanCancelled(t *testing.T) {
+	root := t.TempDir()
+	for i := 0; i < 200; i++ {
+		writeFile(t, filepath.Join(root, "f", strconv.Itoa(i)), time.Now())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	index := newFileIndex()
+	err := scan(ctx, root, index, nil, 0)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, ok := index.summarize(time.Now(), nil)
+	assert.False(t, ok, "index should not be ready after a partial scan")
+}
+
+func TestSaveLoad(t *testing.T) {
+	root := t.TempDir()
+	mtime := time.Now().Add(-time.Hour)
+	writeFile(t, filepath.Join(root, "a"), mtime)
+
+	index := newFileIndex()
+	require.NoError(t, scan(context.Background(), root, index, nil, 0))
+
+	path := filepath.Join(t.TempDir(), "index")
+	require.NoError(t, index.save(path, root))
+
+	loaded := newFileIndex()
+	require.NoError(t, loaded.load(path, root))
+	summary, ok := loaded.summarize(mtime.Add(time.Hour), nil)
+	require.True(t, ok)
+	assert.Equal(t, time.Hour, summary.oldest)
+
+	// An index saved for a different cache is ignored.
+	other := newFileIndex()
+	require.NoError(t, other.load(path, "/other"))
+	_, ok = other.summarize(time.Now(), nil)
+	assert.False(t, ok)
+}
+
+func TestWatcher(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "Infs")
+	writeFile(t, filepath.Join(dir, "old"), time.Now().Add(-time.Hour))
+
+	index := newFileIndex()
+	w, err := newWatcher(root, index, zap.NewNop())
+	require.NoError(t, err)
+	go w.run()
+	defer w.Close()
+
+	require.NoError(t, scan(context.Background(), root, index, w, 0))
+	require.Equal(t, 1, index.len())
+
+	writeFile(t, filepath.Join(dir, "new"), time.Now())
+	require.NoError(t, os.Remove(filepath.Join(dir, "old")))
+
+	assert.Eventually(t, func() bool {
+		w.flush()
+		summary, _ := index.summarize(time.Now(), nil)
+		return index.len() == 1 && summary.oldest < time.Minute
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(1000)
+	start := time.Now()
+	for i := 0; i < 200; i++ {
+		require.NoError(t, l.wait(context.Background()))
+	}
+	// 200 files at 1000 files per second
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+}
+
+func TestPercentile(t *testing.T) {
+	values := make([]time.Duration, 100)
+	for i := range values {
+		values[i] = time.Duration(i + 1)
+	}
+	assert.Equal(t, time.Duration(50), percentile(values, 50))
+	assert.Equal(t, time.Duration(90), percentile(values, 90))
+	assert.Equal(t, time.Duration(99), percentile(values, 99))
+
+	assert.Equal(t, time.Duration(1), percentile(values[:1], 50))
+}
+
+func TestFormatAge(t *testing.T) {
+	assert.Equal(t, "inf", formatAge(0))
+	assert.Equal(t, "7d", formatAge(7*24*time.Hour))
+	assert.Equal(t, "6h", formatAge(6*time.Hour))
+	assert.Equal(t, "30m", formatAge(30*time.Minute))
+	assert.Equal(t, "1.5s", formatAge(1500*time.Millisecond))
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	assert.NoError(t, cfg.Validate())
+
+	cfg.AgeBuckets = []time.Duration{time.Hour, time.Minute}
+	assert.Error(t, cfg.Validate())
+
+	cfg = createDefaultConfig().(*Config)
+	cfg.ScanRate = -1
+	assert.Error(t, cfg.Validate())
+}
@@ -0,0 +1,198 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package oldestfile
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+const watchMask = unix.IN_CREATE | unix.IN_MODIFY | unix.IN_ATTRIB |
+	unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO |
+	unix.IN_ONLYDIR | unix.IN_EXCL_UNLINK
+
+// watcher uses inotify to update the index when files are created, modified
+// or removed between scans.
+//
+// Files that are created or modified are not stat'ed immediately, as the cache
+// can be modified many times a second. Instead the files are marked as dirty,
+// and updated by flush.
+type watcher struct {
+	root   string
+	index  *fileIndex
+	logger *zap.Logger
+	fd     int
+	f      *os.File
+
+	mu     sync.Mutex
+	closed bool
+	dirs   map[int32]string
+	dirty  map[string]struct{}
+	// full is set if the inotify watch limit has been reached, at which point
+	// the index will only be updated by the scans.
+	full bool
+}
+
+func newWatcher(root string, index *fileIndex, logger *zap.Logger) (*watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	return &watcher{
+		root:   root,
+		index:  index,
+		logger: logger,
+		fd:     fd,
+		// Using a non-blocking os.File so that reads use the runtime's poller,
+		// and Close will interrupt a pending read.
+		f:     os.NewFile(uintptr(fd), "inotify"),
+		dirs:  make(map[int32]string),
+		dirty: make(map[string]struct{}),
+	}, nil
+}
+
+func (w *watcher) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	return w.f.Close()
+}
+
+// add watches a directory. Adding the same directory multiple times is safe.
+func (w *watcher) add(dir string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.full || w.closed {
+		return
+	}
+
+	wd, err := unix.InotifyAddWatch(w.fd, dir, watchMask)
+	if err != nil {
+		if errors.Is(err, unix.ENOSPC) {
+			w.logger.Warn("inotify watch limit reached, changes will only be detected by scanning the cache",
+				zap.String("dir", dir))
+			w.full = true
+		} else {
+			w.logger.Debug("could not watch directory", zap.String("dir", dir), zap.Error(err))
+		}
+		return
+	}
+	w.dirs[int32(wd)] = dir
+}
+
+// run reads events until the watcher is closed.
+func (w *watcher) run() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				w.logger.Error("could not read inotify events", zap.Error(err))
+			}
+			return
+		}
+		w.handle(buf[:n])
+	}
+}
+
+func (w *watcher) handle(buf []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for len(buf) >= unix.SizeofInotifyEvent {
+		ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[0]))
+		end := unix.SizeofInotifyEvent + int(ev.Len)
+		if end > len(buf) {
+			return
+		}
+		name := buf[unix.SizeofInotifyEvent:end]
+		buf = buf[end:]
+
+		if ev.Mask&unix.IN_Q_OVERFLOW != 0 {
+			w.logger.Warn("inotify queue overflowed, some changes will not be detected until the next scan")
+			continue
+		}
+
+		dir, found := w.dirs[ev.Wd]
+		if !found {
+			continue
+		}
+		if ev.Mask&unix.IN_IGNORED != 0 {
+			delete(w.dirs, ev.Wd)
+			continue
+		}
+
+		// The name is padded with null bytes.
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+		if len(name) == 0 {
+			continue
+		}
+		path := filepath.Join(dir, string(name))
+		rel, err := filepath.Rel(w.root, path)
+		if err != nil {
+			continue
+		}
+
+		isDir := ev.Mask&unix.IN_ISDIR != 0
+		switch {
+		case ev.Mask&(unix.IN_DELETE|unix.IN_MOVED_FROM) != 0:
+			delete(w.dirty, rel)
+			if isDir {
+				w.index.removeDir(rel)
+			} else {
+				w.index.remove(rel)
+			}
+		case isDir:
+			if ev.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 && !w.full && !w.closed {
+				// Files in the new directory will be found by the next scan.
+				wd, err := unix.InotifyAddWatch(w.fd, path, watchMask)
+				if err == nil {
+					w.dirs[int32(wd)] = path
+				}
+			}
+		default:
+			w.dirty[rel] = struct{}{}
+		}
+	}
+}
+
+// flush updates the index for the files that have changed since the previous
+// flush.
+func (w *watcher) flush() {
+	w.mu.Lock()
+	dirty := w.dirty
+	w.dirty = make(map[string]struct{})
+	w.mu.Unlock()
+
+	for rel := range dirty {
+		info, err := os.Lstat(filepath.Join(w.root, rel))
+		if err != nil || !info.Mode().IsRegular() {
+			w.index.remove(rel)
+			continue
+		}
+		w.index.update(rel, info)
+	}
+}
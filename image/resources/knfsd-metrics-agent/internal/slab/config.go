@@ -19,6 +19,10 @@ package slab
 //go:generate go run github.com/open-telemetry/opentelemetry-collector-contrib/cmd/mdatagen --experimental-gen metadata.yaml
 
 import (
+	"fmt"
+	"path"
+	"regexp"
+
 	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-metrics-agent/internal/slab/internal/metadata"
 	"go.opentelemetry.io/collector/receiver/scraperhelper"
 )
@@ -26,4 +30,26 @@ import (
 type Config struct {
 	scraperhelper.ScraperControllerSettings `mapstructure:",squash"`
 	Metrics                                 metadata.MetricsSettings `mapstructure:"metrics"`
+
+	// Include is a list of slab cache names to report, using shell glob
+	// patterns such as nfs_*.
+	Include []string `mapstructure:"include"`
+
+	// IncludeRegexp is a regular expression matching additional slab cache
+	// names to report.
+	IncludeRegexp string `mapstructure:"include_regexp"`
+}
+
+func (cfg *Config) Validate() error {
+	for _, pattern := range cfg.Include {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+		}
+	}
+	if cfg.IncludeRegexp != "" {
+		if _, err := regexp.Compile(cfg.IncludeRegexp); err != nil {
+			return fmt.Errorf("invalid include_regexp: %w", err)
+		}
+	}
+	return nil
 }
@@ -43,6 +43,16 @@ func createDefaultConfig() config.Receiver {
 	return &Config{
 		ScraperControllerSettings: scraperhelper.DefaultScraperControllerSettings(typeStr),
 		Metrics:                   metadata.DefaultMetricsSettings(),
+		Include: []string{
+			"dentry",
+			"inode_cache",
+			"nfs_*",
+			"nfsd*",
+			"fscache_*",
+			"cachefiles_*",
+			"xfs_inode",
+			"ext4_inode_cache",
+		},
 	}
 }
 
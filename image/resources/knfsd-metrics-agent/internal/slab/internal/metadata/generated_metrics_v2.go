@@ -31,27 +31,145 @@ type MetricSettings struct {
 
 // MetricsSettings provides settings for slabinfo metrics.
 type MetricsSettings struct {
+	MeminfoSlab                    MetricSettings `mapstructure:"meminfo.slab"`
+	SlabActiveObjects              MetricSettings `mapstructure:"slab.active_objects"`
 	SlabDentryCacheActiveObjects   MetricSettings `mapstructure:"slab.dentry_cache.active_objects"`
 	SlabDentryCacheObjsize         MetricSettings `mapstructure:"slab.dentry_cache.objsize"`
+	SlabMemory                     MetricSettings `mapstructure:"slab.memory"`
 	SlabNfsInodeCacheActiveObjects MetricSettings `mapstructure:"slab.nfs_inode_cache.active_objects"`
 	SlabNfsInodeCacheObjsize       MetricSettings `mapstructure:"slab.nfs_inode_cache.objsize"`
+	SlabTotalObjects               MetricSettings `mapstructure:"slab.total_objects"`
 }
 
 func DefaultMetricsSettings() MetricsSettings {
 	return MetricsSettings{
+		MeminfoSlab: MetricSettings{
+			Enabled: true,
+		},
+		SlabActiveObjects: MetricSettings{
+			Enabled: true,
+		},
 		SlabDentryCacheActiveObjects: MetricSettings{
 			Enabled: true,
 		},
 		SlabDentryCacheObjsize: MetricSettings{
 			Enabled: true,
 		},
+		SlabMemory: MetricSettings{
+			Enabled: true,
+		},
 		SlabNfsInodeCacheActiveObjects: MetricSettings{
 			Enabled: true,
 		},
 		SlabNfsInodeCacheObjsize: MetricSettings{
 			Enabled: true,
 		},
+		SlabTotalObjects: MetricSettings{
+			Enabled: true,
+		},
+	}
+}
+
+type metricMeminfoSlab struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills meminfo.slab metric with initial data.
+func (m *metricMeminfoSlab) init() {
+	m.data.SetName("meminfo.slab")
+	m.data.SetDescription("Memory used by all the slab caches from /proc/meminfo")
+	m.data.SetUnit("By")
+	m.data.SetDataType(pdata.MetricDataTypeGauge)
+	m.data.Gauge().DataPoints().EnsureCapacity(m.capacity)
+}
+
+func (m *metricMeminfoSlab) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val int64, typeAttributeValue string) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Gauge().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetIntVal(val)
+	dp.Attributes().Insert(A.Type, pdata.NewAttributeValueString(typeAttributeValue))
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricMeminfoSlab) updateCapacity() {
+	if m.data.Gauge().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Gauge().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricMeminfoSlab) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Gauge().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricMeminfoSlab(settings MetricSettings) metricMeminfoSlab {
+	m := metricMeminfoSlab{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
+}
+
+type metricSlabActiveObjects struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills slab.active_objects metric with initial data.
+func (m *metricSlabActiveObjects) init() {
+	m.data.SetName("slab.active_objects")
+	m.data.SetDescription("Number of objects in use in each slab cache")
+	m.data.SetUnit("{objects}")
+	m.data.SetDataType(pdata.MetricDataTypeGauge)
+	m.data.Gauge().DataPoints().EnsureCapacity(m.capacity)
+}
+
+func (m *metricSlabActiveObjects) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val int64, slabAttributeValue string) {
+	if !m.settings.Enabled {
+		return
 	}
+	dp := m.data.Gauge().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetIntVal(val)
+	dp.Attributes().Insert(A.Slab, pdata.NewAttributeValueString(slabAttributeValue))
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricSlabActiveObjects) updateCapacity() {
+	if m.data.Gauge().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Gauge().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricSlabActiveObjects) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Gauge().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricSlabActiveObjects(settings MetricSettings) metricSlabActiveObjects {
+	m := metricSlabActiveObjects{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
 }
 
 type metricSlabDentryCacheActiveObjects struct {
@@ -152,6 +270,57 @@ func newMetricSlabDentryCacheObjsize(settings MetricSettings) metricSlabDentryCa
 	return m
 }
 
+type metricSlabMemory struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills slab.memory metric with initial data.
+func (m *metricSlabMemory) init() {
+	m.data.SetName("slab.memory")
+	m.data.SetDescription("Memory used by each slab cache")
+	m.data.SetUnit("By")
+	m.data.SetDataType(pdata.MetricDataTypeGauge)
+	m.data.Gauge().DataPoints().EnsureCapacity(m.capacity)
+}
+
+func (m *metricSlabMemory) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val int64, slabAttributeValue string) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Gauge().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetIntVal(val)
+	dp.Attributes().Insert(A.Slab, pdata.NewAttributeValueString(slabAttributeValue))
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricSlabMemory) updateCapacity() {
+	if m.data.Gauge().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Gauge().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricSlabMemory) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Gauge().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricSlabMemory(settings MetricSettings) metricSlabMemory {
+	m := metricSlabMemory{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
+}
+
 type metricSlabNfsInodeCacheActiveObjects struct {
 	data     pdata.Metric   // data buffer for generated metric.
 	settings MetricSettings // metric settings provided by user.
@@ -250,14 +419,69 @@ func newMetricSlabNfsInodeCacheObjsize(settings MetricSettings) metricSlabNfsIno
 	return m
 }
 
+type metricSlabTotalObjects struct {
+	data     pdata.Metric   // data buffer for generated metric.
+	settings MetricSettings // metric settings provided by user.
+	capacity int            // max observed number of data points added to the metric.
+}
+
+// init fills slab.total_objects metric with initial data.
+func (m *metricSlabTotalObjects) init() {
+	m.data.SetName("slab.total_objects")
+	m.data.SetDescription("Total number of objects allocated in each slab cache, including unused objects")
+	m.data.SetUnit("{objects}")
+	m.data.SetDataType(pdata.MetricDataTypeGauge)
+	m.data.Gauge().DataPoints().EnsureCapacity(m.capacity)
+}
+
+func (m *metricSlabTotalObjects) recordDataPoint(start pdata.Timestamp, ts pdata.Timestamp, val int64, slabAttributeValue string) {
+	if !m.settings.Enabled {
+		return
+	}
+	dp := m.data.Gauge().DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	dp.SetIntVal(val)
+	dp.Attributes().Insert(A.Slab, pdata.NewAttributeValueString(slabAttributeValue))
+}
+
+// updateCapacity saves max length of data point slices that will be used for the slice capacity.
+func (m *metricSlabTotalObjects) updateCapacity() {
+	if m.data.Gauge().DataPoints().Len() > m.capacity {
+		m.capacity = m.data.Gauge().DataPoints().Len()
+	}
+}
+
+// emit appends recorded metric data to a metrics slice and prepares it for recording another set of data points.
+func (m *metricSlabTotalObjects) emit(metrics pdata.MetricSlice) {
+	if m.settings.Enabled && m.data.Gauge().DataPoints().Len() > 0 {
+		m.updateCapacity()
+		m.data.MoveTo(metrics.AppendEmpty())
+		m.init()
+	}
+}
+
+func newMetricSlabTotalObjects(settings MetricSettings) metricSlabTotalObjects {
+	m := metricSlabTotalObjects{settings: settings}
+	if settings.Enabled {
+		m.data = pdata.NewMetric()
+		m.init()
+	}
+	return m
+}
+
 // MetricsBuilder provides an interface for scrapers to report metrics while taking care of all the transformations
 // required to produce metric representation defined in metadata and user settings.
 type MetricsBuilder struct {
 	startTime                            pdata.Timestamp
+	metricMeminfoSlab                    metricMeminfoSlab
+	metricSlabActiveObjects              metricSlabActiveObjects
 	metricSlabDentryCacheActiveObjects   metricSlabDentryCacheActiveObjects
 	metricSlabDentryCacheObjsize         metricSlabDentryCacheObjsize
+	metricSlabMemory                     metricSlabMemory
 	metricSlabNfsInodeCacheActiveObjects metricSlabNfsInodeCacheActiveObjects
 	metricSlabNfsInodeCacheObjsize       metricSlabNfsInodeCacheObjsize
+	metricSlabTotalObjects               metricSlabTotalObjects
 }
 
 // metricBuilderOption applies changes to default metrics builder.
@@ -273,10 +497,14 @@ func WithStartTime(startTime pdata.Timestamp) metricBuilderOption {
 func NewMetricsBuilder(settings MetricsSettings, options ...metricBuilderOption) *MetricsBuilder {
 	mb := &MetricsBuilder{
 		startTime:                            pdata.NewTimestampFromTime(time.Now()),
+		metricMeminfoSlab:                    newMetricMeminfoSlab(settings.MeminfoSlab),
+		metricSlabActiveObjects:              newMetricSlabActiveObjects(settings.SlabActiveObjects),
 		metricSlabDentryCacheActiveObjects:   newMetricSlabDentryCacheActiveObjects(settings.SlabDentryCacheActiveObjects),
 		metricSlabDentryCacheObjsize:         newMetricSlabDentryCacheObjsize(settings.SlabDentryCacheObjsize),
+		metricSlabMemory:                     newMetricSlabMemory(settings.SlabMemory),
 		metricSlabNfsInodeCacheActiveObjects: newMetricSlabNfsInodeCacheActiveObjects(settings.SlabNfsInodeCacheActiveObjects),
 		metricSlabNfsInodeCacheObjsize:       newMetricSlabNfsInodeCacheObjsize(settings.SlabNfsInodeCacheObjsize),
+		metricSlabTotalObjects:               newMetricSlabTotalObjects(settings.SlabTotalObjects),
 	}
 	for _, op := range options {
 		op(mb)
@@ -288,10 +516,24 @@ func NewMetricsBuilder(settings MetricsSettings, options ...metricBuilderOption)
 // another set of data points. This function will be doing all transformations required to produce metric representation
 // defined in metadata and user settings, e.g. delta/cumulative translation.
 func (mb *MetricsBuilder) Emit(metrics pdata.MetricSlice) {
+	mb.metricMeminfoSlab.emit(metrics)
+	mb.metricSlabActiveObjects.emit(metrics)
 	mb.metricSlabDentryCacheActiveObjects.emit(metrics)
 	mb.metricSlabDentryCacheObjsize.emit(metrics)
+	mb.metricSlabMemory.emit(metrics)
 	mb.metricSlabNfsInodeCacheActiveObjects.emit(metrics)
 	mb.metricSlabNfsInodeCacheObjsize.emit(metrics)
+	mb.metricSlabTotalObjects.emit(metrics)
+}
+
+// RecordMeminfoSlabDataPoint adds a data point to meminfo.slab metric.
+func (mb *MetricsBuilder) RecordMeminfoSlabDataPoint(ts pdata.Timestamp, val int64, typeAttributeValue string) {
+	mb.metricMeminfoSlab.recordDataPoint(mb.startTime, ts, val, typeAttributeValue)
+}
+
+// RecordSlabActiveObjectsDataPoint adds a data point to slab.active_objects metric.
+func (mb *MetricsBuilder) RecordSlabActiveObjectsDataPoint(ts pdata.Timestamp, val int64, slabAttributeValue string) {
+	mb.metricSlabActiveObjects.recordDataPoint(mb.startTime, ts, val, slabAttributeValue)
 }
 
 // RecordSlabDentryCacheActiveObjectsDataPoint adds a data point to slab.dentry_cache.active_objects metric.
@@ -304,6 +546,11 @@ func (mb *MetricsBuilder) RecordSlabDentryCacheObjsizeDataPoint(ts pdata.Timesta
 	mb.metricSlabDentryCacheObjsize.recordDataPoint(mb.startTime, ts, val)
 }
 
+// RecordSlabMemoryDataPoint adds a data point to slab.memory metric.
+func (mb *MetricsBuilder) RecordSlabMemoryDataPoint(ts pdata.Timestamp, val int64, slabAttributeValue string) {
+	mb.metricSlabMemory.recordDataPoint(mb.startTime, ts, val, slabAttributeValue)
+}
+
 // RecordSlabNfsInodeCacheActiveObjectsDataPoint adds a data point to slab.nfs_inode_cache.active_objects metric.
 func (mb *MetricsBuilder) RecordSlabNfsInodeCacheActiveObjectsDataPoint(ts pdata.Timestamp, val int64) {
 	mb.metricSlabNfsInodeCacheActiveObjects.recordDataPoint(mb.startTime, ts, val)
@@ -314,6 +561,11 @@ func (mb *MetricsBuilder) RecordSlabNfsInodeCacheObjsizeDataPoint(ts pdata.Times
 	mb.metricSlabNfsInodeCacheObjsize.recordDataPoint(mb.startTime, ts, val)
 }
 
+// RecordSlabTotalObjectsDataPoint adds a data point to slab.total_objects metric.
+func (mb *MetricsBuilder) RecordSlabTotalObjectsDataPoint(ts pdata.Timestamp, val int64, slabAttributeValue string) {
+	mb.metricSlabTotalObjects.recordDataPoint(mb.startTime, ts, val, slabAttributeValue)
+}
+
 // Reset resets metrics builder to its initial state. It should be used when external metrics source is restarted,
 // and metrics builder should update its startTime and reset it's internal state accordingly.
 func (mb *MetricsBuilder) Reset(options ...metricBuilderOption) {
@@ -325,7 +577,23 @@ func (mb *MetricsBuilder) Reset(options ...metricBuilderOption) {
 
 // Attributes contains the possible metric attributes that can be used.
 var Attributes = struct {
-}{}
+	// Slab (Slab cache name)
+	Slab string
+	// Type (Whether the slab memory can be reclaimed)
+	Type string
+}{
+	"slab",
+	"type",
+}
 
 // A is an alias for Attributes.
 var A = Attributes
+
+// AttributeType are the possible values that the attribute "type" can have.
+var AttributeType = struct {
+	Reclaimable   string
+	Unreclaimable string
+}{
+	"reclaimable",
+	"unreclaimable",
+}
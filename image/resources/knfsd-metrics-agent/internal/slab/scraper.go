@@ -18,8 +18,12 @@ package slab
 
 import (
 	"context"
+	"os"
+	"path"
+	"regexp"
 	"time"
 
+	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-metrics-agent/convert"
 	"github.com/GoogleCloudPlatform/knfsd-cache-utils/image/resources/knfsd-metrics-agent/internal/slab/internal/metadata"
 	"github.com/prometheus/procfs"
 	"go.opentelemetry.io/collector/component"
@@ -28,13 +32,22 @@ import (
 )
 
 type slabScraper struct {
-	fs procfs.FS
-	mb *metadata.MetricsBuilder
+	fs       procfs.FS
+	mb       *metadata.MetricsBuilder
+	filter   slabFilter
+	pageSize int64
 }
 
 func newScraper(cfg *Config) (scraperhelper.Scraper, error) {
+	filter, err := newSlabFilter(cfg.Include, cfg.IncludeRegexp)
+	if err != nil {
+		return nil, err
+	}
+
 	s := &slabScraper{
-		mb: metadata.NewMetricsBuilder(cfg.Metrics),
+		mb:       metadata.NewMetricsBuilder(cfg.Metrics),
+		filter:   filter,
+		pageSize: int64(os.Getpagesize()),
 	}
 	return scraperhelper.NewScraper(
 		typeStr,
@@ -68,32 +81,68 @@ func (s *slabScraper) scrape(ctx context.Context) (pdata.Metrics, error) {
 		return md, err
 	}
 
+	meminfo, err := s.fs.Meminfo()
+	if err != nil {
+		return md, err
+	}
+
 	now := pdata.NewTimestampFromTime(time.Now())
 	metrics := md.ResourceMetrics().AppendEmpty().
 		InstrumentationLibraryMetrics().AppendEmpty().
 		Metrics()
 
-	dentry := find(info.Slabs, "dentry")
-	if dentry != nil {
-		s.mb.RecordSlabDentryCacheActiveObjectsDataPoint(now, dentry.ObjActive)
-		s.mb.RecordSlabDentryCacheObjsizeDataPoint(now, dentry.ObjSize)
+	for _, slab := range info.Slabs {
+		switch slab.Name {
+		case "dentry":
+			s.mb.RecordSlabDentryCacheActiveObjectsDataPoint(now, slab.ObjActive)
+			s.mb.RecordSlabDentryCacheObjsizeDataPoint(now, slab.ObjSize)
+		case "nfs_inode_cache":
+			s.mb.RecordSlabNfsInodeCacheActiveObjectsDataPoint(now, slab.ObjActive)
+			s.mb.RecordSlabNfsInodeCacheObjsizeDataPoint(now, slab.ObjSize)
+		}
+
+		if !s.filter.match(slab.Name) {
+			continue
+		}
+		s.mb.RecordSlabActiveObjectsDataPoint(now, slab.ObjActive, slab.Name)
+		s.mb.RecordSlabTotalObjectsDataPoint(now, slab.ObjNum, slab.Name)
+		s.mb.RecordSlabMemoryDataPoint(now, slab.SlabNum*slab.PagesPerSlab*s.pageSize, slab.Name)
 	}
 
-	nfs := find(info.Slabs, "nfs_inode_cache")
-	if nfs != nil {
-		s.mb.RecordSlabNfsInodeCacheActiveObjectsDataPoint(now, nfs.ObjActive)
-		s.mb.RecordSlabNfsInodeCacheObjsizeDataPoint(now, nfs.ObjSize)
+	if meminfo.SReclaimableBytes != nil {
+		s.mb.RecordMeminfoSlabDataPoint(now, convert.Int64(*meminfo.SReclaimableBytes), metadata.AttributeType.Reclaimable)
+	}
+	if meminfo.SUnreclaimBytes != nil {
+		s.mb.RecordMeminfoSlabDataPoint(now, convert.Int64(*meminfo.SUnreclaimBytes), metadata.AttributeType.Unreclaimable)
 	}
 
 	s.mb.Emit(metrics)
 	return md, nil
 }
 
-func find(slabs []*procfs.Slab, name string) *procfs.Slab {
-	for _, s := range slabs {
-		if s.Name == name {
-			return s
+// slabFilter matches the slab caches to report.
+type slabFilter struct {
+	patterns []string
+	re       *regexp.Regexp
+}
+
+func newSlabFilter(patterns []string, expr string) (slabFilter, error) {
+	f := slabFilter{patterns: patterns}
+	if expr != "" {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return f, err
 		}
+		f.re = re
 	}
-	return nil
+	return f, nil
+}
+
+func (f slabFilter) match(name string) bool {
+	for _, pattern := range f.patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return f.re != nil && f.re.MatchString(name)
 }
@@ -0,0 +1,57 @@
+/*
+ Copyright 2022 Google LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package slab
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlabFilter(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	f, err := newSlabFilter(cfg.Include, cfg.IncludeRegexp)
+	require.NoError(t, err)
+
+	assert.True(t, f.match("dentry"))
+	assert.True(t, f.match("nfs_inode_cache"))
+	assert.True(t, f.match("nfsd_drc"))
+	assert.True(t, f.match("nfsd4_stateids"))
+	assert.True(t, f.match("fscache_cookie_jar"))
+	assert.True(t, f.match("ext4_inode_cache"))
+	assert.False(t, f.match("kmalloc-64"))
+	assert.False(t, f.match("xfs_inode_log_item"))
+
+	f, err = newSlabFilter(nil, "^kmalloc-[0-9]+$")
+	require.NoError(t, err)
+	assert.True(t, f.match("kmalloc-64"))
+	assert.False(t, f.match("kmalloc-rcl-64"))
+	assert.False(t, f.match("dentry"))
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Include = []string{"nfs_["}
+	assert.Error(t, cfg.Validate())
+
+	cfg = createDefaultConfig().(*Config)
+	cfg.IncludeRegexp = "("
+	assert.Error(t, cfg.Validate())
+}
@@ -17,6 +17,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -31,7 +32,7 @@ const (
 
 func TestKernelVersion(t *testing.T) {
 	t.Parallel()
-	version, err := proxy.GetOS()
+	version, err := proxy.GetOS(context.Background())
 	require.NoError(t, err)
 	assert.Equal(t, KERNEL_VERSION, version.Kernel)
 }
@@ -48,7 +49,7 @@ func TestStatus(t *testing.T) {
 		{"cachefilesd", []string{"enabled", "running", "fscache mounted"}},
 	}
 
-	status, err := proxy.GetStatus()
+	status, err := proxy.GetStatus(context.Background())
 	require.NoError(t, err)
 
 	find := func(name string) *client.ServiceHealth {
@@ -101,7 +102,7 @@ func TestStatus(t *testing.T) {
 func TestProxyMountedSource(t *testing.T) {
 	t.Parallel()
 
-	mounts, err := proxy.GetMounts()
+	mounts, err := proxy.GetMounts(context.Background())
 	require.NoError(t, err)
 	require.NotNil(t, mounts)
 	require.Len(t, mounts.Mounts, 1)
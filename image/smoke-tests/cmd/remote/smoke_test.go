@@ -17,6 +17,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
@@ -324,7 +325,7 @@ func dropLocalVMCaches() error {
 }
 
 func fsCacheSize() (uint64, error) {
-	u, err := proxy.CacheUsage()
+	u, err := proxy.CacheUsage(context.Background())
 	if err != nil {
 		return 0, err
 	}